@@ -0,0 +1,23 @@
+// Package client compiles the builds of the Rosenbridge clients into the binary.
+package client
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// web holds the build of RosenApp, the web SPA. The "all:" prefix includes files that start with a dot or an
+// underscore, which bundlers may emit.
+//
+//go:embed all:web
+var web embed.FS
+
+// Web returns the files of RosenApp, rooted at its build directory.
+func Web() fs.FS {
+	files, err := fs.Sub(web, "web")
+	if err != nil {
+		// The directory is embedded, so this cannot happen.
+		panic("failed to open the embedded web client: " + err.Error())
+	}
+	return files
+}
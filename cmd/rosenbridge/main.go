@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
+	"io/fs"
 	"log/slog"
 	"net"
 	"net/http"
@@ -12,12 +15,27 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/shivanshkc/rosenbridge/internal/blob"
+	"github.com/shivanshkc/rosenbridge/internal/broker"
 	"github.com/shivanshkc/rosenbridge/internal/config"
 	"github.com/shivanshkc/rosenbridge/internal/database"
 	"github.com/shivanshkc/rosenbridge/internal/logger"
+	"github.com/shivanshkc/rosenbridge/internal/mqtt"
+	"github.com/shivanshkc/rosenbridge/internal/password"
 	"github.com/shivanshkc/rosenbridge/internal/rest"
+	"github.com/shivanshkc/rosenbridge/internal/retained"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/internal/tracing"
+	"github.com/shivanshkc/rosenbridge/internal/webhook"
 )
 
+// defaultConfigPath is the config file that is used if the -config flag is not set. It is optional.
+const defaultConfigPath = "config/config.json"
+
+// defaultDrainTimeout is the time that websocket connections get to close gracefully at shutdown, if none is
+// configured.
+const defaultDrainTimeout = 10 * time.Second
+
 func main() {
 	// This is the root context of the app.
 	// It is canceled in two cases:
@@ -28,15 +46,46 @@ func main() {
 
 	// Allow the user to specify the config path.
 	// This makes switching between test and live configs convenient.
-	configPath := flag.String("config", "config/config.json", "config file path")
-	flag.Parse()
+	configPath := flag.String("config", defaultConfigPath, "config file path")
+	printConfig := flag.Bool("print-config", false, "print the effective config, with secrets redacted, and exit")
+	// Every config field can also be set with a flag.
+	overrides := config.RegisterFlags(flag.CommandLine)
+
+	// The "config check" command validates the config and exits. Its flags come after it.
+	args := os.Args[1:]
+	checkConfig := len(args) >= 2 && args[0] == "config" && args[1] == "check"
+	if checkConfig {
+		args = args[2:]
+	}
+	_ = flag.CommandLine.Parse(args) // The CommandLine flag set exits on errors.
+
+	// Containers can be configured with environment variables and flags alone, without the default config file.
+	if !isFlagSet("config") {
+		if _, err := os.Stat(*configPath); errors.Is(err, fs.ErrNotExist) {
+			*configPath = ""
+		}
+	}
 
 	// Very first dependency of the app.
-	conf, err := config.Load(*configPath)
+	conf, err := config.Load(*configPath, overrides)
+	if checkConfig {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("config is valid")
+		return
+	}
 	if err != nil {
 		panic("failed to load config: " + err.Error())
 	}
 
+	if *printConfig {
+		encoded, _ := json.MarshalIndent(conf.Redacted(), "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+
 	// Setup logger.
 	logger.Init(os.Stdout, conf.Logger.Level, conf.Logger.Pretty)
 
@@ -44,14 +93,72 @@ func main() {
 	wd, _ := os.Getwd()
 	slog.InfoContext(ctx, "config file path", "path", *configPath, "wd", wd)
 
+	// Export trace spans, if enabled.
+	var spanExporter *tracing.Exporter
+	if conf.Tracing.Endpoint != "" {
+		spanExporter = tracing.StartExporter(tracing.ExporterOptions{
+			Endpoint:    conf.Tracing.Endpoint,
+			ServiceName: conf.Tracing.ServiceName,
+		})
+	}
+
 	// Instantiate database.
 	dbase, err := database.NewFileDatabase(conf.Database.UsersFilePath)
 	if err != nil {
 		panic("failed to init database: " + err.Error())
 	}
 
+	// Instantiate password hashing and policy.
+	passwords, err := password.New(password.Options{
+		Algorithm:  conf.Password.Algorithm,
+		BcryptCost: conf.Password.BcryptCost,
+		Argon2: password.Argon2Params{
+			Memory:      conf.Password.Argon2.MemoryKiB,
+			Iterations:  conf.Password.Argon2.Iterations,
+			Parallelism: conf.Password.Argon2.Parallelism,
+		},
+		MinLength:        conf.Password.MinLength,
+		BreachedListPath: conf.Password.BreachedListPath,
+	})
+	if err != nil {
+		panic("failed to init password hasher: " + err.Error())
+	}
+
+	// Instantiate attachment storage, if enabled.
+	blobs, err := makeBlobStore(conf)
+	if err != nil {
+		panic("failed to init blob store: " + err.Error())
+	}
+
+	// Instantiate topic access rules.
+	topics, err := topic.NewACL(conf.Topic.ACL)
+	if err != nil {
+		panic("failed to init topic ACL: " + err.Error())
+	}
+
+	// Instantiate retained message storage, if enabled.
+	retainedStore, err := makeRetainedStore(conf)
+	if err != nil {
+		panic("failed to init retained message store: " + err.Error())
+	}
+
+	// A nil *blob.FileStore must not be passed as a non-nil blob.Store.
+	var blobStore blob.Store
+	if blobs != nil {
+		blobStore = blobs
+	}
+
+	// The topic message bus, shared by all protocols.
+	bus := broker.New(topics, retainedStore)
+
+	// Instantiate the webhook dispatcher, if enabled.
+	webhooks, err := makeWebhookDispatcher(conf)
+	if err != nil {
+		panic("failed to init webhook dispatcher: " + err.Error())
+	}
+
 	// Set up the API handlers.
-	handler := rest.NewHandler(conf, dbase)
+	handler := rest.NewHandler(conf, dbase, passwords, blobStore, bus, webhooks)
 
 	// The REST API server of the app.
 	httpServer := makeHttpServer(ctx, conf.HttpServer.Addr, handler)
@@ -70,10 +177,77 @@ func main() {
 		}
 	}()
 
+	// The MQTT server of the app, if enabled.
+	mqttServer, err := startMqttServer(ctx, cancel, conf, handler, bus)
+	if err != nil {
+		panic("failed to start mqtt server: " + err.Error())
+	}
+
+	// Reload the config on SIGHUP.
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+	go func() {
+		running := conf
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangups:
+				running = reloadConfig(ctx, running, *configPath, overrides, handler)
+			}
+		}
+	}()
+
 	// The app exits only once the root context is canceled.
 	<-ctx.Done()
 	// Gracefully shutdown services before exiting.
-	cleanup(httpServer, handler)
+	shutdownDelay := time.Duration(conf.HttpServer.ShutdownDelaySec) * time.Second
+	drainTimeout := time.Duration(conf.HttpServer.DrainTimeoutSec) * time.Second
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+	cleanup(shutdownDelay, drainTimeout, httpServer, mqttServer, handler, webhooks, blobs, spanExporter)
+}
+
+// isFlagSet reports whether the flag with the given name was set on the command line.
+func isFlagSet(name string) bool {
+	var set bool
+	flag.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
+
+// reloadConfig loads the config again, and applies its reloadable fields to the running logger and handler. It returns
+// the config that is in effect afterwards.
+//
+// Changes to other fields are logged as requiring a restart. An invalid config is rejected as a whole, and the running
+// server is left as it is.
+func reloadConfig(ctx context.Context, running config.Config, path string, overrides *config.Overrides,
+	handler *rest.Handler,
+) config.Config {
+	// Only the reloadable fields are applied. Changes to the others are logged, and need a restart.
+	slog.InfoContext(ctx, "reloading the config", "path", path, "reloadable", config.ReloadableFields())
+
+	next, err := config.Load(path, overrides)
+	if err != nil {
+		slog.ErrorContext(ctx, "config reload rejected, the running config is kept", "error", err)
+		return running
+	}
+
+	reloaded, applied, restartRequired := config.Reload(running, next)
+	if len(restartRequired) > 0 {
+		slog.WarnContext(ctx, "config fields changed that require a restart, they are not applied",
+			"fields", restartRequired, "reloadable", config.ReloadableFields())
+	}
+
+	// The level was validated by config.Load.
+	if err := logger.SetLevel(reloaded.Logger.Level); err != nil {
+		slog.ErrorContext(ctx, "failed to set the log level", "error", err)
+	}
+	handler.Reload(reloaded)
+
+	slog.InfoContext(ctx, "config reloaded", "applied", applied)
+	return reloaded
 }
 
 // makeHttpServer makes the http server and returns it without calling any Listen methods.
@@ -90,9 +264,115 @@ func makeHttpServer(ctx context.Context, addr string, handler http.Handler) *htt
 	}
 }
 
+// startMqttServer starts the MQTT server as per the config. It returns nil if MQTT is disabled.
+//
+// The passed cancel function is called if the server stops unexpectedly.
+func startMqttServer(ctx context.Context, cancel context.CancelFunc, conf config.Config, handler *rest.Handler,
+	bus *broker.Broker,
+) (*mqtt.Server, error) {
+	if conf.MQTT.Addr == "" {
+		return nil, nil
+	}
+
+	listener, err := net.Listen("tcp", conf.MQTT.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", conf.MQTT.Addr, err)
+	}
+
+	// MQTT clients are authenticated against the same users as the REST API, and get the same direct messages.
+	server := mqtt.NewServer(bus, handler.MQTTHandler(), conf.MQTT.MaxPacketBytes)
+	bus.AddDeliverer(server)
+	handler.SetMQTT(server)
+
+	go func() {
+		slog.InfoContext(ctx, "starting the mqtt server", "addr", conf.MQTT.Addr)
+
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, mqtt.ErrServerClosed) {
+			slog.ErrorContext(ctx, "error in mqtt Serve call", "error", err)
+			// Signal the app to exit.
+			cancel()
+		}
+	}()
+
+	return server, nil
+}
+
+// makeBlobStore makes the attachment store as per the config. It returns nil if attachments are disabled.
+func makeBlobStore(conf config.Config) (*blob.FileStore, error) {
+	attachmentConf := conf.Attachment
+	if attachmentConf.Dir == "" {
+		return nil, nil
+	}
+
+	return blob.NewFileStore(attachmentConf.Dir,
+		attachmentConf.MaxBytes,
+		attachmentConf.UserQuotaBytes,
+		time.Duration(attachmentConf.TTLSec)*time.Second,
+		time.Duration(attachmentConf.SweepIntervalSec)*time.Second,
+	)
+}
+
+// makeRetainedStore makes the retained message store as per the config. It returns nil if retained messages are
+// disabled.
+func makeRetainedStore(conf config.Config) (retained.Store, error) {
+	if conf.Retained.FilePath == "" {
+		return nil, nil
+	}
+
+	// The concrete type is not returned directly, as a nil *FileStore would make a non-nil interface.
+	store, err := retained.NewFileStore(conf.Retained.FilePath, conf.Retained.MaxTopics)
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// makeWebhookDispatcher makes the webhook dispatcher as per the config. It returns nil if webhooks are disabled.
+func makeWebhookDispatcher(conf config.Config) (*webhook.Dispatcher, error) {
+	webhookConf := conf.Webhook
+	if !webhookConf.Enabled {
+		return nil, nil
+	}
+
+	var deadLetters *webhook.DeadLetterLog
+	if webhookConf.DeadLetterFilePath != "" {
+		var err error
+		if deadLetters, err = webhook.NewDeadLetterLog(webhookConf.DeadLetterFilePath); err != nil {
+			return nil, err
+		}
+	}
+
+	options := webhook.Options{
+		Workers:              webhookConf.Workers,
+		QueueSize:            webhookConf.QueueSize,
+		MaxAttempts:          webhookConf.MaxAttempts,
+		InitialBackoff:       time.Duration(webhookConf.InitialBackoffSec) * time.Second,
+		MaxBackoff:           time.Duration(webhookConf.MaxBackoffSec) * time.Second,
+		Timeout:              time.Duration(webhookConf.TimeoutSec) * time.Second,
+		AllowPrivateNetworks: webhookConf.AllowPrivateNetworks,
+	}
+
+	return webhook.NewDispatcher(options, deadLetters), nil
+}
+
 // cleanup closes all the passed dependencies gracefully.
 // It is supposed to be called before the app exits.
-func cleanup(httpServer *http.Server, handler *rest.Handler) {
+//
+// The readiness check fails for the shutdown delay before anything is closed, so that load balancers stop routing
+// traffic to the server while it still serves. Websocket connections then get the drain timeout to close gracefully.
+func cleanup(shutdownDelay, drainTimeout time.Duration, httpServer *http.Server, mqttServer *mqtt.Server,
+	handler *rest.Handler, webhooks *webhook.Dispatcher, blobs *blob.FileStore, spanExporter *tracing.Exporter,
+) {
+	if handler != nil {
+		handler.Drain()
+	}
+	if shutdownDelay > 0 {
+		slog.Info("readiness check is failing, waiting before shutdown", "delay", shutdownDelay)
+		time.Sleep(shutdownDelay)
+	}
+
 	// To allow dependencies some time for graceful shutdown.
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
@@ -105,11 +385,48 @@ func cleanup(httpServer *http.Server, handler *rest.Handler) {
 		}
 	}
 
+	if mqttServer != nil {
+		if err := mqttServer.Close(); err != nil {
+			slog.ErrorContext(ctx, "failed to close mqtt server", "error", err)
+		} else {
+			slog.InfoContext(ctx, "mqtt server shutdown successful")
+		}
+	}
+
 	if handler != nil {
-		if err := handler.Close(); err != nil {
+		drainCtx, drainCancel := context.WithTimeout(ctx, drainTimeout)
+		err := handler.Close(drainCtx)
+		drainCancel()
+		if err != nil {
 			slog.ErrorContext(ctx, "failed to close rest handler", "error", err)
 		} else {
 			slog.InfoContext(ctx, "rest handler shutdown successful")
 		}
 	}
+
+	// Closed after the handler, so that no more deliveries are enqueued.
+	if webhooks != nil {
+		if err := webhooks.Close(); err != nil {
+			slog.ErrorContext(ctx, "failed to close webhook dispatcher", "error", err)
+		} else {
+			slog.InfoContext(ctx, "webhook dispatcher shutdown successful")
+		}
+	}
+
+	if blobs != nil {
+		if err := blobs.Close(); err != nil {
+			slog.ErrorContext(ctx, "failed to close blob store", "error", err)
+		} else {
+			slog.InfoContext(ctx, "blob store shutdown successful")
+		}
+	}
+
+	// Closed last, so that the spans of the shutdown are exported too.
+	if spanExporter != nil {
+		if err := spanExporter.Close(); err != nil {
+			slog.ErrorContext(ctx, "failed to close span exporter", "error", err)
+		} else {
+			slog.InfoContext(ctx, "span exporter shutdown successful")
+		}
+	}
 }
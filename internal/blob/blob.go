@@ -0,0 +1,51 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"time"
+)
+
+// idPattern matches all blob IDs.
+var idPattern = regexp.MustCompile("^[0-9a-f]{32}$")
+
+var (
+	ErrBlobNotFound  = errors.New("blob not found")
+	ErrBlobTooLarge  = errors.New("blob is too large")
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+)
+
+// Blob is the metadata of a stored blob.
+type Blob struct {
+	ID          string    `json:"id"`
+	Owner       string    `json:"owner"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"createdAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// Store encapsulates all blob storage operations required by Rosenbridge.
+type Store interface {
+	// Put stores the data read from the given reader as a new blob owned by the given owner.
+	// It returns ErrBlobTooLarge if the data exceeds the max blob size, and ErrQuotaExceeded if storing it would
+	// exceed the owner's quota.
+	Put(ctx context.Context, owner, contentType string, data io.Reader) (Blob, error)
+
+	// Get returns the metadata of the blob with the given ID. If not found or expired, it returns ErrBlobNotFound.
+	Get(ctx context.Context, id string) (Blob, error)
+
+	// Open returns the metadata and the content of the blob with the given ID. The caller must close the content.
+	// If not found or expired, it returns ErrBlobNotFound.
+	Open(ctx context.Context, id string) (Blob, io.ReadSeekCloser, error)
+
+	// Ping returns an error if the store cannot store new blobs, like when its storage is no longer writable.
+	Ping(ctx context.Context) error
+}
+
+// ValidID reports whether the given string has the format of a blob ID.
+func ValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
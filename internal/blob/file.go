@@ -0,0 +1,328 @@
+package blob
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Suffixes of the two files that make up each blob.
+	dataFileSuffix = ".bin"
+	metaFileSuffix = ".json"
+)
+
+// FileStore implements Store using a local directory.
+//
+// Each blob is stored as two files: <id>.bin holds the content and <id>.json holds the metadata. The metadata of all
+// blobs is also kept in memory for quick lookups and quota calculations.
+type FileStore struct {
+	blobs map[string]Blob
+	// usage is the total size of the blobs of each owner.
+	usage map[string]int64
+	mutex sync.RWMutex
+
+	dir        string
+	maxBytes   int64
+	quotaBytes int64
+	ttl        time.Duration
+
+	// now is swappable for testing.
+	now func() time.Time
+
+	stopSweeper chan struct{}
+	sweeperDone chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewFileStore returns a new FileStore instance that stores blobs in the given directory.
+//
+// Blobs larger than maxBytes are rejected, owners cannot store more than quotaBytes in total, and every blob expires
+// after the given TTL. Expired blobs are deleted by a background sweeper that runs every sweepInterval, until Close
+// is called.
+func NewFileStore(dir string, maxBytes, quotaBytes int64, ttl, sweepInterval time.Duration) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("blob directory path is empty")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	store := &FileStore{
+		blobs:       map[string]Blob{},
+		usage:       map[string]int64{},
+		dir:         dir,
+		maxBytes:    maxBytes,
+		quotaBytes:  quotaBytes,
+		ttl:         ttl,
+		now:         time.Now,
+		stopSweeper: make(chan struct{}),
+		sweeperDone: make(chan struct{}),
+	}
+
+	if err := store.loadIndex(); err != nil {
+		return nil, fmt.Errorf("failed to load blob index: %w", err)
+	}
+
+	go store.sweepLoop(sweepInterval)
+	return store, nil
+}
+
+// MaxBytes returns the max allowed size of a blob.
+func (f *FileStore) MaxBytes() int64 {
+	return f.maxBytes
+}
+
+// Ping makes sure that new files can be created in the blob directory.
+func (f *FileStore) Ping(ctx context.Context) error {
+	file, err := os.CreateTemp(f.dir, "ping-*.tmp")
+	if err != nil {
+		return fmt.Errorf("blob directory is not writable: %w", err)
+	}
+
+	_ = file.Close()
+	return os.Remove(file.Name())
+}
+
+func (f *FileStore) Put(ctx context.Context, owner, contentType string, data io.Reader) (Blob, error) {
+	// Fail fast if the owner has no quota left.
+	f.mutex.RLock()
+	used := f.usage[owner]
+	f.mutex.RUnlock()
+
+	if used >= f.quotaBytes {
+		return Blob{}, ErrQuotaExceeded
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Blob{}, fmt.Errorf("failed to generate blob ID: %w", err)
+	}
+
+	// Stream the data to a temporary file, so that partial uploads never become visible.
+	tempFile, err := os.CreateTemp(f.dir, "upload-*.tmp")
+	if err != nil {
+		return Blob{}, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+
+	tempPath := tempFile.Name()
+	// Removal fails harmlessly if the file has already been renamed.
+	defer func() { _ = os.Remove(tempPath) }()
+
+	// Read one extra byte to detect oversized data.
+	size, err := io.Copy(tempFile, io.LimitReader(data, f.maxBytes+1))
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Blob{}, fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	if size > f.maxBytes {
+		return Blob{}, ErrBlobTooLarge
+	}
+
+	// Normalized so that the in-memory metadata is identical to what gets read back from the metadata file.
+	now := f.now().Round(0).UTC()
+	blob := Blob{
+		ID:          id,
+		Owner:       owner,
+		ContentType: contentType,
+		Size:        size,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(f.ttl),
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// Check the quota again, as other uploads by the same owner may have completed in the meantime.
+	if f.usage[owner]+size > f.quotaBytes {
+		return Blob{}, ErrQuotaExceeded
+	}
+
+	if err := f.writeMeta(blob); err != nil {
+		return Blob{}, err
+	}
+
+	if err := os.Rename(tempPath, f.path(id, dataFileSuffix)); err != nil {
+		_ = os.Remove(f.path(id, metaFileSuffix))
+		return Blob{}, fmt.Errorf("failed to move blob into place: %w", err)
+	}
+
+	f.blobs[id] = blob
+	f.usage[owner] += size
+	return blob, nil
+}
+
+func (f *FileStore) Get(ctx context.Context, id string) (Blob, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	blob, exists := f.blobs[id]
+	if !exists || !blob.ExpiresAt.After(f.now()) {
+		return Blob{}, ErrBlobNotFound
+	}
+
+	return blob, nil
+}
+
+func (f *FileStore) Open(ctx context.Context, id string) (Blob, io.ReadSeekCloser, error) {
+	blob, err := f.Get(ctx, id)
+	if err != nil {
+		return Blob{}, nil, err
+	}
+
+	file, err := os.Open(f.path(id, dataFileSuffix))
+	if err != nil {
+		// The sweeper may have deleted it right after the Get call.
+		if errors.Is(err, os.ErrNotExist) {
+			return Blob{}, nil, ErrBlobNotFound
+		}
+		return Blob{}, nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+
+	return blob, file, nil
+}
+
+// Sweep deletes all expired blobs. It is called periodically by the background sweeper.
+func (f *FileStore) Sweep() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	now := f.now()
+	for id, blob := range f.blobs {
+		if blob.ExpiresAt.After(now) {
+			continue
+		}
+
+		// The data file is removed first, so a failure never leaves data without metadata.
+		if err := removeIfExists(f.path(id, dataFileSuffix)); err != nil {
+			slog.Error("failed to delete expired blob", "id", id, "error", err)
+			continue
+		}
+		if err := removeIfExists(f.path(id, metaFileSuffix)); err != nil {
+			slog.Error("failed to delete expired blob metadata", "id", id, "error", err)
+		}
+
+		delete(f.blobs, id)
+		f.usage[blob.Owner] -= blob.Size
+		if f.usage[blob.Owner] <= 0 {
+			delete(f.usage, blob.Owner)
+		}
+	}
+}
+
+// Close stops the background sweeper. It does not delete any blobs.
+func (f *FileStore) Close() error {
+	f.closeOnce.Do(func() { close(f.stopSweeper) })
+	<-f.sweeperDone
+	return nil
+}
+
+// sweepLoop calls Sweep periodically until the store is closed.
+func (f *FileStore) sweepLoop(interval time.Duration) {
+	defer close(f.sweeperDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.Sweep()
+		case <-f.stopSweeper:
+			return
+		}
+	}
+}
+
+// loadIndex reads the metadata of all blobs in the directory into memory, and removes leftover temporary files.
+func (f *FileStore) loadIndex() error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read blob directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		// Temporary files belong to uploads that were interrupted by a restart.
+		if strings.HasSuffix(name, ".tmp") {
+			_ = os.Remove(filepath.Join(f.dir, name))
+			continue
+		}
+
+		id, isMeta := strings.CutSuffix(name, metaFileSuffix)
+		if !isMeta || !idPattern.MatchString(id) {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(f.dir, name))
+		if err != nil {
+			return fmt.Errorf("failed to read blob metadata %s: %w", name, err)
+		}
+
+		var blob Blob
+		if err := json.Unmarshal(content, &blob); err != nil {
+			return fmt.Errorf("failed to unmarshal blob metadata %s: %w", name, err)
+		}
+
+		// The files are found by the ID, so metadata copied under another name would point to the wrong data.
+		if blob.ID != id {
+			return fmt.Errorf("blob metadata %s is for another blob: %q", name, blob.ID)
+		}
+
+		f.blobs[blob.ID] = blob
+		f.usage[blob.Owner] += blob.Size
+	}
+
+	return nil
+}
+
+// writeMeta writes the metadata file of the given blob.
+func (f *FileStore) writeMeta(blob Blob) error {
+	marshalled, err := json.MarshalIndent(blob, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal blob metadata: %w", err)
+	}
+
+	if err := os.WriteFile(f.path(blob.ID, metaFileSuffix), marshalled, 0600); err != nil {
+		return fmt.Errorf("failed to write blob metadata: %w", err)
+	}
+
+	return nil
+}
+
+// path returns the path of the file with the given suffix for the blob with the given ID.
+func (f *FileStore) path(id, suffix string) string {
+	return filepath.Join(f.dir, id+suffix)
+}
+
+// newID returns a new random blob ID.
+func newID() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// removeIfExists removes the file at the given path, ignoring the error if it does not exist.
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStore(t *testing.T, dir string) *FileStore {
+	store, err := NewFileStore(dir, 10, 15, time.Hour, time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestNewFileStore_EmptyDir(t *testing.T) {
+	store, err := NewFileStore("", 10, 10, time.Hour, time.Hour)
+	require.ErrorContains(t, err, "blob directory path is empty")
+	require.Nil(t, store)
+}
+
+func TestFileStore_PutOpen(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t, t.TempDir())
+
+	blob, err := store.Put(ctx, "alice", "text/plain", strings.NewReader("hello"))
+	require.NoError(t, err)
+	require.True(t, ValidID(blob.ID))
+	require.Equal(t, "alice", blob.Owner)
+	require.Equal(t, "text/plain", blob.ContentType)
+	require.Equal(t, int64(5), blob.Size)
+
+	gotten, content, err := store.Open(ctx, blob.ID)
+	require.NoError(t, err)
+	defer func() { _ = content.Close() }()
+	require.Equal(t, blob, gotten)
+
+	data, err := io.ReadAll(content)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	_, err = store.Get(ctx, strings.Repeat("0", 32))
+	require.ErrorIs(t, err, ErrBlobNotFound)
+}
+
+func TestFileStore_Limits(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+
+	// Larger than max bytes.
+	_, err := store.Put(ctx, "alice", "text/plain", strings.NewReader(strings.Repeat("x", 11)))
+	require.ErrorIs(t, err, ErrBlobTooLarge)
+
+	// Fills 10 of the 15 quota bytes.
+	_, err = store.Put(ctx, "alice", "text/plain", strings.NewReader(strings.Repeat("x", 10)))
+	require.NoError(t, err)
+
+	// Would exceed the quota.
+	_, err = store.Put(ctx, "alice", "text/plain", strings.NewReader(strings.Repeat("x", 6)))
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+
+	// Other owners are unaffected.
+	_, err = store.Put(ctx, "bob", "text/plain", strings.NewReader(strings.Repeat("x", 6)))
+	require.NoError(t, err)
+
+	// Failed uploads must not leave files behind. Two blobs, two files each.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+}
+
+func TestFileStore_SweepAndReload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	expiring, err := store.Put(ctx, "alice", "text/plain", strings.NewReader("old"))
+	require.NoError(t, err)
+
+	now = now.Add(30 * time.Minute)
+	fresh, err := store.Put(ctx, "alice", "text/plain", strings.NewReader("new"))
+	require.NoError(t, err)
+
+	// The first blob expires.
+	now = now.Add(31 * time.Minute)
+	_, err = store.Get(ctx, expiring.ID)
+	require.ErrorIs(t, err, ErrBlobNotFound)
+
+	store.Sweep()
+	require.NoFileExists(t, filepath.Join(dir, expiring.ID+dataFileSuffix))
+	require.NoFileExists(t, filepath.Join(dir, expiring.ID+metaFileSuffix))
+	require.Equal(t, int64(3), store.usage["alice"])
+
+	// A new store over the same directory sees the remaining blob, and cleans up leftover temporary files.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "upload-1.tmp"), []byte("partial"), 0600))
+
+	reloaded := newTestStore(t, dir)
+	require.Equal(t, map[string]Blob{fresh.ID: store.blobs[fresh.ID]}, reloaded.blobs)
+	require.Equal(t, int64(3), reloaded.usage["alice"])
+	require.NoFileExists(t, filepath.Join(dir, "upload-1.tmp"))
+}
+
+func TestFileStore_Ping(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "blobs")
+	store := newTestStore(t, dir)
+
+	require.NoError(t, store.Ping(context.Background()))
+
+	// The probe file must not be left behind.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// A file in place of the directory makes writes fail, even for root.
+	require.NoError(t, os.Remove(dir))
+	require.NoError(t, os.WriteFile(dir, nil, 0600))
+	require.ErrorContains(t, store.Ping(context.Background()), "blob directory is not writable")
+}
+
+func TestNewFileStore_MismatchedMetadata(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestStore(t, dir)
+
+	blob, err := store.Put(context.Background(), "alice", "text/plain", strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	// Metadata copied under the name of another blob.
+	content, err := os.ReadFile(filepath.Join(dir, blob.ID+metaFileSuffix))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, strings.Repeat("0", 32)+metaFileSuffix), content, 0600))
+
+	_, err = NewFileStore(dir, 10, 15, time.Hour, time.Hour)
+	require.ErrorContains(t, err, "is for another blob")
+}
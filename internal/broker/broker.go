@@ -0,0 +1,161 @@
+// Package broker routes the messages published to topics to their subscribers, regardless of the protocol that the
+// publishers and subscribers use.
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/shivanshkc/rosenbridge/internal/retained"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+)
+
+var ErrRetainDisabled = errors.New("retained messages are disabled")
+
+// Deliverer delivers published messages to the subscribers of one protocol, like websocket or MQTT.
+type Deliverer interface {
+	// Deliver sends the message to all subscribers of its topic that pass the allow function.
+	Deliver(ctx context.Context, message protocol.MessageReceived, allow Allow) error
+}
+
+// Allow reports whether a subscriber, of the given user and authenticated with the given scopes, may receive a
+// message.
+type Allow func(username string, scopes scope.Set) bool
+
+// Authorizer reports whether the user holds the required scope. The scopes are those that the user authenticated with,
+// which are fewer than the user's own if an API key was used.
+type Authorizer func(username string, scopes scope.Set, required string) bool
+
+// Broker applies the topic access rules, keeps the retained messages, and fans out published messages to all
+// Deliverers.
+type Broker struct {
+	topics   *topic.ACL
+	retained retained.Store
+
+	deliverers      []Deliverer
+	deliverersMutex sync.RWMutex
+
+	authorizer      Authorizer
+	authorizerMutex sync.RWMutex
+}
+
+// New returns a new Broker instance. A nil ACL denies everything, and a nil retained store disables retained messages.
+func New(topics *topic.ACL, retained retained.Store) *Broker {
+	return &Broker{topics: topics, retained: retained}
+}
+
+// AddDeliverer registers a Deliverer. All messages published after this call are delivered through it as well.
+func (b *Broker) AddDeliverer(deliverer Deliverer) {
+	b.deliverersMutex.Lock()
+	defer b.deliverersMutex.Unlock()
+
+	b.deliverers = append(b.deliverers, deliverer)
+}
+
+// SetAuthorizer makes the broker check the scopes of users, on top of the topic access rules. Publishing requires the
+// send scope, and subscribing requires the connect scope. Without an Authorizer, scopes are not checked.
+func (b *Broker) SetAuthorizer(authorizer Authorizer) {
+	b.authorizerMutex.Lock()
+	defer b.authorizerMutex.Unlock()
+
+	b.authorizer = authorizer
+}
+
+// CanPublish reports whether the user, authenticated with the given scopes, can publish to the given topic name.
+func (b *Broker) CanPublish(username string, scopes scope.Set, name string) bool {
+	return b.authorize(username, scopes, scope.Send) && b.topics.CanPublish(username, name)
+}
+
+// CanSubscribe reports whether the user, authenticated with the given scopes, can subscribe to the given topic filter.
+func (b *Broker) CanSubscribe(username string, scopes scope.Set, filter string) bool {
+	return b.authorize(username, scopes, scope.Connect) && b.topics.CanSubscribe(username, filter)
+}
+
+// authorize reports whether the user holds the required scope, as per the Authorizer.
+func (b *Broker) authorize(username string, scopes scope.Set, required string) bool {
+	b.authorizerMutex.RLock()
+	authorizer := b.authorizer
+	b.authorizerMutex.RUnlock()
+
+	return authorizer == nil || authorizer(username, scopes, required)
+}
+
+// RetainEnabled reports whether retained messages are enabled.
+func (b *Broker) RetainEnabled() bool {
+	return b.retained != nil
+}
+
+// Retain makes the message the retained message of its topic. It does not deliver the message.
+// It returns ErrRetainDisabled if retained messages are disabled.
+func (b *Broker) Retain(ctx context.Context, message protocol.MessageReceived) error {
+	if b.retained == nil {
+		return ErrRetainDisabled
+	}
+
+	// Retained is a delivery flag, so it is never stored.
+	message.Retained = false
+	return b.retained.Set(ctx, message)
+}
+
+// DeleteRetained deletes the retained message of the given topic, if any.
+// It returns ErrRetainDisabled if retained messages are disabled.
+func (b *Broker) DeleteRetained(ctx context.Context, name string) error {
+	if b.retained == nil {
+		return ErrRetainDisabled
+	}
+
+	return b.retained.Delete(ctx, name)
+}
+
+// Retained returns the retained messages of all topics that match the given filter and that the user, authenticated
+// with the given scopes, is allowed to subscribe to. The returned messages have the Retained flag set. It returns
+// nothing if retained messages are disabled.
+func (b *Broker) Retained(ctx context.Context, username string, scopes scope.Set, filter string,
+) ([]protocol.MessageReceived, error) {
+	if b.retained == nil {
+		return nil, nil
+	}
+
+	messages, err := b.retained.Match(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get retained messages: %w", err)
+	}
+
+	allowed := make([]protocol.MessageReceived, 0, len(messages))
+	for _, message := range messages {
+		// A more specific rule may deny some of the topics that match an allowed filter.
+		if !b.CanSubscribe(username, scopes, message.Topic) {
+			continue
+		}
+		message.Retained = true
+		allowed = append(allowed, message)
+	}
+
+	return allowed, nil
+}
+
+// Publish delivers the message to all subscribers of its topic, through all Deliverers.
+//
+// The publisher's access is not checked here, but every subscriber's is, since subscriptions are only checked against
+// the first rule that covers their whole filter.
+func (b *Broker) Publish(ctx context.Context, message protocol.MessageReceived) error {
+	b.deliverersMutex.RLock()
+	deliverers := b.deliverers
+	b.deliverersMutex.RUnlock()
+
+	allow := func(username string, scopes scope.Set) bool { return b.CanSubscribe(username, scopes, message.Topic) }
+
+	// This will collect all errors.
+	var errs []error
+	for _, deliverer := range deliverers {
+		if err := deliverer.Deliver(ctx, message, allow); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
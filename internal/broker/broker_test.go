@@ -0,0 +1,124 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/shivanshkc/rosenbridge/internal/retained"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDeliverer records the deliveries, along with the users that are allowed to receive them.
+type fakeDeliverer struct {
+	users []string
+	// scopes are those that every user authenticated with.
+	scopes     scope.Set
+	deliveries map[string][]string
+	err        error
+}
+
+func (f *fakeDeliverer) Deliver(_ context.Context, message protocol.MessageReceived, allow Allow) error {
+	for _, user := range f.users {
+		if allow(user, f.scopes) {
+			f.deliveries[message.Topic] = append(f.deliveries[message.Topic], user)
+		}
+	}
+	return f.err
+}
+
+func newTestACL(t *testing.T) *topic.ACL {
+	topics, err := topic.NewACL([]topic.Rule{
+		{Topic: "status/secret", Subscribers: []string{"bob"}},
+		{Topic: "status/#", Publishers: []string{"bob"}, Subscribers: []string{topic.AnyUser}},
+	})
+	require.NoError(t, err)
+	return topics
+}
+
+func TestBroker_Publish(t *testing.T) {
+	b := New(newTestACL(t), nil)
+
+	first := &fakeDeliverer{users: []string{"alice", "bob"}, deliveries: map[string][]string{}}
+	second := &fakeDeliverer{users: []string{"carol"}, deliveries: map[string][]string{}, err: errors.New("oops")}
+	b.AddDeliverer(first)
+	b.AddDeliverer(second)
+
+	require.True(t, b.CanPublish("bob", nil, "status/a"))
+	require.False(t, b.CanPublish("alice", nil, "status/a"))
+	require.True(t, b.CanSubscribe("alice", nil, "status/*"))
+
+	// Errors of one deliverer do not stop the others.
+	require.ErrorContains(t, b.Publish(context.Background(), protocol.MessageReceived{Topic: "status/a"}), "oops")
+
+	second.err = nil
+	require.NoError(t, b.Publish(context.Background(), protocol.MessageReceived{Topic: "status/secret"}))
+
+	require.Equal(t, map[string][]string{"status/a": {"alice", "bob"}, "status/secret": {"bob"}}, first.deliveries)
+	require.Equal(t, map[string][]string{"status/a": {"carol"}}, second.deliveries)
+}
+
+func TestBroker_SetAuthorizer(t *testing.T) {
+	b := New(newTestACL(t), nil)
+
+	receivers := &fakeDeliverer{
+		users:      []string{"alice", "bob", "carol"},
+		scopes:     scope.Set{scope.Connect},
+		deliveries: map[string][]string{},
+	}
+	senders := &fakeDeliverer{users: []string{"alice"}, scopes: scope.Set{scope.Send}, deliveries: map[string][]string{}}
+	b.AddDeliverer(receivers)
+	b.AddDeliverer(senders)
+
+	// Carol has no scopes of her own, and the others have whatever they authenticated with.
+	b.SetAuthorizer(func(username string, scopes scope.Set, required string) bool {
+		return username != "carol" && scopes.Has(required)
+	})
+
+	require.True(t, b.CanPublish("bob", scope.Set{scope.Send}, "status/a"))
+	require.False(t, b.CanPublish("bob", scope.Set{scope.Connect}, "status/a"))
+	require.False(t, b.CanSubscribe("alice", scope.Set{scope.Send}, "status/*"))
+	require.True(t, b.CanSubscribe("alice", scope.Set{scope.Connect}, "status/*"))
+
+	require.NoError(t, b.Publish(context.Background(), protocol.MessageReceived{Topic: "status/a"}))
+	require.Equal(t, map[string][]string{"status/a": {"alice", "bob"}}, receivers.deliveries)
+	require.Empty(t, senders.deliveries)
+}
+
+func TestBroker_Retained(t *testing.T) {
+	ctx := context.Background()
+
+	disabled := New(newTestACL(t), nil)
+	require.False(t, disabled.RetainEnabled())
+	require.ErrorIs(t, disabled.Retain(ctx, protocol.MessageReceived{Topic: "status/a"}), ErrRetainDisabled)
+	require.ErrorIs(t, disabled.DeleteRetained(ctx, "status/a"), ErrRetainDisabled)
+
+	messages, err := disabled.Retained(ctx, "alice", nil, "#")
+	require.NoError(t, err)
+	require.Empty(t, messages)
+
+	store, err := retained.NewFileStore(filepath.Join(t.TempDir(), "retained.json"), 0)
+	require.NoError(t, err)
+
+	b := New(newTestACL(t), store)
+	require.True(t, b.RetainEnabled())
+
+	for _, name := range []string{"status/a", "status/secret"} {
+		// The flag must not be stored.
+		require.NoError(t, b.Retain(ctx, protocol.MessageReceived{Message: "up", Topic: name, Retained: true}))
+	}
+
+	messages, err = b.Retained(ctx, "alice", nil, "status/#")
+	require.NoError(t, err)
+	require.Equal(t, []protocol.MessageReceived{{Message: "up", Topic: "status/a", Retained: true}}, messages)
+
+	require.NoError(t, b.DeleteRetained(ctx, "status/a"))
+	messages, err = b.Retained(ctx, "bob", nil, "status/#")
+	require.NoError(t, err)
+	require.Equal(t, []protocol.MessageReceived{{Message: "up", Topic: "status/secret", Retained: true}}, messages)
+}
@@ -2,25 +2,192 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
+	"reflect"
+
+	"github.com/shivanshkc/rosenbridge/internal/topic"
 )
 
 // Config encapsulates all config required by the application.
+//
+// Every field can be overridden by an environment variable and a flag, named after its JSON keys. See Load.
+// Fields with the `secret:"true"` tag are redacted when the config is printed. Fields with the `reload:"true"` tag
+// can change without a restart, see Reload.
 type Config struct {
 	HttpServer struct {
 		Addr           string   `json:"addr"`
-		AllowedOrigins []string `json:"allowedOrigins"`
+		AllowedOrigins []string `json:"allowedOrigins" reload:"true"`
 		// Read here: https://developer.mozilla.org/en-US/docs/Web/HTTP/Reference/Headers/Access-Control-Max-Age
-		CorsMaxAgeSec int `json:"corsMaxAgeSec"`
+		CorsMaxAgeSec int `json:"corsMaxAgeSec" reload:"true"`
+		// Seconds that the server keeps serving after shutdown begins, with the readiness check failing, so that load
+		// balancers stop routing traffic to it first.
+		ShutdownDelaySec int `json:"shutdownDelaySec"`
+		// Seconds that websocket connections get to close gracefully at shutdown, after which they are dropped.
+		// Defaults to 10.
+		DrainTimeoutSec int `json:"drainTimeoutSec" default:"10"`
+		// Upper bound of the reconnect delay suggested to websocket clients at shutdown. Each client gets a random
+		// delay up to it. Defaults to 10.
+		ReconnectDelayMaxSec int `json:"reconnectDelayMaxSec" default:"10"`
 	} `json:"httpServer"`
 
 	Logger struct {
-		Level  string `json:"level"`
+		// One of "debug", "info", "warn" and "error". Defaults to "info".
+		Level  string `json:"level" default:"info" reload:"true"`
 		Pretty bool   `json:"pretty"`
 	} `json:"logger"`
 
+	Metrics struct {
+		// Whether GET /metrics serves metrics in the Prometheus text format.
+		Enabled bool `json:"enabled"`
+		// If set, scrapers must send it in the "Authorization: Bearer <token>" header.
+		BearerToken string `json:"bearerToken" secret:"true"`
+		// If set, only clients from these networks can scrape, like "10.0.0.0/8" or "127.0.0.1". The address of the
+		// client is the one of the TCP connection, so proxies in front of Rosenbridge must be allowed instead.
+		AllowedNetworks []string `json:"allowedNetworks"`
+	} `json:"metrics"`
+
+	Tracing struct {
+		// Base URL of an OpenTelemetry collector that accepts OTLP/HTTP, like "http://localhost:4318". Spans are
+		// exported only if it is set.
+		Endpoint string `json:"endpoint"`
+		// The service.name of the exported spans. Defaults to "rosenbridge".
+		ServiceName string `json:"serviceName" default:"rosenbridge"`
+	} `json:"tracing"`
+
+	// Size limits of the different parts of a message. Zero means the default limit, and negative values are
+	// rejected. The request body limit is derived from them, with room for the fully escaped text, the payload, the
+	// base64 encoded binary data, and 16 KiB for the rest of the body.
+	Message struct {
+		// Max size of the message text. Defaults to 4096.
+		MaxTextBytes int `json:"maxTextBytes" default:"4096"`
+		// Max size of the JSON payload. Defaults to 8192.
+		MaxPayloadBytes int `json:"maxPayloadBytes" default:"8192"`
+		// Max size of the binary data, after base64 decoding. Defaults to 8192.
+		MaxBinaryBytes int `json:"maxBinaryBytes" default:"8192"`
+	} `json:"message"`
+
+	Privacy struct {
+		// What happens to messages for receivers who blocked the sender, or who only accept messages from their
+		// contacts. With "drop", the default, they are accepted but not delivered. With "reject", they are reported as
+		// rejected, the same way as messages for users that do not exist.
+		RefusedMessages string `json:"refusedMessages" default:"drop"`
+	} `json:"privacy"`
+
+	Idempotency struct {
+		// How long the response of a request is remembered against its Idempotency-Key.
+		// Zero or a negative value disables idempotency keys.
+		TTLSec int `json:"ttlSec"`
+		// Max number of keys remembered at once. The oldest keys are forgotten first.
+		MaxKeys int `json:"maxKeys" default:"100000"`
+	} `json:"idempotency"`
+
+	Attachment struct {
+		// Directory where attachments are stored. Attachments are disabled if empty.
+		Dir string `json:"dir"`
+		// Max size of a single attachment.
+		MaxBytes int64 `json:"maxBytes" default:"10485760"`
+		// Max total size of the attachments of a user.
+		UserQuotaBytes int64 `json:"userQuotaBytes" default:"104857600"`
+		// Attachments are deleted after this duration.
+		TTLSec int `json:"ttlSec" default:"604800"`
+		// Download links sent to receivers stop working after this duration.
+		LinkTTLSec int `json:"linkTtlSec" default:"3600"`
+		// How often expired attachments are deleted.
+		SweepIntervalSec int `json:"sweepIntervalSec" default:"300"`
+		// Key used to sign download links. If empty, a random key is generated on startup, which means that links
+		// stop working after a restart.
+		SigningKey string `json:"signingKey" secret:"true"`
+	} `json:"attachment"`
+
+	Topic struct {
+		// Access rules of topics. The first rule whose topic filter covers a topic applies to it.
+		// If no rule applies, the topic can neither be published to nor subscribed to.
+		ACL []topic.Rule `json:"acl"`
+	} `json:"topic"`
+
+	Retained struct {
+		// File where the retained message of each topic is stored. Retained messages are disabled if empty.
+		FilePath string `json:"filePath"`
+		// Max number of topics that can have a retained message. Zero means no limit.
+		MaxTopics int `json:"maxTopics"`
+	} `json:"retained"`
+
+	MQTT struct {
+		// Address of the MQTT 3.1.1 listener. MQTT is disabled if empty.
+		Addr string `json:"addr"`
+		// Max size of the packets sent by MQTT clients, excluding the fixed header. Defaults to 65536.
+		MaxPacketBytes int `json:"maxPacketBytes" default:"65536"`
+	} `json:"mqtt"`
+
+	Webhook struct {
+		// Enabled allows users to register a webhook that receives their messages.
+		Enabled bool `json:"enabled"`
+		// Number of deliveries that can be in flight at once. Defaults to 4.
+		Workers int `json:"workers" default:"4"`
+		// Max number of deliveries waiting to be sent, including retries. Defaults to 1000.
+		QueueSize int `json:"queueSize" default:"1000"`
+		// Max number of attempts of a delivery before it is dead-lettered. Defaults to 5.
+		MaxAttempts int `json:"maxAttempts" default:"5"`
+		// Delay before the first retry. It doubles with every retry. Defaults to 1.
+		InitialBackoffSec int `json:"initialBackoffSec" default:"1"`
+		// Max delay between retries. Defaults to 300.
+		MaxBackoffSec int `json:"maxBackoffSec" default:"300"`
+		// Max duration of a delivery attempt. Defaults to 10.
+		TimeoutSec int `json:"timeoutSec" default:"10"`
+		// File where permanently failed deliveries are appended as JSON lines. They are only logged if empty.
+		DeadLetterFilePath string `json:"deadLetterFilePath"`
+		// Allows webhooks on loopback, private and link-local addresses. Only set it if all users are trusted.
+		AllowPrivateNetworks bool `json:"allowPrivateNetworks"`
+	} `json:"webhook"`
+
+	OIDC struct {
+		// Issuer URL of the OpenID Connect provider, like https://accounts.google.com. OIDC login is disabled if empty.
+		Issuer       string `json:"issuer"`
+		ClientID     string `json:"clientId"`
+		ClientSecret string `json:"clientSecret" secret:"true"`
+		// The callback URL registered at the provider. It must point to /api/auth/oidc/callback of this server.
+		RedirectURL string `json:"redirectUrl"`
+		// Scopes requested in addition to "openid". Defaults to ["email", "profile"].
+		Scopes []string `json:"scopes" default:"email,profile"`
+		// Creates a user for every new identity. If false, only identities that are already linked can log in.
+		AutoProvision bool `json:"autoProvision"`
+		// Links a new identity to the existing user with the same username, instead of creating another user.
+		// Only set it if the provider is trusted to hand out usernames, like a company directory.
+		LinkExistingUsers bool `json:"linkExistingUsers"`
+		// Where the browser is sent after login, with the session token in the URL fragment.
+		// If empty, the callback responds with the session token as JSON.
+		PostLoginRedirectURL string `json:"postLoginRedirectUrl"`
+	} `json:"oidc"`
+
+	Password struct {
+		// Algorithm used to hash new passwords, "bcrypt" (the default) or "argon2id". Hashes made with another
+		// algorithm, or with weaker parameters, are replaced on the next successful login of their user.
+		Algorithm string `json:"algorithm" default:"bcrypt"`
+		// Cost of bcrypt hashes, between 4 and 31. Defaults to 10.
+		BcryptCost int `json:"bcryptCost" default:"10"`
+		// Parameters of argon2id hashes. They default to 19456 KiB of memory, 2 iterations, and 1 thread.
+		Argon2 struct {
+			MemoryKiB   uint32 `json:"memoryKiB" default:"19456"`
+			Iterations  uint32 `json:"iterations" default:"2"`
+			Parallelism uint8  `json:"parallelism" default:"1"`
+		} `json:"argon2"`
+		// Min length of new passwords, in characters. Defaults to 8.
+		MinLength int `json:"minLength" default:"8"`
+		// A file of passwords that are known to be leaked, one per line, which cannot be used as new passwords.
+		// No passwords are refused if it is empty.
+		BreachedListPath string `json:"breachedListPath"`
+	} `json:"password"`
+
+	Session struct {
+		// Key used to sign session tokens. If empty, a random key is generated on startup, which means that sessions
+		// end with a restart.
+		SigningKey string `json:"signingKey" secret:"true"`
+		// Validity duration of session tokens. Defaults to 43200, which is 12 hours.
+		TTLSec int `json:"ttlSec" default:"43200"`
+	} `json:"session"`
+
 	Database struct {
 		UsersFilePath string `json:"usersFilePath"`
 	} `json:"database"`
@@ -28,42 +195,55 @@ type Config struct {
 	Frontend struct {
 		// The base URL of the backend that the frontend will use.
 		BackendAddr string `json:"backendAddr"`
-		// Path to the directory that contains the frontend SPA files.
+		// Path to the directory that contains the frontend SPA files. It overrides the SPA that is embedded in the
+		// binary, which is served if it is empty.
 		Path string `json:"path"`
+		// Features sets the feature flags of the frontend. They take precedence over the ones derived from the rest
+		// of the config, like "attachments".
+		Features map[string]bool `json:"features"`
 	} `json:"frontend"`
 }
 
-// Load config from the given JSON file.
-func Load(jsonPath string) (Config, error) {
-	content, err := os.ReadFile(jsonPath)
-	if err != nil {
-		return Config{}, fmt.Errorf("failed to read config file at %s because: %w", jsonPath, err)
-	}
+// Load config from the given JSON file, the environment and the flags, in increasing order of precedence. Fields that
+// are set by none of them keep their defaults, as per their `default` tags.
+//
+// The file is skipped if the path is empty. The environment variable of a field is its JSON keys joined with
+// underscores, in upper case, and prefixed with ROSENBRIDGE_, like ROSENBRIDGE_HTTPSERVER_ADDR. The overrides can be
+// nil if there are no flags.
+//
+// The config is then validated. Unknown keys in the file, invalid values and any other problems are all reported
+// together, in one error.
+func Load(jsonPath string, overrides *Overrides) (Config, error) {
+	config := withDefaults()
 
-	var config Config
-	if err := json.Unmarshal(content, &config); err != nil {
-		return Config{}, fmt.Errorf("failed to unmarshal config file at %s because: %w", jsonPath, err)
-	}
+	// This will collect all problems.
+	var errs []error
 
-	if err := setFrontendConfig(config); err != nil {
-		return Config{}, fmt.Errorf("failed to set frontend config because: %w", err)
-	}
+	if jsonPath != "" {
+		content, err := os.ReadFile(jsonPath)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read config file at %s because: %w", jsonPath, err)
+		}
 
-	return config, nil
-}
+		// Unknown keys are most likely typos, which would otherwise go unnoticed.
+		var decoded any
+		if err := json.Unmarshal(content, &decoded); err != nil {
+			return Config{}, fmt.Errorf("failed to unmarshal config file at %s because: %w", jsonPath, err)
+		}
+		for _, key := range unknownKeys(decoded, reflect.TypeFor[Config](), "") {
+			errs = append(errs, fmt.Errorf("%s: unknown field in config file", key))
+		}
 
-// setFrontendConfig sets the config values for the frontend.
-func setFrontendConfig(conf Config) error {
-	if conf.Frontend.Path == "" {
-		return nil
+		if err := json.Unmarshal(content, &config); err != nil {
+			errs = append(errs, fmt.Errorf("failed to unmarshal config file at %s because: %w", jsonPath, err))
+		}
 	}
 
-	path := filepath.Join(conf.Frontend.Path, "config.json")
-	data := fmt.Sprintf(`{ "apiBaseUrl": "%s" }`, conf.Frontend.BackendAddr)
-
-	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
-		return fmt.Errorf("error writing frontend config file: %w", err)
+	errs = append(errs, applyOverrides(&config, overrides)...)
+	errs = append(errs, config.validate()...)
+	if len(errs) > 0 {
+		return Config{}, fmt.Errorf("invalid config:\n%w", errors.Join(errs...))
 	}
 
-	return nil
+	return config, nil
 }
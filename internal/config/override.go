@@ -0,0 +1,244 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// envPrefix is the prefix of the environment variables that override config fields.
+const envPrefix = "ROSENBRIDGE_"
+
+// redacted replaces the secrets of the config when it is printed.
+const redacted = "REDACTED"
+
+// Overrides holds the config values set by command line flags.
+type Overrides struct {
+	// values maps the flag names to their raw values. Only the flags that were set are present.
+	values map[string]string
+}
+
+// RegisterFlags defines a flag for every config field on the given flag set, and returns the Overrides that they set
+// when the flag set is parsed.
+//
+// The flag of a field is named after its JSON keys, like -httpServer.addr. Its value is parsed the same way as the
+// one of its environment variable, see Load.
+func RegisterFlags(flags *flag.FlagSet) *Overrides {
+	overrides := &Overrides{values: map[string]string{}}
+
+	for _, f := range fields() {
+		set := func(raw string) error {
+			// Invalid values are reported by the flag set, along with the usage.
+			if _, err := parseValue(f.typ, raw); err != nil {
+				return err
+			}
+			overrides.values[f.flagName()] = raw
+			return nil
+		}
+
+		usage := fmt.Sprintf("overrides the %s config field, and the %s environment variable",
+			f.flagName(), f.envName())
+		if f.typ.Kind() == reflect.Bool {
+			flags.BoolFunc(f.flagName(), usage, set)
+		} else {
+			flags.Func(f.flagName(), usage, set)
+		}
+	}
+
+	return overrides
+}
+
+// lookup returns the raw value of the flag of the field, and whether it was set.
+func (o *Overrides) lookup(f field) (string, bool) {
+	if o == nil {
+		return "", false
+	}
+	raw, ok := o.values[f.flagName()]
+	return raw, ok
+}
+
+// Redacted returns a copy of the config with the secrets replaced, so that it can be printed.
+//
+// Secrets are the fields with the `secret:"true"` tag. Empty secrets are kept as they are, to show that they are unset.
+func (c Config) Redacted() Config {
+	root := reflect.ValueOf(&c).Elem()
+	for _, f := range fields() {
+		value := root.FieldByIndex(f.index)
+		if f.secret && value.Kind() == reflect.String && value.String() != "" {
+			value.SetString(redacted)
+		}
+	}
+	return c
+}
+
+// applyOverrides sets the config fields that have an environment variable, and then those that have a flag, so that
+// flags take precedence. It returns an error for every invalid value.
+func applyOverrides(config *Config, overrides *Overrides) []error {
+	root := reflect.ValueOf(config).Elem()
+
+	var errs []error
+	for _, f := range fields() {
+		if raw, ok := os.LookupEnv(f.envName()); ok {
+			if err := f.set(root, raw); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value of the %s environment variable: %w", f.envName(), err))
+			}
+		}
+
+		if raw, ok := overrides.lookup(f); ok {
+			if err := f.set(root, raw); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value of the -%s flag: %w", f.flagName(), err))
+			}
+		}
+	}
+
+	return errs
+}
+
+// field is a config field that can be overridden.
+type field struct {
+	// path holds the JSON keys that lead to the field, like ["httpServer", "addr"].
+	path []string
+	// index is the index sequence of the field, for reflect.Value.FieldByIndex.
+	index []int
+	typ   reflect.Type
+	// secret fields are redacted when the config is printed.
+	secret bool
+	// reloadable fields can change without a restart.
+	reloadable bool
+	// defaultValue is the raw value of the `default` tag of the field, if hasDefault.
+	defaultValue string
+	hasDefault   bool
+}
+
+// fields returns all fields of Config that can be overridden, in order of declaration.
+//
+// Sections, like HttpServer, are not fields themselves. Their fields are, recursively.
+func fields() []field {
+	return collectFields(reflect.TypeFor[Config](), nil, nil)
+}
+
+// collectFields returns the fields of the given struct type, which is at the given path and index of Config.
+func collectFields(typ reflect.Type, path []string, index []int) []field {
+	var result []field
+
+	for i := range typ.NumField() {
+		structField := typ.Field(i)
+		name, _, _ := strings.Cut(structField.Tag.Get("json"), ",")
+		if !structField.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = structField.Name
+		}
+
+		f := field{
+			path:   append(slices.Clone(path), name),
+			index:  append(slices.Clone(index), i),
+			typ:    structField.Type,
+			secret: structField.Tag.Get("secret") == "true",
+
+			reloadable: structField.Tag.Get("reload") == "true",
+		}
+		f.defaultValue, f.hasDefault = structField.Tag.Lookup("default")
+
+		// Sections are anonymous structs. Named struct types are values, which are overridden as a whole.
+		if f.typ.Kind() == reflect.Struct && f.typ.Name() == "" {
+			result = append(result, collectFields(f.typ, f.path, f.index)...)
+			continue
+		}
+
+		result = append(result, f)
+	}
+
+	return result
+}
+
+// envName returns the name of the environment variable of the field, like ROSENBRIDGE_HTTPSERVER_ADDR.
+func (f field) envName() string {
+	return envPrefix + strings.ToUpper(strings.Join(f.path, "_"))
+}
+
+// flagName returns the name of the flag of the field, like httpServer.addr.
+func (f field) flagName() string {
+	return strings.Join(f.path, ".")
+}
+
+// set parses the raw value and sets it to the field of the given Config value.
+func (f field) set(root reflect.Value, raw string) error {
+	value, err := parseValue(f.typ, raw)
+	if err != nil {
+		return err
+	}
+
+	root.FieldByIndex(f.index).Set(value)
+	return nil
+}
+
+// parseValue parses the raw value of a field of the given type.
+//
+// Strings are taken as they are, and numbers and booleans are parsed as per strconv. Lists of strings can be comma
+// separated, like "a,b". Any other value, like a list of objects, must be JSON.
+func parseValue(typ reflect.Type, raw string) (reflect.Value, error) {
+	value := reflect.New(typ).Elem()
+
+	switch typ.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not a boolean", raw)
+		}
+		value.SetBool(parsed)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not an integer of %d bits", raw, typ.Bits())
+		}
+		value.SetInt(parsed)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not an unsigned integer of %d bits", raw, typ.Bits())
+		}
+		value.SetUint(parsed)
+
+	default:
+		if typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.String && !isJSONArray(raw) {
+			value.Set(reflect.ValueOf(splitList(raw)).Convert(typ))
+			break
+		}
+
+		if err := json.Unmarshal([]byte(raw), value.Addr().Interface()); err != nil {
+			return reflect.Value{}, fmt.Errorf("invalid JSON: %w", err)
+		}
+	}
+
+	return value, nil
+}
+
+// isJSONArray reports whether the raw value looks like a JSON array, as opposed to a comma separated list.
+func isJSONArray(raw string) bool {
+	return strings.HasPrefix(strings.TrimSpace(raw), "[")
+}
+
+// splitList splits a comma separated list, trimming the spaces around its items. An empty value is an empty list.
+func splitList(raw string) []string {
+	items := []string{}
+	if strings.TrimSpace(raw) == "" {
+		return items
+	}
+
+	for item := range strings.SplitSeq(raw, ",") {
+		items = append(items, strings.TrimSpace(item))
+	}
+	return items
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeConfigFile writes the given JSON to a config file and returns its path.
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+// setRequiredEnv sets the config fields that have no defaults, but are required, with environment variables.
+func setRequiredEnv(t *testing.T) {
+	t.Setenv("ROSENBRIDGE_HTTPSERVER_ADDR", "localhost:8080")
+	t.Setenv("ROSENBRIDGE_DATABASE_USERSFILEPATH", filepath.Join(t.TempDir(), "users.json"))
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	setRequiredEnv(t)
+	path := writeConfigFile(t, `{
+		"httpServer": {"addr": "file:8080", "corsMaxAgeSec": 10, "allowedOrigins": ["https://file.com"]},
+		"logger": {"level": "info"}
+	}`)
+
+	t.Setenv("ROSENBRIDGE_HTTPSERVER_ADDR", "env:8080")
+	t.Setenv("ROSENBRIDGE_HTTPSERVER_CORSMAXAGESEC", "20")
+	t.Setenv("ROSENBRIDGE_METRICS_ENABLED", "true")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	overrides := RegisterFlags(flags)
+	require.NoError(t, flags.Parse([]string{"-httpServer.addr", "flag:8080", "-password.argon2.parallelism=4"}))
+
+	conf, err := Load(path, overrides)
+	require.NoError(t, err)
+
+	// Flags win over the environment, which wins over the file.
+	require.Equal(t, "flag:8080", conf.HttpServer.Addr)
+	require.Equal(t, 20, conf.HttpServer.CorsMaxAgeSec)
+	require.Equal(t, []string{"https://file.com"}, conf.HttpServer.AllowedOrigins)
+	require.Equal(t, "info", conf.Logger.Level)
+	require.True(t, conf.Metrics.Enabled)
+	require.Equal(t, uint8(4), conf.Password.Argon2.Parallelism)
+}
+
+func TestLoad_WithoutFile(t *testing.T) {
+	usersFilePath := filepath.Join(t.TempDir(), "data", "users.json")
+	t.Setenv("ROSENBRIDGE_HTTPSERVER_ADDR", ":8080")
+	t.Setenv("ROSENBRIDGE_DATABASE_USERSFILEPATH", usersFilePath)
+
+	conf, err := Load("", nil)
+	require.NoError(t, err)
+	require.Equal(t, usersFilePath, conf.Database.UsersFilePath)
+}
+
+func TestLoad_Overrides(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		env         string
+		value       string
+		expectedErr string
+		check       func(t *testing.T, conf Config)
+	}{
+		{
+			name:  "Comma separated list, no error expected",
+			env:   "ROSENBRIDGE_HTTPSERVER_ALLOWEDORIGINS",
+			value: "https://a.com, https://b.com",
+			check: func(t *testing.T, conf Config) {
+				require.Equal(t, []string{"https://a.com", "https://b.com"}, conf.HttpServer.AllowedOrigins)
+			},
+		},
+		{
+			name:  "JSON list, no error expected",
+			env:   "ROSENBRIDGE_OIDC_SCOPES",
+			value: `["email", "a,b"]`,
+			check: func(t *testing.T, conf Config) {
+				require.Equal(t, []string{"email", "a,b"}, conf.OIDC.Scopes)
+			},
+		},
+		{
+			name:  "Empty list, no error expected",
+			env:   "ROSENBRIDGE_METRICS_ALLOWEDNETWORKS",
+			value: "",
+			check: func(t *testing.T, conf Config) {
+				require.NotNil(t, conf.Metrics.AllowedNetworks)
+				require.Empty(t, conf.Metrics.AllowedNetworks)
+			},
+		},
+		{
+			name:  "List of objects, no error expected",
+			env:   "ROSENBRIDGE_TOPIC_ACL",
+			value: `[{"topic": "#", "publishers": ["*"]}]`,
+			check: func(t *testing.T, conf Config) {
+				require.Equal(t, []topic.Rule{{Topic: "#", Publishers: []string{"*"}}}, conf.Topic.ACL)
+			},
+		},
+		{
+			name:  "Map of feature flags, no error expected",
+			env:   "ROSENBRIDGE_FRONTEND_FEATURES",
+			value: `{"darkMode": true}`,
+			check: func(t *testing.T, conf Config) {
+				require.Equal(t, map[string]bool{"darkMode": true}, conf.Frontend.Features)
+			},
+		},
+		{
+			name:        "Invalid integer, error expected",
+			env:         "ROSENBRIDGE_MQTT_MAXPACKETBYTES",
+			value:       "64KB",
+			expectedErr: `invalid value of the ROSENBRIDGE_MQTT_MAXPACKETBYTES environment variable`,
+		},
+		{
+			name:        "Integer out of range, error expected",
+			env:         "ROSENBRIDGE_PASSWORD_ARGON2_PARALLELISM",
+			value:       "256",
+			expectedErr: `"256" is not an unsigned integer of 8 bits`,
+		},
+		{
+			name:        "Invalid boolean, error expected",
+			env:         "ROSENBRIDGE_WEBHOOK_ENABLED",
+			value:       "yes",
+			expectedErr: `"yes" is not a boolean`,
+		},
+		{
+			name:        "Invalid JSON, error expected",
+			env:         "ROSENBRIDGE_TOPIC_ACL",
+			value:       `[{"topic": }]`,
+			expectedErr: "invalid JSON",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv(tc.env, tc.value)
+
+			conf, err := Load("", nil)
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			tc.check(t, conf)
+		})
+	}
+}
+
+func TestRegisterFlags_InvalidValue(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	RegisterFlags(flags)
+
+	err := flags.Parse([]string{"-httpServer.corsMaxAgeSec", "forever"})
+	require.ErrorContains(t, err, `"forever" is not an integer of 64 bits`)
+}
+
+func TestFields(t *testing.T) {
+	names := map[string]string{}
+	for _, f := range fields() {
+		names[f.flagName()] = f.envName()
+	}
+
+	// Every leaf is a field, including the ones of nested sections.
+	require.Equal(t, "ROSENBRIDGE_HTTPSERVER_ADDR", names["httpServer.addr"])
+	require.Equal(t, "ROSENBRIDGE_PASSWORD_ARGON2_MEMORYKIB", names["password.argon2.memoryKiB"])
+	require.Equal(t, "ROSENBRIDGE_TOPIC_ACL", names["topic.acl"])
+	// Sections are not fields.
+	require.NotContains(t, names, "httpServer")
+	require.NotContains(t, names, "password.argon2")
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	var conf Config
+	conf.Session.SigningKey = "session-key"
+	conf.OIDC.ClientSecret = "client-secret"
+	conf.OIDC.ClientID = "client-id"
+
+	redactedConf := conf.Redacted()
+	require.Equal(t, redacted, redactedConf.Session.SigningKey)
+	require.Equal(t, redacted, redactedConf.OIDC.ClientSecret)
+	// Other fields, and unset secrets, are kept.
+	require.Equal(t, "client-id", redactedConf.OIDC.ClientID)
+	require.Empty(t, redactedConf.Attachment.SigningKey)
+	// The original is unchanged.
+	require.Equal(t, "session-key", conf.Session.SigningKey)
+}
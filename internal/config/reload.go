@@ -0,0 +1,45 @@
+package config
+
+import (
+	"reflect"
+)
+
+// Reload returns the running config with the reloadable fields of the next one, along with the paths of the fields
+// that changed and were applied, and of those that changed but require a restart, like "httpServer.addr".
+//
+// Reloadable fields are the ones with the `reload:"true"` tag. The others keep their running values, so that the
+// returned config is the one that is in effect.
+func Reload(running, next Config) (Config, []string, []string) {
+	current := reflect.ValueOf(&running).Elem()
+	nextRoot := reflect.ValueOf(next)
+
+	var applied, restartRequired []string
+	for _, f := range fields() {
+		value, nextValue := current.FieldByIndex(f.index), nextRoot.FieldByIndex(f.index)
+		if reflect.DeepEqual(value.Interface(), nextValue.Interface()) {
+			continue
+		}
+
+		if !f.reloadable {
+			restartRequired = append(restartRequired, f.flagName())
+			continue
+		}
+
+		value.Set(nextValue)
+		applied = append(applied, f.flagName())
+	}
+
+	return running, applied, restartRequired
+}
+
+// ReloadableFields returns the paths of the fields that Reload applies, like "logger.level". Changes to all other
+// fields require a restart.
+func ReloadableFields() []string {
+	var paths []string
+	for _, f := range fields() {
+		if f.reloadable {
+			paths = append(paths, f.flagName())
+		}
+	}
+	return paths
+}
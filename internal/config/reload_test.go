@@ -0,0 +1,40 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	running := withDefaults()
+	running.HttpServer.Addr = "localhost:8080"
+
+	next := running
+	next.HttpServer.Addr = "localhost:9090"
+	next.HttpServer.AllowedOrigins = []string{"https://example.com"}
+	next.Logger.Level = "debug"
+	next.Webhook.Workers = 8
+
+	reloaded, applied, restartRequired := Reload(running, next)
+	require.Equal(t, []string{"httpServer.allowedOrigins", "logger.level"}, applied)
+	require.Equal(t, []string{"httpServer.addr", "webhook.workers"}, restartRequired)
+
+	// Only the reloadable fields change.
+	require.Equal(t, []string{"https://example.com"}, reloaded.HttpServer.AllowedOrigins)
+	require.Equal(t, "debug", reloaded.Logger.Level)
+	require.Equal(t, "localhost:8080", reloaded.HttpServer.Addr)
+	require.Equal(t, 4, reloaded.Webhook.Workers)
+	// The running config is unchanged.
+	require.Empty(t, running.HttpServer.AllowedOrigins)
+
+	// Nothing to do for an identical config.
+	_, applied, restartRequired = Reload(reloaded, reloaded)
+	require.Empty(t, applied)
+	require.Empty(t, restartRequired)
+}
+
+func TestReloadableFields(t *testing.T) {
+	expected := []string{"httpServer.allowedOrigins", "httpServer.corsMaxAgeSec", "logger.level"}
+	require.Equal(t, expected, ReloadableFields())
+}
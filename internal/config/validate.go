@@ -0,0 +1,368 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+)
+
+// Accepted values of the enum fields.
+var (
+	logLevels       = []string{"debug", "info", "warn", "warning", "error"}
+	refusedMessages = []string{"drop", "reject"}
+	algorithms      = []string{"bcrypt", "argon2id"}
+)
+
+// Bounds of the bcrypt cost, as per the bcrypt package.
+const (
+	minBcryptCost = 4
+	maxBcryptCost = 31
+)
+
+// negativeAllowed holds the paths of the integer fields that can be negative. All others must not be.
+var negativeAllowed = map[string]struct{}{
+	// A negative value disables idempotency keys, the same as zero.
+	"idempotency.ttlSec": {},
+}
+
+// withDefaults returns a Config whose fields are set to the values of their `default` tags.
+func withDefaults() Config {
+	var config Config
+	root := reflect.ValueOf(&config).Elem()
+
+	for _, f := range fields() {
+		if !f.hasDefault {
+			continue
+		}
+		// The tags are constants, so an invalid one is a programming error.
+		if err := f.set(root, f.defaultValue); err != nil {
+			panic(fmt.Sprintf("invalid default of %s: %v", f.flagName(), err))
+		}
+	}
+
+	return config
+}
+
+// unknownKeys returns the paths of the keys of the decoded JSON value that match no field of the given type, like
+// "httpServer.adr". Keys are matched case-insensitively, like json.Unmarshal does.
+func unknownKeys(value any, typ reflect.Type, path string) []string {
+	var unknown []string
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		// Type mismatches are reported by json.Unmarshal.
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+
+		for key, child := range object {
+			keyPath := strings.TrimPrefix(path+"."+key, ".")
+
+			structField, ok := fieldByKey(typ, key)
+			if !ok {
+				unknown = append(unknown, keyPath)
+				continue
+			}
+
+			unknown = append(unknown, unknownKeys(child, structField.Type, keyPath)...)
+		}
+
+	case reflect.Slice, reflect.Array:
+		items, _ := value.([]any)
+		for i, item := range items {
+			unknown = append(unknown, unknownKeys(item, typ.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+
+	slices.Sort(unknown)
+	return unknown
+}
+
+// fieldByKey returns the field of the struct type that the JSON key is decoded into, and whether there is one.
+func fieldByKey(typ reflect.Type, key string) (reflect.StructField, bool) {
+	for i := range typ.NumField() {
+		structField := typ.Field(i)
+		name, _, _ := strings.Cut(structField.Tag.Get("json"), ",")
+		if name == "" {
+			name = structField.Name
+		}
+		if structField.IsExported() && name != "-" && strings.EqualFold(name, key) {
+			return structField, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// validate checks every field of the config, and returns an error for every problem.
+func (c Config) validate() []error {
+	var errs []error
+	// check records a problem with the field, if the error is not nil.
+	check := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+	}
+
+	// Integers are checked generically, so that new fields are covered too.
+	root := reflect.ValueOf(c)
+	for _, f := range fields() {
+		value := root.FieldByIndex(f.index)
+		if _, allowed := negativeAllowed[f.flagName()]; !allowed && value.CanInt() && value.Int() < 0 {
+			check(f.flagName(), errors.New("must not be negative"))
+		}
+	}
+
+	check("httpServer.addr", validateAddr(c.HttpServer.Addr, true))
+	for i, origin := range c.HttpServer.AllowedOrigins {
+		check(fmt.Sprintf("httpServer.allowedOrigins[%d]", i), validateOrigin(origin))
+	}
+
+	check("logger.level", validateEnum(strings.ToLower(c.Logger.Level), logLevels))
+
+	for i, network := range c.Metrics.AllowedNetworks {
+		check(fmt.Sprintf("metrics.allowedNetworks[%d]", i), validateNetwork(network))
+	}
+
+	check("tracing.endpoint", validateURL(c.Tracing.Endpoint, false))
+
+	check("privacy.refusedMessages", validateEnum(c.Privacy.RefusedMessages, refusedMessages))
+
+	// A store of a single key would defeat the feature.
+	if c.Idempotency.TTLSec > 0 && c.Idempotency.MaxKeys <= 0 {
+		check("idempotency.maxKeys", errors.New("must be positive if idempotency keys are enabled"))
+	}
+
+	check("attachment.dir", validateWritableDir(c.Attachment.Dir))
+	if c.Attachment.Dir != "" {
+		// Zero would reject every upload, expire every link at once, or make the sweeper panic.
+		check("attachment.maxBytes", validatePositive(c.Attachment.MaxBytes))
+		check("attachment.userQuotaBytes", validatePositive(c.Attachment.UserQuotaBytes))
+		check("attachment.ttlSec", validatePositive(int64(c.Attachment.TTLSec)))
+		check("attachment.linkTtlSec", validatePositive(int64(c.Attachment.LinkTTLSec)))
+		check("attachment.sweepIntervalSec", validatePositive(int64(c.Attachment.SweepIntervalSec)))
+	}
+
+	if _, err := topic.NewACL(c.Topic.ACL); err != nil {
+		check("topic.acl", err)
+	}
+
+	check("retained.filePath", validateWritableFile(c.Retained.FilePath, false))
+
+	check("mqtt.addr", validateAddr(c.MQTT.Addr, false))
+
+	check("webhook.deadLetterFilePath", validateWritableFile(c.Webhook.DeadLetterFilePath, false))
+
+	if c.OIDC.Issuer != "" {
+		check("oidc.issuer", validateURL(c.OIDC.Issuer, true))
+		check("oidc.clientId", validateRequired(c.OIDC.ClientID))
+		check("oidc.redirectUrl", validateURL(c.OIDC.RedirectURL, true))
+	}
+	check("oidc.postLoginRedirectUrl", validateURL(c.OIDC.PostLoginRedirectURL, false))
+
+	check("password.algorithm", validateEnum(c.Password.Algorithm, algorithms))
+	if c.Password.BcryptCost < minBcryptCost || c.Password.BcryptCost > maxBcryptCost {
+		check("password.bcryptCost", fmt.Errorf("must be between %d and %d", minBcryptCost, maxBcryptCost))
+	}
+	check("password.breachedListPath", validateReadableFile(c.Password.BreachedListPath))
+
+	check("database.usersFilePath", validateWritableFile(c.Database.UsersFilePath, true))
+
+	check("frontend.backendAddr", validateURL(c.Frontend.BackendAddr, false))
+	check("frontend.path", validateDir(c.Frontend.Path))
+
+	return errs
+}
+
+// validateRequired fails if the value is empty.
+func validateRequired(value string) error {
+	if value == "" {
+		return errors.New("is required")
+	}
+	return nil
+}
+
+// validatePositive fails if the value is not positive.
+func validatePositive(value int64) error {
+	if value <= 0 {
+		return errors.New("must be positive")
+	}
+	return nil
+}
+
+// validateEnum fails if the value is not one of the accepted ones.
+func validateEnum(value string, accepted []string) error {
+	if !slices.Contains(accepted, value) {
+		return fmt.Errorf("%q is not one of %q", value, accepted)
+	}
+	return nil
+}
+
+// validateAddr fails if the value is not a listen address, like "localhost:8080" or ":8080".
+func validateAddr(value string, required bool) error {
+	if value == "" {
+		if required {
+			return errors.New("is required")
+		}
+		return nil
+	}
+
+	_, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a host:port address", value)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("%q is not a valid port", port)
+	}
+	return nil
+}
+
+// validateOrigin fails if the value is neither "*" nor an origin, like "https://example.com".
+func validateOrigin(value string) error {
+	if value == "*" {
+		return nil
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+		parsed.Path != "" || parsed.RawQuery != "" || parsed.Fragment != "" || parsed.User != nil {
+		return fmt.Errorf(`%q is neither "*" nor an origin like "https://example.com"`, value)
+	}
+	return nil
+}
+
+// validateURL fails if the value is not an absolute http or https URL.
+func validateURL(value string, required bool) error {
+	if value == "" {
+		if required {
+			return errors.New("is required")
+		}
+		return nil
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%q is not an absolute http or https URL", value)
+	}
+	return nil
+}
+
+// validateNetwork fails if the value is neither an IP address nor a CIDR network.
+func validateNetwork(value string) error {
+	if _, err := netip.ParsePrefix(value); err == nil {
+		return nil
+	}
+	if _, err := netip.ParseAddr(value); err == nil {
+		return nil
+	}
+	return fmt.Errorf("%q is neither an IP address nor a CIDR network", value)
+}
+
+// validateReadableFile fails if the value is set, and is not a readable file.
+func validateReadableFile(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot be read: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		return fmt.Errorf("%s is not a file", path)
+	}
+	return nil
+}
+
+// validateDir fails if the value is set, and is not an existing directory.
+func validateDir(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot be accessed: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	return nil
+}
+
+// validateWritableFile fails if the file cannot be written, or created along with its missing parent directories.
+func validateWritableFile(path string, required bool) error {
+	if path == "" {
+		if required {
+			return errors.New("is required")
+		}
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return validateCreatable(filepath.Dir(path))
+	}
+	if err != nil {
+		return fmt.Errorf("cannot be accessed: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+
+	// The file is opened without truncation, so its content is kept.
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("is not writable: %w", err)
+	}
+	return file.Close()
+}
+
+// validateWritableDir fails if the value is set, and files cannot be created in the directory, or if it cannot be
+// created.
+func validateWritableDir(path string) error {
+	if path == "" {
+		return nil
+	}
+	return validateCreatable(path)
+}
+
+// validateCreatable fails if files cannot be created in the given directory, which is created later if it does not
+// exist. So, its nearest existing ancestor must be a writable directory.
+func validateCreatable(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if errors.Is(err, fs.ErrNotExist) && filepath.Dir(dir) != dir {
+			dir = filepath.Dir(dir)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot be accessed: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		break
+	}
+
+	// Permission bits do not tell the whole story, because of ACLs and read-only mounts. So, a file is created.
+	file, err := os.CreateTemp(dir, ".rosenbridge-check-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	_ = file.Close()
+	return os.Remove(file.Name())
+}
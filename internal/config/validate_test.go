@@ -0,0 +1,236 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad_ExampleConfig(t *testing.T) {
+	// The paths of the example are relative to the root of the repository.
+	t.Setenv("ROSENBRIDGE_DATABASE_USERSFILEPATH", filepath.Join(t.TempDir(), "users.json"))
+	t.Setenv("ROSENBRIDGE_FRONTEND_PATH", "")
+
+	_, err := Load(filepath.Join("..", "..", "config", "config.example.json"), nil)
+	require.NoError(t, err)
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	setRequiredEnv(t)
+	path := writeConfigFile(t, `{"webhook": {"workers": 8}}`)
+
+	conf, err := Load(path, nil)
+	require.NoError(t, err)
+
+	// Fields that are not set keep their defaults, while the others replace them.
+	require.Equal(t, "info", conf.Logger.Level)
+	require.Equal(t, 8, conf.Webhook.Workers)
+	require.Equal(t, 1000, conf.Webhook.QueueSize)
+	require.Equal(t, []string{"email", "profile"}, conf.OIDC.Scopes)
+	require.Equal(t, uint32(19456), conf.Password.Argon2.MemoryKiB)
+	require.Equal(t, 43200, conf.Session.TTLSec)
+	require.Equal(t, 100000, conf.Idempotency.MaxKeys)
+	require.Equal(t, 300, conf.Attachment.SweepIntervalSec)
+}
+
+func TestLoad_AllProblems(t *testing.T) {
+	t.Setenv("ROSENBRIDGE_MQTT_MAXPACKETBYTES", "large")
+	path := writeConfigFile(t, `{
+		"httpServer": {"adr": ":8080", "corsMaxAgeSec": -1},
+		"logger": {"level": "loud"},
+		"topic": {"acl": [{"topic": "#", "publisher": ["*"]}]},
+		"database": {"usersFilePath": "users.json"}
+	}`)
+
+	_, err := Load(path, nil)
+	require.Error(t, err)
+
+	// Every problem is reported, not just the first one.
+	for _, expected := range []string{
+		"httpServer.adr: unknown field in config file",
+		"topic.acl[0].publisher: unknown field in config file",
+		"invalid value of the ROSENBRIDGE_MQTT_MAXPACKETBYTES environment variable",
+		"httpServer.addr: is required",
+		"httpServer.corsMaxAgeSec: must not be negative",
+		`logger.level: "loud" is not one of`,
+	} {
+		require.ErrorContains(t, err, expected)
+	}
+}
+
+func TestLoad_TypeMismatch(t *testing.T) {
+	setRequiredEnv(t)
+	path := writeConfigFile(t, `{"httpServer": {"corsMaxAgeSec": "forever"}}`)
+
+	_, err := Load(path, nil)
+	require.ErrorContains(t, err, "failed to unmarshal config file")
+}
+
+func TestConfig_validate(t *testing.T) {
+	dir := t.TempDir()
+
+	// A regular file, which cannot be the parent of other files.
+	regularFile := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(regularFile, nil, 0600))
+
+	var testCases = []struct {
+		name        string
+		modify      func(conf *Config)
+		expectedErr string
+	}{
+		{
+			name:   "Valid config, no error expected",
+			modify: func(conf *Config) {},
+		},
+		{
+			name:   "Address without host, no error expected",
+			modify: func(conf *Config) { conf.HttpServer.Addr = ":8080" },
+		},
+		{
+			name:        "Address without port, error expected",
+			modify:      func(conf *Config) { conf.HttpServer.Addr = "localhost" },
+			expectedErr: `httpServer.addr: "localhost" is not a host:port address`,
+		},
+		{
+			name:        "Address with invalid port, error expected",
+			modify:      func(conf *Config) { conf.MQTT.Addr = "localhost:99999" },
+			expectedErr: `mqtt.addr: "99999" is not a valid port`,
+		},
+		{
+			name:   "Wildcard and valid origins, no error expected",
+			modify: func(conf *Config) { conf.HttpServer.AllowedOrigins = []string{"*", "http://localhost:4200"} },
+		},
+		{
+			name:        "Origin with a path, error expected",
+			modify:      func(conf *Config) { conf.HttpServer.AllowedOrigins = []string{"https://example.com/app"} },
+			expectedErr: "httpServer.allowedOrigins[0]",
+		},
+		{
+			name:        "Origin without scheme, error expected",
+			modify:      func(conf *Config) { conf.HttpServer.AllowedOrigins = []string{"example.com"} },
+			expectedErr: "httpServer.allowedOrigins[0]",
+		},
+		{
+			name:   "Upper case log level, no error expected",
+			modify: func(conf *Config) { conf.Logger.Level = "DEBUG" },
+		},
+		{
+			name:        "Invalid network, error expected",
+			modify:      func(conf *Config) { conf.Metrics.AllowedNetworks = []string{"10.0.0.0/8", "intranet"} },
+			expectedErr: `metrics.allowedNetworks[1]: "intranet" is neither an IP address nor a CIDR network`,
+		},
+		{
+			name:        "Relative tracing endpoint, error expected",
+			modify:      func(conf *Config) { conf.Tracing.Endpoint = "localhost:4318" },
+			expectedErr: "tracing.endpoint",
+		},
+		{
+			name:        "Invalid refused messages mode, error expected",
+			modify:      func(conf *Config) { conf.Privacy.RefusedMessages = "bounce" },
+			expectedErr: "privacy.refusedMessages",
+		},
+		{
+			name:   "Negative idempotency TTL, no error expected",
+			modify: func(conf *Config) { conf.Idempotency.TTLSec = -1 },
+		},
+		{
+			name: "Idempotency without keys, error expected",
+			modify: func(conf *Config) {
+				conf.Idempotency.TTLSec = 60
+				conf.Idempotency.MaxKeys = 0
+			},
+			expectedErr: "idempotency.maxKeys: must be positive if idempotency keys are enabled",
+		},
+		{
+			name:        "Negative webhook workers, error expected",
+			modify:      func(conf *Config) { conf.Webhook.Workers = -1 },
+			expectedErr: "webhook.workers: must not be negative",
+		},
+		{
+			name:   "Attachment directory that does not exist yet, no error expected",
+			modify: func(conf *Config) { conf.Attachment.Dir = filepath.Join(dir, "a", "b") },
+		},
+		{
+			name:        "Attachment directory under a file, error expected",
+			modify:      func(conf *Config) { conf.Attachment.Dir = filepath.Join(regularFile, "attachments") },
+			expectedErr: "attachment.dir: cannot be accessed",
+		},
+		{
+			name: "Attachments with a zero sweep interval, error expected",
+			modify: func(conf *Config) {
+				conf.Attachment.Dir = filepath.Join(dir, "attachments")
+				conf.Attachment.SweepIntervalSec = 0
+			},
+			expectedErr: "attachment.sweepIntervalSec: must be positive",
+		},
+		{
+			name:   "Attachments disabled with zero limits, no error expected",
+			modify: func(conf *Config) { conf.Attachment.MaxBytes = 0 },
+		},
+		{
+			name:        "Invalid topic rule, error expected",
+			modify:      func(conf *Config) { conf.Topic.ACL = []topic.Rule{{Topic: "a/#/b"}} },
+			expectedErr: "topic.acl: invalid topic in rule 1",
+		},
+		{
+			name:        "Retained messages file that is a directory, error expected",
+			modify:      func(conf *Config) { conf.Retained.FilePath = dir },
+			expectedErr: "retained.filePath: " + dir + " is a directory",
+		},
+		{
+			name: "OIDC issuer without client, error expected",
+			modify: func(conf *Config) {
+				conf.OIDC.Issuer = "https://accounts.example.com"
+				conf.OIDC.RedirectURL = "https://rosenbridge.example.com/api/auth/oidc/callback"
+			},
+			expectedErr: "oidc.clientId: is required",
+		},
+		{
+			name:        "Invalid password algorithm, error expected",
+			modify:      func(conf *Config) { conf.Password.Algorithm = "md5" },
+			expectedErr: "password.algorithm",
+		},
+		{
+			name:        "Bcrypt cost out of range, error expected",
+			modify:      func(conf *Config) { conf.Password.BcryptCost = 32 },
+			expectedErr: "password.bcryptCost: must be between 4 and 31",
+		},
+		{
+			name:        "Breached list that does not exist, error expected",
+			modify:      func(conf *Config) { conf.Password.BreachedListPath = filepath.Join(dir, "missing.txt") },
+			expectedErr: "password.breachedListPath: cannot be read",
+		},
+		{
+			name:        "Users file under a file, error expected",
+			modify:      func(conf *Config) { conf.Database.UsersFilePath = filepath.Join(regularFile, "users.json") },
+			expectedErr: "database.usersFilePath",
+		},
+		{
+			name:        "Frontend path that is a file, error expected",
+			modify:      func(conf *Config) { conf.Frontend.Path = regularFile },
+			expectedErr: "frontend.path: " + regularFile + " is not a directory",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := withDefaults()
+			conf.HttpServer.Addr = "localhost:8080"
+			conf.Database.UsersFilePath = filepath.Join(dir, "users.json")
+			tc.modify(&conf)
+
+			errs := conf.validate()
+			if tc.expectedErr == "" {
+				require.Empty(t, errs)
+				return
+			}
+
+			require.Len(t, errs, 1)
+			require.ErrorContains(t, errs[0], tc.expectedErr)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var (
@@ -14,6 +15,58 @@ var (
 type User struct {
 	Username     string `json:"username"`
 	PasswordHash string `json:"passwordHash"`
+
+	// Scopes are the permissions of the user. If empty, the user has the default scopes.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Webhook is where the user's messages are POSTed. It is nil if the user has not registered one.
+	Webhook *Webhook `json:"webhook,omitempty"`
+
+	// APIKeys let services authenticate as the user without its password.
+	APIKeys []APIKey `json:"apiKeys,omitempty"`
+
+	// Blocked are the users whose messages the user does not accept.
+	Blocked []string `json:"blocked,omitempty"`
+	// Contacts are the users whose messages the user accepts in contacts-only mode.
+	Contacts []string `json:"contacts,omitempty"`
+	// ContactsOnly makes the user accept messages only from their contacts.
+	ContactsOnly bool `json:"contactsOnly,omitempty"`
+
+	// Identities are the accounts of the user at OpenID Connect providers, which the user can log in with.
+	Identities []Identity `json:"identities,omitempty"`
+}
+
+// Identity is an account at an OpenID Connect provider.
+type Identity struct {
+	// Issuer identifies the provider.
+	Issuer string `json:"issuer"`
+	// Subject identifies the account at the provider. It never changes, unlike the username or email of the account.
+	Subject string `json:"subject"`
+}
+
+// Webhook is a URL registered by a user to receive their messages over HTTP.
+type Webhook struct {
+	URL string `json:"url"`
+	// Secret is the key of the HMAC-SHA256 signature of every delivery.
+	Secret string `json:"secret"`
+	// AllMessages makes every message delivered to the webhook, instead of only those for which the user is offline.
+	AllMessages bool `json:"allMessages"`
+}
+
+// APIKey is a named credential of a user, meant for services.
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Hash is the hex encoded SHA-256 hash of the secret part of the key. The secret is random and long, so a slow
+	// password hash is not needed.
+	Hash string `json:"hash"`
+
+	// Scopes are the permissions of the key. They never exceed the scopes of the user, even if the user loses some.
+	Scopes []string `json:"scopes"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	// LastUsedAt is nil if the key was never used.
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
 }
 
 // Database encapsulates all database operations required by Rosenbridge.
@@ -24,4 +77,16 @@ type Database interface {
 
 	// GetUser fetches the user with the given username from the database. If not found, it returns ErrUserNotFound.
 	GetUser(ctx context.Context, username string) (User, error)
+
+	// GetUserByIdentity fetches the user linked to the given identity from the database. If not found, it returns
+	// ErrUserNotFound.
+	GetUserByIdentity(ctx context.Context, identity Identity) (User, error)
+
+	// UpdateUser atomically reads the user with the given username, passes it to the update function for
+	// modification, and stores the result. If the update function returns an error, nothing is stored and the error is
+	// returned as is. The username must not be modified. If the user does not exist, it returns ErrUserNotFound.
+	UpdateUser(ctx context.Context, username string, update func(user *User) error) error
+
+	// Ping returns an error if the database cannot serve requests, like when its storage is no longer writable.
+	Ping(ctx context.Context) error
 }
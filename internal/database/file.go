@@ -9,9 +9,18 @@ import (
 	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/metrics"
+	"github.com/shivanshkc/rosenbridge/internal/tracing"
 )
 
+// writeDuration is the latency of writing the users file, which happens on every change.
+var writeDuration = metrics.NewHistogram("rosenbridge_database_write_duration_seconds",
+	"Duration of users file writes.", metrics.DefaultBuckets)
+
 // FileDatabase implements Database using the file system.
 type FileDatabase struct {
 	users map[string]User
@@ -58,6 +67,9 @@ func NewFileDatabase(usersFilePath string) (*FileDatabase, error) {
 }
 
 func (f *FileDatabase) InsertUser(ctx context.Context, user User) error {
+	ctx, span := startSpan(ctx, "InsertUser")
+	defer span.End()
+
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
@@ -66,27 +78,41 @@ func (f *FileDatabase) InsertUser(ctx context.Context, user User) error {
 		return ErrUserAlreadyExists
 	}
 
-	// The actual map will be modified only if the file write is successful.
-	clone := maps.Clone(f.users)
-	clone[user.Username] = user
+	return f.write(ctx, user)
+}
 
-	// Marshal for file writing.
-	marshalled, err := json.MarshalIndent(clone, "", "\t")
-	if err != nil {
-		return fmt.Errorf("failed to marshal users data: %w", err)
+func (f *FileDatabase) UpdateUser(ctx context.Context, username string, update func(user *User) error) error {
+	ctx, span := startSpan(ctx, "UpdateUser")
+	defer span.End()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// Error if it does not exist.
+	user, exists := f.users[username]
+	if !exists {
+		return ErrUserNotFound
 	}
 
-	// File write.
-	if err := os.WriteFile(f.usersFilePath, marshalled, 0600); err != nil {
-		return fmt.Errorf("failed to write users file: %w", err)
+	// The stored record may be in use by readers, so the update must not modify its slices in place.
+	user.APIKeys = slices.Clone(user.APIKeys)
+	user.Blocked = slices.Clone(user.Blocked)
+	user.Contacts = slices.Clone(user.Contacts)
+	user.Identities = slices.Clone(user.Identities)
+
+	if err := update(&user); err != nil {
+		return err
 	}
 
-	// File write was successful, now we can replace the actual map.
-	f.users = clone
-	return nil
+	// The username is the key, so it cannot change.
+	user.Username = username
+	return f.write(ctx, user)
 }
 
 func (f *FileDatabase) GetUser(ctx context.Context, username string) (User, error) {
+	_, span := startSpan(ctx, "GetUser")
+	defer span.End()
+
 	f.mutex.RLock()
 	defer f.mutex.RUnlock()
 
@@ -98,6 +124,73 @@ func (f *FileDatabase) GetUser(ctx context.Context, username string) (User, erro
 	return user, nil
 }
 
+func (f *FileDatabase) GetUserByIdentity(ctx context.Context, identity Identity) (User, error) {
+	_, span := startSpan(ctx, "GetUserByIdentity")
+	defer span.End()
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	// Identities are not indexed. Scanning all users is fine, as it only happens on OpenID Connect logins.
+	for _, user := range f.users {
+		if slices.Contains(user.Identities, identity) {
+			return user, nil
+		}
+	}
+
+	return User{}, ErrUserNotFound
+}
+
+// Ping makes sure that the users file can still be written, as every change rewrites it. The file is not modified.
+func (f *FileDatabase) Ping(ctx context.Context) error {
+	_, span := startSpan(ctx, "Ping")
+	defer span.End()
+
+	file, err := os.OpenFile(f.usersFilePath, os.O_WRONLY, 0600)
+	if err != nil {
+		span.SetError(err)
+		return fmt.Errorf("users file is not writable: %w", err)
+	}
+
+	return file.Close()
+}
+
+// startSpan starts the span of a database operation.
+func startSpan(ctx context.Context, operation string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "db "+operation, tracing.KindInternal)
+	span.SetAttribute("db.system", "file")
+	span.SetAttribute("db.operation.name", operation)
+	return ctx, span
+}
+
+// write stores the user record, replacing the existing one if any. The caller must hold the write lock.
+func (f *FileDatabase) write(ctx context.Context, user User) error {
+	// The actual map will be modified only if the file write is successful.
+	clone := maps.Clone(f.users)
+	clone[user.Username] = user
+
+	// Marshal for file writing.
+	marshalled, err := json.MarshalIndent(clone, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal users data: %w", err)
+	}
+
+	// File write.
+	_, span := tracing.Start(ctx, "db write file", tracing.KindInternal)
+	start := time.Now()
+	err = os.WriteFile(f.usersFilePath, marshalled, 0600)
+	writeDuration.Observe(time.Since(start).Seconds())
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return fmt.Errorf("failed to write users file: %w", err)
+	}
+
+	// File write was successful, now we can replace the actual map.
+	f.users = clone
+	return nil
+}
+
 // validateFilePath makes sure that the path is not empty, and that it does not belong to a directory.
 func validateFilePath(path string) error {
 	if path == "" {
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -195,6 +196,94 @@ func TestFileDatabase_GetUser(t *testing.T) {
 	require.Equal(t, User{}, gottenUser2)
 }
 
+func TestFileDatabase_UpdateUser(t *testing.T) {
+	usersFilePath := filepath.Join(t.TempDir(), "users.json")
+	dbase, err := NewFileDatabase(usersFilePath)
+	require.NoError(t, err)
+
+	webhook := &Webhook{URL: "https://example.com"}
+	setWebhook := func(user *User) error {
+		user.Webhook = webhook
+		return nil
+	}
+
+	// Updating a non-inserted user should lead to not found error.
+	err = dbase.UpdateUser(context.Background(), "shivansh", setWebhook)
+	require.ErrorIs(t, err, ErrUserNotFound)
+
+	err = dbase.InsertUser(context.Background(), User{Username: "shivansh", PasswordHash: "123"})
+	require.NoError(t, err)
+
+	// A failing update should not change anything.
+	errUpdate := errors.New("update failed")
+	err = dbase.UpdateUser(context.Background(), "shivansh", func(user *User) error {
+		user.PasswordHash = "456"
+		return errUpdate
+	})
+	require.ErrorIs(t, err, errUpdate)
+
+	err = dbase.UpdateUser(context.Background(), "shivansh", setWebhook)
+	require.NoError(t, err)
+
+	gottenUser, err := dbase.GetUser(context.Background(), "shivansh")
+	require.NoError(t, err)
+	require.Equal(t, User{Username: "shivansh", PasswordHash: "123", Webhook: webhook}, gottenUser)
+
+	// The update must be persisted.
+	reopened, err := NewFileDatabase(usersFilePath)
+	require.NoError(t, err)
+	require.Equal(t, dbase.users, reopened.users)
+
+	// Records returned earlier must not be changed by in-place updates of their slices.
+	err = dbase.UpdateUser(context.Background(), "shivansh", func(user *User) error {
+		user.APIKeys = append(user.APIKeys, APIKey{ID: "1", Name: "first"})
+		return nil
+	})
+	require.NoError(t, err)
+
+	beforeRename, err := dbase.GetUser(context.Background(), "shivansh")
+	require.NoError(t, err)
+
+	err = dbase.UpdateUser(context.Background(), "shivansh", func(user *User) error {
+		user.APIKeys[0].Name = "renamed"
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "first", beforeRename.APIKeys[0].Name)
+}
+
+func TestFileDatabase_GetUserByIdentity(t *testing.T) {
+	usersFilePath := filepath.Join(t.TempDir(), "users.json")
+	dbase, err := NewFileDatabase(usersFilePath)
+	require.NoError(t, err)
+
+	identity := Identity{Issuer: "https://accounts.example.com", Subject: "1234"}
+	insertedUser := User{Username: "shivansh", Identities: []Identity{identity}}
+	require.NoError(t, dbase.InsertUser(context.Background(), insertedUser))
+	require.NoError(t, dbase.InsertUser(context.Background(), User{Username: "alice"}))
+
+	gottenUser, err := dbase.GetUserByIdentity(context.Background(), identity)
+	require.NoError(t, err)
+	require.Equal(t, insertedUser, gottenUser)
+
+	// The same subject at another issuer is another account.
+	_, err = dbase.GetUserByIdentity(context.Background(), Identity{Issuer: "https://other.example.com", Subject: "1234"})
+	require.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestFileDatabase_Ping(t *testing.T) {
+	usersFilePath := filepath.Join(t.TempDir(), "users.json")
+	dbase, err := NewFileDatabase(usersFilePath)
+	require.NoError(t, err)
+
+	require.NoError(t, dbase.Ping(context.Background()))
+
+	// A directory in place of the file makes writes fail, even for root.
+	require.NoError(t, os.Remove(usersFilePath))
+	require.NoError(t, os.Mkdir(usersFilePath, 0700))
+	require.ErrorContains(t, dbase.Ping(context.Background()), "users file is not writable")
+}
+
 // makeInaccessibleFile creates a file in the given temp directory, and then calls chmod on that file to make it
 // inaccessible. It returns the path to the inaccessible file.
 func makeInaccessibleFile(tempDir string) (string, error) {
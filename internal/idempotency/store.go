@@ -0,0 +1,139 @@
+package idempotency
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrFingerprintMismatch = errors.New("idempotency key was already used with a different request")
+	ErrInProgress          = errors.New("a request with the same idempotency key is still in progress")
+)
+
+// Response is the outcome of a request that gets replayed for retries carrying the same idempotency key.
+type Response struct {
+	StatusCode int
+	Body       any
+}
+
+// Store is an in-memory, bounded store of idempotency keys and the responses they produced.
+//
+// Keys are namespaced by an owner (for example, the sender's username), so two owners can use the same key without
+// interfering. Entries expire after the configured TTL, and the oldest entries are evicted once the store is full.
+type Store struct {
+	mutex sync.Mutex
+	// entries maps namespaced keys to their list elements, for O(1) lookups.
+	entries map[string]*list.Element
+	// order holds the entries in insertion order. Since all entries share the same TTL, this is also expiry order.
+	order *list.List
+
+	ttl        time.Duration
+	maxEntries int
+
+	// now is swappable for testing.
+	now func() time.Time
+}
+
+// entry is the value held by each element of Store.order.
+type entry struct {
+	namespacedKey string
+	fingerprint   string
+	expiresAt     time.Time
+	// response is nil while the request is still in progress.
+	response *Response
+}
+
+// NewStore returns a new Store instance.
+//
+// The ttl decides how long a key is remembered, and maxEntries decides how many keys are remembered at most.
+func NewStore(ttl time.Duration, maxEntries int) *Store {
+	return &Store{
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+		ttl:        ttl,
+		maxEntries: max(maxEntries, 1),
+		now:        time.Now,
+	}
+}
+
+// Begin registers the start of a request that carries the given idempotency key.
+//
+// If the key was already used by the same owner with the same fingerprint, the original response is returned and
+// the caller must replay it instead of processing the request again. If the fingerprint differs, it returns
+// ErrFingerprintMismatch. If the original request is still being processed, it returns ErrInProgress.
+//
+// If it returns (nil, nil), the caller must process the request and then call either Complete or Abort.
+func (s *Store) Begin(owner, key, fingerprint string) (*Response, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	s.removeExpired(now)
+
+	namespacedKey := namespace(owner, key)
+	if elem, exists := s.entries[namespacedKey]; exists {
+		stored := elem.Value.(*entry)
+		if stored.fingerprint != fingerprint {
+			return nil, ErrFingerprintMismatch
+		}
+		if stored.response == nil {
+			return nil, ErrInProgress
+		}
+		return stored.response, nil
+	}
+
+	// Make room for the new entry by evicting the oldest ones.
+	for s.order.Len() >= s.maxEntries {
+		s.remove(s.order.Front())
+	}
+
+	newEntry := &entry{namespacedKey: namespacedKey, fingerprint: fingerprint, expiresAt: now.Add(s.ttl)}
+	s.entries[namespacedKey] = s.order.PushBack(newEntry)
+	return nil, nil
+}
+
+// Complete records the response for a request that was registered using Begin.
+// If the entry was evicted in the meantime, this is a no-op.
+func (s *Store) Complete(owner, key string, response Response) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if elem, exists := s.entries[namespace(owner, key)]; exists {
+		elem.Value.(*entry).response = &response
+	}
+}
+
+// Abort forgets a request that was registered using Begin, so the key can be used again.
+// It is meant for requests that failed without any side effects, like validation failures.
+func (s *Store) Abort(owner, key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if elem, exists := s.entries[namespace(owner, key)]; exists && elem.Value.(*entry).response == nil {
+		s.remove(elem)
+	}
+}
+
+// removeExpired removes all expired entries. The caller must hold the mutex.
+func (s *Store) removeExpired(now time.Time) {
+	for elem := s.order.Front(); elem != nil; elem = s.order.Front() {
+		if elem.Value.(*entry).expiresAt.After(now) {
+			return
+		}
+		s.remove(elem)
+	}
+}
+
+// remove removes the given element from the store. The caller must hold the mutex.
+func (s *Store) remove(elem *list.Element) {
+	s.order.Remove(elem)
+	delete(s.entries, elem.Value.(*entry).namespacedKey)
+}
+
+// namespace makes the key unique across owners.
+func namespace(owner, key string) string {
+	// The NUL character is not allowed in usernames or keys, so this cannot collide.
+	return owner + "\x00" + key
+}
@@ -0,0 +1,102 @@
+package idempotency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_BeginComplete(t *testing.T) {
+	store := NewStore(time.Minute, 10)
+
+	// First request proceeds.
+	response, err := store.Begin("alice", "key-1", "fp-1")
+	require.NoError(t, err)
+	require.Nil(t, response)
+
+	// Retry while the first one is in progress.
+	response, err = store.Begin("alice", "key-1", "fp-1")
+	require.ErrorIs(t, err, ErrInProgress)
+	require.Nil(t, response)
+
+	store.Complete("alice", "key-1", Response{StatusCode: 202, Body: "done"})
+
+	// Retry after completion gets the original response.
+	response, err = store.Begin("alice", "key-1", "fp-1")
+	require.NoError(t, err)
+	require.Equal(t, &Response{StatusCode: 202, Body: "done"}, response)
+
+	// Same key with a different request.
+	response, err = store.Begin("alice", "key-1", "fp-2")
+	require.ErrorIs(t, err, ErrFingerprintMismatch)
+	require.Nil(t, response)
+
+	// Same key but a different owner.
+	response, err = store.Begin("bob", "key-1", "fp-2")
+	require.NoError(t, err)
+	require.Nil(t, response)
+}
+
+func TestStore_Abort(t *testing.T) {
+	store := NewStore(time.Minute, 10)
+
+	_, err := store.Begin("alice", "key-1", "fp-1")
+	require.NoError(t, err)
+
+	store.Abort("alice", "key-1")
+
+	// The key is usable again, even with a different request.
+	response, err := store.Begin("alice", "key-1", "fp-2")
+	require.NoError(t, err)
+	require.Nil(t, response)
+
+	// Abort must not remove completed entries.
+	store.Complete("alice", "key-1", Response{StatusCode: 202})
+	store.Abort("alice", "key-1")
+
+	response, err = store.Begin("alice", "key-1", "fp-2")
+	require.NoError(t, err)
+	require.Equal(t, &Response{StatusCode: 202}, response)
+}
+
+func TestStore_TTL(t *testing.T) {
+	now := time.Now()
+	store := NewStore(time.Minute, 10)
+	store.now = func() time.Time { return now }
+
+	_, err := store.Begin("alice", "key-1", "fp-1")
+	require.NoError(t, err)
+	store.Complete("alice", "key-1", Response{StatusCode: 202})
+
+	// Still remembered just before expiry.
+	now = now.Add(time.Minute - time.Second)
+	response, err := store.Begin("alice", "key-1", "fp-1")
+	require.NoError(t, err)
+	require.NotNil(t, response)
+
+	// Forgotten after expiry.
+	now = now.Add(time.Second)
+	response, err = store.Begin("alice", "key-1", "fp-2")
+	require.NoError(t, err)
+	require.Nil(t, response)
+	require.Equal(t, 1, store.order.Len())
+}
+
+func TestStore_MaxEntries(t *testing.T) {
+	store := NewStore(time.Minute, 2)
+
+	for _, key := range []string{"key-1", "key-2", "key-3"} {
+		_, err := store.Begin("alice", key, "fp")
+		require.NoError(t, err)
+		store.Complete("alice", key, Response{StatusCode: 202})
+	}
+
+	require.Equal(t, 2, store.order.Len())
+	require.Len(t, store.entries, 2)
+
+	// The oldest key got evicted.
+	response, err := store.Begin("alice", "key-1", "fp")
+	require.NoError(t, err)
+	require.Nil(t, response)
+}
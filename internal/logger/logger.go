@@ -1,21 +1,47 @@
 package logger
 
 import (
+	"errors"
 	"io"
 	"log/slog"
 	"strings"
 )
 
+// currentLevel is the level of the default logger. It is a slog.LevelVar, so that it can be changed at runtime.
+var currentLevel slog.LevelVar
+
 // Init creates a new slog logger and sets it as the default one.
 //
 // `level` should be one of "debug", "info", "warn" and "error".
 //
 // If `pretty` is true, logs will follow key=value format, otherwise JSON format.
 func Init(destination io.Writer, level string, pretty bool) {
+	if err := SetLevel(level); err != nil {
+		panic(err.Error())
+	}
+
+	// Set logger level. It is shared, so that SetLevel applies to the created logger.
+	options := &slog.HandlerOptions{AddSource: true, Level: &currentLevel}
+
+	var handler slog.Handler
+	if pretty {
+		handler = slog.NewTextHandler(destination, options)
+	} else {
+		handler = slog.NewJSONHandler(destination, options)
+	}
+
+	handler = ContextHandler{Handler: handler}
+	slog.SetDefault(slog.New(handler))
+}
+
+// SetLevel changes the level of the logger created by Init. It can be called while the logger is in use.
+//
+// `lvl` should be one of "debug", "info", "warn" and "error". Otherwise, an error is returned.
+func SetLevel(lvl string) error {
 	var slogLevel slog.Level
 
 	// Convert the given log-level to slog.Level case-insensitively.
-	switch strings.ToLower(level) {
+	switch strings.ToLower(lvl) {
 	case "debug":
 		slogLevel = slog.LevelDebug
 	case "info":
@@ -25,19 +51,9 @@ func Init(destination io.Writer, level string, pretty bool) {
 	case "error":
 		slogLevel = slog.LevelError
 	default:
-		panic("unknown log level provided: " + level)
+		return errors.New("unknown log level provided: " + lvl)
 	}
 
-	// Set logger level.
-	options := &slog.HandlerOptions{AddSource: true, Level: slogLevel}
-
-	var handler slog.Handler
-	if pretty {
-		handler = slog.NewTextHandler(destination, options)
-	} else {
-		handler = slog.NewJSONHandler(destination, options)
-	}
-
-	handler = ContextHandler{Handler: handler}
-	slog.SetDefault(slog.New(handler))
+	currentLevel.Set(slogLevel)
+	return nil
 }
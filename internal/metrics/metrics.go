@@ -0,0 +1,396 @@
+// Package metrics implements counters, gauges and histograms, and exposes them in the Prometheus text format.
+//
+// It only implements what Rosenbridge needs, so that no client library is required. Metrics are usually declared as
+// package level variables with the functions of this package, which register them with the Default registry.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the content type of the text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds of histogram buckets that suit most latencies, in seconds.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry that the functions of this package register metrics with.
+var Default = NewRegistry()
+
+// The kinds of metrics, as named by the text format.
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// Registry holds metrics, and writes them in the text format.
+type Registry struct {
+	mutex    sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry returns a new Registry without any metrics.
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// family is a metric with all its label values.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	// buckets are only set for histograms.
+	buckets []float64
+
+	mutex sync.Mutex
+	// children are the metrics per label values. The key is the label values joined by keySeparator.
+	children map[string]any
+}
+
+// keySeparator cannot appear in label values that are valid UTF-8.
+const keySeparator = "\xff"
+
+// register adds a new family to the registry. It panics if the name is taken, as that is a programming error.
+func (r *Registry) register(name, help, kind string, buckets []float64, labels []string) *family {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.families[name]; exists {
+		panic("metric registered twice: " + name)
+	}
+
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, children: map[string]any{}}
+	r.families[name] = f
+	return f
+}
+
+// child returns the metric for the label values, and creates it if required.
+func (f *family) child(values []string) any {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, keySeparator)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if existing, ok := f.children[key]; ok {
+		return existing
+	}
+
+	var created any
+	switch f.kind {
+	case kindCounter:
+		created = &Counter{}
+	case kindGauge:
+		created = &Gauge{}
+	case kindHistogram:
+		created = &Histogram{buckets: f.buckets, counts: make([]uint64, len(f.buckets))}
+	}
+
+	f.children[key] = created
+	return created
+}
+
+// Counter is a value that only goes up, like the number of requests served.
+type Counter struct {
+	value atomicFloat
+}
+
+// Inc adds 1 to the counter.
+func (c *Counter) Inc() {
+	c.value.add(1)
+}
+
+// Add adds the given value to the counter. It must not be negative.
+func (c *Counter) Add(value float64) {
+	c.value.add(value)
+}
+
+// Gauge is a value that goes up and down, like the number of open connections.
+type Gauge struct {
+	value atomicFloat
+}
+
+// Set sets the gauge to the given value.
+func (g *Gauge) Set(value float64) {
+	g.value.set(value)
+}
+
+// Add adds the given value to the gauge. It can be negative.
+func (g *Gauge) Add(value float64) {
+	g.value.add(value)
+}
+
+// Histogram counts observations, like request latencies, in buckets.
+type Histogram struct {
+	mutex   sync.Mutex
+	buckets []float64
+	// counts are per bucket, and not cumulative. Observations above the last bucket are only in count.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Observe adds a value to the histogram.
+func (h *Histogram) Observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if i, _ := slices.BinarySearch(h.buckets, value); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+}
+
+// CounterVec is a counter with labels.
+type CounterVec struct{ family *family }
+
+// With returns the counter for the label values, which must be in the order of the label names.
+func (c CounterVec) With(values ...string) *Counter {
+	return c.family.child(values).(*Counter)
+}
+
+// GaugeVec is a gauge with labels.
+type GaugeVec struct{ family *family }
+
+// With returns the gauge for the label values, which must be in the order of the label names.
+func (g GaugeVec) With(values ...string) *Gauge {
+	return g.family.child(values).(*Gauge)
+}
+
+// HistogramVec is a histogram with labels.
+type HistogramVec struct{ family *family }
+
+// With returns the histogram for the label values, which must be in the order of the label names.
+func (h HistogramVec) With(values ...string) *Histogram {
+	return h.family.child(values).(*Histogram)
+}
+
+// NewCounter registers a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// NewCounterVec registers a counter with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) CounterVec {
+	return CounterVec{family: r.register(name, help, kindCounter, nil, labels)}
+}
+
+// NewGauge registers a gauge without labels.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+// NewGaugeVec registers a gauge with the given label names.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) GaugeVec {
+	return GaugeVec{family: r.register(name, help, kindGauge, nil, labels)}
+}
+
+// NewHistogram registers a histogram without labels. The buckets are their upper bounds, in increasing order.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+// NewHistogramVec registers a histogram with the given label names. The buckets are their upper bounds, in increasing
+// order.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) HistogramVec {
+	if !slices.IsSorted(buckets) {
+		panic("histogram buckets are not sorted: " + name)
+	}
+	return HistogramVec{family: r.register(name, help, kindHistogram, buckets, labels)}
+}
+
+// NewCounter registers a counter without labels with the Default registry.
+func NewCounter(name, help string) *Counter {
+	return Default.NewCounter(name, help)
+}
+
+// NewCounterVec registers a counter with labels with the Default registry.
+func NewCounterVec(name, help string, labels ...string) CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewGauge registers a gauge without labels with the Default registry.
+func NewGauge(name, help string) *Gauge {
+	return Default.NewGauge(name, help)
+}
+
+// NewGaugeVec registers a gauge with labels with the Default registry.
+func NewGaugeVec(name, help string, labels ...string) GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+// NewHistogram registers a histogram without labels with the Default registry.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return Default.NewHistogram(name, help, buckets)
+}
+
+// NewHistogramVec registers a histogram with labels with the Default registry.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// Handler returns an http.Handler that responds with the metrics of the registry in the text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_ = r.Write(w)
+	})
+}
+
+// Write writes all metrics in the text format, sorted by name and label values.
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mutex.Unlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	buffered := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(buffered)
+	}
+	return buffered.Flush()
+}
+
+// write writes the family in the text format.
+func (f *family) write(w *bufio.Writer) {
+	f.mutex.Lock()
+	keys := make([]string, 0, len(f.children))
+	for key := range f.children {
+		keys = append(keys, key)
+	}
+	children := make([]any, len(keys))
+	sort.Strings(keys)
+	for i, key := range keys {
+		children[i] = f.children[key]
+	}
+	f.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	for i, key := range keys {
+		var values []string
+		if len(f.labels) > 0 {
+			values = strings.Split(key, keySeparator)
+		}
+		labels := formatLabels(f.labels, values)
+
+		switch child := children[i].(type) {
+		case *Counter:
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels, formatFloat(child.value.load()))
+		case *Gauge:
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels, formatFloat(child.value.load()))
+		case *Histogram:
+			child.write(w, f.name, f.labels, values)
+		}
+	}
+}
+
+// write writes the buckets, the sum and the count of the histogram.
+func (h *Histogram) write(w *bufio.Writer, name string, labelNames, labelValues []string) {
+	h.mutex.Lock()
+	counts, sum, count := slices.Clone(h.counts), h.sum, h.count
+	h.mutex.Unlock()
+
+	bucketLabels := append(slices.Clone(labelNames), "le")
+
+	// Buckets are cumulative in the text format.
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += counts[i]
+		labels := formatLabels(bucketLabels, append(slices.Clone(labelValues), formatFloat(bound)))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels, cumulative)
+	}
+	labels := formatLabels(bucketLabels, append(slices.Clone(labelValues), "+Inf"))
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels, count)
+
+	labels = formatLabels(labelNames, labelValues)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, count)
+}
+
+// formatLabels returns the labels in the text format, like {a="1",b="2"}, or an empty string if there are none.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(name + `="` + escapeLabelValue(values[i]) + `"`)
+	}
+	builder.WriteByte('}')
+	return builder.String()
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// formatFloat formats the value as the text format expects, including infinities.
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// atomicFloat is a float64 that can be updated atomically.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (a *atomicFloat) load() float64 {
+	return math.Float64frombits(a.bits.Load())
+}
+
+func (a *atomicFloat) set(value float64) {
+	a.bits.Store(math.Float64bits(value))
+}
+
+func (a *atomicFloat) add(delta float64) {
+	for {
+		old := a.bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if a.bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	registry := NewRegistry()
+
+	requests := registry.NewCounterVec("test_requests_total", "Requests served.", "route", "status")
+	requests.With("/api", "200").Inc()
+	requests.With("/api", "200").Add(2)
+	requests.With(`/a"b\c`, "500").Inc()
+
+	connections := registry.NewGauge("test_connections", "Open connections.\nWith a newline.")
+	connections.Add(3)
+	connections.Add(-1)
+
+	latency := registry.NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	latency.With("/api").Observe(0.05)
+	latency.With("/api").Observe(0.1)
+	latency.With("/api").Observe(0.5)
+	latency.With("/api").Observe(5)
+
+	// Metrics without labels are written before they are used.
+	registry.NewCounter("test_unused_total", "Unused.")
+
+	var builder strings.Builder
+	require.NoError(t, registry.Write(&builder))
+
+	expected := `# HELP test_connections Open connections.\nWith a newline.
+# TYPE test_connections gauge
+test_connections 2
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="/api",le="0.1"} 2
+test_latency_seconds_bucket{route="/api",le="1"} 3
+test_latency_seconds_bucket{route="/api",le="+Inf"} 4
+test_latency_seconds_sum{route="/api"} 5.65
+test_latency_seconds_count{route="/api"} 4
+# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total{route="/a\"b\\c",status="500"} 1
+test_requests_total{route="/api",status="200"} 3
+# HELP test_unused_total Unused.
+# TYPE test_unused_total counter
+test_unused_total 0
+`
+	require.Equal(t, expected, builder.String())
+}
+
+func TestRegistry_Panics(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("test_total", "Test.", "label")
+
+	require.PanicsWithValue(t, "metric registered twice: test_total", func() {
+		registry.NewCounter("test_total", "Test.")
+	})
+	require.PanicsWithValue(t, "metric test_total has 1 labels, got 2 values", func() {
+		counter.With("a", "b")
+	})
+	require.PanicsWithValue(t, "histogram buckets are not sorted: test_seconds", func() {
+		registry.NewHistogram("test_seconds", "Test.", []float64{1, 0.1})
+	})
+}
+
+func TestCounter_Concurrent(t *testing.T) {
+	counter := NewRegistry().NewCounter("test_total", "Test.")
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			for range 1000 {
+				counter.Inc()
+			}
+		})
+	}
+	wg.Wait()
+
+	require.Equal(t, float64(10000), counter.value.load())
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewGauge("test_gauge", "Test.").Set(1.5)
+
+	w := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	require.Equal(t, "# HELP test_gauge Test.\n# TYPE test_gauge gauge\ntest_gauge 1.5\n", w.Body.String())
+}
@@ -0,0 +1,112 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+)
+
+// mqttSingleLevelWildcard is the MQTT equivalent of topic.SingleLevelWildcard.
+const mqttSingleLevelWildcard = "+"
+
+// userTopicPrefix is the prefix of the topics that address users, like "user/alice". Clients subscribe to their own
+// to receive direct messages, and publish to that of another user to send them one.
+const userTopicPrefix = "user" + topic.Separator
+
+// binaryContentType is the content type of MQTT payloads that are neither JSON objects nor UTF-8 text.
+const binaryContentType = "application/octet-stream"
+
+var errAsteriskLevel = errors.New("the * topic level is reserved")
+
+// userTopic returns the topic that addresses the given user.
+func userTopic(username string) string {
+	return userTopicPrefix + username
+}
+
+// isUserTopic reports whether the topic name or filter is one of the topics that address users.
+func isUserTopic(name string) bool {
+	return strings.HasPrefix(name, userTopicPrefix)
+}
+
+// toFilter converts an MQTT topic filter into a Rosenbridge topic filter. The "#" wildcard is the same in both, but
+// "+" becomes "*". A literal "*" level is rejected, as it is a wildcard in Rosenbridge.
+func toFilter(mqttFilter string) (string, error) {
+	levels := strings.Split(mqttFilter, topic.Separator)
+	for i, level := range levels {
+		switch level {
+		case mqttSingleLevelWildcard:
+			levels[i] = topic.SingleLevelWildcard
+		case topic.SingleLevelWildcard:
+			return "", errAsteriskLevel
+		}
+	}
+
+	filter := strings.Join(levels, topic.Separator)
+	if err := topic.ValidateFilter(filter); err != nil {
+		return "", fmt.Errorf("invalid topic filter: %w", err)
+	}
+
+	return filter, nil
+}
+
+// toMessage converts the payload of an MQTT PUBLISH packet into a message:
+//   - A JSON object becomes the JSON payload of the message.
+//   - Any other UTF-8 text becomes the message text.
+//   - Anything else becomes binary data.
+func toMessage(sender, topicName string, payload []byte) protocol.MessageReceived {
+	message := protocol.MessageReceived{Sender: sender, Topic: topicName}
+
+	// The payload slice belongs to the read buffer of the packet, so it is cloned.
+	payload = bytes.Clone(payload)
+
+	trimmed := bytes.TrimSpace(payload)
+	switch {
+	case len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(trimmed):
+		message.Payload = payload
+	case utf8.Valid(payload):
+		message.Message = string(payload)
+	default:
+		message.Binary = &protocol.Binary{ContentType: binaryContentType, Data: payload}
+	}
+
+	return message
+}
+
+// toPayload converts a message into the payload of an MQTT PUBLISH packet. A message that only has one of text, JSON
+// payload or binary data is sent as is. Any other message is sent as the JSON encoding of the MessageReceived event
+// body.
+func toPayload(message protocol.MessageReceived) ([]byte, error) {
+	hasText := message.Message != ""
+	hasPayload := len(message.Payload) > 0 && string(message.Payload) != "null"
+	hasBinary := message.Binary != nil
+	hasAttachments := len(message.Attachments) > 0
+
+	switch {
+	case !hasPayload && !hasBinary && !hasAttachments:
+		return []byte(message.Message), nil
+	case !hasText && !hasBinary && !hasAttachments:
+		return message.Payload, nil
+	case !hasText && !hasPayload && !hasAttachments:
+		return message.Binary.Data, nil
+	}
+
+	return toDirectPayload(message)
+}
+
+// toDirectPayload converts a direct message into the payload of an MQTT PUBLISH packet. It is always the JSON
+// encoding of the MessageReceived event body, since the topic names the receiver, so only the body can name the
+// sender.
+func toDirectPayload(message protocol.MessageReceived) ([]byte, error) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	return payload, nil
+}
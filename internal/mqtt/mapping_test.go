@@ -0,0 +1,89 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestToFilter(t *testing.T) {
+	var testCases = []struct {
+		input         string
+		expected      string
+		expectedError bool
+	}{
+		{input: "a/b", expected: "a/b"},
+		{input: "a/+/c", expected: "a/*/c"},
+		{input: "+/#", expected: "*/#"},
+		{input: "#", expected: "#"},
+		{input: "a/*", expectedError: true},
+		{input: "a/#/c", expectedError: true},
+		{input: "a//b", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			filter, err := toFilter(tc.input)
+			if tc.expectedError {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, filter)
+		})
+	}
+}
+
+func TestToMessage(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		input    []byte
+		expected protocol.MessageReceived
+	}{
+		{
+			name:     "Text",
+			input:    []byte("21.5"),
+			expected: protocol.MessageReceived{Sender: "alice", Topic: "t", Message: "21.5"},
+		},
+		{
+			name:     "JSON object",
+			input:    []byte(`{"temp":21.5}`),
+			expected: protocol.MessageReceived{Sender: "alice", Topic: "t", Payload: json.RawMessage(`{"temp":21.5}`)},
+		},
+		{
+			name:  "Binary",
+			input: []byte{0xFF, 0x00},
+			expected: protocol.MessageReceived{Sender: "alice", Topic: "t",
+				Binary: &protocol.Binary{ContentType: binaryContentType, Data: []byte{0xFF, 0x00}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			message := toMessage("alice", "t", tc.input)
+			require.Equal(t, tc.expected, message)
+
+			// Single kind messages convert back to the same payload.
+			payload, err := toPayload(message)
+			require.NoError(t, err)
+			require.Equal(t, tc.input, payload)
+		})
+	}
+}
+
+func TestToPayload_MixedMessage(t *testing.T) {
+	message := protocol.MessageReceived{
+		Sender:  "alice",
+		Topic:   "t",
+		Message: "hello",
+		Payload: json.RawMessage(`{"a":1}`),
+	}
+
+	payload, err := toPayload(message)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"message":"hello","payload":{"a":1},"sender":"alice","topic":"t"}`, string(payload))
+}
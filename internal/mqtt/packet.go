@@ -0,0 +1,427 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Control packet types of MQTT 3.1.1.
+const (
+	packetConnect     byte = 1
+	packetConnack     byte = 2
+	packetPublish     byte = 3
+	packetPuback      byte = 4
+	packetPubrec      byte = 5
+	packetPubrel      byte = 6
+	packetPubcomp     byte = 7
+	packetSubscribe   byte = 8
+	packetSuback      byte = 9
+	packetUnsubscribe byte = 10
+	packetUnsuback    byte = 11
+	packetPingreq     byte = 12
+	packetPingresp    byte = 13
+	packetDisconnect  byte = 14
+)
+
+// Return codes of the CONNACK packet.
+const (
+	connackAccepted              byte = 0
+	connackUnacceptableProtocol  byte = 1
+	connackIdentifierRejected    byte = 2
+	connackServerUnavailable     byte = 3
+	connackBadUsernameOrPassword byte = 4
+	connackNotAuthorized         byte = 5
+)
+
+// Protocol name and level of MQTT 3.1.1.
+const (
+	protocolName  = "MQTT"
+	protocolLevel = 4
+)
+
+// subackFailure is the SUBACK return code for a rejected subscription.
+const subackFailure byte = 0x80
+
+var (
+	errMalformedPacket = errors.New("malformed packet")
+	errPacketTooLarge  = errors.New("packet too large")
+)
+
+// packet is a raw control packet.
+type packet struct {
+	// kind is the control packet type, one of the packetXXX constants.
+	kind byte
+	// flags are the lower 4 bits of the fixed header.
+	flags byte
+	// body is the variable header and the payload.
+	body []byte
+}
+
+// readPacket reads one control packet. Packets larger than maxBytes (excluding the fixed header) are rejected
+// without being read.
+func readPacket(reader *bufio.Reader, maxBytes int) (packet, error) {
+	first, err := reader.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	// The remaining length is a variable length integer of at most 4 bytes.
+	var length, multiplier int = 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, fmt.Errorf("%w: invalid remaining length", errMalformedPacket)
+		}
+
+		encoded, err := reader.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+
+		length += int(encoded&0x7F) * multiplier
+		multiplier *= 128
+
+		if encoded&0x80 == 0 {
+			break
+		}
+	}
+
+	if length > maxBytes {
+		return packet{}, fmt.Errorf("%w: %d bytes", errPacketTooLarge, length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return packet{}, err
+	}
+
+	return packet{kind: first >> 4, flags: first & 0x0F, body: body}, nil
+}
+
+// encode returns the wire format of the packet.
+func (p packet) encode() []byte {
+	encoded := make([]byte, 0, len(p.body)+5)
+	encoded = append(encoded, p.kind<<4|p.flags&0x0F)
+
+	length := len(p.body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		encoded = append(encoded, digit)
+		if length == 0 {
+			break
+		}
+	}
+
+	return append(encoded, p.body...)
+}
+
+// decoder reads the fields of a packet body. The first error is sticky, so the fields can be read without checking
+// for errors after each one.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(reason string) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", errMalformedPacket, reason)
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.data) < 1 {
+		d.fail("unexpected end of packet")
+		return 0
+	}
+	value := d.data[0]
+	d.data = d.data[1:]
+	return value
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.data) < 2 {
+		d.fail("unexpected end of packet")
+		return 0
+	}
+	value := binary.BigEndian.Uint16(d.data)
+	d.data = d.data[2:]
+	return value
+}
+
+// bytes reads a length prefixed byte slice.
+func (d *decoder) bytes() []byte {
+	length := int(d.uint16())
+	if d.err != nil || len(d.data) < length {
+		d.fail("unexpected end of packet")
+		return nil
+	}
+	value := d.data[:length]
+	d.data = d.data[length:]
+	return value
+}
+
+// string reads a length prefixed UTF-8 string. As per the spec, it must not contain the null character.
+func (d *decoder) string() string {
+	value := d.bytes()
+	if d.err != nil {
+		return ""
+	}
+	if !utf8.Valid(value) || bytes.IndexByte(value, 0) >= 0 {
+		d.fail("invalid UTF-8 string")
+		return ""
+	}
+	return string(value)
+}
+
+// rest returns all the unread bytes.
+func (d *decoder) rest() []byte {
+	value := d.data
+	d.data = nil
+	return value
+}
+
+// empty reports whether all bytes have been read.
+func (d *decoder) empty() bool {
+	return len(d.data) == 0
+}
+
+// encoder writes the fields of a packet body.
+type encoder struct {
+	data []byte
+}
+
+func (e *encoder) byte(value byte) {
+	e.data = append(e.data, value)
+}
+
+func (e *encoder) uint16(value uint16) {
+	e.data = binary.BigEndian.AppendUint16(e.data, value)
+}
+
+func (e *encoder) string(value string) {
+	e.uint16(uint16(len(value)))
+	e.data = append(e.data, value...)
+}
+
+func (e *encoder) raw(value []byte) {
+	e.data = append(e.data, value...)
+}
+
+// connectPacket is a decoded CONNECT packet.
+type connectPacket struct {
+	protocolName  string
+	protocolLevel byte
+	cleanSession  bool
+	keepAliveSec  uint16
+	clientID      string
+
+	// will is the message published on behalf of the client if it disconnects without a DISCONNECT packet.
+	will *publishPacket
+
+	username    string
+	hasUsername bool
+	password    string
+	hasPassword bool
+}
+
+// decodeConnect decodes the body of a CONNECT packet.
+//
+// If the protocol name or level is not supported, it returns the packet without decoding the rest of it, so that the
+// caller can respond appropriately.
+func decodeConnect(body []byte) (connectPacket, error) {
+	d := &decoder{data: body}
+
+	var p connectPacket
+	p.protocolName = d.string()
+	p.protocolLevel = d.byte()
+	if d.err != nil || p.protocolName != protocolName || p.protocolLevel != protocolLevel {
+		return p, d.err
+	}
+
+	flags := d.byte()
+	p.keepAliveSec = d.uint16()
+	p.clientID = d.string()
+
+	if flags&0x01 != 0 {
+		d.fail("reserved connect flag is set")
+	}
+
+	p.cleanSession = flags&0x02 != 0
+
+	if flags&0x04 != 0 {
+		will := &publishPacket{qos: flags >> 3 & 0x03, retain: flags&0x20 != 0}
+		will.topic = d.string()
+		will.payload = d.bytes()
+		if will.qos > 2 {
+			d.fail("invalid will QoS")
+		}
+		p.will = will
+	} else if flags&0x38 != 0 {
+		d.fail("will flags are set without a will")
+	}
+
+	if p.hasUsername = flags&0x80 != 0; p.hasUsername {
+		p.username = d.string()
+	}
+
+	if p.hasPassword = flags&0x40 != 0; p.hasPassword {
+		p.password = string(d.bytes())
+		if !p.hasUsername {
+			d.fail("password is set without a username")
+		}
+	}
+
+	if d.err == nil && !d.empty() {
+		d.fail("unexpected trailing bytes")
+	}
+
+	return p, d.err
+}
+
+// encodeConnack returns a CONNACK packet.
+func encodeConnack(returnCode byte) packet {
+	// Sessions are never persisted, so the session present flag is always 0.
+	return packet{kind: packetConnack, body: []byte{0, returnCode}}
+}
+
+// publishPacket is a decoded PUBLISH packet.
+type publishPacket struct {
+	topic    string
+	packetID uint16
+	qos      byte
+	retain   bool
+	dup      bool
+	payload  []byte
+}
+
+// decodePublish decodes a PUBLISH packet.
+func decodePublish(p packet) (publishPacket, error) {
+	d := &decoder{data: p.body}
+
+	publish := publishPacket{
+		dup:    p.flags&0x08 != 0,
+		qos:    p.flags >> 1 & 0x03,
+		retain: p.flags&0x01 != 0,
+	}
+
+	if publish.qos > 2 {
+		return publishPacket{}, fmt.Errorf("%w: invalid QoS", errMalformedPacket)
+	}
+
+	publish.topic = d.string()
+	if publish.qos > 0 {
+		publish.packetID = d.uint16()
+	}
+	publish.payload = d.rest()
+
+	return publish, d.err
+}
+
+// encode returns the PUBLISH packet.
+func (p publishPacket) encode() packet {
+	flags := p.qos << 1
+	if p.retain {
+		flags |= 0x01
+	}
+	if p.dup {
+		flags |= 0x08
+	}
+
+	e := &encoder{}
+	e.string(p.topic)
+	if p.qos > 0 {
+		e.uint16(p.packetID)
+	}
+	e.raw(p.payload)
+
+	return packet{kind: packetPublish, flags: flags, body: e.data}
+}
+
+// subscription is a topic filter along with its requested QoS.
+type subscription struct {
+	filter string
+	qos    byte
+}
+
+// subscribePacket is a decoded SUBSCRIBE packet.
+type subscribePacket struct {
+	packetID      uint16
+	subscriptions []subscription
+}
+
+// decodeSubscribe decodes the body of a SUBSCRIBE packet.
+func decodeSubscribe(body []byte) (subscribePacket, error) {
+	d := &decoder{data: body}
+
+	p := subscribePacket{packetID: d.uint16()}
+	for d.err == nil && !d.empty() {
+		sub := subscription{filter: d.string(), qos: d.byte()}
+		if sub.qos > 2 {
+			d.fail("invalid requested QoS")
+		}
+		p.subscriptions = append(p.subscriptions, sub)
+	}
+
+	if d.err == nil && len(p.subscriptions) == 0 {
+		d.fail("no topic filters")
+	}
+
+	return p, d.err
+}
+
+// unsubscribePacket is a decoded UNSUBSCRIBE packet.
+type unsubscribePacket struct {
+	packetID uint16
+	filters  []string
+}
+
+// decodeUnsubscribe decodes the body of an UNSUBSCRIBE packet.
+func decodeUnsubscribe(body []byte) (unsubscribePacket, error) {
+	d := &decoder{data: body}
+
+	p := unsubscribePacket{packetID: d.uint16()}
+	for d.err == nil && !d.empty() {
+		p.filters = append(p.filters, d.string())
+	}
+
+	if d.err == nil && len(p.filters) == 0 {
+		d.fail("no topic filters")
+	}
+
+	return p, d.err
+}
+
+// decodePacketID decodes the body of the packets that only carry a packet identifier, like PUBACK.
+func decodePacketID(body []byte) (uint16, error) {
+	d := &decoder{data: body}
+
+	packetID := d.uint16()
+	if d.err == nil && !d.empty() {
+		d.fail("unexpected trailing bytes")
+	}
+
+	return packetID, d.err
+}
+
+// encodePacketID returns a packet of the given type that only carries a packet identifier, like PUBACK.
+func encodePacketID(kind byte, packetID uint16) packet {
+	e := &encoder{}
+	e.uint16(packetID)
+	return packet{kind: kind, body: e.data}
+}
+
+// encodeSuback returns a SUBACK packet.
+func encodeSuback(packetID uint16, returnCodes []byte) packet {
+	e := &encoder{}
+	e.uint16(packetID)
+	e.raw(returnCodes)
+	return packet{kind: packetSuback, body: e.data}
+}
@@ -0,0 +1,215 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPacket(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		input         []byte
+		maxBytes      int
+		expected      packet
+		expectedError error
+	}{
+		{
+			name:     "Empty body",
+			input:    []byte{0xC0, 0x00},
+			maxBytes: 10,
+			expected: packet{kind: packetPingreq, body: []byte{}},
+		},
+		{
+			name:     "Flags are split from the type",
+			input:    []byte{0x82, 0x02, 0x00, 0x01},
+			maxBytes: 10,
+			expected: packet{kind: packetSubscribe, flags: 0x02, body: []byte{0x00, 0x01}},
+		},
+		{
+			name:          "Remaining length longer than 4 bytes, error expected",
+			input:         []byte{0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0x01},
+			maxBytes:      10,
+			expectedError: errMalformedPacket,
+		},
+		{
+			name:          "Body larger than the limit, error expected",
+			input:         []byte{0x30, 0x0B},
+			maxBytes:      10,
+			expectedError: errPacketTooLarge,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := readPacket(bufio.NewReader(bytes.NewReader(tc.input)), tc.maxBytes)
+			if tc.expectedError != nil {
+				require.ErrorIs(t, err, tc.expectedError)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, p)
+		})
+	}
+}
+
+func TestPacket_Encode(t *testing.T) {
+	// These lengths are the boundaries of the variable length encoding.
+	for _, length := range []int{0, 127, 128, 16383, 16384, 2097152} {
+		original := packet{kind: packetPublish, flags: 0x03, body: bytes.Repeat([]byte{'x'}, length)}
+
+		decoded, err := readPacket(bufio.NewReader(bytes.NewReader(original.encode())), length)
+		require.NoError(t, err)
+		require.Equal(t, original, decoded)
+	}
+}
+
+func TestDecodeConnect(t *testing.T) {
+	// makeBody returns the body of a CONNECT packet with the given flags and payload.
+	makeBody := func(flags byte, payload ...string) []byte {
+		e := &encoder{}
+		e.string(protocolName)
+		e.byte(protocolLevel)
+		e.byte(flags)
+		e.uint16(30)
+		for _, field := range payload {
+			e.string(field)
+		}
+		return e.data
+	}
+
+	var testCases = []struct {
+		name          string
+		input         []byte
+		expected      connectPacket
+		expectedError bool
+	}{
+		{
+			name:  "Username and password",
+			input: makeBody(0xC2, "sensor", "alice", "secret"),
+			expected: connectPacket{
+				protocolName:  protocolName,
+				protocolLevel: protocolLevel,
+				cleanSession:  true,
+				keepAliveSec:  30,
+				clientID:      "sensor",
+				username:      "alice",
+				hasUsername:   true,
+				password:      "secret",
+				hasPassword:   true,
+			},
+		},
+		{
+			name:  "Will with QoS 1 and retain",
+			input: makeBody(0x2C, "sensor", "status/sensor", "offline"),
+			expected: connectPacket{
+				protocolName:  protocolName,
+				protocolLevel: protocolLevel,
+				keepAliveSec:  30,
+				clientID:      "sensor",
+				will: &publishPacket{
+					topic:   "status/sensor",
+					qos:     1,
+					retain:  true,
+					payload: []byte("offline"),
+				},
+			},
+		},
+		{
+			name:          "Reserved flag, error expected",
+			input:         makeBody(0x01, "sensor"),
+			expectedError: true,
+		},
+		{
+			name:          "Will QoS without a will, error expected",
+			input:         makeBody(0x08, "sensor"),
+			expectedError: true,
+		},
+		{
+			name:          "Password without username, error expected",
+			input:         makeBody(0x40, "sensor", "secret"),
+			expectedError: true,
+		},
+		{
+			name:          "Missing client ID, error expected",
+			input:         makeBody(0x02),
+			expectedError: true,
+		},
+		{
+			name:          "Trailing bytes, error expected",
+			input:         makeBody(0x02, "sensor", "extra"),
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := decodeConnect(tc.input)
+			if tc.expectedError {
+				require.ErrorIs(t, err, errMalformedPacket)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, p)
+		})
+	}
+}
+
+func TestDecodeConnect_UnsupportedProtocol(t *testing.T) {
+	e := &encoder{}
+	e.string("MQIsdp")
+	e.byte(3)
+
+	// The rest of the packet is not read, so that the server can respond as per the protocol version.
+	p, err := decodeConnect(e.data)
+	require.NoError(t, err)
+	require.Equal(t, "MQIsdp", p.protocolName)
+	require.Equal(t, byte(3), p.protocolLevel)
+}
+
+func TestPublishPacket_RoundTrip(t *testing.T) {
+	for _, original := range []publishPacket{
+		{topic: "a/b", payload: []byte("hello")},
+		{topic: "a/b", packetID: 7, qos: 1, retain: true, dup: true, payload: []byte{}},
+	} {
+		decoded, err := decodePublish(original.encode())
+		require.NoError(t, err)
+		require.Equal(t, original, decoded)
+	}
+
+	_, err := decodePublish(packet{kind: packetPublish, flags: 0x06})
+	require.ErrorIs(t, err, errMalformedPacket)
+}
+
+func TestDecodeSubscribe(t *testing.T) {
+	e := &encoder{}
+	e.uint16(1)
+	e.string("a/+")
+	e.byte(1)
+	e.string("b/#")
+	e.byte(0)
+
+	p, err := decodeSubscribe(e.data)
+	require.NoError(t, err)
+	require.Equal(t, subscribePacket{
+		packetID:      1,
+		subscriptions: []subscription{{filter: "a/+", qos: 1}, {filter: "b/#", qos: 0}},
+	}, p)
+
+	// A subscribe packet must have at least one filter.
+	_, err = decodeSubscribe([]byte{0x00, 0x01})
+	require.ErrorIs(t, err, errMalformedPacket)
+
+	// Strings must not contain the null character.
+	e = &encoder{}
+	e.uint16(1)
+	e.string(strings.Repeat("\x00", 2))
+	e.byte(0)
+	_, err = decodeSubscribe(e.data)
+	require.ErrorIs(t, err, errMalformedPacket)
+}
@@ -0,0 +1,249 @@
+// Package mqtt implements an MQTT 3.1.1 listener that bridges MQTT clients to the topics of the broker, and to the
+// direct messages of users through the "user/<username>" topics.
+//
+// It supports CONNECT, PUBLISH at QoS 0 and 1, SUBSCRIBE, UNSUBSCRIBE, PINGREQ and DISCONNECT. Sessions are never
+// persisted, so every connection starts with a clean session, regardless of the clean session flag.
+package mqtt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/shivanshkc/rosenbridge/internal/broker"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+)
+
+// defaultMaxPacketBytes is used when the configured max packet size is not positive.
+const defaultMaxPacketBytes = 64 * 1024
+
+// ErrServerClosed is returned by Serve after Close is called.
+var ErrServerClosed = errors.New("mqtt: server closed")
+
+// Handler handles what the broker does not, that is, authentication and direct messages.
+type Handler interface {
+	// Authenticate verifies the credentials of a user, and returns the scopes that they grant. It returns false, with
+	// a nil error, if they are wrong. Errors are reserved for unexpected failures.
+	Authenticate(ctx context.Context, username, password string) (scope.Set, bool, error)
+
+	// CanReceive reports whether the user, authenticated with the given scopes, is allowed to receive direct messages.
+	CanReceive(username string, scopes scope.Set) bool
+
+	// ValidateMessage validates a message that is to be published to a topic, like its size.
+	ValidateMessage(message protocol.MessageReceived) error
+
+	// SendMessage validates the message and sends it to the given receivers. The scopes are those returned by
+	// Authenticate for the sender.
+	SendMessage(ctx context.Context, message protocol.MessageReceived, scopes scope.Set, receivers []string) error
+}
+
+// Server is an MQTT 3.1.1 server. It implements broker.Deliverer, so that its clients receive the messages published
+// through any protocol.
+type Server struct {
+	broker  *broker.Broker
+	handler Handler
+	// maxPacketBytes is the max size of the packets sent by clients, excluding the fixed header.
+	maxPacketBytes int
+
+	mutex    sync.Mutex
+	sessions map[string]*session
+	listener net.Listener
+	closed   bool
+
+	// connections tracks the goroutines that serve connections.
+	connections sync.WaitGroup
+}
+
+// NewServer returns a new Server instance. Use Serve to start accepting connections.
+func NewServer(bus *broker.Broker, handler Handler, maxPacketBytes int) *Server {
+	if maxPacketBytes <= 0 {
+		maxPacketBytes = defaultMaxPacketBytes
+	}
+
+	return &Server{
+		broker:         bus,
+		handler:        handler,
+		maxPacketBytes: maxPacketBytes,
+		sessions:       map[string]*session{},
+	}
+}
+
+// Serve accepts connections on the listener and serves them, each in its own goroutine. It blocks until the listener
+// fails or the server is closed. After Close, it returns ErrServerClosed.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mutex.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+
+			if closed {
+				return ErrServerClosed
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		s.connections.Add(1)
+		go func() {
+			defer s.connections.Done()
+			newSession(s, conn).serve()
+		}()
+	}
+}
+
+// Close stops the listener and closes all connections. Wills are not published for the closed connections.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	listener := s.listener
+	sessions := s.sessions
+	s.sessions = map[string]*session{}
+	s.mutex.Unlock()
+
+	var errs []error
+	if listener != nil {
+		if err := listener.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close listener: %w", err))
+		}
+	}
+
+	for _, sess := range sessions {
+		sess.close(false)
+	}
+
+	s.connections.Wait()
+	return errors.Join(errs...)
+}
+
+// Deliver sends the message to all clients subscribed to its topic that pass the allow function.
+//
+// Topics under "user/" are not delivered, as MQTT clients use them for direct messages.
+func (s *Server) Deliver(ctx context.Context, message protocol.MessageReceived, allow broker.Allow) error {
+	if isUserTopic(message.Topic) {
+		return nil
+	}
+
+	payload, err := toPayload(message)
+	if err != nil {
+		return err
+	}
+
+	// This will collect all errors.
+	var errs []error
+	for _, sess := range s.sessionList() {
+		qos, subscribed := sess.grantedQoS(message.Topic)
+		if !subscribed || !allow(sess.username, sess.scopes) {
+			continue
+		}
+
+		if err := sess.publish(ctx, message.Topic, payload, qos, false); err != nil {
+			errs = append(errs, fmt.Errorf("failed to deliver to %s: %w", sess.clientID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SendToUsers sends the direct message to all clients of the receivers that are subscribed to their user topic.
+func (s *Server) SendToUsers(ctx context.Context, message protocol.MessageReceived, receivers []string) error {
+	payload, err := toDirectPayload(message)
+	if err != nil {
+		return err
+	}
+
+	// This will collect all errors.
+	var errs []error
+	for _, sess := range s.sessionList() {
+		if !slices.Contains(receivers, sess.username) {
+			continue
+		}
+
+		qos, subscribed := sess.userTopicQoS()
+		if !subscribed {
+			continue
+		}
+
+		if err := sess.publish(ctx, userTopic(sess.username), payload, qos, false); err != nil {
+			errs = append(errs, fmt.Errorf("failed to deliver to %s: %w", sess.clientID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// IsConnected reports whether the user has at least one client subscribed to their user topic, which means that
+// SendToUsers would deliver to them.
+func (s *Server) IsConnected(username string) bool {
+	for _, sess := range s.sessionList() {
+		if _, subscribed := sess.userTopicQoS(); subscribed && sess.username == username {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sessionList returns a snapshot of the sessions, so that they can be written to without holding the lock.
+func (s *Server) sessionList() []*session {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
+
+// register adds the session to the server. An existing session with the same client ID is closed, as required by
+// the spec. It returns false if the server is closed.
+func (s *Server) register(sess *session) bool {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return false
+	}
+	existing := s.sessions[sess.clientID]
+	s.sessions[sess.clientID] = sess
+	s.mutex.Unlock()
+
+	if existing != nil {
+		slog.Info("closing mqtt connection taken over by a new one", "clientId", sess.clientID)
+		existing.close(true)
+	}
+
+	return true
+}
+
+// unregister removes the session from the server, unless it has already been replaced.
+func (s *Server) unregister(sess *session) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.sessions[sess.clientID] == sess {
+		delete(s.sessions, sess.clientID)
+	}
+}
+
+// newClientID returns a random client ID for clients that do not provide one.
+func newClientID() string {
+	raw := make([]byte, 8)
+	_, _ = rand.Read(raw)
+	return "rosenbridge-" + hex.EncodeToString(raw)
+}
@@ -0,0 +1,321 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/broker"
+	"github.com/shivanshkc/rosenbridge/internal/retained"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testClient is a minimal MQTT client that exchanges raw packets with the server.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dial connects to the server and sends a CONNECT packet with the given credentials. It returns the CONNACK return
+// code.
+func dial(t *testing.T, addr, clientID, username, password string) (*testClient, byte) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	client := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	e := &encoder{}
+	e.string(protocolName)
+	e.byte(protocolLevel)
+	e.byte(0xC2) // Username, password and clean session.
+	e.uint16(0)
+	e.string(clientID)
+	e.string(username)
+	e.string(password)
+	client.send(packet{kind: packetConnect, body: e.data})
+
+	connack := client.receive()
+	require.Equal(t, packetConnack, connack.kind)
+	require.Len(t, connack.body, 2)
+
+	return client, connack.body[1]
+}
+
+func (c *testClient) send(p packet) {
+	_, err := c.conn.Write(p.encode())
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) receive() packet {
+	require.NoError(c.t, c.conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	p, err := readPacket(c.reader, defaultMaxPacketBytes)
+	require.NoError(c.t, err)
+	return p
+}
+
+func (c *testClient) receivePublish() publishPacket {
+	p := c.receive()
+	require.Equal(c.t, packetPublish, p.kind)
+
+	publish, err := decodePublish(p)
+	require.NoError(c.t, err)
+	return publish
+}
+
+// subscribe subscribes to the filters and returns the SUBACK return codes.
+func (c *testClient) subscribe(qos byte, filters ...string) []byte {
+	e := &encoder{}
+	e.uint16(1)
+	for _, filter := range filters {
+		e.string(filter)
+		e.byte(qos)
+	}
+	c.send(packet{kind: packetSubscribe, flags: 0x02, body: e.data})
+
+	suback := c.receive()
+	require.Equal(c.t, packetSuback, suback.kind)
+	return suback.body[2:]
+}
+
+// fakeHandler delivers all valid direct messages right away. Messages with the text "invalid" fail validation, and
+// the user "mallory" authenticates with the send scope only, so cannot receive direct messages.
+type fakeHandler struct {
+	server *Server
+}
+
+func (f *fakeHandler) Authenticate(_ context.Context, username, password string) (scope.Set, bool, error) {
+	if username == "broken" {
+		return nil, false, errors.New("database unavailable")
+	}
+	if password != username+"-password" {
+		return nil, false, nil
+	}
+	if username == "mallory" {
+		return scope.Set{scope.Send}, true, nil
+	}
+	return scope.Default, true, nil
+}
+
+func (f *fakeHandler) CanReceive(_ string, scopes scope.Set) bool {
+	return scopes.Has(scope.Connect)
+}
+
+func (f *fakeHandler) ValidateMessage(message protocol.MessageReceived) error {
+	if message.Message == "invalid" {
+		return errors.New("message is invalid")
+	}
+	return nil
+}
+
+func (f *fakeHandler) SendMessage(ctx context.Context, message protocol.MessageReceived, _ scope.Set,
+	receivers []string,
+) error {
+	if message.Message == "invalid" {
+		return errors.New("message is invalid")
+	}
+	return f.server.SendToUsers(ctx, message, receivers)
+}
+
+// startServer starts a server on a random local port and returns its address.
+func startServer(t *testing.T, bus *broker.Broker) (string, *Server) {
+	handler := &fakeHandler{}
+	server := NewServer(bus, handler, 0)
+	handler.server = server
+	bus.AddDeliverer(server)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	return listener.Addr().String(), server
+}
+
+func newTestBroker(t *testing.T) *broker.Broker {
+	topics, err := topic.NewACL([]topic.Rule{
+		{Topic: "private/#", Publishers: []string{"bob"}, Subscribers: []string{"bob"}},
+		{Topic: "#", Publishers: []string{topic.AnyUser}, Subscribers: []string{topic.AnyUser}},
+	})
+	require.NoError(t, err)
+
+	store, err := retained.NewFileStore(filepath.Join(t.TempDir(), "retained.json"), 0)
+	require.NoError(t, err)
+
+	return broker.New(topics, store)
+}
+
+func TestServer_Connect(t *testing.T) {
+	addr, _ := startServer(t, newTestBroker(t))
+
+	var testCases = []struct {
+		name         string
+		username     string
+		password     string
+		expectedCode byte
+	}{
+		{name: "Valid credentials", username: "alice", password: "alice-password", expectedCode: connackAccepted},
+		{name: "Wrong password", username: "alice", password: "wrong", expectedCode: connackBadUsernameOrPassword},
+		{name: "Authentication error", username: "broken", password: "x", expectedCode: connackServerUnavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, code := dial(t, addr, "", tc.username, tc.password)
+			require.Equal(t, tc.expectedCode, code)
+
+			if code == connackAccepted {
+				client.send(packet{kind: packetPingreq})
+				require.Equal(t, packetPingresp, client.receive().kind)
+			}
+		})
+	}
+}
+
+func TestServer_PublishSubscribe(t *testing.T) {
+	addr, _ := startServer(t, newTestBroker(t))
+
+	alice, code := dial(t, addr, "alice-sensor", "alice", "alice-password")
+	require.Equal(t, connackAccepted, code)
+	bob, code := dial(t, addr, "bob-sensor", "bob", "bob-password")
+	require.Equal(t, connackAccepted, code)
+
+	// Alice is not allowed to subscribe to the private topics, and * is not a valid MQTT filter level.
+	require.Equal(t, []byte{1, subackFailure, subackFailure}, alice.subscribe(1, "sensors/+", "private/#", "a/*"))
+	require.Equal(t, []byte{0}, bob.subscribe(0, "sensors/#"))
+
+	// Bob publishes at QoS 1. He receives his own publish at QoS 0, as it is delivered before the acknowledgement.
+	bob.send(publishPacket{topic: "sensors/temp", packetID: 9, qos: 1, payload: []byte("21.5")}.encode())
+	received := bob.receivePublish()
+	require.Equal(t, "sensors/temp", received.topic)
+	require.Equal(t, byte(0), received.qos)
+	require.Equal(t, encodePacketID(packetPuback, 9), bob.receive())
+
+	// Alice receives it at QoS 1, and acknowledges it.
+	received = alice.receivePublish()
+	require.Equal(t, "sensors/temp", received.topic)
+	require.Equal(t, []byte("21.5"), received.payload)
+	require.Equal(t, byte(1), received.qos)
+	require.False(t, received.retain)
+	alice.send(encodePacketID(packetPuback, received.packetID))
+
+	// Alice's publish to a private topic is acknowledged but dropped.
+	alice.send(publishPacket{topic: "private/x", packetID: 10, qos: 1, payload: []byte("x")}.encode())
+	require.Equal(t, encodePacketID(packetPuback, 10), alice.receive())
+
+	// Invalid messages are acknowledged but dropped as well.
+	bob.send(publishPacket{topic: "sensors/temp", packetID: 11, qos: 1, payload: []byte("invalid")}.encode())
+	require.Equal(t, encodePacketID(packetPuback, 11), bob.receive())
+
+	// A PINGREQ proves that nothing else was sent to Alice.
+	alice.send(packet{kind: packetPingreq})
+	require.Equal(t, packetPingresp, alice.receive().kind)
+}
+
+func TestServer_Retained(t *testing.T) {
+	addr, _ := startServer(t, newTestBroker(t))
+
+	bob, code := dial(t, addr, "bob-sensor", "bob", "bob-password")
+	require.Equal(t, connackAccepted, code)
+
+	bob.send(publishPacket{topic: "status/bob", retain: true, payload: []byte("online")}.encode())
+	// A PINGREQ ensures that the publish is processed before Alice subscribes.
+	bob.send(packet{kind: packetPingreq})
+	require.Equal(t, packetPingresp, bob.receive().kind)
+
+	alice, code := dial(t, addr, "alice-sensor", "alice", "alice-password")
+	require.Equal(t, connackAccepted, code)
+	require.Equal(t, []byte{0}, alice.subscribe(0, "status/#"))
+
+	received := alice.receivePublish()
+	require.Equal(t, "status/bob", received.topic)
+	require.Equal(t, []byte("online"), received.payload)
+	require.True(t, received.retain)
+}
+
+func TestServer_Deliver(t *testing.T) {
+	bus := newTestBroker(t)
+	addr, _ := startServer(t, bus)
+
+	alice, code := dial(t, addr, "alice-sensor", "alice", "alice-password")
+	require.Equal(t, connackAccepted, code)
+	require.Equal(t, []byte{0}, alice.subscribe(0, "chat"))
+
+	// Messages published through other protocols reach MQTT clients.
+	message := protocol.MessageReceived{Sender: "bob", Topic: "chat", Payload: []byte(`{"text":"hi"}`)}
+	require.NoError(t, bus.Publish(context.Background(), message))
+
+	received := alice.receivePublish()
+	require.Equal(t, "chat", received.topic)
+	require.JSONEq(t, `{"text":"hi"}`, string(received.payload))
+}
+
+func TestServer_TakeOver(t *testing.T) {
+	addr, _ := startServer(t, newTestBroker(t))
+
+	first, code := dial(t, addr, "sensor", "alice", "alice-password")
+	require.Equal(t, connackAccepted, code)
+
+	_, code = dial(t, addr, "sensor", "alice", "alice-password")
+	require.Equal(t, connackAccepted, code)
+
+	// The first connection is closed by the server.
+	require.NoError(t, first.conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, err := readPacket(first.reader, defaultMaxPacketBytes)
+	require.Error(t, err)
+	require.False(t, errors.Is(err, errMalformedPacket))
+}
+
+func TestServer_DirectMessages(t *testing.T) {
+	bus := newTestBroker(t)
+	addr, server := startServer(t, bus)
+
+	alice, code := dial(t, addr, "alice-sensor", "alice", "alice-password")
+	require.Equal(t, connackAccepted, code)
+	bob, code := dial(t, addr, "bob-sensor", "bob", "bob-password")
+	require.Equal(t, connackAccepted, code)
+	mallory, code := dial(t, addr, "mallory-sensor", "mallory", "mallory-password")
+	require.Equal(t, connackAccepted, code)
+
+	// Users can only subscribe to their own user topic, and only if they can receive direct messages.
+	require.Equal(t, []byte{1, subackFailure, subackFailure}, alice.subscribe(1, "user/alice", "user/bob", "user/+"))
+	require.Equal(t, []byte{0}, bob.subscribe(0, "#"))
+	require.Equal(t, []byte{subackFailure}, mallory.subscribe(0, "user/mallory"))
+
+	// Bob's publish to Alice's user topic is a direct message. It is sent as JSON, so that it names the sender.
+	bob.send(publishPacket{topic: "user/alice", packetID: 7, qos: 1, payload: []byte("hi")}.encode())
+	require.Equal(t, encodePacketID(packetPuback, 7), bob.receive())
+
+	received := alice.receivePublish()
+	require.Equal(t, "user/alice", received.topic)
+	require.Equal(t, byte(1), received.qos)
+	require.JSONEq(t, `{"message":"hi","sender":"bob"}`, string(received.payload))
+	alice.send(encodePacketID(packetPuback, received.packetID))
+
+	// Invalid direct messages are dropped.
+	bob.send(publishPacket{topic: "user/alice", payload: []byte("invalid")}.encode())
+
+	// Topic messages under "user/" are not bridged, so they cannot pass for direct messages.
+	message := protocol.MessageReceived{Sender: "bob", Topic: "user/alice", Message: "fake"}
+	require.NoError(t, bus.Publish(context.Background(), message))
+
+	// Only clients subscribed to their own user topic count as connected.
+	require.True(t, server.IsConnected("alice"))
+	require.False(t, server.IsConnected("bob"))
+
+	// A PINGREQ proves that nothing else was sent to Alice, and that Bob's wildcard subscription got nothing.
+	alice.send(packet{kind: packetPingreq})
+	require.Equal(t, packetPingresp, alice.receive().kind)
+	bob.send(packet{kind: packetPingreq})
+	require.Equal(t, packetPingresp, bob.receive().kind)
+}
@@ -0,0 +1,568 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/broker"
+	"github.com/shivanshkc/rosenbridge/internal/retained"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+)
+
+const (
+	// connectTimeout is the max time allowed for a client to send CONNECT after connecting.
+	connectTimeout = 10 * time.Second
+	// writeTimeout is the max time allowed for writing a packet.
+	writeTimeout = 5 * time.Second
+	// publishTimeout is the max time allowed for delivering a message published by a client.
+	publishTimeout = 5 * time.Second
+
+	// maxSubscriptions is the max number of topic filters that a client can be subscribed to at once.
+	maxSubscriptions = 100
+	// maxInflight is the max number of QoS 1 messages awaiting PUBACK. Beyond that, messages are sent at QoS 0.
+	maxInflight = 100
+)
+
+// session is the state of one client connection.
+type session struct {
+	server *Server
+	conn   net.Conn
+
+	// These are set after CONNECT.
+	clientID string
+	username string
+	scopes   scope.Set
+
+	writeMutex sync.Mutex
+
+	// mutex guards all the following fields.
+	mutex sync.Mutex
+	// subscriptions maps the topic filters of the client to their granted QoS.
+	subscriptions map[string]byte
+	// inflight is the set of packet IDs of the QoS 1 messages awaiting PUBACK.
+	inflight     map[uint16]struct{}
+	nextPacketID uint16
+	// will is published when the connection is lost without a DISCONNECT packet.
+	will *publishPacket
+}
+
+func newSession(server *Server, conn net.Conn) *session {
+	return &session{
+		server:        server,
+		conn:          conn,
+		subscriptions: map[string]byte{},
+		inflight:      map[uint16]struct{}{},
+	}
+}
+
+// serve handles the connection until it is closed. It is a blocking call.
+func (s *session) serve() {
+	defer func() { _ = s.conn.Close() }()
+
+	reader := bufio.NewReader(s.conn)
+
+	keepAlive, ok := s.connect(reader)
+	if !ok {
+		return
+	}
+
+	defer s.server.unregister(s)
+	defer s.publishWill()
+
+	slog.Info("mqtt client connected", "clientId", s.clientID, "username", s.username)
+
+	for {
+		// As per the spec, the connection is closed if nothing is received for one and a half keep alive periods.
+		var deadline time.Time
+		if keepAlive > 0 {
+			deadline = time.Now().Add(keepAlive * 3 / 2)
+		}
+		_ = s.conn.SetReadDeadline(deadline)
+
+		p, err := readPacket(reader, s.server.maxPacketBytes)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				slog.Info("mqtt connection closed", "clientId", s.clientID)
+			} else {
+				slog.Error("mqtt connection read error", "clientId", s.clientID, "error", err)
+			}
+			return
+		}
+
+		if err := s.handle(p); err != nil {
+			if errors.Is(err, errDisconnect) {
+				slog.Info("mqtt client disconnected", "clientId", s.clientID)
+			} else {
+				slog.Error("closing mqtt connection", "clientId", s.clientID, "error", err)
+			}
+			return
+		}
+	}
+}
+
+// connect reads and processes the CONNECT packet. It returns the keep alive duration, and false if the connection
+// must be closed.
+func (s *session) connect(reader *bufio.Reader) (time.Duration, bool) {
+	_ = s.conn.SetReadDeadline(time.Now().Add(connectTimeout))
+
+	p, err := readPacket(reader, s.server.maxPacketBytes)
+	if err != nil {
+		slog.Error("failed to read mqtt connect packet", "error", err)
+		return 0, false
+	}
+
+	if p.kind != packetConnect {
+		slog.Error("first mqtt packet is not connect", "type", p.kind)
+		return 0, false
+	}
+
+	connect, err := decodeConnect(p.body)
+	if err != nil {
+		slog.Error("invalid mqtt connect packet", "error", err)
+		return 0, false
+	}
+
+	if connect.protocolName != protocolName {
+		slog.Error("unsupported mqtt protocol name", "protocolName", connect.protocolName)
+		return 0, false
+	}
+
+	if connect.protocolLevel != protocolLevel {
+		slog.Error("unsupported mqtt protocol level", "protocolLevel", connect.protocolLevel)
+		_ = s.write(encodeConnack(connackUnacceptableProtocol))
+		return 0, false
+	}
+
+	if code := s.accept(connect); code != connackAccepted {
+		_ = s.write(encodeConnack(code))
+		return 0, false
+	}
+
+	if !s.server.register(s) {
+		return 0, false
+	}
+
+	if err := s.write(encodeConnack(connackAccepted)); err != nil {
+		slog.Error("failed to write mqtt connack", "clientId", s.clientID, "error", err)
+		s.server.unregister(s)
+		return 0, false
+	}
+
+	return time.Duration(connect.keepAliveSec) * time.Second, true
+}
+
+// accept validates the CONNECT packet and authenticates the client. It returns the CONNACK return code.
+func (s *session) accept(connect connectPacket) byte {
+	ctx := context.Background()
+
+	s.clientID = connect.clientID
+	if s.clientID == "" {
+		// Clients without an ID must ask for a clean session, as their session could never be resumed.
+		if !connect.cleanSession {
+			slog.Error("mqtt client without ID asked for a persistent session")
+			return connackIdentifierRejected
+		}
+		s.clientID = newClientID()
+	}
+
+	if !connect.hasUsername {
+		slog.Error("mqtt client did not provide a username", "clientId", s.clientID)
+		return connackNotAuthorized
+	}
+
+	scopes, ok, err := s.server.handler.Authenticate(ctx, connect.username, connect.password)
+	if err != nil {
+		slog.Error("unexpected error while authenticating mqtt client", "clientId", s.clientID, "error", err)
+		return connackServerUnavailable
+	}
+
+	if !ok {
+		slog.Error("mqtt authentication failed", "clientId", s.clientID, "username", connect.username)
+		return connackBadUsernameOrPassword
+	}
+
+	s.username, s.scopes = connect.username, scopes
+
+	if connect.will != nil {
+		if err := topic.ValidateName(connect.will.topic); err != nil {
+			slog.Error("invalid mqtt will topic", "clientId", s.clientID, "error", err)
+			return connackNotAuthorized
+		}
+		// Wills to user topics are direct messages, which are checked when they are sent.
+		if !isUserTopic(connect.will.topic) && !s.server.broker.CanPublish(s.username, s.scopes, connect.will.topic) {
+			slog.Error("mqtt will not allowed", "clientId", s.clientID, "topic", connect.will.topic)
+			return connackNotAuthorized
+		}
+		s.will = connect.will
+	}
+
+	return connackAccepted
+}
+
+// errDisconnect is returned by handle when the client sends DISCONNECT.
+var errDisconnect = errors.New("disconnect")
+
+// handle processes one packet sent by the client. If it returns an error, the connection must be closed.
+func (s *session) handle(p packet) error {
+	switch p.kind {
+	case packetPublish:
+		publish, err := decodePublish(p)
+		if err != nil {
+			return err
+		}
+		return s.handlePublish(publish)
+
+	case packetPuback:
+		packetID, err := decodePacketID(p.body)
+		if err != nil {
+			return err
+		}
+		s.mutex.Lock()
+		delete(s.inflight, packetID)
+		s.mutex.Unlock()
+		return nil
+
+	case packetSubscribe:
+		if p.flags != 0x02 {
+			return fmt.Errorf("%w: invalid subscribe flags", errMalformedPacket)
+		}
+		subscribe, err := decodeSubscribe(p.body)
+		if err != nil {
+			return err
+		}
+		return s.handleSubscribe(subscribe)
+
+	case packetUnsubscribe:
+		if p.flags != 0x02 {
+			return fmt.Errorf("%w: invalid unsubscribe flags", errMalformedPacket)
+		}
+		unsubscribe, err := decodeUnsubscribe(p.body)
+		if err != nil {
+			return err
+		}
+		return s.handleUnsubscribe(unsubscribe)
+
+	case packetPingreq:
+		return s.write(packet{kind: packetPingresp})
+
+	case packetDisconnect:
+		// A clean disconnect discards the will.
+		s.mutex.Lock()
+		s.will = nil
+		s.mutex.Unlock()
+		return errDisconnect
+
+	case packetPubrec, packetPubrel, packetPubcomp:
+		return fmt.Errorf("QoS 2 is not supported")
+
+	default:
+		return fmt.Errorf("unexpected packet type %d", p.kind)
+	}
+}
+
+// handlePublish processes a PUBLISH packet sent by the client.
+//
+// MQTT 3.1.1 has no way to reject a publish, so invalid or forbidden publishes are acknowledged and dropped.
+func (s *session) handlePublish(publish publishPacket) error {
+	if publish.qos == 2 {
+		return fmt.Errorf("QoS 2 is not supported")
+	}
+
+	s.processPublish(publish)
+
+	if publish.qos == 1 {
+		return s.write(encodePacketID(packetPuback, publish.packetID))
+	}
+
+	return nil
+}
+
+// processPublish retains and delivers a message published by the client, or the client's will. A publish to a user
+// topic is sent as a direct message instead.
+func (s *session) processPublish(publish publishPacket) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	if err := topic.ValidateName(publish.topic); err != nil {
+		slog.Error("dropping mqtt publish with invalid topic", "clientId", s.clientID, "error", err)
+		return
+	}
+
+	if receiver, found := strings.CutPrefix(publish.topic, userTopicPrefix); found {
+		// Direct messages have no topic, and cannot be retained.
+		message := toMessage(s.username, "", publish.payload)
+		if err := s.server.handler.SendMessage(ctx, message, s.scopes, []string{receiver}); err != nil {
+			slog.Error("dropping mqtt direct message", "clientId", s.clientID, "error", err)
+		}
+		return
+	}
+
+	if !s.server.broker.CanPublish(s.username, s.scopes, publish.topic) {
+		slog.Error("dropping mqtt publish not allowed", "clientId", s.clientID, "topic", publish.topic)
+		return
+	}
+
+	// As per the spec, an empty retained message deletes the retained message of the topic. It is not delivered.
+	if publish.retain && len(publish.payload) == 0 {
+		s.deleteRetained(ctx, publish.topic)
+		return
+	}
+
+	message := toMessage(s.username, publish.topic, publish.payload)
+
+	// The message is subject to the same limits as those sent over the other protocols.
+	if err := s.server.handler.ValidateMessage(message); err != nil {
+		slog.Error("dropping invalid mqtt publish", "clientId", s.clientID, "error", err)
+		return
+	}
+
+	if publish.retain {
+		err := s.server.broker.Retain(ctx, message)
+		switch {
+		case errors.Is(err, broker.ErrRetainDisabled), errors.Is(err, retained.ErrLimitReached):
+			slog.Warn("mqtt publish not retained", "clientId", s.clientID, "reason", err)
+		case err != nil:
+			slog.Error("failed to retain mqtt publish", "clientId", s.clientID, "error", err)
+		}
+	}
+
+	if err := s.server.broker.Publish(ctx, message); err != nil {
+		slog.Error("failed to publish mqtt message", "clientId", s.clientID, "error", err)
+	}
+}
+
+// deleteRetained deletes the retained message of the topic, on behalf of the client.
+func (s *session) deleteRetained(ctx context.Context, name string) {
+	err := s.server.broker.DeleteRetained(ctx, name)
+	switch {
+	case errors.Is(err, broker.ErrRetainDisabled):
+		slog.Warn("mqtt retained message not deleted", "clientId", s.clientID, "reason", err)
+	case err != nil:
+		slog.Error("failed to delete mqtt retained message", "clientId", s.clientID, "error", err)
+	}
+}
+
+// handleSubscribe processes a SUBSCRIBE packet, and sends the retained messages of the new subscriptions.
+func (s *session) handleSubscribe(subscribe subscribePacket) error {
+	returnCodes := make([]byte, len(subscribe.subscriptions))
+	granted := map[string]byte{}
+
+	for i, sub := range subscribe.subscriptions {
+		filter, err := toFilter(sub.filter)
+		if err != nil {
+			slog.Error("rejecting mqtt subscription", "clientId", s.clientID, "filter", sub.filter, "error", err)
+			returnCodes[i] = subackFailure
+			continue
+		}
+
+		// Clients can only subscribe to their own user topic, out of all the topics that address users.
+		var allowed bool
+		if isUserTopic(filter) {
+			allowed = filter == userTopic(s.username) && s.server.handler.CanReceive(s.username, s.scopes)
+		} else {
+			allowed = s.server.broker.CanSubscribe(s.username, s.scopes, filter)
+		}
+
+		if !allowed {
+			slog.Error("mqtt subscription not allowed", "clientId", s.clientID, "filter", sub.filter)
+			returnCodes[i] = subackFailure
+			continue
+		}
+
+		// QoS 2 is downgraded to 1, which the spec allows.
+		qos := min(sub.qos, 1)
+		if !s.subscribe(filter, qos) {
+			slog.Error("mqtt subscription limit reached", "clientId", s.clientID)
+			returnCodes[i] = subackFailure
+			continue
+		}
+
+		returnCodes[i] = qos
+		// User topics have no retained messages.
+		if !isUserTopic(filter) {
+			granted[filter] = qos
+		}
+	}
+
+	if err := s.write(encodeSuback(subscribe.packetID, returnCodes)); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	for filter, qos := range granted {
+		messages, err := s.server.broker.Retained(ctx, s.username, s.scopes, filter)
+		if err != nil {
+			slog.Error("failed to get retained messages", "clientId", s.clientID, "error", err)
+			continue
+		}
+
+		for _, message := range messages {
+			// Like in Deliver, the topics that address users are not bridged.
+			if isUserTopic(message.Topic) {
+				continue
+			}
+			payload, err := toPayload(message)
+			if err != nil {
+				slog.Error("failed to convert retained message", "clientId", s.clientID, "error", err)
+				continue
+			}
+			if err := s.publish(ctx, message.Topic, payload, qos, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// handleUnsubscribe processes an UNSUBSCRIBE packet.
+func (s *session) handleUnsubscribe(unsubscribe unsubscribePacket) error {
+	s.mutex.Lock()
+	for _, mqttFilter := range unsubscribe.filters {
+		if filter, err := toFilter(mqttFilter); err == nil {
+			delete(s.subscriptions, filter)
+		}
+	}
+	s.mutex.Unlock()
+
+	return s.write(encodePacketID(packetUnsuback, unsubscribe.packetID))
+}
+
+// subscribe adds or updates a subscription. It returns false if the subscription limit is reached.
+func (s *session) subscribe(filter string, qos byte) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.subscriptions[filter]; !exists && len(s.subscriptions) >= maxSubscriptions {
+		return false
+	}
+
+	s.subscriptions[filter] = qos
+	return true
+}
+
+// grantedQoS returns the highest QoS granted by the subscriptions that match the topic name. It returns false if
+// no subscription matches. Messages are delivered once per client, even if multiple subscriptions match.
+func (s *session) grantedQoS(name string) (byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var qos byte
+	var matched bool
+	for filter, granted := range s.subscriptions {
+		if topic.Match(filter, name) {
+			qos = max(qos, granted)
+			matched = true
+		}
+	}
+
+	return qos, matched
+}
+
+// userTopicQoS returns the QoS granted for the user topic of the client. It returns false if the client is not
+// subscribed to it. Wildcard subscriptions do not count, so that direct messages are only sent to clients that asked
+// for them.
+func (s *session) userTopicQoS() (byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	qos, subscribed := s.subscriptions[userTopic(s.username)]
+	return qos, subscribed
+}
+
+// publish sends a PUBLISH packet to the client.
+func (s *session) publish(ctx context.Context, name string, payload []byte, qos byte, retain bool) error {
+	publish := publishPacket{topic: name, qos: qos, retain: retain, payload: payload}
+
+	if qos == 1 {
+		packetID, ok := s.allocatePacketID()
+		if ok {
+			publish.packetID = packetID
+		} else {
+			// The client is not acknowledging fast enough.
+			publish.qos = 0
+		}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		return s.writeWithDeadline(publish.encode(), deadline)
+	}
+
+	return s.write(publish.encode())
+}
+
+// allocatePacketID returns an unused packet ID for a QoS 1 message. It returns false if too many messages are in
+// flight.
+func (s *session) allocatePacketID() (uint16, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.inflight) >= maxInflight {
+		return 0, false
+	}
+
+	for {
+		// Zero is not a valid packet ID.
+		s.nextPacketID++
+		if s.nextPacketID == 0 {
+			continue
+		}
+		if _, exists := s.inflight[s.nextPacketID]; !exists {
+			s.inflight[s.nextPacketID] = struct{}{}
+			return s.nextPacketID, true
+		}
+	}
+}
+
+// publishWill publishes the will of the client, if any.
+func (s *session) publishWill() {
+	s.mutex.Lock()
+	will := s.will
+	s.will = nil
+	s.mutex.Unlock()
+
+	if will != nil {
+		slog.Info("publishing mqtt will", "clientId", s.clientID, "topic", will.topic)
+		s.processPublish(*will)
+	}
+}
+
+// close closes the connection. The serving goroutine then exits, publishing the will only if asked to.
+func (s *session) close(publishWill bool) {
+	if !publishWill {
+		s.mutex.Lock()
+		s.will = nil
+		s.mutex.Unlock()
+	}
+
+	_ = s.conn.Close()
+}
+
+// write writes a packet to the connection.
+func (s *session) write(p packet) error {
+	return s.writeWithDeadline(p, time.Now().Add(writeTimeout))
+}
+
+// writeWithDeadline writes a packet to the connection with the given deadline.
+func (s *session) writeWithDeadline(p packet, deadline time.Time) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	_ = s.conn.SetWriteDeadline(deadline)
+	if _, err := s.conn.Write(p.encode()); err != nil {
+		return fmt.Errorf("failed to write packet: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+// Package oidc implements the parts of OpenID Connect that a relying party needs for the authorization code flow with
+// PKCE: provider discovery, the token exchange, and the validation of ID tokens against the keys of the provider.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/version"
+)
+
+// ErrInvalidToken is returned when an ID token fails validation.
+var ErrInvalidToken = errors.New("invalid id token")
+
+// maxResponseBytes is the max size of a response body from the provider.
+const maxResponseBytes = 1024 * 1024
+
+// Options configure a Provider. Zero values mean the defaults.
+type Options struct {
+	// Issuer is the URL of the provider, like https://accounts.google.com. The discovery document is fetched from
+	// <Issuer>/.well-known/openid-configuration.
+	Issuer string
+	// ClientID and ClientSecret identify Rosenbridge at the provider. The secret can be empty for public clients.
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback URL registered at the provider.
+	RedirectURL string
+	// Scopes are requested in addition to "openid". Defaults to "email" and "profile".
+	Scopes []string
+	// Timeout of every request to the provider. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Scopes == nil {
+		o.Scopes = []string{"email", "profile"}
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	return o
+}
+
+// metadata is the part of the discovery document that is used.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect provider that users can log in with.
+//
+// The discovery document and the keys are fetched on first use, and not on creation, so that a provider that is down
+// does not keep Rosenbridge from starting.
+type Provider struct {
+	options Options
+	client  *http.Client
+
+	// mutex guards the fields below.
+	mutex    sync.Mutex
+	metadata *metadata
+	keys     *keySet
+
+	// now is replaceable for tests.
+	now func() time.Time
+}
+
+// NewProvider returns a new Provider instance.
+func NewProvider(options Options) *Provider {
+	options = options.withDefaults()
+	return &Provider{
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		now:     time.Now,
+	}
+}
+
+// AuthCodeURL returns the URL of the provider's login page. After the login, the provider redirects the user to the
+// redirect URL with the given state, and with a code that Exchange accepts.
+//
+// The nonce ends up in the ID token, and the verifier must be passed to Exchange, so both must be kept until then.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.options.ClientID)
+	query.Set("redirect_uri", p.options.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, p.options.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
+
+// Exchange redeems the authorization code for tokens, and returns the claims of the validated ID token. The verifier
+// and the nonce must be those passed to AuthCodeURL.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.options.RedirectURL)
+	form.Set("code_verifier", verifier)
+	// Public clients identify themselves in the body, and confidential clients with basic auth.
+	if p.options.ClientSecret == "" {
+		form.Set("client_id", p.options.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, fmt.Errorf("failed to create token request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.options.ClientSecret != "" {
+		// The credentials are form encoded before being put in the header, as per RFC 6749, section 2.3.1.
+		req.SetBasicAuth(url.QueryEscape(p.options.ClientID), url.QueryEscape(p.options.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.do(req, &tokens); err != nil {
+		return Claims{}, fmt.Errorf("token request failed: %w", err)
+	}
+
+	if tokens.IDToken == "" {
+		return Claims{}, errors.New("token response has no id token")
+	}
+
+	return p.Verify(ctx, tokens.IDToken, nonce)
+}
+
+// discover returns the discovery document of the provider, fetching it if it was not fetched yet.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(p.options.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery request: %w", err)
+	}
+
+	var meta metadata
+	if err := p.do(req, &meta); err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+
+	// ID tokens are checked against the configured issuer, so it must match, as per OpenID Connect Discovery 4.3.
+	if meta.Issuer != p.options.Issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q instead of %q", meta.Issuer, p.options.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("discovery document lacks required endpoints")
+	}
+
+	p.metadata = &meta
+	return p.metadata, nil
+}
+
+// do sends the request to the provider, and decodes the JSON response into the target.
+func (p *Provider) do(req *http.Request, target any) error {
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "rosenbridge/"+version.Version)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	if err := json.Unmarshal(body, target); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// RandomString returns a random, URL safe string with 256 bits of entropy. It is suitable for states, nonces and PKCE
+// verifiers.
+func RandomString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Challenge returns the S256 PKCE challenge of the verifier.
+func Challenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
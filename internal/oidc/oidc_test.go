@@ -0,0 +1,275 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/oidc/oidctest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvider_AuthCodeURL(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	provider := NewProvider(Options{Issuer: issuer.URL(), ClientID: oidctest.ClientID, RedirectURL: "https://rb/cb"})
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state-1", "nonce-1", "verifier-1")
+	require.NoError(t, err)
+
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	require.Equal(t, issuer.URL()+"/authorize", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+
+	query := parsed.Query()
+	require.Equal(t, "code", query.Get("response_type"))
+	require.Equal(t, oidctest.ClientID, query.Get("client_id"))
+	require.Equal(t, "https://rb/cb", query.Get("redirect_uri"))
+	require.Equal(t, "openid email profile", query.Get("scope"))
+	require.Equal(t, "state-1", query.Get("state"))
+	require.Equal(t, "nonce-1", query.Get("nonce"))
+	require.Equal(t, Challenge("verifier-1"), query.Get("code_challenge"))
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+}
+
+func TestProvider_Discovery_IssuerMismatch(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	// The discovery document names the real issuer, which must be the configured one.
+	provider := NewProvider(Options{Issuer: issuer.URL() + "/", ClientID: oidctest.ClientID})
+
+	_, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	require.ErrorContains(t, err, "discovery document is for issuer")
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	provider := NewProvider(Options{Issuer: issuer.URL(), ClientID: oidctest.ClientID, RedirectURL: "https://rb/cb"})
+
+	// login goes through the provider's login page, and returns the code.
+	login := func(verifier string) string {
+		authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", verifier)
+		require.NoError(t, err)
+
+		code, state := issuer.Authorize(authURL, map[string]any{"preferred_username": "shivansh"})
+		require.Equal(t, "state", state)
+		return code
+	}
+
+	t.Run("Valid code and verifier, claims expected", func(t *testing.T) {
+		claims, err := provider.Exchange(context.Background(), login("verifier"), "verifier", "nonce")
+		require.NoError(t, err)
+		require.Equal(t, issuer.URL(), claims.Issuer)
+		require.Equal(t, "subject-1", claims.Subject)
+		require.Equal(t, "shivansh", claims.PreferredUsername)
+	})
+
+	t.Run("Wrong verifier, error expected", func(t *testing.T) {
+		_, err := provider.Exchange(context.Background(), login("verifier"), "other-verifier", "nonce")
+		require.ErrorContains(t, err, "unexpected status code: 400")
+	})
+
+	t.Run("Wrong nonce, error expected", func(t *testing.T) {
+		_, err := provider.Exchange(context.Background(), login("verifier"), "verifier", "other-nonce")
+		require.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Code used twice, error expected", func(t *testing.T) {
+		code := login("verifier")
+		_, err := provider.Exchange(context.Background(), code, "verifier", "nonce")
+		require.NoError(t, err)
+
+		_, err = provider.Exchange(context.Background(), code, "verifier", "nonce")
+		require.ErrorContains(t, err, "unexpected status code: 400")
+	})
+}
+
+func TestProvider_Verify(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	provider := NewProvider(Options{Issuer: issuer.URL(), ClientID: oidctest.ClientID})
+
+	// claims returns valid claims, with the given ones added or overridden.
+	claims := func(overrides map[string]any) map[string]any {
+		valid := map[string]any{
+			"iss":   issuer.URL(),
+			"sub":   "subject-1",
+			"aud":   oidctest.ClientID,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": "nonce",
+		}
+		for name, value := range overrides {
+			valid[name] = value
+		}
+		return valid
+	}
+
+	valid := issuer.Sign(claims(nil))
+
+	var testCases = []struct {
+		name           string
+		token          string
+		expectedErrMsg string
+	}{
+		{
+			name:  "Valid token, no error expected",
+			token: valid,
+		},
+		{
+			name: "Audience array with authorized party, no error expected",
+			token: issuer.Sign(claims(map[string]any{
+				"aud": []string{"other", oidctest.ClientID},
+				"azp": oidctest.ClientID,
+			})),
+		},
+		{
+			name:           "Malformed token, error expected",
+			token:          "a.b",
+			expectedErrMsg: "malformed token",
+		},
+		{
+			name:           "Unsigned token, error expected",
+			token:          unsignedToken(claims(nil)),
+			expectedErrMsg: `unsupported algorithm "none"`,
+		},
+		{
+			name:           "Tampered claims, error expected",
+			token:          tamperClaims(valid, claims(map[string]any{"sub": "subject-2"})),
+			expectedErrMsg: "signature does not match",
+		},
+		{
+			name:           "Wrong issuer, error expected",
+			token:          issuer.Sign(claims(map[string]any{"iss": "https://evil.example.com"})),
+			expectedErrMsg: "unexpected issuer",
+		},
+		{
+			name:           "Wrong audience, error expected",
+			token:          issuer.Sign(claims(map[string]any{"aud": "other"})),
+			expectedErrMsg: "token is not meant for this client",
+		},
+		{
+			name:           "Audience array without authorized party, error expected",
+			token:          issuer.Sign(claims(map[string]any{"aud": []string{"other", oidctest.ClientID}})),
+			expectedErrMsg: "token is not authorized for this client",
+		},
+		{
+			name:           "Expired token, error expected",
+			token:          issuer.Sign(claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})),
+			expectedErrMsg: "token is expired",
+		},
+		{
+			name:           "Token from the future, error expected",
+			token:          issuer.Sign(claims(map[string]any{"iat": time.Now().Add(time.Hour).Unix()})),
+			expectedErrMsg: "token is issued in the future",
+		},
+		{
+			name:           "Wrong nonce, error expected",
+			token:          issuer.Sign(claims(map[string]any{"nonce": "other"})),
+			expectedErrMsg: "nonce does not match",
+		},
+		{
+			name:           "No subject, error expected",
+			token:          issuer.Sign(claims(map[string]any{"sub": ""})),
+			expectedErrMsg: "no subject",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := provider.Verify(context.Background(), tc.token, "nonce")
+			if tc.expectedErrMsg == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrInvalidToken)
+			require.ErrorContains(t, err, tc.expectedErrMsg)
+		})
+	}
+}
+
+func TestProvider_Verify_KeyRotation(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	provider := NewProvider(Options{Issuer: issuer.URL(), ClientID: oidctest.ClientID})
+
+	now := time.Now()
+	provider.now = func() time.Time { return now }
+
+	claims := map[string]any{"iss": issuer.URL(), "sub": "subject-1", "aud": oidctest.ClientID,
+		"exp": now.Add(time.Hour).Unix(), "iat": now.Unix(), "nonce": "nonce"}
+
+	_, err := provider.Verify(context.Background(), issuer.Sign(claims), "nonce")
+	require.NoError(t, err)
+
+	// Unknown key IDs do not make the keys be fetched again right away.
+	issuer.KeyID = "key-2"
+	_, err = provider.Verify(context.Background(), issuer.Sign(claims), "nonce")
+	require.ErrorContains(t, err, `unknown key id "key-2"`)
+
+	// After a while, they do.
+	now = now.Add(keysRefreshInterval)
+	_, err = provider.Verify(context.Background(), issuer.Sign(claims), "nonce")
+	require.NoError(t, err)
+}
+
+func TestProvider_Verify_ES256(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	// A provider with a single EC key, and no key IDs.
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/jwks" {
+			point, _ := key.PublicKey.Bytes()
+			_ = json.NewEncoder(w).Encode(map[string]any{"keys": []any{map[string]string{
+				"kty": "EC",
+				"crv": "P-256",
+				"x":   base64.RawURLEncoding.EncodeToString(point[1:33]),
+				"y":   base64.RawURLEncoding.EncodeToString(point[33:]),
+			}}})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": server.URL, "authorization_endpoint": "/a",
+			"token_endpoint": "/t", "jwks_uri": server.URL + "/jwks"})
+	}))
+	defer server.Close()
+
+	header, _ := json.Marshal(map[string]string{"alg": "ES256"})
+	payload, _ := json.Marshal(map[string]any{"iss": server.URL, "sub": "subject-1", "aud": oidctest.ClientID,
+		"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(), "nonce": "nonce"})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	provider := NewProvider(Options{Issuer: server.URL, ClientID: oidctest.ClientID})
+	claims, err := provider.Verify(context.Background(),
+		signed+"."+base64.RawURLEncoding.EncodeToString(signature), "nonce")
+	require.NoError(t, err)
+	require.Equal(t, "subject-1", claims.Subject)
+}
+
+// unsignedToken returns a token with the "none" algorithm.
+func unsignedToken(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "none"})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+// tamperClaims replaces the claims of the signed token, keeping its header and signature.
+func tamperClaims(token string, claims map[string]any) string {
+	payload, _ := json.Marshal(claims)
+	parts := strings.Split(token, ".")
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+}
@@ -0,0 +1,157 @@
+// Package oidctest provides a local OpenID Connect issuer for tests.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ClientID is the only client that the issuer knows.
+const ClientID = "rosenbridge"
+
+// Issuer is an OpenID Connect provider that logs in every user right away. It signs ID tokens with an RS256 key.
+type Issuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// KeyID is the ID of the signing key. It can be changed to test key rotation.
+	KeyID string
+
+	mutex sync.Mutex
+	// logins maps the issued codes to their pending logins.
+	logins map[string]login
+}
+
+// login is an authorization that waits for its code to be exchanged.
+type login struct {
+	challenge string
+	claims    map[string]any
+}
+
+// NewIssuer starts a new Issuer that is closed with the test.
+func NewIssuer(t *testing.T) *Issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	issuer := &Issuer{key: key, KeyID: "key-1", logins: map[string]login{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("GET /jwks", issuer.jwks)
+	mux.HandleFunc("POST /token", issuer.token)
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// URL is the issuer identifier, which is also the base URL of its endpoints.
+func (i *Issuer) URL() string {
+	return i.server.URL
+}
+
+// Authorize does what the login page of a real provider does. It takes the URL that the user is sent to, and returns
+// the code that the provider would send back to the redirect URL, along with the state.
+//
+// The ID token of the code has the given claims, in addition to the standard ones. The claims can also override the
+// standard ones.
+func (i *Issuer) Authorize(authURL string, claims map[string]any) (code, state string) {
+	parsed, _ := url.Parse(authURL)
+	query := parsed.Query()
+
+	tokenClaims := map[string]any{
+		"iss":   i.URL(),
+		"sub":   "subject-1",
+		"aud":   query.Get("client_id"),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range claims {
+		tokenClaims[name] = value
+	}
+
+	code = rand.Text()
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.logins[code] = login{challenge: query.Get("code_challenge"), claims: tokenClaims}
+
+	return code, query.Get("state")
+}
+
+// Sign returns an ID token with the given claims, signed with the key of the issuer.
+func (i *Issuer) Sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": i.KeyID})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJson(w, http.StatusOK, map[string]string{
+		"issuer":                 i.URL(),
+		"authorization_endpoint": i.URL() + "/authorize",
+		"token_endpoint":         i.URL() + "/token",
+		"jwks_uri":               i.URL() + "/jwks",
+	})
+}
+
+func (i *Issuer) jwks(w http.ResponseWriter, _ *http.Request) {
+	key := map[string]string{
+		"kty": "RSA",
+		"kid": i.KeyID,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(i.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+	}
+	writeJson(w, http.StatusOK, map[string]any{"keys": []any{key}})
+}
+
+// token redeems a code once, if the PKCE verifier matches its challenge.
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	i.mutex.Lock()
+	pending, ok := i.logins[r.FormValue("code")]
+	delete(i.logins, r.FormValue("code"))
+	i.mutex.Unlock()
+
+	if !ok || r.FormValue("grant_type") != "authorization_code" {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != pending.challenge {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"id_token":     i.Sign(pending.claims),
+	})
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,341 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// clockSkew is tolerated when checking the times in ID tokens.
+	clockSkew = time.Minute
+	// keysRefreshInterval limits how often the keys are fetched again because of an unknown key ID, so that forged
+	// tokens cannot make Rosenbridge flood the provider.
+	keysRefreshInterval = time.Minute
+)
+
+// Claims are the claims of an ID token that Rosenbridge uses.
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	ExpiresAt       int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// audience is the "aud" claim, which is either a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// header is the JOSE header of an ID token.
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// algorithm describes a supported signature algorithm.
+type algorithm struct {
+	keyType string
+	hash    crypto.Hash
+	// curve is only set for ECDSA algorithms.
+	curve elliptic.Curve
+}
+
+// algorithms are the supported signature algorithms. Symmetric algorithms and "none" are deliberately missing, as
+// they would let anyone who knows the client secret, or anyone at all, forge tokens.
+var algorithms = map[string]algorithm{
+	"RS256": {keyType: "RSA", hash: crypto.SHA256},
+	"RS384": {keyType: "RSA", hash: crypto.SHA384},
+	"RS512": {keyType: "RSA", hash: crypto.SHA512},
+	"ES256": {keyType: "EC", hash: crypto.SHA256, curve: elliptic.P256()},
+	"ES384": {keyType: "EC", hash: crypto.SHA384, curve: elliptic.P384()},
+	"ES512": {keyType: "EC", hash: crypto.SHA512, curve: elliptic.P521()},
+}
+
+// Verify validates the signature and the claims of the ID token, and returns the claims. The nonce must be the one
+// passed to AuthCodeURL.
+//
+// Errors caused by the token itself wrap ErrInvalidToken. Other errors are about reaching the provider.
+func (p *Provider) Verify(ctx context.Context, rawToken, nonce string) (Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var head header
+	if err := decodeSegment(parts[0], &head); err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed header: %w", ErrInvalidToken, err)
+	}
+
+	alg, ok := algorithms[head.Algorithm]
+	if !ok {
+		return Claims{}, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, head.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed signature: %w", ErrInvalidToken, err)
+	}
+
+	keys, err := p.keysFor(ctx, head.KeyID)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := slices.ContainsFunc(keys, func(key crypto.PublicKey) bool {
+		return verifySignature(alg, key, signed, signature)
+	})
+	if !verified {
+		return Claims{}, fmt.Errorf("%w: signature does not match", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed claims: %w", ErrInvalidToken, err)
+	}
+
+	if err := p.validateClaims(claims, nonce); err != nil {
+		return Claims{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	return claims, nil
+}
+
+// validateClaims checks the claims as per OpenID Connect Core, section 3.1.3.7.
+func (p *Provider) validateClaims(claims Claims, nonce string) error {
+	if claims.Issuer != p.options.Issuer {
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+
+	if claims.Subject == "" {
+		return errors.New("no subject")
+	}
+
+	if !slices.Contains(claims.Audience, p.options.ClientID) {
+		return errors.New("token is not meant for this client")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.options.ClientID {
+		return errors.New("token is not authorized for this client")
+	}
+
+	now := p.now()
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)) {
+		return errors.New("token is expired")
+	}
+	if now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)) {
+		return errors.New("token is issued in the future")
+	}
+
+	// The nonce ties the token to the login that asked for it, which prevents replays.
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return errors.New("nonce does not match")
+	}
+
+	return nil
+}
+
+// keySet is the parsed JWKS of the provider.
+type keySet struct {
+	// byID maps key IDs to keys. Keys without an ID are under the empty string.
+	byID      map[string][]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// keysFor returns the keys that may have signed a token with the given key ID. If there is no key with the ID, the
+// keys are fetched again, as the provider may have rotated them.
+func (p *Provider) keysFor(ctx context.Context, keyID string) ([]crypto.PublicKey, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.keys != nil {
+		if keys := p.keys.lookup(keyID); len(keys) > 0 {
+			return keys, nil
+		}
+		if p.now().Sub(p.keys.fetchedAt) < keysRefreshInterval {
+			return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, keyID)
+		}
+	}
+
+	keys, err := p.fetchKeys(ctx, meta.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+
+	if keys := p.keys.lookup(keyID); len(keys) > 0 {
+		return keys, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, keyID)
+}
+
+// lookup returns the keys with the given ID. Tokens without a key ID can be signed by any key.
+func (k *keySet) lookup(keyID string) []crypto.PublicKey {
+	if keyID != "" {
+		return k.byID[keyID]
+	}
+
+	var all []crypto.PublicKey
+	for _, keys := range k.byID {
+		all = append(all, keys...)
+	}
+	return all
+}
+
+// jsonWebKey is a key in a JWKS, as per RFC 7517. Only the members of RSA and EC public keys are used.
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+
+	N string `json:"n"`
+	E string `json:"e"`
+
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+// fetchKeys fetches and parses the JWKS. Keys that are not for signatures, or that cannot be parsed, are skipped.
+func (p *Provider) fetchKeys(ctx context.Context, jwksURI string) (*keySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create jwks request: %w", err)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.do(req, &jwks); err != nil {
+		return nil, fmt.Errorf("jwks request failed: %w", err)
+	}
+
+	keys := &keySet{byID: map[string][]crypto.PublicKey{}, fetchedAt: p.now()}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys.byID[jwk.KeyID] = append(keys.byID[jwk.KeyID], key)
+		}
+	}
+
+	return keys, nil
+}
+
+// publicKey parses the key.
+func (j jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch j.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("exponent is too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+
+	case "EC":
+		curve, ok := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(),
+			"P-521": elliptic.P521()}[j.Curve]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", j.Curve)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid coordinate length")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.KeyType)
+	}
+}
+
+// verifySignature reports whether the signature of the signed bytes is valid for the algorithm and the key.
+func verifySignature(alg algorithm, key crypto.PublicKey, signed, signature []byte) bool {
+	hasher := alg.hash.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return alg.keyType == "RSA" && rsa.VerifyPKCS1v15(key, alg.hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		if alg.keyType != "EC" || key.Curve != alg.curve {
+			return false
+		}
+		// JWS signatures are the two integers of the signature concatenated, instead of their ASN.1 encoding.
+		size := (alg.curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	default:
+		return false
+	}
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token.
+func decodeSegment(segment string, target any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
@@ -0,0 +1,305 @@
+// Package password hashes and verifies passwords, and enforces the rules that new passwords must follow.
+//
+// Hashes carry their algorithm and parameters, so hashes made with different algorithms or parameters can be verified
+// side by side. That allows the algorithm to be changed without locking out existing users: their hashes are replaced
+// on their next successful login.
+package password
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shivanshkc/rosenbridge/internal/metrics"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The supported hashing algorithms.
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+const (
+	// DefaultMinLength is the min length of passwords if none is configured, in characters.
+	DefaultMinLength = 8
+	// MaxLength is the max length of passwords, in bytes. It keeps the cost of hashing in check.
+	MaxLength = 100
+	// bcryptMaxLength is the max length of passwords that bcrypt supports, in bytes. The rest would be ignored.
+	bcryptMaxLength = 72
+
+	// argon2SaltLength and argon2KeyLength are the sizes of the salt and the key of argon2id hashes, in bytes.
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// DefaultArgon2 are the argon2id parameters used if none are configured, as recommended by OWASP.
+var DefaultArgon2 = Argon2Params{Memory: 19 * 1024, Iterations: 2, Parallelism: 1}
+
+var (
+	// ErrMismatch is returned by Verify if the password does not match the hash.
+	ErrMismatch = errors.New("password does not match")
+	// ErrBreached is returned by Validate if the password is in the breached list.
+	ErrBreached = errors.New("password is known to be leaked, please choose another one")
+
+	errUnknownHash = errors.New("unknown hash format")
+)
+
+// verifyDuration is the latency of password verifications, which is mostly the cost of the hashing algorithm.
+var verifyDuration = metrics.NewHistogramVec("rosenbridge_password_verify_duration_seconds",
+	"Duration of password verifications, by the algorithm of the hash.", metrics.DefaultBuckets, "algorithm")
+
+// Argon2Params are the parameters of argon2id hashes.
+type Argon2Params struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// Options configure a Hasher. Zero values are replaced by the defaults.
+type Options struct {
+	// Algorithm of new hashes, Bcrypt or Argon2id. Defaults to Bcrypt.
+	Algorithm string
+	// BcryptCost is the cost of bcrypt hashes. Defaults to bcrypt.DefaultCost.
+	BcryptCost int
+	// Argon2 are the parameters of argon2id hashes. Defaults to DefaultArgon2.
+	Argon2 Argon2Params
+
+	// MinLength is the min length of new passwords, in characters. Defaults to DefaultMinLength.
+	MinLength int
+	// BreachedListPath is a file of passwords that are known to be leaked, one per line, which cannot be used as new
+	// passwords. No passwords are refused if it is empty.
+	BreachedListPath string
+}
+
+// Hasher hashes passwords as per its options.
+//
+// The zero value is ready to use, and hashes with bcrypt at the default cost.
+type Hasher struct {
+	options Options
+	// breached is the set of passwords that are known to be leaked.
+	breached map[string]struct{}
+}
+
+// New returns a Hasher with the given options. It reads the breached list, if any.
+func New(options Options) (Hasher, error) {
+	switch options.Algorithm {
+	case "", Bcrypt, Argon2id:
+	default:
+		return Hasher{}, fmt.Errorf("unknown algorithm: %q", options.Algorithm)
+	}
+
+	if options.BcryptCost != 0 && (options.BcryptCost < bcrypt.MinCost || options.BcryptCost > bcrypt.MaxCost) {
+		return Hasher{}, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	hasher := Hasher{options: options}
+	if options.BreachedListPath == "" {
+		return hasher, nil
+	}
+
+	breached, err := readBreachedList(options.BreachedListPath)
+	if err != nil {
+		return Hasher{}, fmt.Errorf("failed to read breached list: %w", err)
+	}
+	hasher.breached = breached
+	return hasher, nil
+}
+
+// Validate returns an error if the password does not follow the rules for new passwords. The error is safe to show
+// to the user.
+func (h Hasher) Validate(password string) error {
+	if minLength := h.minLength(); utf8.RuneCountInString(password) < minLength {
+		return fmt.Errorf("password must be at least %d characters", minLength)
+	}
+
+	if maxLength := h.maxLength(); len(password) > maxLength {
+		return fmt.Errorf("password must not be longer than %d bytes", maxLength)
+	}
+
+	if _, ok := h.breached[password]; ok {
+		return ErrBreached
+	}
+
+	return nil
+}
+
+// Hash returns the hash of the password, made with the configured algorithm and parameters.
+func (h Hasher) Hash(password string) (string, error) {
+	if h.algorithm() == Argon2id {
+		return hashArgon2id(password, h.argon2())
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost())
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify checks the password against the hash, which can be made with any supported algorithm. If they match, it
+// also reports whether the hash should be replaced, because its algorithm or parameters are weaker than configured.
+//
+// If they do not match, it returns ErrMismatch. Other errors mean that the hash is malformed.
+func (h Hasher) Verify(hash, password string) (rehash bool, err error) {
+	start := time.Now()
+
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		defer func() { verifyDuration.With(Bcrypt).Observe(time.Since(start).Seconds()) }()
+
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, ErrMismatch
+			}
+			return false, err
+		}
+
+		// The cost is known to be valid, as the comparison succeeded.
+		cost, _ := bcrypt.Cost([]byte(hash))
+		return h.algorithm() != Bcrypt || cost < h.bcryptCost(), nil
+
+	case strings.HasPrefix(hash, "$"+Argon2id+"$"):
+		defer func() { verifyDuration.With(Argon2id).Observe(time.Since(start).Seconds()) }()
+
+		params, err := verifyArgon2id(hash, password)
+		if err != nil {
+			return false, err
+		}
+
+		want := h.argon2()
+		return h.algorithm() != Argon2id || params.Memory < want.Memory || params.Iterations < want.Iterations ||
+			params.Parallelism < want.Parallelism, nil
+
+	default:
+		return false, errUnknownHash
+	}
+}
+
+func (h Hasher) algorithm() string {
+	if h.options.Algorithm == "" {
+		return Bcrypt
+	}
+	return h.options.Algorithm
+}
+
+func (h Hasher) bcryptCost() int {
+	if h.options.BcryptCost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return h.options.BcryptCost
+}
+
+// argon2 returns the configured argon2id parameters, with unset values replaced by the defaults.
+func (h Hasher) argon2() Argon2Params {
+	params := h.options.Argon2
+	if params.Memory == 0 {
+		params.Memory = DefaultArgon2.Memory
+	}
+	if params.Iterations == 0 {
+		params.Iterations = DefaultArgon2.Iterations
+	}
+	if params.Parallelism == 0 {
+		params.Parallelism = DefaultArgon2.Parallelism
+	}
+	return params
+}
+
+func (h Hasher) minLength() int {
+	if h.options.MinLength <= 0 {
+		return DefaultMinLength
+	}
+	return h.options.MinLength
+}
+
+// maxLength is MaxLength, or less if the algorithm cannot use that many bytes.
+func (h Hasher) maxLength() int {
+	if h.algorithm() == Bcrypt {
+		return bcryptMaxLength
+	}
+	return MaxLength
+}
+
+// hashArgon2id returns the argon2id hash of the password in the PHC string format, which is
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>.
+func hashArgon2id(password string, params Argon2Params) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2id, argon2.Version, params.Memory, params.Iterations,
+		params.Parallelism, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyArgon2id checks the password against the argon2id hash, and returns the parameters of the hash.
+func verifyArgon2id(hash, password string) (Argon2Params, error) {
+	// The first part is empty, as the hash starts with the separator.
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, fmt.Errorf("unsupported argon2id version: %q", parts[2])
+	}
+
+	var params Argon2Params
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil || params.Iterations == 0 || params.Parallelism == 0 {
+		return Argon2Params{}, fmt.Errorf("malformed argon2id parameters: %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2Params{}, errors.New("malformed argon2id key")
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism,
+		uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return Argon2Params{}, ErrMismatch
+	}
+
+	return params, nil
+}
+
+// readBreachedList reads the file of breached passwords, one per line. Empty lines are skipped.
+func readBreachedList(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	breached := map[string]struct{}{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Lists made on Windows end their lines with "\r\n".
+		if line := strings.TrimSuffix(scanner.Text(), "\r"); line != "" {
+			breached[line] = struct{}{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return breached, nil
+}
@@ -0,0 +1,231 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastArgon2 keeps the tests fast.
+var fastArgon2 = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestNew(t *testing.T) {
+	_, err := New(Options{Algorithm: "md5"})
+	require.ErrorContains(t, err, `unknown algorithm: "md5"`)
+
+	_, err = New(Options{BcryptCost: bcrypt.MaxCost + 1})
+	require.ErrorContains(t, err, "bcrypt cost must be between")
+
+	_, err = New(Options{BreachedListPath: filepath.Join(t.TempDir(), "missing.txt")})
+	require.ErrorContains(t, err, "failed to read breached list")
+}
+
+func TestHasher_Validate(t *testing.T) {
+	breachedListPath := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(breachedListPath, []byte("password\r\n\n123456789\n"), 0o600))
+
+	bcryptHasher, err := New(Options{BreachedListPath: breachedListPath})
+	require.NoError(t, err)
+
+	argon2Hasher, err := New(Options{Algorithm: Argon2id, MinLength: 4})
+	require.NoError(t, err)
+
+	var testCases = []struct {
+		name           string
+		hasher         Hasher
+		password       string
+		expectedErrMsg string
+	}{
+		{
+			name:     "Valid password, no error expected",
+			hasher:   bcryptHasher,
+			password: "correct horse",
+		},
+		{
+			name:           "Too short, error expected",
+			hasher:         bcryptHasher,
+			password:       "short",
+			expectedErrMsg: "password must be at least 8 characters",
+		},
+		{
+			name:     "Length is in characters, no error expected",
+			hasher:   bcryptHasher,
+			password: "ääääääää",
+		},
+		{
+			name:           "Longer than bcrypt supports, error expected",
+			hasher:         bcryptHasher,
+			password:       strings.Repeat("s", bcryptMaxLength+1),
+			expectedErrMsg: "password must not be longer than 72 bytes",
+		},
+		{
+			name:           "Breached password, error expected",
+			hasher:         bcryptHasher,
+			password:       "123456789",
+			expectedErrMsg: ErrBreached.Error(),
+		},
+		{
+			name:     "Configured min length, no error expected",
+			hasher:   argon2Hasher,
+			password: "four",
+		},
+		{
+			name:     "Longer than bcrypt supports with argon2id, no error expected",
+			hasher:   argon2Hasher,
+			password: strings.Repeat("s", MaxLength),
+		},
+		{
+			name:           "Too long, error expected",
+			hasher:         argon2Hasher,
+			password:       strings.Repeat("s", MaxLength+1),
+			expectedErrMsg: "password must not be longer than 100 bytes",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.hasher.Validate(tc.password)
+			if tc.expectedErrMsg == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.expectedErrMsg)
+		})
+	}
+}
+
+func TestHasher_HashAndVerify(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		options        Options
+		expectedPrefix string
+	}{
+		{
+			name:           "Zero options, bcrypt expected",
+			expectedPrefix: "$2a$10$",
+		},
+		{
+			name:           "Bcrypt with cost, bcrypt expected",
+			options:        Options{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost},
+			expectedPrefix: "$2a$04$",
+		},
+		{
+			name:           "Argon2id, argon2id expected",
+			options:        Options{Algorithm: Argon2id, Argon2: fastArgon2},
+			expectedPrefix: "$argon2id$v=19$m=64,t=1,p=1$",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hasher, err := New(tc.options)
+			require.NoError(t, err)
+
+			hash, err := hasher.Hash("correct horse")
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(hash, tc.expectedPrefix), hash)
+
+			rehash, err := hasher.Verify(hash, "correct horse")
+			require.NoError(t, err)
+			require.False(t, rehash)
+
+			_, err = hasher.Verify(hash, "wrong horse")
+			require.ErrorIs(t, err, ErrMismatch)
+		})
+	}
+}
+
+func TestHasher_Verify_Rehash(t *testing.T) {
+	hash := func(options Options) string {
+		hasher, err := New(options)
+		require.NoError(t, err)
+		hash, err := hasher.Hash("correct horse")
+		require.NoError(t, err)
+		return hash
+	}
+
+	bcryptMin := hash(Options{BcryptCost: bcrypt.MinCost})
+	bcryptHigher := hash(Options{BcryptCost: bcrypt.MinCost + 1})
+	argon2Fast := hash(Options{Algorithm: Argon2id, Argon2: fastArgon2})
+
+	var testCases = []struct {
+		name     string
+		options  Options
+		hash     string
+		expected bool
+	}{
+		{
+			name:     "Bcrypt with the configured cost, no rehash expected",
+			options:  Options{BcryptCost: bcrypt.MinCost},
+			hash:     bcryptMin,
+			expected: false,
+		},
+		{
+			name:     "Bcrypt with a higher cost, no rehash expected",
+			options:  Options{BcryptCost: bcrypt.MinCost},
+			hash:     bcryptHigher,
+			expected: false,
+		},
+		{
+			name:     "Bcrypt with a lower cost, rehash expected",
+			options:  Options{BcryptCost: bcrypt.MinCost + 1},
+			hash:     bcryptMin,
+			expected: true,
+		},
+		{
+			name:     "Bcrypt when argon2id is configured, rehash expected",
+			options:  Options{Algorithm: Argon2id, Argon2: fastArgon2},
+			hash:     bcryptMin,
+			expected: true,
+		},
+		{
+			name:     "Argon2id when bcrypt is configured, rehash expected",
+			options:  Options{BcryptCost: bcrypt.MinCost},
+			hash:     argon2Fast,
+			expected: true,
+		},
+		{
+			name:     "Argon2id with less memory, rehash expected",
+			options:  Options{Algorithm: Argon2id, Argon2: Argon2Params{Memory: 128, Iterations: 1, Parallelism: 1}},
+			hash:     argon2Fast,
+			expected: true,
+		},
+		{
+			name:     "Argon2id with fewer iterations, rehash expected",
+			options:  Options{Algorithm: Argon2id, Argon2: Argon2Params{Memory: 64, Iterations: 2, Parallelism: 1}},
+			hash:     argon2Fast,
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hasher, err := New(tc.options)
+			require.NoError(t, err)
+
+			rehash, err := hasher.Verify(tc.hash, "correct horse")
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, rehash)
+		})
+	}
+}
+
+func TestHasher_Verify_Malformed(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$2a$04$short",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5",
+	} {
+		_, err := Hasher{}.Verify(hash, "correct horse")
+		require.Error(t, err, hash)
+		require.NotErrorIs(t, err, ErrMismatch, hash)
+	}
+}
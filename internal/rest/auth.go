@@ -0,0 +1,80 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
+)
+
+// caller is the authenticated user of a request.
+type caller struct {
+	username string
+	// scopes are those of the user, or of the API key if one was used.
+	scopes scope.Set
+	// apiKey is the key that authenticated the request. It is nil if the password was used.
+	apiKey *database.APIKey
+}
+
+// require returns an error naming the first of the required scopes that the caller does not have.
+func (c caller) require(required ...string) error {
+	if missing := c.scopes.Missing(required); missing != "" {
+		return fmt.Errorf("missing scope: %s", missing)
+	}
+	return nil
+}
+
+// allowAccountManagement returns an error if the caller used an API key. Only the password can change the account, so
+// that a leaked key cannot be used to mint more keys, for example.
+func (c caller) allowAccountManagement() error {
+	if c.apiKey != nil {
+		return errAPIKeyNotAllowed
+	}
+	return nil
+}
+
+// authorize authenticates the caller of the request, and makes sure that it has all the required scopes. Every route
+// that needs a scope declares it by calling this.
+//
+// The caller does not need to log the returned error. Also, the returned error is safe to send in the response.
+func (h *Handler) authorize(r *http.Request, required ...string) (caller, error) {
+	caller, err := h.authenticateUser(r)
+	if err != nil {
+		return caller, err
+	}
+
+	if err := caller.require(required...); err != nil {
+		slog.ErrorContext(r.Context(), "caller lacks a scope", "username", caller.username, "error", err)
+		return caller, httputils.Forbidden().WithReasonErr(err)
+	}
+
+	return caller, nil
+}
+
+// hasScope reports whether the user, authenticated with the given scopes, has the required scope. The user's current
+// scopes must grant it as well, so that taking a scope away from the user applies to their open connections too. It
+// is the broker.Authorizer of the handler, so unexpected errors deny access.
+func (h *Handler) hasScope(username string, scopes scope.Set, required string) bool {
+	user, err := h.dbase.GetUser(context.Background(), username)
+	if err != nil {
+		if !errors.Is(err, database.ErrUserNotFound) {
+			slog.Error("failed to get user to check scope", "username", username, "error", err)
+		}
+		return false
+	}
+
+	return scopes.Has(required) && userScopes(user).Has(required)
+}
+
+// userScopes returns the scopes of the user, which are the defaults if none were given.
+func userScopes(user database.User) scope.Set {
+	if len(user.Scopes) == 0 {
+		return scope.Default
+	}
+	return user.Scopes
+}
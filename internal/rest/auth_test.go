@@ -0,0 +1,224 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shivanshkc/rosenbridge/internal/broker"
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/internal/ws"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_scopes(t *testing.T) {
+	sendMessage := func() *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/api/message",
+			strings.NewReader(`{"message":"hello","receivers":["alice","bob"]}`))
+	}
+
+	publish := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/topic/news/publish", strings.NewReader(`{"message":"hello"}`))
+		r.SetPathValue("name", "news")
+		return r
+	}
+
+	connect := func() *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/api/connect", nil)
+	}
+
+	var testCases = []struct {
+		name         string
+		userScopes   []string
+		keyScopes    []string // The password is used if nil.
+		request      func() *http.Request
+		serve        func(h *Handler, w http.ResponseWriter, r *http.Request)
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Receive-only user sends a message, 403 expected",
+			userScopes:   []string{scope.Connect},
+			request:      sendMessage,
+			serve:        (*Handler).sendMessage,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"missing scope: send:*"}`,
+		},
+		{
+			name:         "Send-only user connects, 403 expected",
+			userScopes:   []string{scope.Send},
+			request:      connect,
+			serve:        (*Handler).getConnection,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"missing scope: connect"}`,
+		},
+		{
+			name:         "User limited to one receiver sends to another, 403 expected",
+			userScopes:   []string{scope.SendTo("alice")},
+			request:      sendMessage,
+			serve:        (*Handler).sendMessage,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"missing scope: send:bob"}`,
+		},
+		{
+			name:         "User allowed to send to both receivers, 202 expected",
+			userScopes:   []string{scope.SendTo("alice"), scope.SendTo("bob")},
+			request:      sendMessage,
+			serve:        (*Handler).sendMessage,
+			expectedCode: http.StatusAccepted,
+			expectedBody: `{"receivers":[{"username":"alice","outcome":"accepted"},{"username":"bob","outcome":"accepted"}]}`,
+		},
+		{
+			name:         "User limited to one receiver publishes, 403 expected",
+			userScopes:   []string{scope.SendTo("alice")},
+			request:      publish,
+			serve:        (*Handler).publishToTopic,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"missing scope: send"}`,
+		},
+		{
+			name:         "User with default scopes publishes, 202 expected",
+			request:      publish,
+			serve:        (*Handler).publishToTopic,
+			expectedCode: http.StatusAccepted,
+			expectedBody: `{}`,
+		},
+		{
+			name:         "Send-only key connects, 403 expected",
+			keyScopes:    []string{scope.Send},
+			request:      connect,
+			serve:        (*Handler).getConnection,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"missing scope: connect"}`,
+		},
+		{
+			name:         "Key keeps no more scopes than its user, 403 expected",
+			userScopes:   []string{scope.Connect},
+			keyScopes:    []string{scope.Connect, scope.Send},
+			request:      sendMessage,
+			serve:        (*Handler).sendMessage,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"missing scope: send:*"}`,
+		},
+		{
+			name:         "Receive-only user creates an API key, 403 expected",
+			userScopes:   []string{scope.Connect},
+			request:      func() *http.Request { return httptest.NewRequest(http.MethodPost, apiKeyPath, nil) },
+			serve:        (*Handler).createAPIKey,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"missing scope: send:*"}`,
+		},
+		{
+			name:         "Send-only user reads the privacy settings, 403 expected",
+			userScopes:   []string{scope.Send},
+			request:      func() *http.Request { return httptest.NewRequest(http.MethodGet, privacyPath, nil) },
+			serve:        (*Handler).getPrivacy,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"missing scope: connect"}`,
+		},
+		{
+			name:         "Key registers a webhook, 403 expected",
+			keyScopes:    scope.Default,
+			request:      func() *http.Request { return httptest.NewRequest(http.MethodPut, webhookPath, nil) },
+			serve:        (*Handler).putWebhook,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"` + errAPIKeyNotAllowed.Error() + `"}`,
+		},
+	}
+
+	topics, err := topic.NewACL([]topic.Rule{{Topic: "#", Publishers: []string{topic.AnyUser}}})
+	require.NoError(t, err)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			user, key := newAPIKeyTestUser(t, "password123", tc.keyScopes)
+			user.Scopes = tc.userScopes
+
+			w := httptest.NewRecorder()
+			r := tc.request()
+			if tc.keyScopes != nil {
+				r.Header.Set(headerAPIKey, key)
+			} else {
+				r.SetBasicAuth(user.Username, "password123")
+			}
+
+			handler := &Handler{
+				dbase:     &fakeDatabase{getUser: user},
+				broker:    broker.New(topics, nil),
+				wsManager: ws.NewManager(nil),
+			}
+			tc.serve(handler, w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.Equal(t, tc.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestHandler_setUserScopes(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		callerScopes []string
+		requestBody  string
+		errUpdate    error
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Caller is not an admin, 403 expected",
+			callerScopes: scope.Default,
+			requestBody:  `{"scopes":["connect"]}`,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"missing scope: admin"}`,
+		},
+		{
+			name:         "Unknown scope, 400 expected",
+			callerScopes: []string{scope.Admin},
+			requestBody:  `{"scopes":["connect","everything"]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"unknown scope: \"everything\""}`,
+		},
+		{
+			name:         "User not found, 404 expected",
+			callerScopes: []string{scope.Admin},
+			requestBody:  `{"scopes":["connect"]}`,
+			errUpdate:    database.ErrUserNotFound,
+			expectedCode: http.StatusNotFound,
+			expectedBody: `{"status":"Not Found","reason":"user not found"}`,
+		},
+		{
+			name:         "Valid request, 200 expected",
+			callerScopes: []string{scope.Admin},
+			requestBody:  `{"scopes":["connect","send:alice"]}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"scopes":["connect","send:alice"],"username":"kiosk"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			admin, _ := newAPIKeyTestUser(t, "password123", nil)
+			admin.Scopes = tc.callerScopes
+			dbase := &fakeDatabase{getUser: admin, errUpdateUser: tc.errUpdate}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, "/api/user/kiosk/scopes", strings.NewReader(tc.requestBody))
+			r.SetPathValue("username", "kiosk")
+			r.SetBasicAuth(admin.Username, "password123")
+
+			handler := &Handler{dbase: dbase}
+			handler.setUserScopes(w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.Equal(t, tc.expectedBody, w.Body.String())
+
+			if tc.expectedCode == http.StatusOK {
+				require.Equal(t, []string{scope.Connect, scope.SendTo("alice")}, dbase.updatedUser.Scopes)
+			}
+		})
+	}
+}
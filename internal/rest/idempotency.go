@@ -0,0 +1,113 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/shivanshkc/rosenbridge/internal/idempotency"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
+)
+
+// beginIdempotentRequest registers the given idempotency key (usually from the Idempotency-Key header) for the given
+// owner and decoded request body. It is a no-op if the key is empty or if idempotency keys are disabled.
+//
+// If it returns false, the response has already been written, either as a replay of the original response or as an
+// error, and the caller must not process the request. Otherwise, the caller must process the request and then call
+// completeIdempotentRequest with the response.
+func (h *Handler) beginIdempotentRequest(w http.ResponseWriter, r *http.Request, owner, key string, body any) bool {
+	ctx := r.Context()
+
+	if key == "" || h.idempotency == nil {
+		return true
+	}
+
+	if err := validateIdempotencyKey(key); err != nil {
+		slog.ErrorContext(ctx, "invalid idempotency key", "error", err)
+		httputils.WriteError(w, httputils.BadRequest().WithReasonErr(err))
+		return false
+	}
+
+	fingerprint, err := requestFingerprint(r, body)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to fingerprint request", "error", err)
+		httputils.WriteError(w, httputils.InternalServerError())
+		return false
+	}
+
+	response, err := h.idempotency.Begin(owner, key, fingerprint)
+	switch {
+	case errors.Is(err, idempotency.ErrFingerprintMismatch), errors.Is(err, idempotency.ErrInProgress):
+		slog.ErrorContext(ctx, "idempotency key conflict", "error", err)
+		httputils.WriteError(w, httputils.Conflict().WithReasonErr(err))
+		return false
+	case err != nil:
+		slog.ErrorContext(ctx, "unexpected error in idempotency check", "error", err)
+		httputils.WriteError(w, httputils.InternalServerError())
+		return false
+	case response != nil:
+		slog.InfoContext(ctx, "replaying response for idempotency key")
+		headers := map[string]string{headerIdempotentReplay: "true"}
+		httputils.WriteJson(w, response.StatusCode, headers, response.Body)
+		return false
+	}
+
+	return true
+}
+
+// abortIdempotentRequest forgets the idempotency key registered by beginIdempotentRequest, so the request can be
+// retried with it. It must be called if the request fails after beginIdempotentRequest.
+// It is a no-op if the key is empty or if idempotency keys are disabled.
+func (h *Handler) abortIdempotentRequest(owner, key string) {
+	if key == "" || h.idempotency == nil {
+		return
+	}
+
+	h.idempotency.Abort(owner, key)
+}
+
+// completeIdempotentRequest records the response against the idempotency key registered by beginIdempotentRequest.
+// It is a no-op if the key is empty or if idempotency keys are disabled.
+func (h *Handler) completeIdempotentRequest(owner, key string, response idempotency.Response) {
+	if key == "" || h.idempotency == nil {
+		return
+	}
+
+	h.idempotency.Complete(owner, key, response)
+}
+
+// requestFingerprint returns the fingerprint of a request with the given decoded body, which detects reuse of an
+// idempotency key with a different request. It covers the path as well, because the same body can be sent to
+// different topics, for example.
+//
+// The body is canonicalised first, so retries that only differ in whitespace, key order or unknown fields, including
+// those within the payload, are the same request.
+func requestFingerprint(r *http.Request, body any) (string, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode body: %w", err)
+	}
+
+	// Decoding into a generic value and encoding it again sorts the keys of every object. Numbers are kept as they
+	// are, so they do not lose precision.
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+
+	var generic any
+	if err := decoder.Decode(&generic); err != nil {
+		return "", fmt.Errorf("failed to decode body: %w", err)
+	}
+
+	canonical, err := json.Marshal(generic)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode canonical body: %w", err)
+	}
+
+	fingerprint := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), canonical...))
+	return hex.EncodeToString(fingerprint[:]), nil
+}
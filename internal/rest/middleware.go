@@ -1,34 +1,42 @@
 package rest
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 	"runtime/debug"
 	"slices"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/shivanshkc/rosenbridge/internal/logger"
+	"github.com/shivanshkc/rosenbridge/internal/tracing"
 	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
 
 	"github.com/google/uuid"
 )
 
 const (
-	headerCorrelationID = "X-Correlation-ID"
+	headerCorrelationID    = "X-Correlation-ID"
+	headerIdempotencyKey   = "Idempotency-Key"
+	headerIdempotentReplay = "Idempotent-Replayed"
+	headerAPIKey           = "X-API-Key"
 
 	ctxRequestID     = "request-id"
 	ctxCorrelationID = "correlation-id"
+	ctxTraceID       = "trace-id"
 
 	// The browser will not send the actual request after preflight if the method is not allowed.
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Reference/Headers/Access-Control-Allow-Methods
 	corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
 	// The browser will not send the actual request after preflight if it requires headers outside of this list.
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Reference/Headers/Access-Control-Allow-Headers
-	corsAllowedHeaders = "Accept, Authorization, Content-Type, " + headerCorrelationID
+	corsAllowedHeaders = "Accept, Authorization, Content-Type, " + headerCorrelationID + ", " + headerIdempotencyKey +
+		", " + headerAPIKey + ", " + tracing.HeaderTraceParent + ", " + tracing.HeaderTraceState
 	// The browser javascript will be able to read only these headers.
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Reference/Headers/Access-Control-Expose-Headers
-	corsExposedHeaders = headerCorrelationID
+	corsExposedHeaders = headerCorrelationID + ", " + headerIdempotentReplay
 )
 
 // recoveryMiddleware wraps the given http.Handler with a panic recover call. This makes sure that if the app panics
@@ -55,6 +63,8 @@ func recoveryMiddleware(next http.Handler) http.Handler {
 
 // accessLoggerMiddleware wraps the given http.Handler with a logger that logs http request-response details, like
 // method, URL, execution time (latency), and response status code.
+//
+// It also starts the server span of the request, continuing the trace of the caller if it sent a traceparent header.
 func accessLoggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -74,6 +84,12 @@ func accessLoggerMiddleware(next http.Handler) http.Handler {
 		// 2. Request ID
 		newCtx = logger.AddContextValue(newCtx, ctxRequestID, uuid.NewString())
 
+		// The span is renamed after its route once the request is routed.
+		newCtx, span := tracing.Start(tracing.Extract(newCtx, r.Header), r.Method, tracing.KindServer)
+		defer span.End()
+		// 3. Trace ID
+		newCtx = logger.AddContextValue(newCtx, ctxTraceID, span.Context().TraceID.String())
+
 		// Update the request context to the new one.
 		*r = *r.WithContext(newCtx)
 
@@ -86,37 +102,74 @@ func accessLoggerMiddleware(next http.Handler) http.Handler {
 		slog.InfoContext(newCtx, "request received", "url", r.URL.String(), "method", r.Method)
 		// Release control to the next middleware or handler.
 		next.ServeHTTP(cw, r)
+		latency := time.Since(start)
+		observeRequest(r, cw.StatusCode, latency.Seconds())
+		endRequestSpan(span, r, cw.StatusCode)
 		// Request exit log.
-		slog.InfoContext(newCtx, "request completed", "latency", time.Since(start), "status", cw.StatusCode)
+		slog.InfoContext(newCtx, "request completed", "latency", latency, "status", cw.StatusCode)
 	})
 }
 
-// corsMiddleware wraps the given http.Handler to apply a strict, browser-correct CORS policy.
-// It adds CORS headers (Access-Control-XXX-XXX) to the response for allowed origins only, short-circuits preflight
-// requests, and leaves non-browser clients unaffected.
+// endRequestSpan names the server span of a request after its route, and records the outcome of the request.
+// Server errors mark the span as failed, but client errors do not, as per the OpenTelemetry conventions.
+func endRequestSpan(span *tracing.Span, r *http.Request, status int) {
+	route := requestRoute(r)
+	status = responseStatus(status)
+
+	span.SetName(r.Method + " " + route)
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("http.route", route)
+	span.SetAttribute("url.path", r.URL.Path)
+	span.SetAttribute("http.response.status_code", status)
+	if status >= http.StatusInternalServerError {
+		span.SetError(errors.New(http.StatusText(status)))
+	}
+}
+
+// corsPolicy is the set of allowed origins, along with the max age of preflight responses.
+type corsPolicy struct {
+	// allowAll is set if "*" is allowed, to easily handle that case.
+	allowAll bool
+	// origins holds the allowed origins, for easy lookups.
+	origins   map[string]struct{}
+	maxAgeSec int
+}
+
+// newCorsPolicy returns a corsPolicy that allows the given origins.
 //
 // TODO: Trim origin values?
-func corsMiddleware(next http.Handler, origins []string, maxAgeSec int) http.Handler {
-	// To easily handle cases where "*" is allowed.
-	allowAllOrigins := slices.Contains(origins, "*")
-	// For easy lookups.
-	allowedOrigins := make(map[string]struct{}, len(origins))
+func newCorsPolicy(origins []string, maxAgeSec int) *corsPolicy {
+	policy := &corsPolicy{
+		allowAll:  slices.Contains(origins, "*"),
+		origins:   make(map[string]struct{}, len(origins)),
+		maxAgeSec: maxAgeSec,
+	}
 	for _, o := range origins {
-		allowedOrigins[o] = struct{}{}
+		policy.origins[o] = struct{}{}
 	}
+	return policy
+}
 
-	// Convenience function. Makes the code below much more readable.
-	isOriginAllowed := func(origin string) bool {
-		if allowAllOrigins {
-			return true
-		}
-
-		_, allowed := allowedOrigins[origin]
-		return allowed
+// allows reports whether the origin is allowed.
+func (p *corsPolicy) allows(origin string) bool {
+	if p.allowAll {
+		return true
 	}
 
+	_, allowed := p.origins[origin]
+	return allowed
+}
+
+// corsMiddleware wraps the given http.Handler to apply a strict, browser-correct CORS policy.
+// It adds CORS headers (Access-Control-XXX-XXX) to the response for allowed origins only, short-circuits preflight
+// requests, and leaves non-browser clients unaffected.
+//
+// The policy is loaded for every request, so it can be replaced while the server is running.
+func corsMiddleware(next http.Handler, policy *atomic.Pointer[corsPolicy]) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
+		// A single load, so that the whole request sees the same policy.
+		current := policy.Load()
 
 		// If no origin is present, process the request, but don't add CORS headers (Access-Control-XXX-XXX) to the
 		// response. This means that a browser will not allow the client javascript to read the response, but cURL,
@@ -133,7 +186,7 @@ func corsMiddleware(next http.Handler, origins []string, maxAgeSec int) http.Han
 		}
 
 		// If origin is not allowed:
-		if !isOriginAllowed(origin) {
+		if !current.allows(origin) {
 			// If it's a Preflight request, respond without adding CORS headers (Access-Control-XXX-XXX).
 			// This will result in the browser never sending the actual request.
 			if r.Method == http.MethodOptions {
@@ -160,7 +213,7 @@ func corsMiddleware(next http.Handler, origins []string, maxAgeSec int) http.Han
 		if r.Method == http.MethodOptions {
 			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
 			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
-			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(maxAgeSec))
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(current.maxAgeSec))
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
@@ -171,9 +224,13 @@ func corsMiddleware(next http.Handler, origins []string, maxAgeSec int) http.Han
 }
 
 // bodySizeLimitMiddleware wraps the given http.Handler to apply a max read limit on the request body.
-func bodySizeLimitMiddleware(next http.Handler, maxBytes int64) http.Handler {
+//
+// Requests to the exempted paths are not limited. Their handlers are responsible for applying limits of their own.
+func bodySizeLimitMiddleware(next http.Handler, maxBytes int64, exemptedPaths ...string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		if !slices.Contains(exemptedPaths, r.URL.Path) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -3,18 +3,32 @@ package rest
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/shivanshkc/rosenbridge/internal/config"
+	"github.com/shivanshkc/rosenbridge/internal/database"
 	"github.com/shivanshkc/rosenbridge/internal/logger"
+	"github.com/shivanshkc/rosenbridge/internal/tracing"
+	"github.com/shivanshkc/rosenbridge/internal/tracing/tracingtest"
+	"github.com/shivanshkc/rosenbridge/internal/webhook"
+	"github.com/shivanshkc/rosenbridge/internal/ws"
 	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
 
+	"github.com/coder/websocket"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestRecoveryMiddleware(t *testing.T) {
@@ -76,6 +90,10 @@ func TestAccessLoggerMiddleware(t *testing.T) {
 	_, err := uuid.Parse(requestID.String())
 	require.NoError(t, err)
 
+	// Logs should name the trace of the request.
+	_, exists = ctxInfo[ctxTraceID]
+	require.True(t, exists)
+
 	// Expect the correct response code.
 	require.Equal(t, expectedStatusCode, recorder.Code)
 
@@ -89,6 +107,111 @@ func TestAccessLoggerMiddleware(t *testing.T) {
 	require.Equal(t, 2, actualLogCount)
 }
 
+func TestAccessLoggerMiddleware_Tracing(t *testing.T) {
+	// This test cannot run in parallel because it relies on the global span exporter.
+	collector := tracingtest.NewCollector(t)
+	exporter := tracing.StartExporter(tracing.ExporterOptions{Endpoint: collector.URL()})
+	defer func() { _ = exporter.Close() }()
+
+	mockPassword := "password123"
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(mockPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	// Traceparent headers of webhook deliveries.
+	traceParents := make(chan string, 10)
+	webhookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParents <- r.Header.Get(tracing.HeaderTraceParent)
+	}))
+	defer webhookServer.Close()
+
+	// The real database is used, as it makes spans of its own.
+	dbase, err := database.NewFileDatabase(filepath.Join(t.TempDir(), "users.json"))
+	require.NoError(t, err)
+	require.NoError(t, dbase.InsertUser(context.Background(),
+		database.User{Username: "shivansh", PasswordHash: string(passwordHash)}))
+	require.NoError(t, dbase.InsertUser(context.Background(), database.User{
+		Username:     "alice",
+		PasswordHash: string(passwordHash),
+		Webhook:      &database.Webhook{URL: webhookServer.URL, Secret: "secret", AllMessages: true},
+	}))
+
+	dispatcher := webhook.NewDispatcher(webhook.Options{AllowPrivateNetworks: true, Timeout: time.Second}, nil)
+	defer func() { _ = dispatcher.Close() }()
+
+	handler := &Handler{dbase: dbase, wsManager: ws.NewManager(nil), webhooks: dispatcher}
+
+	// Connect the receiver, so that the message is written to its connection.
+	connectServer := httptest.NewServer(http.HandlerFunc(handler.getConnection))
+	defer connectServer.Close()
+
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("alice:"+mockPassword)))
+
+	ctx := context.Background()
+	conn, _, err := websocket.Dial(ctx, "ws"+connectServer.URL[4:], &websocket.DialOptions{HTTPHeader: header})
+	require.NoError(t, err)
+	defer func() { _ = conn.Close(websocket.StatusNormalClosure, "") }()
+
+	require.Eventually(t, func() bool { return handler.wsManager.IsConnected("alice") }, 5*time.Second,
+		10*time.Millisecond)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/message", handler.sendMessage)
+
+	traceID, parentSpanID := "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	request := httptest.NewRequest(http.MethodPost, "/api/message",
+		strings.NewReader(`{"message":"hello","receivers":["alice"]}`))
+	request.Header.Set(tracing.HeaderTraceParent, "00-"+traceID+"-"+parentSpanID+"-01")
+	request.SetBasicAuth("shivansh", mockPassword)
+
+	recorder := httptest.NewRecorder()
+	accessLoggerMiddleware(mux).ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+
+	// The receiver gets the message over its connection and its webhook, within the trace of the request.
+	_, _, err = conn.Read(ctx)
+	require.NoError(t, err)
+
+	select {
+	case traceParent := <-traceParents:
+		require.Contains(t, traceParent, traceID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the webhook delivery")
+	}
+
+	// spansOfTrace returns the spans of the trace of the request by name. The connection has a trace of its own.
+	spansOfTrace := func() map[string]tracingtest.Span {
+		spans := map[string]tracingtest.Span{}
+		for _, span := range collector.Spans() {
+			if span.TraceID == traceID {
+				spans[span.Name] = span
+			}
+		}
+		return spans
+	}
+
+	// The delivery span ends only after the webhook server responds.
+	require.Eventually(t, func() bool {
+		exporter.Flush()
+		_, exists := spansOfTrace()["webhook delivery"]
+		return exists
+	}, 5*time.Second, 10*time.Millisecond)
+
+	spans := spansOfTrace()
+	for _, name := range []string{"auth", "password verify", "db GetUser", "websocket write", "webhook delivery"} {
+		require.Contains(t, spans, name)
+	}
+
+	server := spans["POST /api/message"]
+	require.Equal(t, parentSpanID, server.ParentSpanID)
+	require.Equal(t, int(tracing.KindServer), server.Kind)
+	require.Equal(t, "/api/message", server.Attributes["http.route"])
+
+	require.Equal(t, server.SpanID, spans["auth"].ParentSpanID)
+	require.Equal(t, "basic", spans["auth"].Attributes["auth.method"])
+	require.Equal(t, "alice", spans["websocket write"].Attributes["rosenbridge.receiver"])
+}
+
 func TestCorsMiddleware(t *testing.T) {
 	mockOrigin := "https://rosenbridge.shivansh.io"
 	mockMaxAgeSec := 86400
@@ -202,7 +325,9 @@ func TestCorsMiddleware(t *testing.T) {
 			recorder := httptest.NewRecorder()
 
 			// Invoke the middleware.
-			handler := corsMiddleware(mockNext, tc.allowedOrigins, mockMaxAgeSec)
+			var policy atomic.Pointer[corsPolicy]
+			policy.Store(newCorsPolicy(tc.allowedOrigins, mockMaxAgeSec))
+			handler := corsMiddleware(mockNext, &policy)
 			handler.ServeHTTP(recorder, request)
 
 			// Verify flow and response.
@@ -212,3 +337,33 @@ func TestCorsMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_Reload(t *testing.T) {
+	mockOrigin := "https://rosenbridge.shivansh.io"
+
+	var conf config.Config
+	conf.HttpServer.CorsMaxAgeSec = 60
+
+	handler := &Handler{underlying: http.NotFoundHandler()}
+	handler.addMiddleware(conf)
+
+	preflight := func() *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodOptions, "https://rosenbridge.shivansh.io", nil)
+		request.Header.Set("Origin", mockOrigin)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// The origin is not allowed yet.
+	require.Empty(t, preflight().Header().Get("Access-Control-Allow-Origin"))
+
+	conf.HttpServer.AllowedOrigins = []string{mockOrigin}
+	conf.HttpServer.CorsMaxAgeSec = 120
+	handler.Reload(conf)
+
+	// The new policy applies to the next requests.
+	recorder := preflight()
+	require.Equal(t, mockOrigin, recorder.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "120", recorder.Header().Get("Access-Control-Max-Age"))
+}
@@ -0,0 +1,58 @@
+package rest
+
+import (
+	"context"
+	"errors"
+
+	"github.com/shivanshkc/rosenbridge/internal/mqtt"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+)
+
+// mqttHandler implements mqtt.Handler using the same authentication and validations as the REST API.
+type mqttHandler struct {
+	handler *Handler
+}
+
+// MQTTHandler returns the mqtt.Handler of the MQTT server. The server must then be passed to SetMQTT, so that direct
+// messages reach its clients.
+func (h *Handler) MQTTHandler() mqtt.Handler {
+	return mqttHandler{handler: h}
+}
+
+// SetMQTT makes direct messages reach the clients of the MQTT server, and makes those clients count as connected.
+func (h *Handler) SetMQTT(server *mqtt.Server) {
+	h.mqtt.Store(server)
+}
+
+// Authenticate verifies the credentials, and returns the scopes of the caller. Unlike STOMP, MQTT does not require
+// the connect scope to connect, since clients may only publish. Every subscription checks the scopes instead.
+func (m mqttHandler) Authenticate(ctx context.Context, username, password string) (scope.Set, bool, error) {
+	caller, err := m.handler.authenticatePassword(ctx, username, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return caller.scopes, true, nil
+}
+
+// CanReceive reports whether the caller has the connect scope, which is required to receive direct messages over any
+// protocol.
+func (m mqttHandler) CanReceive(username string, scopes scope.Set) bool {
+	return m.handler.hasScope(username, scopes, scope.Connect)
+}
+
+// ValidateMessage validates the message like the POST /api/topic/{name}/publish route. MQTT messages cannot have
+// attachments.
+func (m mqttHandler) ValidateMessage(message protocol.MessageReceived) error {
+	return validateMessage(message.Message, message.Payload, message.Binary, nil, m.handler.messageLimits.orDefaults())
+}
+
+// SendMessage validates the message like the POST /api/message route, and sends it to the receivers.
+func (m mqttHandler) SendMessage(ctx context.Context, message protocol.MessageReceived, scopes scope.Set,
+	receivers []string,
+) error {
+	return m.handler.relayMessage(ctx, message, scopes, receivers)
+}
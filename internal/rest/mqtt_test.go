@@ -0,0 +1,62 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMqttHandler_Authenticate(t *testing.T) {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	// MQTT clients may only publish, so they do not require the connect scope.
+	sendOnlyUser := database.User{Username: "shivansh", PasswordHash: string(passwordHash), Scopes: []string{scope.Send}}
+
+	handler := mqttHandler{handler: &Handler{dbase: &fakeDatabase{getUser: sendOnlyUser}}}
+	scopes, ok, err := handler.Authenticate(context.Background(), "shivansh", "password123")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, scope.Set{scope.Send}, scopes)
+
+	_, ok, err = handler.Authenticate(context.Background(), "shivansh", "wrong")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	handler = mqttHandler{handler: &Handler{dbase: &fakeDatabase{errGetUser: errors.New("db connection failed")}}}
+	_, ok, err = handler.Authenticate(context.Background(), "shivansh", "password123")
+	require.Error(t, err)
+	require.False(t, ok)
+}
+
+func TestMqttHandler_CanReceive(t *testing.T) {
+	handler := mqttHandler{handler: &Handler{dbase: &fakeDatabase{getUser: database.User{Username: "shivansh"}}}}
+	require.True(t, handler.CanReceive("shivansh", scope.Default))
+	// The scopes that the client authenticated with apply, and so do those of the user.
+	require.False(t, handler.CanReceive("shivansh", scope.Set{scope.Send}))
+
+	sendOnlyUser := database.User{Username: "shivansh", Scopes: []string{scope.Send}}
+	handler = mqttHandler{handler: &Handler{dbase: &fakeDatabase{getUser: sendOnlyUser}}}
+	require.False(t, handler.CanReceive("shivansh", scope.Default))
+
+	handler = mqttHandler{handler: &Handler{dbase: &fakeDatabase{errGetUser: database.ErrUserNotFound}}}
+	require.False(t, handler.CanReceive("shivansh", scope.Default))
+}
+
+func TestMqttHandler_ValidateMessage(t *testing.T) {
+	handler := mqttHandler{handler: &Handler{messageLimits: messageLimits{text: 5, payload: 10, binary: 10}}}
+
+	require.NoError(t, handler.ValidateMessage(protocol.MessageReceived{Message: "hello"}))
+	require.ErrorIs(t, handler.ValidateMessage(protocol.MessageReceived{}), errMessageEmpty)
+	require.Equal(t, errMessageTooLong(5), handler.ValidateMessage(protocol.MessageReceived{Message: "hello!"}))
+
+	binary := &protocol.Binary{ContentType: "application/octet-stream", Data: make([]byte, 11)}
+	require.Error(t, handler.ValidateMessage(protocol.MessageReceived{Binary: binary}))
+}
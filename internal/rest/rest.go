@@ -1,21 +1,41 @@
 package rest
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
+	"fmt"
+	"io/fs"
 	"log/slog"
 	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/shivanshkc/rosenbridge/client"
+	"github.com/shivanshkc/rosenbridge/internal/blob"
+	"github.com/shivanshkc/rosenbridge/internal/broker"
 	"github.com/shivanshkc/rosenbridge/internal/config"
 	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/idempotency"
+	"github.com/shivanshkc/rosenbridge/internal/mqtt"
+	"github.com/shivanshkc/rosenbridge/internal/oidc"
+	"github.com/shivanshkc/rosenbridge/internal/password"
+	"github.com/shivanshkc/rosenbridge/internal/stomp"
+	"github.com/shivanshkc/rosenbridge/internal/tracing"
+	"github.com/shivanshkc/rosenbridge/internal/webhook"
 	"github.com/shivanshkc/rosenbridge/internal/ws"
 	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
-// maxBodyReadBytes is the max size that a request body is allowed to have.
+// maxBodyReadBytes is the max size of a request body, besides the parts of a message. See messageLimits.maxBodyBytes.
 const maxBodyReadBytes = 16 * 1024
 
+// defaultReconnectDelayMax is the upper bound of the reconnect delay suggested to websocket clients at shutdown, if
+// none is configured.
+const defaultReconnectDelayMax = 10 * time.Second
+
 // Handler encapsulates all REST API handlers.
 //
 // It implements the http.Handler interface for convenient usage with an http.Server.
@@ -23,16 +43,155 @@ type Handler struct {
 	underlying http.Handler
 	dbase      database.Database
 	wsManager  *ws.Manager
+
+	// passwords hashes the passwords of users, and enforces the password policy.
+	passwords password.Hasher
+
+	// messageLimits are the configured size limits of messages.
+	messageLimits messageLimits
+
+	// rejectRefused reports refused receivers as rejected, instead of silently dropping their messages.
+	rejectRefused bool
+
+	// idempotency remembers responses against idempotency keys. It is nil if the feature is disabled.
+	idempotency *idempotency.Store
+
+	// blobs stores attachments. It is nil if attachments are disabled.
+	blobs blob.Store
+	// attachmentKey signs attachment download links.
+	attachmentKey []byte
+	// attachmentLinkTTL is the validity duration of attachment download links.
+	attachmentLinkTTL time.Duration
+
+	// broker routes the messages published to topics.
+	broker *broker.Broker
+
+	// stomp serves the websocket connections that use the STOMP subprotocol. It can be nil, in which case STOMP is
+	// not supported.
+	stomp *stomp.Server
+	// mqtt serves the MQTT clients, which also receive direct messages. It is set by SetMQTT, and nil if MQTT is
+	// disabled.
+	mqtt atomic.Pointer[mqtt.Server]
+
+	// webhooks delivers messages to the webhooks of users. It is nil if webhooks are disabled.
+	webhooks *webhook.Dispatcher
+
+	// oidc is the OpenID Connect provider that users can log in with. It is nil if OIDC login is disabled.
+	oidc *oidc.Provider
+	// oidcLogin is the config of OIDC logins.
+	oidcLogin oidcLogin
+
+	// metricsAccess restricts who can scrape metrics. It is nil if metrics are disabled.
+	metricsAccess *metricsAccess
+
+	// sessionKey signs session tokens, and the cookies of OIDC logins.
+	sessionKey []byte
+	// sessionTTL is the validity duration of session tokens.
+	sessionTTL time.Duration
+
+	// frontendConfig is the runtime config of the SPA.
+	frontendConfig frontendConfig
+
+	// startedAt is the time when the handler was created, which is reported as the start of the server.
+	startedAt time.Time
+	// draining is set once shutdown begins, which fails the readiness check and refuses new websocket connections.
+	draining atomic.Bool
+
+	// cors is the CORS policy, which is replaced when the config is reloaded.
+	cors atomic.Pointer[corsPolicy]
 }
 
 // NewHandler returns a new Handler instance.
-func NewHandler(conf config.Config, dbase database.Database) *Handler {
+//
+// The blobs store can be nil, in which case attachments are disabled. Likewise, the webhooks dispatcher can be nil,
+// in which case webhooks are disabled.
+//
+// Websocket and STOMP connections are registered with the broker as Deliverers, so they receive the messages
+// published to the topics they subscribe to, through any protocol.
+func NewHandler(conf config.Config, dbase database.Database, passwords password.Hasher, blobs blob.Store,
+	bus *broker.Broker, webhooks *webhook.Dispatcher,
+) *Handler {
+	reconnectDelayMax := time.Duration(conf.HttpServer.ReconnectDelayMaxSec) * time.Second
+	if reconnectDelayMax <= 0 {
+		reconnectDelayMax = defaultReconnectDelayMax
+	}
+
 	handler := &Handler{
 		dbase:     dbase,
-		wsManager: ws.NewManager(),
+		passwords: passwords,
+		blobs:     blobs,
+		broker:    bus,
+		webhooks:  webhooks,
+		wsManager: ws.NewManager(socketHandler{broker: bus, reconnectDelayMax: reconnectDelayMax}),
+		messageLimits: messageLimits{
+			text:    conf.Message.MaxTextBytes,
+			payload: conf.Message.MaxPayloadBytes,
+			binary:  conf.Message.MaxBinaryBytes,
+		},
+		rejectRefused:  conf.Privacy.RefusedMessages == refusedMessagesReject,
+		frontendConfig: newFrontendConfig(conf),
+		startedAt:      time.Now(),
+	}
+
+	if conf.Idempotency.TTLSec > 0 {
+		ttl := time.Duration(conf.Idempotency.TTLSec) * time.Second
+		handler.idempotency = idempotency.NewStore(ttl, conf.Idempotency.MaxKeys)
+	}
+
+	handler.attachmentLinkTTL = time.Duration(conf.Attachment.LinkTTLSec) * time.Second
+	handler.attachmentKey = []byte(conf.Attachment.SigningKey)
+	if len(handler.attachmentKey) == 0 {
+		// Links signed with a random key stop working after a restart, which is acceptable for short-lived links.
+		handler.attachmentKey = make([]byte, 32)
+		_, _ = rand.Read(handler.attachmentKey)
+	}
+
+	handler.sessionTTL = time.Duration(conf.Session.TTLSec) * time.Second
+	if handler.sessionTTL <= 0 {
+		handler.sessionTTL = defaultSessionTTL
 	}
+	handler.sessionKey = []byte(conf.Session.SigningKey)
+	if len(handler.sessionKey) == 0 {
+		// Sessions signed with a random key end with a restart, which only means that users have to log in again.
+		handler.sessionKey = make([]byte, 32)
+		_, _ = rand.Read(handler.sessionKey)
+	}
+
+	if conf.OIDC.Issuer != "" {
+		handler.oidc = oidc.NewProvider(oidc.Options{
+			Issuer:       conf.OIDC.Issuer,
+			ClientID:     conf.OIDC.ClientID,
+			ClientSecret: conf.OIDC.ClientSecret,
+			RedirectURL:  conf.OIDC.RedirectURL,
+			Scopes:       conf.OIDC.Scopes,
+		})
+		handler.oidcLogin = oidcLogin{
+			autoProvision:        conf.OIDC.AutoProvision,
+			linkExistingUsers:    conf.OIDC.LinkExistingUsers,
+			postLoginRedirectURL: conf.OIDC.PostLoginRedirectURL,
+			secureCookie:         strings.HasPrefix(conf.OIDC.RedirectURL, "https://"),
+		}
+	}
+
+	if conf.Metrics.Enabled {
+		handler.metricsAccess = newMetricsAccess(context.Background(), conf.Metrics.BearerToken,
+			conf.Metrics.AllowedNetworks)
+	}
+
+	handler.stomp = stomp.NewServer(bus, stompHandler{handler: handler})
 
-	handler.addRoutes(conf.Frontend.Path)
+	// Websocket messages carry messages too, so they have the same room as request bodies.
+	readLimit := handler.messageLimits.orDefaults().maxBodyBytes()
+	handler.wsManager.SetReadLimit(readLimit)
+	handler.stomp.SetReadLimit(readLimit)
+
+	// Topics are used over every protocol, so the broker checks the scopes of their users.
+	bus.SetAuthorizer(handler.hasScope)
+
+	bus.AddDeliverer(wsDeliverer{manager: handler.wsManager})
+	bus.AddDeliverer(handler.stomp)
+
+	handler.addRoutes(frontendFiles(conf))
 	handler.addMiddleware(conf)
 	return handler
 }
@@ -42,12 +201,29 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 // Close the handler's operations gracefully.
-func (h *Handler) Close() error {
-	return h.wsManager.Close()
+//
+// Websocket and STOMP connections are told that the server is shutting down, and closed with StatusGoingAway. The
+// context bounds how long they get to close; the ones still open when it expires are dropped.
+func (h *Handler) Close(ctx context.Context) error {
+	err := h.wsManager.Close(ctx)
+	if h.stomp != nil {
+		err = errors.Join(err, h.stomp.Close(ctx))
+	}
+	return err
+}
+
+// frontendFiles returns the files of the SPA to serve. They are the ones embedded in the binary, unless a directory is
+// configured, which allows custom builds.
+func frontendFiles(conf config.Config) fs.FS {
+	if conf.Frontend.Path != "" {
+		return os.DirFS(conf.Frontend.Path)
+	}
+	return client.Web()
 }
 
-// addRoutes instantiates the underlying handler and attaches all REST routes to it.
-func (h *Handler) addRoutes(frontendDir string) {
+// addRoutes instantiates the underlying handler and attaches all REST routes to it. The SPA is served from the given
+// files, unless they are nil.
+func (h *Handler) addRoutes(frontend fs.FS) {
 	// A ServeMux will act as the underlying http.Handler.
 	mux := http.NewServeMux()
 	h.underlying = mux
@@ -57,15 +233,64 @@ func (h *Handler) addRoutes(frontendDir string) {
 		httputils.WriteJson(w, http.StatusOK, nil, map[string]any{"code": "OK"})
 	})
 
+	// Health APIs.
+	mux.HandleFunc("GET "+healthLivePath, h.getLiveness)
+	mux.HandleFunc("GET "+healthReadyPath, h.getReadiness)
+	mux.HandleFunc("GET "+healthPath, h.getHealth)
+
 	// Create User API.
 	mux.HandleFunc("POST /api/user", h.createUser)
+	// User Scopes API.
+	mux.HandleFunc("PUT /api/user/{username}/scopes", h.setUserScopes)
 	// Websocket API.
-	mux.HandleFunc("GET /api/connect", h.getConnection)
+	mux.HandleFunc("GET "+connectPath, h.getConnection)
 	// Send Message API.
 	mux.HandleFunc("POST /api/message", h.sendMessage)
+	// Publish API. Topic names contain slashes, so they must be URL encoded in the path.
+	mux.HandleFunc("POST /api/topic/{name}/publish", h.publishToTopic)
+	// Clear Retained Message API.
+	mux.HandleFunc("DELETE /api/topic/{name}/retained", h.deleteRetainedMessage)
+
+	if h.blobs != nil {
+		// Attachment APIs.
+		mux.HandleFunc("POST "+attachmentPath, h.uploadAttachment)
+		mux.HandleFunc("GET "+attachmentPath+"/{id}", h.downloadAttachment)
+	}
+
+	// Privacy APIs.
+	mux.HandleFunc("GET "+privacyPath, h.getPrivacy)
+	mux.HandleFunc("PUT "+privacyPath, h.putPrivacy)
+	mux.HandleFunc("PUT "+blockedPath+"/{username}", h.blockUser)
+	mux.HandleFunc("DELETE "+blockedPath+"/{username}", h.unblockUser)
+	mux.HandleFunc("PUT "+contactsPath+"/{username}", h.addContact)
+	mux.HandleFunc("DELETE "+contactsPath+"/{username}", h.removeContact)
+
+	// API key APIs.
+	mux.HandleFunc("POST "+apiKeyPath, h.createAPIKey)
+	mux.HandleFunc("GET "+apiKeyPath, h.listAPIKeys)
+	mux.HandleFunc("DELETE "+apiKeyPath+"/{id}", h.revokeAPIKey)
+
+	if h.oidc != nil {
+		// OpenID Connect login APIs.
+		mux.HandleFunc("GET "+oidcLoginPath, h.oidcLoginStart)
+		mux.HandleFunc("GET "+oidcCallbackPath, h.oidcLoginCallback)
+	}
+
+	if h.metricsAccess != nil {
+		// Metrics API. It is not under /api, as that is where scrapers look by default.
+		mux.HandleFunc("GET "+metricsPath, h.getMetrics)
+	}
+
+	if h.webhooks != nil {
+		// Webhook APIs.
+		mux.HandleFunc("PUT "+webhookPath, h.putWebhook)
+		mux.HandleFunc("DELETE "+webhookPath, h.deleteWebhook)
+	}
 
-	if frontendDir != "" {
-		mux.Handle("/", serveFrontend(frontendDir))
+	if frontend != nil {
+		// SPA APIs. The runtime config is not a file, so that the server never writes to the SPA files.
+		mux.HandleFunc("GET "+frontendConfigPath, h.getFrontendConfig)
+		mux.Handle("/", serveFrontend(frontend))
 	}
 }
 
@@ -74,42 +299,161 @@ func (h *Handler) addMiddleware(conf config.Config) {
 	// TODO: Add rate limiting.
 
 	// Middleware attachments. This order is opposite to the execution order.
-	next := bodySizeLimitMiddleware(h.underlying, maxBodyReadBytes)
-	next = corsMiddleware(next, conf.HttpServer.AllowedOrigins, conf.HttpServer.CorsMaxAgeSec)
+	// Attachment uploads are limited by the blob store instead.
+	next := bodySizeLimitMiddleware(h.underlying, h.messageLimits.orDefaults().maxBodyBytes(), attachmentPath)
+	h.cors.Store(newCorsPolicy(conf.HttpServer.AllowedOrigins, conf.HttpServer.CorsMaxAgeSec))
+	next = corsMiddleware(next, &h.cors)
 	next = accessLoggerMiddleware(next)
 	next = recoveryMiddleware(next) // <- This will execute first.
 
 	h.underlying = next
 }
 
-// authenticateUser reads basic auth credentials from the request, checks user's existence, and verifies their password.
+// Reload applies the reloadable settings of the config to the running handler, which are the CORS settings. Requests
+// in flight keep the settings they started with.
+func (h *Handler) Reload(conf config.Config) {
+	h.cors.Store(newCorsPolicy(conf.HttpServer.AllowedOrigins, conf.HttpServer.CorsMaxAgeSec))
+}
+
+// ErrInvalidCredentials is returned by Authenticate if the user does not exist or the password is wrong.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticate checks the user's existence and verifies their password. It allows listeners other than HTTP, like
+// MQTT, to authenticate users against the same user store.
+//
+// If the credentials are wrong, it returns ErrInvalidCredentials. Other errors are unexpected.
+func (h *Handler) Authenticate(ctx context.Context, username, password string) error {
+	_, err := h.verifyPassword(ctx, username, password)
+	return err
+}
+
+// verifyPassword is like Authenticate, but it also returns the user.
+func (h *Handler) verifyPassword(ctx context.Context, username, password string) (database.User, error) {
+	// Get user's details for password verification.
+	user, err := h.dbase.GetUser(ctx, username)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			return database.User{}, fmt.Errorf("%w: user does not exist", ErrInvalidCredentials)
+		}
+		return database.User{}, fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	// Verify password. It is traced on its own, as it is slow by design.
+	_, span := tracing.Start(ctx, "password verify", tracing.KindInternal)
+	rehash, err := h.passwords.Verify(user.PasswordHash, password)
+	span.End()
+	if err != nil {
+		return database.User{}, fmt.Errorf("%w: password does not match: %w", ErrInvalidCredentials, err)
+	}
+
+	if rehash {
+		h.rehashPassword(ctx, user, password)
+	}
+
+	return user, nil
+}
+
+// rehashPassword replaces the password hash of the user with one made as per the current config. It is called after
+// a successful login, as that is the only time when the password is known.
+//
+// Failures are only logged, as the old hash still works.
+func (h *Handler) rehashPassword(ctx context.Context, user database.User, password string) {
+	newHash, err := h.passwords.Hash(password)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to rehash password", "username", user.Username, "error", err)
+		return
+	}
+
+	err = h.dbase.UpdateUser(ctx, user.Username, func(stored *database.User) error {
+		// The password may have changed since it was verified, in which case the new one must be kept.
+		if stored.PasswordHash == user.PasswordHash {
+			stored.PasswordHash = newHash
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to save rehashed password", "username", user.Username, "error", err)
+		return
+	}
+
+	slog.InfoContext(ctx, "rehashed password", "username", user.Username)
+}
+
+// The methods that callers can authenticate with, as named in the auth.method span attribute.
+const (
+	authMethodAPIKey  = "api_key"
+	authMethodSession = "session"
+	authMethodBasic   = "basic"
+)
+
+// authenticateUser authenticates the caller of the request, with an API key from the X-API-Key header if present, or
+// with a session token from the Authorization header if present, or with basic auth credentials otherwise.
+//
+// Routes should use authorize instead, so that they declare the scopes they require.
 // The caller does not need to log the returned error. Also, the returned error is safe to send in the response.
-func (h *Handler) authenticateUser(r *http.Request) (string, error) {
-	ctx := r.Context()
+func (h *Handler) authenticateUser(r *http.Request) (caller, error) {
+	ctx, span := tracing.Start(r.Context(), "auth", tracing.KindInternal)
+	defer span.End()
+
+	key := r.Header.Get(headerAPIKey)
+	token, isBearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	var authenticated caller
+	var err error
+	switch {
+	case key != "":
+		span.SetAttribute("auth.method", authMethodAPIKey)
+		authenticated, err = h.authenticateAPIKey(ctx, key)
+	case isBearer:
+		span.SetAttribute("auth.method", authMethodSession)
+		authenticated, err = h.authenticateSessionToken(ctx, token)
+	default:
+		span.SetAttribute("auth.method", authMethodBasic)
+		authenticated, err = h.authenticateBasic(ctx, r)
+	}
 
+	if err != nil {
+		span.SetError(err)
+		return authenticated, err
+	}
+
+	span.SetAttribute("enduser.id", authenticated.username)
+	return authenticated, nil
+}
+
+// authenticateBasic authenticates the caller of the request with basic auth credentials.
+//
+// The caller does not need to log the returned error. Also, the returned error is safe to send in the response.
+func (h *Handler) authenticateBasic(ctx context.Context, r *http.Request) (caller, error) {
 	// These will be verified.
 	username, password, ok := r.BasicAuth()
 	if !ok {
 		slog.ErrorContext(ctx, "basic auth credentials are absent")
-		return "", httputils.Unauthorized().WithReasonStr("basic auth credentials absent")
+		return caller{}, httputils.Unauthorized().WithReasonStr("basic auth credentials absent")
 	}
 
-	// Get user's details for password verification.
-	user, err := h.dbase.GetUser(ctx, username)
+	authenticated, err := h.authenticatePassword(ctx, username, password)
 	if err != nil {
-		if errors.Is(err, database.ErrUserNotFound) {
-			slog.ErrorContext(ctx, "user does not exist")
-			return "", httputils.Unauthorized()
+		if errors.Is(err, ErrInvalidCredentials) {
+			slog.ErrorContext(ctx, "authentication failed", "error", err)
+			return caller{}, httputils.Unauthorized()
 		}
-		slog.ErrorContext(ctx, "unexpected error while fetching user", "error", err)
-		return "", httputils.InternalServerError()
+		slog.ErrorContext(ctx, "unexpected error while authenticating user", "error", err)
+		return caller{}, httputils.InternalServerError()
 	}
 
-	// Verify password.
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-		slog.ErrorContext(ctx, "password does not match", "error", err)
-		return "", httputils.Unauthorized()
+	return authenticated, nil
+}
+
+// authenticatePassword verifies the password of the user, and returns them as the caller. Besides basic auth, it is
+// used by the listeners that take a username and a password, like STOMP and MQTT.
+//
+// If the credentials are wrong, it returns ErrInvalidCredentials. Other errors are unexpected.
+func (h *Handler) authenticatePassword(ctx context.Context, username, password string) (caller, error) {
+	user, err := h.verifyPassword(ctx, username, password)
+	if err != nil {
+		return caller{}, err
 	}
 
-	return username, nil
+	return caller{username: username, scopes: userScopes(user)}, nil
 }
@@ -0,0 +1,338 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
+)
+
+const (
+	// apiKeyPath is the route of the API key APIs.
+	apiKeyPath = "/api/user/key"
+
+	// apiKeyPrefix makes API keys easy to recognize, for example by secret scanners.
+	apiKeyPrefix = "rbk_"
+
+	apiKeyIDBytes     = 8
+	apiKeySecretBytes = 32
+
+	apiKeysMaxCount = 20
+
+	// apiKeyLastUsedInterval limits how often the last-used time of a key is stored, as every store rewrites the
+	// database.
+	apiKeyLastUsedInterval = time.Minute
+)
+
+var (
+	errAPIKeyNameTaken = errors.New("an api key with this name already exists")
+	errAPIKeysTooMany  = fmt.Errorf("a user can have at most %d api keys", apiKeysMaxCount)
+	errAPIKeyNotFound  = errors.New("api key not found")
+
+	errAPIKeyNotAllowed = errors.New("api keys cannot be used for this operation, use the password instead")
+)
+
+// apiKeyResponse is the representation of an API key in responses. It never includes the hash.
+type apiKeyResponse struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Key is only set when the key is created, as it cannot be recovered afterward.
+	Key        string     `json:"key,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+// newAPIKeyResponse converts the API key for responses.
+func newAPIKeyResponse(key database.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
+
+// createAPIKey is the API handler for the POST /api/user/key route.
+//
+// The key itself is only revealed in this response. The database only stores its hash.
+func (h *Handler) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Make sure credentials are correct, and that they allow sending, which API keys are meant for.
+	caller, err := h.authorize(r, scope.SendAny)
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	if err := caller.allowAccountManagement(); err != nil {
+		slog.ErrorContext(ctx, "api key used for account management", "error", err)
+		httputils.WriteError(w, httputils.Forbidden().WithReasonErr(err))
+		return
+	}
+
+	// Anonymous struct variable to decode request body.
+	var body struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	if err := readJsonBody(r, &body); err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	if err := validateAPIKeyName(body.Name); err != nil {
+		slog.ErrorContext(ctx, "invalid api key name", "error", err)
+		httputils.WriteError(w, httputils.BadRequest().WithReasonErr(err))
+		return
+	}
+
+	// Keys get all the scopes of the user by default.
+	if body.Scopes == nil {
+		body.Scopes = slices.Clone(caller.scopes)
+	}
+
+	if err := validateScopes(body.Scopes); err != nil {
+		slog.ErrorContext(ctx, "invalid api key scopes", "error", err)
+		httputils.WriteError(w, httputils.BadRequest().WithReasonErr(err))
+		return
+	}
+
+	// A key cannot have more access than its user.
+	if err := caller.require(body.Scopes...); err != nil {
+		slog.ErrorContext(ctx, "api key scopes exceed the user's", "error", err)
+		httputils.WriteError(w, httputils.Forbidden().WithReasonErr(err))
+		return
+	}
+
+	id, secret := randomHex(apiKeyIDBytes), randomHex(apiKeySecretBytes)
+	apiKey := database.APIKey{
+		ID:        id,
+		Name:      body.Name,
+		Hash:      hashAPIKeySecret(secret),
+		Scopes:    body.Scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	// The limits are checked in the update function, so that concurrent requests cannot exceed them.
+	err = h.dbase.UpdateUser(ctx, caller.username, func(user *database.User) error {
+		if len(user.APIKeys) >= apiKeysMaxCount {
+			return errAPIKeysTooMany
+		}
+		if slices.ContainsFunc(user.APIKeys, func(k database.APIKey) bool { return k.Name == apiKey.Name }) {
+			return errAPIKeyNameTaken
+		}
+
+		user.APIKeys = append(user.APIKeys, apiKey)
+		return nil
+	})
+
+	if err != nil {
+		switch {
+		case errors.Is(err, errAPIKeysTooMany), errors.Is(err, errAPIKeyNameTaken):
+			slog.ErrorContext(ctx, "api key cannot be created", "error", err)
+			httputils.WriteError(w, httputils.Conflict().WithReasonErr(err))
+		case errors.Is(err, database.ErrUserNotFound):
+			// The user was authenticated, so it can only be missing if it was deleted in the meantime.
+			slog.ErrorContext(ctx, "user not found while creating api key", "error", err)
+			httputils.WriteError(w, httputils.Unauthorized())
+		default:
+			slog.ErrorContext(ctx, "unexpected error while creating api key", "error", err)
+			httputils.WriteError(w, httputils.InternalServerError())
+		}
+		return
+	}
+
+	response := newAPIKeyResponse(apiKey)
+	response.Key = formatAPIKey(caller.username, id, secret)
+	httputils.WriteJson(w, http.StatusCreated, nil, response)
+}
+
+// listAPIKeys is the API handler for the GET /api/user/key route.
+func (h *Handler) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Make sure credentials are correct, and that they allow sending, which API keys are meant for.
+	caller, err := h.authorize(r, scope.SendAny)
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	if err := caller.allowAccountManagement(); err != nil {
+		slog.ErrorContext(ctx, "api key used for account management", "error", err)
+		httputils.WriteError(w, httputils.Forbidden().WithReasonErr(err))
+		return
+	}
+
+	user, err := h.dbase.GetUser(ctx, caller.username)
+	if err != nil {
+		slog.ErrorContext(ctx, "unexpected error while listing api keys", "error", err)
+		httputils.WriteError(w, httputils.InternalServerError())
+		return
+	}
+
+	keys := make([]apiKeyResponse, 0, len(user.APIKeys))
+	for _, key := range user.APIKeys {
+		keys = append(keys, newAPIKeyResponse(key))
+	}
+
+	httputils.WriteJson(w, http.StatusOK, nil, map[string]any{"keys": keys})
+}
+
+// revokeAPIKey is the API handler for the DELETE /api/user/key/{id} route.
+func (h *Handler) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Make sure credentials are correct, and that they allow sending, which API keys are meant for.
+	caller, err := h.authorize(r, scope.SendAny)
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	if err := caller.allowAccountManagement(); err != nil {
+		slog.ErrorContext(ctx, "api key used for account management", "error", err)
+		httputils.WriteError(w, httputils.Forbidden().WithReasonErr(err))
+		return
+	}
+
+	id := r.PathValue("id")
+	err = h.dbase.UpdateUser(ctx, caller.username, func(user *database.User) error {
+		index := slices.IndexFunc(user.APIKeys, func(k database.APIKey) bool { return k.ID == id })
+		if index < 0 {
+			return errAPIKeyNotFound
+		}
+
+		user.APIKeys = slices.Delete(user.APIKeys, index, index+1)
+		return nil
+	})
+
+	if err != nil {
+		switch {
+		case errors.Is(err, errAPIKeyNotFound):
+			slog.ErrorContext(ctx, "api key not found", "id", id)
+			httputils.WriteError(w, httputils.NotFound().WithReasonErr(err))
+		case errors.Is(err, database.ErrUserNotFound):
+			// The user was authenticated, so it can only be missing if it was deleted in the meantime.
+			slog.ErrorContext(ctx, "user not found while revoking api key", "error", err)
+			httputils.WriteError(w, httputils.Unauthorized())
+		default:
+			slog.ErrorContext(ctx, "unexpected error while revoking api key", "error", err)
+			httputils.WriteError(w, httputils.InternalServerError())
+		}
+		return
+	}
+
+	httputils.WriteJson(w, http.StatusOK, nil, map[string]string{})
+}
+
+// authenticateAPIKey verifies the API key and returns its caller. It also records the use of the key.
+//
+// The caller does not need to log the returned error. Also, the returned error is safe to send in the response.
+func (h *Handler) authenticateAPIKey(ctx context.Context, key string) (caller, error) {
+	username, id, secret, ok := parseAPIKey(key)
+	if !ok {
+		slog.ErrorContext(ctx, "malformed api key")
+		return caller{}, httputils.Unauthorized()
+	}
+
+	user, err := h.dbase.GetUser(ctx, username)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			slog.ErrorContext(ctx, "api key of a user that does not exist", "username", username)
+			return caller{}, httputils.Unauthorized()
+		}
+		slog.ErrorContext(ctx, "unexpected error while authenticating api key", "error", err)
+		return caller{}, httputils.InternalServerError()
+	}
+
+	index := slices.IndexFunc(user.APIKeys, func(k database.APIKey) bool { return k.ID == id })
+	if index < 0 {
+		slog.ErrorContext(ctx, "api key does not exist", "username", username, "id", id)
+		return caller{}, httputils.Unauthorized()
+	}
+
+	apiKey := user.APIKeys[index]
+	if subtle.ConstantTimeCompare([]byte(apiKey.Hash), []byte(hashAPIKeySecret(secret))) != 1 {
+		slog.ErrorContext(ctx, "api key secret does not match", "username", username, "id", id)
+		return caller{}, httputils.Unauthorized()
+	}
+
+	if apiKey.LastUsedAt == nil || time.Since(*apiKey.LastUsedAt) >= apiKeyLastUsedInterval {
+		h.touchAPIKey(ctx, username, id)
+	}
+
+	scopes := scope.Set(apiKey.Scopes).Restrict(userScopes(user))
+	return caller{username: username, scopes: scopes, apiKey: &apiKey}, nil
+}
+
+// touchAPIKey stores the current time as the last-used time of the key. Failures are only logged, as they must not
+// fail the request.
+func (h *Handler) touchAPIKey(ctx context.Context, username, id string) {
+	now := time.Now().UTC()
+
+	err := h.dbase.UpdateUser(ctx, username, func(user *database.User) error {
+		index := slices.IndexFunc(user.APIKeys, func(k database.APIKey) bool { return k.ID == id })
+		// The key may have been revoked in the meantime.
+		if index < 0 {
+			return errAPIKeyNotFound
+		}
+
+		user.APIKeys[index].LastUsedAt = &now
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, errAPIKeyNotFound) {
+		slog.ErrorContext(ctx, "failed to store the last-used time of api key", "id", id, "error", err)
+	}
+}
+
+// formatAPIKey builds the key given to the user. It embeds the username and the key ID, so that the key can be looked
+// up without scanning all users. Usernames cannot contain dots, so they separate the parts.
+func formatAPIKey(username, id, secret string) string {
+	return apiKeyPrefix + username + "." + id + "." + secret
+}
+
+// parseAPIKey is the inverse of formatAPIKey.
+func parseAPIKey(key string) (username, id, secret string, ok bool) {
+	key, ok = strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", "", "", false
+	}
+
+	parts := strings.Split(key, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", false
+	}
+
+	return parts[0], parts[1], parts[2], true
+}
+
+// hashAPIKeySecret returns the hex encoded SHA-256 hash of the secret.
+func hashAPIKeySecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// randomHex returns the given number of random bytes, hex encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,276 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/ws"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newAPIKeyTestUser returns a user with the given password and an API key with the given scopes. It also returns the
+// formatted key.
+func newAPIKeyTestUser(t *testing.T, password string, scopes []string) (database.User, string) {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	apiKey := database.APIKey{
+		ID:        "0123456789abcdef",
+		Name:      "notifier",
+		Hash:      hashAPIKeySecret("secret"),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	user := database.User{Username: "shivansh", PasswordHash: string(passwordHash), APIKeys: []database.APIKey{apiKey}}
+	return user, formatAPIKey(user.Username, apiKey.ID, "secret")
+}
+
+func TestHandler_createAPIKey(t *testing.T) {
+	mockPassword := "password123"
+	user, key := newAPIKeyTestUser(t, mockPassword, scope.Default)
+
+	var testCases = []struct {
+		name         string
+		useAPIKey    bool
+		requestBody  string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Authenticated with an api key, 403 expected",
+			useAPIKey:    true,
+			requestBody:  `{"name":"other"}`,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"` + errAPIKeyNotAllowed.Error() + `"}`,
+		},
+		{
+			name:         "Empty name, 400 expected",
+			requestBody:  `{"name":""}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"` + errAPIKeyNameLength.Error() + `"}`,
+		},
+		{
+			name:         "Name too long, 400 expected",
+			requestBody:  `{"name":"` + strings.Repeat("n", apiKeyNameMaxLength+1) + `"}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"` + errAPIKeyNameLength.Error() + `"}`,
+		},
+		{
+			name:         "Unknown scope, 400 expected",
+			requestBody:  `{"name":"other","scopes":["delete"]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"unknown scope: \"delete\""}`,
+		},
+		{
+			name:         "Empty scopes, 400 expected",
+			requestBody:  `{"name":"other","scopes":[]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"must provide at least 1 scope"}`,
+		},
+		{
+			name:         "Scope that the user does not have, 403 expected",
+			requestBody:  `{"name":"other","scopes":["send","admin"]}`,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"missing scope: admin"}`,
+		},
+		{
+			name:         "Name already taken, 409 expected",
+			requestBody:  `{"name":"notifier"}`,
+			expectedCode: http.StatusConflict,
+			expectedBody: `{"status":"Conflict","reason":"` + errAPIKeyNameTaken.Error() + `"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, apiKeyPath, strings.NewReader(tc.requestBody))
+			if tc.useAPIKey {
+				r.Header.Set(headerAPIKey, key)
+			} else {
+				r.SetBasicAuth(user.Username, mockPassword)
+			}
+
+			handler := &Handler{dbase: &fakeDatabase{getUser: user}}
+			handler.createAPIKey(w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.Equal(t, tc.expectedBody, w.Body.String())
+		})
+	}
+
+	t.Run("Valid request, key created", func(t *testing.T) {
+		dbase := &fakeDatabase{getUser: user}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, apiKeyPath,
+			strings.NewReader(`{"name":"billing","scopes":["send:alice"]}`))
+		r.SetBasicAuth(user.Username, mockPassword)
+
+		handler := &Handler{dbase: dbase}
+		handler.createAPIKey(w, r)
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		var response apiKeyResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Equal(t, "billing", response.Name)
+		require.Equal(t, []string{"send:alice"}, response.Scopes)
+		require.Nil(t, response.LastUsedAt)
+
+		// The key must identify the new record, and only its hash must be stored.
+		username, id, secret, ok := parseAPIKey(response.Key)
+		require.True(t, ok)
+		require.Equal(t, user.Username, username)
+		require.Equal(t, response.ID, id)
+
+		require.Len(t, dbase.updatedUser.APIKeys, 2)
+		stored := dbase.updatedUser.APIKeys[1]
+		require.Equal(t, response.ID, stored.ID)
+		require.Equal(t, hashAPIKeySecret(secret), stored.Hash)
+		require.NotContains(t, w.Body.String(), stored.Hash)
+	})
+}
+
+func TestHandler_listAPIKeys(t *testing.T) {
+	mockPassword := "password123"
+	user, _ := newAPIKeyTestUser(t, mockPassword, []string{scope.SendTo("alice")})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, apiKeyPath, nil)
+	r.SetBasicAuth(user.Username, mockPassword)
+
+	handler := &Handler{dbase: &fakeDatabase{getUser: user}}
+	handler.listAPIKeys(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), user.APIKeys[0].Hash)
+
+	var response struct {
+		Keys []apiKeyResponse `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Keys, 1)
+	require.Equal(t, user.APIKeys[0].ID, response.Keys[0].ID)
+	require.Empty(t, response.Keys[0].Key)
+	require.Equal(t, []string{"send:alice"}, response.Keys[0].Scopes)
+}
+
+func TestHandler_revokeAPIKey(t *testing.T) {
+	mockPassword := "password123"
+	user, _ := newAPIKeyTestUser(t, mockPassword, scope.Default)
+
+	var testCases = []struct {
+		name         string
+		id           string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Unknown key, 404 expected",
+			id:           "unknown",
+			expectedCode: http.StatusNotFound,
+			expectedBody: `{"status":"Not Found","reason":"` + errAPIKeyNotFound.Error() + `"}`,
+		},
+		{
+			name:         "Existing key, 200 expected",
+			id:           user.APIKeys[0].ID,
+			expectedCode: http.StatusOK,
+			expectedBody: `{}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dbase := &fakeDatabase{getUser: user}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodDelete, apiKeyPath+"/"+tc.id, nil)
+			r.SetPathValue("id", tc.id)
+			r.SetBasicAuth(user.Username, mockPassword)
+
+			handler := &Handler{dbase: dbase}
+			handler.revokeAPIKey(w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.Equal(t, tc.expectedBody, w.Body.String())
+
+			if tc.expectedCode == http.StatusOK {
+				require.Empty(t, dbase.updatedUser.APIKeys)
+			}
+		})
+	}
+}
+
+func TestHandler_authenticateAPIKey(t *testing.T) {
+	user, key := newAPIKeyTestUser(t, "password123", scope.Default)
+
+	var testCases = []struct {
+		name         string
+		key          string
+		errGetUser   error
+		expectedCode int
+	}{
+		{
+			name:         "Malformed key, 401 expected",
+			key:          "not-a-key",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Wrong prefix, 401 expected",
+			key:          strings.TrimPrefix(key, apiKeyPrefix),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Wrong secret, 401 expected",
+			key:          key + "0",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Unknown key ID, 401 expected",
+			key:          formatAPIKey(user.Username, "unknown", "secret"),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Unknown user, 401 expected",
+			key:          key,
+			errGetUser:   database.ErrUserNotFound,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "Valid key, 202 expected",
+			key:          key,
+			expectedCode: http.StatusAccepted,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dbase := &fakeDatabase{getUser: user, errGetUser: tc.errGetUser}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/message",
+				strings.NewReader(`{"message":"hello","receivers":["alice"]}`))
+			r.Header.Set(headerAPIKey, tc.key)
+
+			handler := &Handler{dbase: dbase, wsManager: ws.NewManager(nil)}
+			handler.sendMessage(w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+
+			if tc.expectedCode == http.StatusAccepted {
+				// The use of the key must be recorded.
+				require.Len(t, dbase.updatedUser.APIKeys, 1)
+				require.NotNil(t, dbase.updatedUser.APIKeys[0].LastUsedAt)
+			}
+		})
+	}
+}
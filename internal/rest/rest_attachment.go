@@ -0,0 +1,309 @@
+package rest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/blob"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
+)
+
+const (
+	// attachmentPath is the path of the upload API. Request bodies of this path are not limited by the message limits.
+	attachmentPath = "/api/attachment"
+
+	// defaultAttachmentContentType is used when the upload request does not specify a content type.
+	defaultAttachmentContentType = "application/octet-stream"
+)
+
+var (
+	errAttachmentLinkInvalid = errors.New("attachment link is invalid or expired")
+	errAttachmentNotShared   = errors.New("attachment was not shared with the caller")
+)
+
+// attachmentAudience is who can download the attachments of a message. It is the receivers of a direct message, or
+// the subscribers of the topic of a published one. The uploader can always download their attachments.
+type attachmentAudience struct {
+	receivers []string
+	topic     string
+}
+
+// uploadAttachment is the API handler for the POST /api/attachment route.
+//
+// The request body is the raw content of the attachment, and the Content-Type header is its media type.
+func (h *Handler) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Make sure credentials are correct.
+	caller, err := h.authorize(r, scope.Send)
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+	owner := caller.username
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = defaultAttachmentContentType
+	}
+
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		slog.ErrorContext(ctx, "invalid attachment content type", "error", err)
+		httputils.WriteError(w, httputils.BadRequest().WithReasonStr("invalid content type"))
+		return
+	}
+
+	// The body is streamed to the store, which enforces the size limit and the quota.
+	attachment, err := h.blobs.Put(ctx, owner, contentType, r.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, blob.ErrBlobTooLarge):
+			slog.ErrorContext(ctx, "attachment is too large", "error", err)
+			httputils.WriteError(w, httputils.RequestEntityTooLarge().WithReasonErr(err))
+		case errors.Is(err, blob.ErrQuotaExceeded):
+			slog.ErrorContext(ctx, "attachment quota exceeded", "error", err)
+			httputils.WriteError(w, httputils.Forbidden().WithReasonErr(err))
+		default:
+			slog.ErrorContext(ctx, "unexpected error in attachment upload", "error", err)
+			httputils.WriteError(w, httputils.InternalServerError())
+		}
+		return
+	}
+
+	httputils.WriteJson(w, http.StatusCreated, nil, map[string]any{
+		"id":          attachment.ID,
+		"contentType": attachment.ContentType,
+		"size":        attachment.Size,
+		"expiresAt":   attachment.ExpiresAt,
+	})
+}
+
+// downloadAttachment is the API handler for the GET /api/attachment/{id} route.
+//
+// Besides the signature in the link, the caller must be authenticated and be a part of the audience that the link was
+// signed for, so a leaked link is of no use to others. The trade-off is that the link cannot be used directly by
+// browsers, for example, in an <img> tag, because it needs credentials in the headers.
+func (h *Handler) downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := r.PathValue("id")
+
+	query := r.URL.Query()
+	audience, err := h.verifyAttachmentLink(id, query.Get("expires"), query.Get("audience"), query.Get("signature"))
+	if err != nil {
+		slog.ErrorContext(ctx, "invalid attachment link", "error", err)
+		httputils.WriteError(w, httputils.Forbidden().WithReasonErr(errAttachmentLinkInvalid))
+		return
+	}
+
+	// Make sure credentials are correct, and that they allow receiving messages.
+	caller, err := h.authorize(r, scope.Connect)
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	attachment, content, err := h.blobs.Open(ctx, id)
+	if err != nil {
+		if errors.Is(err, blob.ErrBlobNotFound) {
+			slog.ErrorContext(ctx, "attachment not found", "error", err)
+			httputils.WriteError(w, httputils.NotFound().WithReasonStr("attachment not found"))
+			return
+		}
+		slog.ErrorContext(ctx, "unexpected error in attachment download", "error", err)
+		httputils.WriteError(w, httputils.InternalServerError())
+		return
+	}
+
+	defer func() { _ = content.Close() }()
+
+	if !h.canDownload(caller.username, caller.scopes, attachment.Owner, audience) {
+		slog.ErrorContext(ctx, "attachment was not shared with the caller", "username", caller.username)
+		httputils.WriteError(w, httputils.Forbidden().WithReasonErr(errAttachmentNotShared))
+		return
+	}
+
+	// The content type is chosen by the uploader, so browsers must not sniff it or run any of its scripts.
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+
+	// ServeContent takes care of range and conditional requests.
+	http.ServeContent(w, r, "", attachment.CreatedAt, content)
+}
+
+// resolveAttachments converts the given attachment IDs into attachments that can be sent to the given audience, with
+// download links signed for it. Every attachment must exist and belong to the sender.
+func (h *Handler) resolveAttachments(r *http.Request, sender string, ids []string, audience attachmentAudience,
+) ([]protocol.Attachment, error) {
+	ctx := r.Context()
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	if h.blobs == nil {
+		return nil, errAttachmentsDisabled
+	}
+
+	attachments := make([]protocol.Attachment, 0, len(ids))
+	for _, id := range ids {
+		attachment, err := h.blobs.Get(ctx, id)
+		if err != nil {
+			if errors.Is(err, blob.ErrBlobNotFound) {
+				return nil, errAttachmentNotFound
+			}
+			return nil, fmt.Errorf("failed to get attachment: %w", err)
+		}
+
+		// Senders can only share their own attachments. This is reported as not found to avoid leaking their IDs.
+		if attachment.Owner != sender {
+			return nil, errAttachmentNotFound
+		}
+
+		// The link must not outlive the attachment.
+		expiresAt := time.Now().Add(h.attachmentLinkTTL)
+		if attachment.ExpiresAt.Before(expiresAt) {
+			expiresAt = attachment.ExpiresAt
+		}
+
+		attachments = append(attachments, protocol.Attachment{
+			ID:           attachment.ID,
+			ContentType:  attachment.ContentType,
+			Size:         attachment.Size,
+			URL:          h.signAttachmentLink(attachment.ID, expiresAt, audience),
+			URLExpiresAt: expiresAt.UTC().Truncate(time.Second),
+		})
+	}
+
+	return attachments, nil
+}
+
+// canDownload reports whether the user, authenticated with the given scopes, can download an attachment of the given
+// owner, through a link signed for the given audience.
+func (h *Handler) canDownload(username string, scopes scope.Set, owner string, audience attachmentAudience) bool {
+	switch {
+	case username == owner:
+		return true
+	case audience.topic != "":
+		return h.broker.CanSubscribe(username, scopes, audience.topic)
+	default:
+		return slices.Contains(audience.receivers, username)
+	}
+}
+
+// signAttachmentLink returns the download link of the attachment with the given ID for the given audience, valid till
+// the given time.
+//
+// The audience is sealed into an opaque reference, so that the link does not reveal who else received the message.
+func (h *Handler) signAttachmentLink(id string, expiresAt time.Time, audience attachmentAudience) string {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	reference := h.sealAttachmentAudience(audience)
+
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("audience", reference)
+	query.Set("signature", h.attachmentSignature(id, expires, reference))
+
+	return attachmentPath + "/" + id + "?" + query.Encode()
+}
+
+// verifyAttachmentLink verifies the expiry and the signature of a download link, and returns the audience that it was
+// signed for.
+func (h *Handler) verifyAttachmentLink(id, expires, reference, signature string) (attachmentAudience, error) {
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return attachmentAudience{}, fmt.Errorf("invalid expiry: %w", err)
+	}
+
+	if time.Now().Unix() > expiresUnix {
+		return attachmentAudience{}, errors.New("link expired")
+	}
+
+	expected := h.attachmentSignature(id, expires, reference)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return attachmentAudience{}, errors.New("signature mismatch")
+	}
+
+	return h.openAttachmentAudience(reference)
+}
+
+// attachmentSignature returns the HMAC-SHA256 signature of the given attachment ID, expiry and audience reference.
+//
+// The expiry is a number and the reference is base64, so neither contains a newline, and the signed input is
+// unambiguous.
+func (h *Handler) attachmentSignature(id, expires, reference string) string {
+	mac := hmac.New(sha256.New, h.attachmentKey)
+	mac.Write([]byte(id + "\n" + expires + "\n" + reference))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sealAttachmentAudience encrypts the audience into an opaque reference for download links.
+//
+// Usernames and topic names cannot contain commas or newlines, so the sealed text is unambiguous.
+func (h *Handler) sealAttachmentAudience(audience attachmentAudience) string {
+	aead := h.attachmentAEAD()
+
+	nonce := make([]byte, aead.NonceSize())
+	_, _ = rand.Read(nonce)
+
+	plaintext := []byte(strings.Join(audience.receivers, ",") + "\n" + audience.topic)
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, nil))
+}
+
+// openAttachmentAudience decrypts an audience reference made by sealAttachmentAudience.
+func (h *Handler) openAttachmentAudience(reference string) (attachmentAudience, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(reference)
+	if err != nil {
+		return attachmentAudience{}, fmt.Errorf("invalid audience encoding: %w", err)
+	}
+
+	aead := h.attachmentAEAD()
+	if len(sealed) < aead.NonceSize() {
+		return attachmentAudience{}, errors.New("audience too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return attachmentAudience{}, fmt.Errorf("failed to decrypt audience: %w", err)
+	}
+
+	to, topicName, _ := strings.Cut(string(plaintext), "\n")
+	if topicName != "" {
+		return attachmentAudience{topic: topicName}, nil
+	}
+
+	var receivers []string
+	if to != "" {
+		receivers = strings.Split(to, ",")
+	}
+	return attachmentAudience{receivers: receivers}, nil
+}
+
+// attachmentAEAD returns the cipher that seals the audiences of download links. Its key is derived from the signing
+// key, so that the same secret is not used for both.
+func (h *Handler) attachmentAEAD() cipher.AEAD {
+	key := sha256.Sum256(append([]byte("attachment audience\n"), h.attachmentKey...))
+
+	// AES accepts 32 byte keys, and GCM accepts AES, so neither can fail.
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
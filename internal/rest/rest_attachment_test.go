@@ -0,0 +1,289 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/blob"
+	"github.com/shivanshkc/rosenbridge/internal/broker"
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/internal/ws"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newAttachmentTestHandler returns a Handler with a real blob store that allows blobs of at most 10 bytes.
+func newAttachmentTestHandler(t *testing.T, username, password string) *Handler {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	blobs, err := blob.NewFileStore(t.TempDir(), 10, 100, time.Hour, time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = blobs.Close() })
+
+	return &Handler{
+		dbase:             &fakeDatabase{getUser: database.User{Username: username, PasswordHash: string(passwordHash)}},
+		wsManager:         ws.NewManager(nil),
+		blobs:             blobs,
+		attachmentKey:     []byte("test-key"),
+		attachmentLinkTTL: time.Minute,
+	}
+}
+
+func TestHandler_uploadAttachment(t *testing.T) {
+	mockUsername, mockPassword := "shivansh", "password123"
+	handler := newAttachmentTestHandler(t, mockUsername, mockPassword)
+
+	var testCases = []struct {
+		name         string
+		contentType  string
+		requestBody  string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Invalid content type, error expected",
+			contentType:  "image/png; ===",
+			requestBody:  "hello",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"invalid content type"}`,
+		},
+		{
+			name:         "Attachment too large, error expected",
+			contentType:  "text/plain",
+			requestBody:  strings.Repeat("x", 11),
+			expectedCode: http.StatusRequestEntityTooLarge,
+			expectedBody: `{"status":"Request Entity Too Large","reason":"` + blob.ErrBlobTooLarge.Error() + `"}`,
+		},
+		{
+			name:         "Valid attachment, no error expected",
+			contentType:  "text/plain",
+			requestBody:  "hello",
+			expectedCode: http.StatusCreated,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, attachmentPath, strings.NewReader(tc.requestBody))
+			r.Header.Set("Content-Type", tc.contentType)
+			r.SetBasicAuth(mockUsername, mockPassword)
+
+			handler.uploadAttachment(w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedBody != "" {
+				require.Equal(t, tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_downloadAttachment(t *testing.T) {
+	handler := newAttachmentTestHandler(t, "shivansh", "password123")
+
+	attachment, err := handler.blobs.Put(context.Background(), "shivansh", "text/plain", strings.NewReader("hello"))
+	require.NoError(t, err)
+
+	topics, err := topic.NewACL([]topic.Rule{{Topic: "news/#", Subscribers: []string{"alice"}}})
+	require.NoError(t, err)
+	handler.broker = broker.New(topics, nil)
+
+	expiresAt := time.Now().Add(time.Minute)
+	toAlice := attachmentAudience{receivers: []string{"alice", "bob"}}
+	validLink := handler.signAttachmentLink(attachment.ID, expiresAt, toAlice)
+	topicLink := handler.signAttachmentLink(attachment.ID, expiresAt, attachmentAudience{topic: "news/today"})
+	notSharedBody := `{"status":"Forbidden","reason":"` + errAttachmentNotShared.Error() + `"}`
+
+	// The links must not reveal their audience.
+	require.NotContains(t, validLink, "alice%2Cbob")
+	require.NotContains(t, topicLink, "news%2Ftoday")
+
+	// The audience of a link for mallory, along with the signature of the link for alice.
+	toMallory := attachmentAudience{receivers: []string{"mallory"}}
+	malloryLink := handler.signAttachmentLink(attachment.ID, expiresAt, toMallory)
+	swappedLink, err := url.Parse(validLink)
+	require.NoError(t, err)
+	swappedQuery := swappedLink.Query()
+	swappedQuery.Set("audience", queryParam(t, malloryLink, "audience"))
+	swappedLink.RawQuery = swappedQuery.Encode()
+
+	var testCases = []struct {
+		name         string
+		link         string
+		username     string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Receiver with valid link, no error expected",
+			link:         validLink,
+			username:     "alice",
+			expectedCode: http.StatusOK,
+			expectedBody: "hello",
+		},
+		{
+			name:         "Owner with valid link, no error expected",
+			link:         validLink,
+			username:     "shivansh",
+			expectedCode: http.StatusOK,
+			expectedBody: "hello",
+		},
+		{
+			name:         "Caller is not a receiver, error expected",
+			link:         validLink,
+			username:     "mallory",
+			expectedCode: http.StatusForbidden,
+			expectedBody: notSharedBody,
+		},
+		{
+			name:         "No credentials, error expected",
+			link:         validLink,
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"status":"Unauthorized","reason":"basic auth credentials absent"}`,
+		},
+		{
+			name:         "Topic subscriber, no error expected",
+			link:         topicLink,
+			username:     "alice",
+			expectedCode: http.StatusOK,
+			expectedBody: "hello",
+		},
+		{
+			name:         "Caller cannot subscribe to the topic, error expected",
+			link:         topicLink,
+			username:     "bob",
+			expectedCode: http.StatusForbidden,
+			expectedBody: notSharedBody,
+		},
+		{
+			name:         "Audience of another link, error expected",
+			link:         swappedLink.String(),
+			username:     "mallory",
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"` + errAttachmentLinkInvalid.Error() + `"}`,
+		},
+		{
+			name:         "Tampered signature, error expected",
+			link:         validLink + "x",
+			username:     "alice",
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"` + errAttachmentLinkInvalid.Error() + `"}`,
+		},
+		{
+			name:         "Expired link, error expected",
+			link:         handler.signAttachmentLink(attachment.ID, time.Now().Add(-time.Minute), toAlice),
+			username:     "alice",
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"` + errAttachmentLinkInvalid.Error() + `"}`,
+		},
+		{
+			name:         "Unknown attachment, error expected",
+			link:         handler.signAttachmentLink(strings.Repeat("0", 32), expiresAt, toAlice),
+			username:     "alice",
+			expectedCode: http.StatusNotFound,
+			expectedBody: `{"status":"Not Found","reason":"attachment not found"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tc.link, nil)
+			r.SetPathValue("id", strings.TrimPrefix(r.URL.Path, attachmentPath+"/"))
+			if tc.username != "" {
+				r.SetBasicAuth(tc.username, "password123")
+			}
+
+			handler.downloadAttachment(w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.Equal(t, tc.expectedBody, w.Body.String())
+			if tc.expectedCode == http.StatusOK {
+				require.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+				require.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+			}
+		})
+	}
+}
+
+// queryParam returns the value of the given query parameter of the link.
+func queryParam(t *testing.T, link, key string) string {
+	parsed, err := url.Parse(link)
+	require.NoError(t, err)
+	return parsed.Query().Get(key)
+}
+
+func TestHandler_resolveAttachments(t *testing.T) {
+	handler := newAttachmentTestHandler(t, "shivansh", "password123")
+	r := httptest.NewRequest(http.MethodPost, "/api/message", nil)
+
+	own, err := handler.blobs.Put(context.Background(), "shivansh", "image/png", strings.NewReader("png"))
+	require.NoError(t, err)
+
+	others, err := handler.blobs.Put(context.Background(), "alice", "image/png", strings.NewReader("png"))
+	require.NoError(t, err)
+
+	audience := attachmentAudience{receivers: []string{"alice"}}
+	attachments, err := handler.resolveAttachments(r, "shivansh", []string{own.ID}, audience)
+	require.NoError(t, err)
+	require.Len(t, attachments, 1)
+	require.Equal(t, own.ID, attachments[0].ID)
+	require.Equal(t, "image/png", attachments[0].ContentType)
+	require.Equal(t, int64(3), attachments[0].Size)
+	require.True(t, strings.HasPrefix(attachments[0].URL, attachmentPath+"/"+own.ID+"?"))
+
+	// The generated link must work.
+	w := httptest.NewRecorder()
+	download := httptest.NewRequest(http.MethodGet, attachments[0].URL, nil)
+	download.SetPathValue("id", own.ID)
+	download.SetBasicAuth("alice", "password123")
+	handler.downloadAttachment(w, download)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Attachments of other users cannot be shared.
+	_, err = handler.resolveAttachments(r, "shivansh", []string{others.ID}, audience)
+	require.ErrorIs(t, err, errAttachmentNotFound)
+
+	// Attachments cannot be shared if disabled.
+	_, err = (&Handler{}).resolveAttachments(r, "shivansh", []string{own.ID}, audience)
+	require.ErrorIs(t, err, errAttachmentsDisabled)
+}
+
+func TestHandler_sendMessage_attachments(t *testing.T) {
+	handler := newAttachmentTestHandler(t, "shivansh", "password123")
+
+	attachment, err := handler.blobs.Put(context.Background(), "shivansh", "image/png", strings.NewReader("png"))
+	require.NoError(t, err)
+
+	send := func(ids ...string) *httptest.ResponseRecorder {
+		idsJSON, err := json.Marshal(ids)
+		require.NoError(t, err)
+
+		body := `{"attachments":` + string(idsJSON) + `,"receivers":["alice"]}`
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(body))
+		r.SetBasicAuth("shivansh", "password123")
+		handler.sendMessage(w, r)
+		return w
+	}
+
+	require.Equal(t, http.StatusAccepted, send(attachment.ID).Code)
+
+	w := send("not-an-id")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, `{"status":"Bad Request","reason":"`+errAttachmentID.Error()+`"}`, w.Body.String())
+
+	w = send(strings.Repeat("0", 32))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, `{"status":"Bad Request","reason":"`+errAttachmentNotFound.Error()+`"}`, w.Body.String())
+}
@@ -4,31 +4,54 @@ import (
 	"log/slog"
 	"net/http"
 
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/stomp"
 	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
 )
 
+// connectPath is the route of the websocket API.
+const connectPath = "/api/connect"
+
 func (h *Handler) getConnection(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
+	// New connections would only be closed again soon, so clients are made to connect to another instance.
+	if h.draining.Load() {
+		slog.ErrorContext(ctx, "refusing connection while shutting down")
+		httputils.WriteError(w, httputils.ServiceUnavailable().WithReasonStr("server is shutting down"))
+		return
+	}
+
 	// Browsers cannot send custom headers with WebSocket upgrade requests.
 	// Accept credentials as query parameters as a fallback.
-	if _, _, ok := r.BasicAuth(); !ok {
+	if _, _, ok := r.BasicAuth(); !ok && r.Header.Get("Authorization") == "" {
 		qUsername := r.URL.Query().Get("username")
 		qPassword := r.URL.Query().Get("password")
 		if qUsername != "" && qPassword != "" {
 			r.SetBasicAuth(qUsername, qPassword)
+		} else if qToken := r.URL.Query().Get("token"); qToken != "" {
+			r.Header.Set("Authorization", "Bearer "+qToken)
 		}
 	}
 
-	// Make sure credentials are correct.
-	username, err := h.authenticateUser(r)
+	// STOMP clients authenticate with the CONNECT frame, after the upgrade.
+	if h.stomp != nil && stomp.Requested(r) {
+		if err := h.stomp.Accept(w, r); err != nil {
+			slog.ErrorContext(ctx, "error in stomp Accept call", "error", err)
+			// Response is already written.
+		}
+		return
+	}
+
+	// Make sure credentials are correct, and that they allow receiving messages.
+	caller, err := h.authorize(r, scope.Connect)
 	if err != nil {
 		httputils.WriteError(w, err)
 		return
 	}
 
 	// Upgrade and persist the connection.
-	if err := h.wsManager.UpgradeAndAddConnection(w, r, username); err != nil {
+	if err := h.wsManager.UpgradeAndAddConnection(w, r, caller.username, caller.scopes); err != nil {
 		slog.ErrorContext(ctx, "error in UpgradeAndAddConnection call", "error", err)
 		// Response is already written.
 	}
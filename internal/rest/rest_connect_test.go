@@ -6,9 +6,12 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/shivanshkc/rosenbridge/internal/broker"
 	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/stomp"
 	"github.com/shivanshkc/rosenbridge/internal/ws"
 
 	"github.com/coder/websocket"
@@ -16,7 +19,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-func TestHandler_getConnection_AuthFailures(t *testing.T) {
+func TestHandler_getConnection_Failures(t *testing.T) {
 	mockUsername, mockPassword := "shivansh", "password123"
 
 	passwordHash, err := bcrypt.GenerateFromPassword([]byte(mockPassword), bcrypt.MinCost)
@@ -30,6 +33,7 @@ func TestHandler_getConnection_AuthFailures(t *testing.T) {
 		username     string
 		password     string
 		dbase        database.Database
+		draining     bool
 		expectedCode int
 		expectedBody string
 	}{
@@ -67,6 +71,16 @@ func TestHandler_getConnection_AuthFailures(t *testing.T) {
 			expectedCode: http.StatusUnauthorized,
 			expectedBody: `{"status":"Unauthorized","reason":""}`,
 		},
+		{
+			name:         "Server is draining, 503 expected",
+			setBasicAuth: true,
+			username:     mockUsername,
+			password:     mockPassword,
+			dbase:        &fakeDatabase{getUser: validUser},
+			draining:     true,
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: `{"status":"Service Unavailable","reason":"server is shutting down"}`,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -78,6 +92,7 @@ func TestHandler_getConnection_AuthFailures(t *testing.T) {
 			}
 
 			handler := &Handler{dbase: tc.dbase}
+			handler.draining.Store(tc.draining)
 			handler.getConnection(w, r)
 
 			require.Equal(t, tc.expectedCode, w.Code)
@@ -95,7 +110,7 @@ func TestHandler_getConnection_Success(t *testing.T) {
 	validUser := database.User{Username: mockUsername, PasswordHash: string(passwordHash)}
 	handler := &Handler{
 		dbase:     &fakeDatabase{getUser: validUser},
-		wsManager: ws.NewManager(),
+		wsManager: ws.NewManager(nil),
 	}
 
 	server := httptest.NewServer(http.HandlerFunc(handler.getConnection))
@@ -111,3 +126,35 @@ func TestHandler_getConnection_Success(t *testing.T) {
 
 	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
 }
+
+func TestHandler_getConnection_Stomp(t *testing.T) {
+	mockUsername, mockPassword := "shivansh", "password123"
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(mockPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	validUser := database.User{Username: mockUsername, PasswordHash: string(passwordHash)}
+	handler := &Handler{
+		dbase:     &fakeDatabase{getUser: validUser},
+		wsManager: ws.NewManager(nil),
+	}
+	handler.stomp = stomp.NewServer(broker.New(nil, nil), stompHandler{handler: handler})
+
+	server := httptest.NewServer(http.HandlerFunc(handler.getConnection))
+	defer server.Close()
+
+	// No basic auth is required for the upgrade, as STOMP clients authenticate with the CONNECT frame.
+	dialOptions := &websocket.DialOptions{Subprotocols: []string{stomp.Subprotocol}}
+	conn, _, err := websocket.Dial(context.Background(), "ws"+server.URL[4:], dialOptions)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close(websocket.StatusNormalClosure, "") }()
+
+	require.Equal(t, stomp.Subprotocol, conn.Subprotocol())
+
+	connect := "CONNECT\naccept-version:1.2\nlogin:" + mockUsername + "\npasscode:" + mockPassword + "\n\n\x00"
+	require.NoError(t, conn.Write(context.Background(), websocket.MessageText, []byte(connect)))
+
+	_, reply, err := conn.Read(context.Background())
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(reply), "CONNECTED\n"), string(reply))
+}
@@ -0,0 +1,134 @@
+package rest
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/version"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
+)
+
+// Routes of the health APIs.
+const (
+	healthPath      = "/api/health"
+	healthLivePath  = healthPath + "/live"
+	healthReadyPath = healthPath + "/ready"
+)
+
+// The statuses of the health report and of its checks.
+const (
+	healthOK       = "ok"
+	healthDraining = "draining"
+	healthDegraded = "degraded"
+	healthError    = "error"
+	healthDisabled = "disabled"
+)
+
+// healthCheck is the outcome of one check of the detailed health report.
+type healthCheck struct {
+	Status string `json:"status"`
+	// Error is the reason of the failure, if any. It is only shown to admins.
+	Error string `json:"error,omitempty"`
+}
+
+// healthReport is the response of the detailed health API.
+type healthReport struct {
+	Status    string    `json:"status"`
+	Version   string    `json:"version"`
+	StartedAt time.Time `json:"startedAt"`
+	UptimeSec int64     `json:"uptimeSec"`
+
+	Connections struct {
+		Websocket int `json:"websocket"`
+		Stomp     int `json:"stomp"`
+	} `json:"connections"`
+
+	Storage struct {
+		Database    healthCheck `json:"database"`
+		Attachments healthCheck `json:"attachments"`
+	} `json:"storage"`
+}
+
+// Drain makes the readiness check fail, so that load balancers stop routing new traffic to the server, and refuses
+// new websocket connections. It is called when shutdown begins, and cannot be undone.
+func (h *Handler) Drain() {
+	h.draining.Store(true)
+}
+
+// getLiveness is the API handler for the GET /api/health/live route.
+//
+// It reports that the process is up and serving requests. It does not check any dependency, so that orchestrators
+// do not restart the server for failures that a restart would not fix.
+func (h *Handler) getLiveness(w http.ResponseWriter, r *http.Request) {
+	httputils.WriteJson(w, http.StatusOK, nil, map[string]any{"status": healthOK})
+}
+
+// getReadiness is the API handler for the GET /api/health/ready route.
+//
+// It reports whether the server should receive traffic. It fails once shutdown begins, and while the database is
+// unavailable.
+func (h *Handler) getReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if h.draining.Load() {
+		httputils.WriteError(w, httputils.ServiceUnavailable().WithReasonStr("server is shutting down"))
+		return
+	}
+
+	if err := h.dbase.Ping(ctx); err != nil {
+		slog.ErrorContext(ctx, "readiness check failed", "error", err)
+		httputils.WriteError(w, httputils.ServiceUnavailable().WithReasonStr("database is unavailable"))
+		return
+	}
+
+	httputils.WriteJson(w, http.StatusOK, nil, map[string]any{"status": healthOK})
+}
+
+// getHealth is the API handler for the GET /api/health route.
+//
+// It reports the uptime, version, connection counts and storage status of the server. Only admins can see it, as the
+// storage errors may reveal internal details.
+func (h *Handler) getHealth(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if _, err := h.authorize(r, scope.Admin); err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	var report healthReport
+	report.Status = healthOK
+	report.Version = version.Version
+	report.StartedAt = h.startedAt
+	report.UptimeSec = int64(time.Since(h.startedAt).Seconds())
+
+	report.Connections.Websocket = h.wsManager.ConnectionCount()
+	if h.stomp != nil {
+		report.Connections.Stomp = h.stomp.ConnectionCount()
+	}
+
+	report.Storage.Database = newHealthCheck(h.dbase.Ping(ctx))
+	report.Storage.Attachments = healthCheck{Status: healthDisabled}
+	if h.blobs != nil {
+		report.Storage.Attachments = newHealthCheck(h.blobs.Ping(ctx))
+	}
+
+	if report.Storage.Database.Status == healthError || report.Storage.Attachments.Status == healthError {
+		report.Status = healthDegraded
+	}
+	if h.draining.Load() {
+		report.Status = healthDraining
+	}
+
+	httputils.WriteJson(w, http.StatusOK, nil, report)
+}
+
+// newHealthCheck returns the outcome of a check that returned the given error.
+func newHealthCheck(err error) healthCheck {
+	if err != nil {
+		return healthCheck{Status: healthError, Error: err.Error()}
+	}
+	return healthCheck{Status: healthOK}
+}
@@ -0,0 +1,151 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/version"
+	"github.com/shivanshkc/rosenbridge/internal/ws"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_getLiveness(t *testing.T) {
+	handler := &Handler{dbase: &fakeDatabase{errPing: errors.New("mock error")}}
+	handler.Drain()
+
+	// Liveness does not depend on anything.
+	w := httptest.NewRecorder()
+	handler.getLiveness(w, httptest.NewRequest(http.MethodGet, healthLivePath, nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `{"status":"ok"}`, w.Body.String())
+}
+
+func TestHandler_getReadiness(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		draining     bool
+		errPing      error
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Healthy server, 200 expected",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status":"ok"}`,
+		},
+		{
+			name:         "Database is unavailable, 503 expected",
+			errPing:      errors.New("users file is not writable"),
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: `{"status":"Service Unavailable","reason":"database is unavailable"}`,
+		},
+		{
+			name:         "Server is draining, 503 expected",
+			draining:     true,
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: `{"status":"Service Unavailable","reason":"server is shutting down"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := &Handler{dbase: &fakeDatabase{errPing: tc.errPing}}
+			if tc.draining {
+				handler.Drain()
+			}
+
+			w := httptest.NewRecorder()
+			handler.getReadiness(w, httptest.NewRequest(http.MethodGet, healthReadyPath, nil))
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.Equal(t, tc.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestHandler_getHealth(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		userScopes     []string
+		errPing        error
+		draining       bool
+		expectedCode   int
+		expectedStatus string
+		expectedDB     healthCheck
+	}{
+		{
+			name:         "Caller is not an admin, 403 expected",
+			userScopes:   []string{scope.Connect},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:           "Healthy server, 200 expected",
+			userScopes:     []string{scope.Admin},
+			expectedCode:   http.StatusOK,
+			expectedStatus: healthOK,
+			expectedDB:     healthCheck{Status: healthOK},
+		},
+		{
+			name:           "Database is unavailable, degraded status expected",
+			userScopes:     []string{scope.Admin},
+			errPing:        errors.New("users file is not writable"),
+			expectedCode:   http.StatusOK,
+			expectedStatus: healthDegraded,
+			expectedDB:     healthCheck{Status: healthError, Error: "users file is not writable"},
+		},
+		{
+			name:           "Server is draining, draining status expected",
+			userScopes:     []string{scope.Admin},
+			draining:       true,
+			expectedCode:   http.StatusOK,
+			expectedStatus: healthDraining,
+			expectedDB:     healthCheck{Status: healthOK},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			user := database.User{Username: "shivansh", Scopes: tc.userScopes}
+			handler := &Handler{
+				dbase:      &fakeDatabase{getUser: user, errPing: tc.errPing},
+				wsManager:  ws.NewManager(nil),
+				sessionKey: []byte("key"),
+				sessionTTL: time.Hour,
+				startedAt:  time.Now().Add(-time.Minute),
+			}
+			if tc.draining {
+				handler.Drain()
+			}
+
+			token, _ := handler.issueSessionToken(user.Username)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, healthPath, nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			handler.getHealth(w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var report healthReport
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+
+			require.Equal(t, tc.expectedStatus, report.Status)
+			require.Equal(t, version.Version, report.Version)
+			require.GreaterOrEqual(t, report.UptimeSec, int64(60))
+			require.Zero(t, report.Connections.Websocket)
+			require.Equal(t, tc.expectedDB, report.Storage.Database)
+			require.Equal(t, healthCheck{Status: healthDisabled}, report.Storage.Attachments)
+		})
+	}
+}
@@ -1,40 +1,56 @@
 package rest
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"time"
 
+	"github.com/shivanshkc/rosenbridge/internal/idempotency"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
 	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
 )
 
+// messageBody is the part of the request body that is common to all APIs that send messages.
+type messageBody struct {
+	Message     string           `json:"message"`
+	Payload     json.RawMessage  `json:"payload"`
+	Binary      *protocol.Binary `json:"binary"`
+	Attachments []string         `json:"attachments"`
+}
+
 func (h *Handler) sendMessage(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	// Make sure credentials are correct.
-	sender, err := h.authenticateUser(r)
+	// Make sure credentials are correct, and that they allow sending. The receivers are checked later.
+	caller, err := h.authorize(r, scope.SendAny)
 	if err != nil {
 		httputils.WriteError(w, err)
 		return
 	}
+	sender := caller.username
 
 	// Anonymous struct variable to decode request body.
 	var body struct {
-		Message   string   `json:"message"`
+		messageBody
 		Receivers []string `json:"receivers"`
 	}
 
 	// Read request body.
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		slog.ErrorContext(ctx, "failed to read request body", "error", err)
-		httputils.WriteError(w, httputils.BadRequest().WithReasonStr("failed to read request body"))
+	if err := readJsonBody(r, &body); err != nil {
+		httputils.WriteError(w, err)
 		return
 	}
 
 	// Validate message.
-	if err := validateMessage(body.Message); err != nil {
+	if err := validateMessage(body.Message, body.Payload, body.Binary, body.Attachments,
+		h.messageLimits.orDefaults()); err != nil {
 		slog.ErrorContext(ctx, "invalid message", "error", err)
 		httputils.WriteError(w, httputils.BadRequest().WithReasonErr(err))
 		return
@@ -47,29 +63,169 @@ func (h *Handler) sendMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The required scopes depend on the receivers, so they are checked only now.
+	if err := caller.require(sendScopes(body.Receivers)...); err != nil {
+		slog.ErrorContext(ctx, "caller cannot send to receivers", "error", err)
+		httputils.WriteError(w, httputils.Forbidden().WithReasonErr(err))
+		return
+	}
+
 	// Event to be sent over connections.
-	event := SocketEvent{
-		EventType: eventTypeMessageReceived,
-		EventBody: map[string]any{"message": body.Message, "sender": sender},
+	audience := attachmentAudience{receivers: body.Receivers}
+	message, err := h.newMessage(r, sender, body.messageBody, audience)
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	// Retries carrying the same Idempotency-Key get the original response, and are not broadcast again.
+	idempotencyKey := r.Header.Get(headerIdempotencyKey)
+	if proceed := h.beginIdempotentRequest(w, r, sender, idempotencyKey, body); !proceed {
+		return
 	}
 
-	// Marshal for sending.
-	eventBytes, err := json.Marshal(event)
+	// Receivers who refuse the sender do not get the message. Their outcomes do not reveal why.
+	receivers, outcomes, err := h.filterReceivers(ctx, sender, body.Receivers)
 	if err != nil {
-		slog.ErrorContext(ctx, "failed to marshal event", "error", err)
-		httputils.WriteError(w, httputils.InternalServerError())
+		h.abortIdempotentRequest(sender, idempotencyKey)
+		httputils.WriteError(w, err)
 		return
 	}
 
-	// Send 202 response. In future releases, this will be changed to a 200 response with message delivery details.
-	httputils.WriteJson(w, http.StatusAccepted, nil, map[string]string{})
+	// Send 202 response with the outcome of every receiver.
+	response := idempotency.Response{StatusCode: http.StatusAccepted, Body: map[string]any{"receivers": outcomes}}
+	h.completeIdempotentRequest(sender, idempotencyKey, response)
+	httputils.WriteJson(w, response.StatusCode, nil, response.Body)
 
 	// Context for the websocket write operations.
-	sendCtx, cancelFunc := context.WithTimeout(context.Background(), time.Second*5)
+	// It outlives the request, but keeps its values, like the trace.
+	sendCtx, cancelFunc := context.WithTimeout(context.WithoutCancel(ctx), time.Second*5)
 	defer cancelFunc()
 
 	// Send to all receivers.
-	if err := h.wsManager.Broadcast(sendCtx, eventBytes, body.Receivers); err != nil {
+	if err := h.broadcast(sendCtx, message, receivers); err != nil {
+		slog.ErrorContext(ctx, "failed to broadcast event", "error", err)
+	}
+}
+
+// sendScopes returns the scopes required to send a message to the receivers.
+func sendScopes(receivers []string) []string {
+	scopes := make([]string, len(receivers))
+	for i, receiver := range receivers {
+		scopes[i] = scope.SendTo(receiver)
+	}
+	return scopes
+}
+
+// broadcast sends the message to all connections of the receivers, over websocket, STOMP and MQTT, and to their
+// webhooks.
+func (h *Handler) broadcast(ctx context.Context, message protocol.MessageReceived, receivers []string) error {
+	event := protocol.NewEvent(protocol.EventTypeMessageReceived, message)
+	err := h.wsManager.Broadcast(ctx, event, receivers)
+
+	if h.stomp != nil {
+		err = errors.Join(err, h.stomp.SendToUsers(ctx, message, receivers))
+	}
+
+	if mqttServer := h.mqtt.Load(); mqttServer != nil {
+		err = errors.Join(err, mqttServer.SendToUsers(ctx, message, receivers))
+	}
+
+	if h.webhooks != nil {
+		h.enqueueWebhooks(ctx, message, receivers)
+	}
+
+	return err
+}
+
+// relayMessage validates a direct message sent over a protocol other than HTTP, like STOMP or MQTT, the same way as
+// the POST /api/message route, and sends it to the receivers. The scopes are those that the sender authenticated with.
+//
+// The caller does not need to log the returned error. Also, the returned error is safe to send to the client.
+func (h *Handler) relayMessage(ctx context.Context, message protocol.MessageReceived, scopes scope.Set,
+	receivers []string,
+) error {
+	if err := validateMessage(message.Message, message.Payload, message.Binary, nil,
+		h.messageLimits.orDefaults()); err != nil {
+		slog.ErrorContext(ctx, "invalid message", "error", err)
+		return err
+	}
+
+	if err := validateReceiverList(receivers); err != nil {
+		slog.ErrorContext(ctx, "invalid receivers list", "error", err)
+		return err
+	}
+
+	user, err := h.dbase.GetUser(ctx, message.Sender)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get sender to check scopes", "error", err)
+		return errors.New("failed to check scopes")
+	}
+
+	// Like in hasScope, taking a scope away from the sender applies to their open connections too.
+	required := sendScopes(receivers)
+	if missing := cmp.Or(scopes.Missing(required), userScopes(user).Missing(required)); missing != "" {
+		slog.ErrorContext(ctx, "sender lacks a scope", "scope", missing)
+		return fmt.Errorf("missing scope: %s", missing)
+	}
+
+	// Receivers who refuse the sender are dropped silently, as the other protocols have no per-receiver outcomes.
+	receivers, _, err = h.filterReceivers(ctx, message.Sender, receivers)
+	if err != nil {
+		return errors.New("failed to check receivers")
+	}
+
+	// Delivery failures are not the sender's fault, so they are only logged.
+	if err := h.broadcast(ctx, message, receivers); err != nil {
 		slog.ErrorContext(ctx, "failed to broadcast event", "error", err)
 	}
+
+	return nil
+}
+
+// readJsonBody reads the request body fully and decodes it into the given target.
+//
+// The caller does not need to log the returned error. Also, the returned error is safe to send in the response.
+func readJsonBody(r *http.Request, target any) error {
+	ctx := r.Context()
+
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to read request body", "error", err)
+		return httputils.BadRequest().WithReasonStr("failed to read request body")
+	}
+
+	if err := json.Unmarshal(bodyBytes, target); err != nil {
+		slog.ErrorContext(ctx, "failed to decode request body", "error", err)
+		return httputils.BadRequest().WithReasonStr("failed to read request body")
+	}
+
+	return nil
+}
+
+// newMessage converts an already validated message body into the MessageReceived event body, resolving its
+// attachment IDs into download links signed for the given audience.
+//
+// The caller does not need to log the returned error. Also, the returned error is safe to send in the response.
+func (h *Handler) newMessage(r *http.Request, sender string, body messageBody, audience attachmentAudience,
+) (protocol.MessageReceived, error) {
+	ctx := r.Context()
+
+	attachments, err := h.resolveAttachments(r, sender, body.Attachments, audience)
+	if err != nil {
+		if errors.Is(err, errAttachmentNotFound) || errors.Is(err, errAttachmentsDisabled) {
+			slog.ErrorContext(ctx, "invalid attachments", "error", err)
+			return protocol.MessageReceived{}, httputils.BadRequest().WithReasonErr(err)
+		}
+		slog.ErrorContext(ctx, "unexpected error while resolving attachments", "error", err)
+		return protocol.MessageReceived{}, httputils.InternalServerError()
+	}
+
+	return protocol.MessageReceived{
+		Message:     body.Message,
+		Payload:     body.Payload,
+		Binary:      body.Binary,
+		Attachments: attachments,
+		Sender:      sender,
+	}, nil
 }
@@ -1,12 +1,17 @@
 package rest
 
 import (
+	"bytes"
+	"encoding/base64"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/shivanshkc/rosenbridge/internal/config"
 	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/idempotency"
 	"github.com/shivanshkc/rosenbridge/internal/ws"
 
 	"github.com/stretchr/testify/require"
@@ -41,9 +46,39 @@ func TestHandler_sendMessage_validations(t *testing.T) {
 		},
 		{
 			name:         "Message too long, error expected",
-			requestBody:  `{"message":"` + strings.Repeat("x", messageMaxLength+1) + `","receivers":["alice"]}`,
+			requestBody:  `{"message":"` + strings.Repeat("x", defaultMessageLimits.text+1) + `","receivers":["alice"]}`,
 			expectedCode: http.StatusBadRequest,
-			expectedBody: `{"status":"Bad Request","reason":"` + errMessageTooLong.Error() + `"}`,
+			expectedBody: `{"status":"Bad Request","reason":"` + errMessageTooLong(defaultMessageLimits.text).Error() + `"}`,
+		},
+		{
+			name:         "Multibyte message too long in bytes, error expected",
+			requestBody:  `{"message":"` + strings.Repeat("é", defaultMessageLimits.text/2+1) + `","receivers":["alice"]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"` + errMessageTooLong(defaultMessageLimits.text).Error() + `"}`,
+		},
+		{
+			name:         "Payload is not an object, error expected",
+			requestBody:  `{"payload":[1,2],"receivers":["alice"]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"` + errPayloadNotObject.Error() + `"}`,
+		},
+		{
+			name:         "Payload too long, error expected",
+			requestBody:  `{"payload":{"x":"` + strings.Repeat("x", defaultMessageLimits.payload) + `"},"receivers":["alice"]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"` + errPayloadTooLong(defaultMessageLimits.payload).Error() + `"}`,
+		},
+		{
+			name:         "Binary without content type, error expected",
+			requestBody:  `{"binary":{"data":"aGk="},"receivers":["alice"]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"` + errBinaryContentType.Error() + `"}`,
+		},
+		{
+			name:         "Binary without data, error expected",
+			requestBody:  `{"binary":{"content_type":"application/sdp"},"receivers":["alice"]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"` + errBinaryDataEmpty.Error() + `"}`,
 		},
 		{
 			name:         "No receivers field, error expected",
@@ -85,7 +120,7 @@ func TestHandler_sendMessage_validations(t *testing.T) {
 
 			handler := &Handler{
 				dbase:     &fakeDatabase{getUser: validUser},
-				wsManager: ws.NewManager(),
+				wsManager: ws.NewManager(nil),
 			}
 			handler.sendMessage(w, r)
 
@@ -121,6 +156,16 @@ func TestHandler_sendMessage(t *testing.T) {
 			expectedCode: http.StatusUnauthorized,
 			expectedBody: `{"status":"Unauthorized","reason":"basic auth credentials absent"}`,
 		},
+		{
+			name:         "Valid request with payload and binary data, 202 expected",
+			setBasicAuth: true,
+			username:     mockUsername,
+			password:     mockPassword,
+			dbase:        &fakeDatabase{getUser: validUser},
+			requestBody:  `{"payload":{"sdp":"v=0"},"binary":{"content_type":"image/png","data":"aGk="},"receivers":["alice"]}`,
+			expectedCode: http.StatusAccepted,
+			expectedBody: `{"receivers":[{"username":"alice","outcome":"accepted"}]}`,
+		},
 		{
 			name:         "Valid request, 202 expected",
 			setBasicAuth: true,
@@ -129,7 +174,7 @@ func TestHandler_sendMessage(t *testing.T) {
 			dbase:        &fakeDatabase{getUser: validUser},
 			requestBody:  `{"message":"hello","receivers":["alice"]}`,
 			expectedCode: http.StatusAccepted,
-			expectedBody: `{}`,
+			expectedBody: `{"receivers":[{"username":"alice","outcome":"accepted"}]}`,
 		},
 	}
 
@@ -141,7 +186,7 @@ func TestHandler_sendMessage(t *testing.T) {
 				r.SetBasicAuth(tc.username, tc.password)
 			}
 
-			handler := &Handler{dbase: tc.dbase, wsManager: ws.NewManager()}
+			handler := &Handler{dbase: tc.dbase, wsManager: ws.NewManager(nil)}
 			handler.sendMessage(w, r)
 
 			require.Equal(t, tc.expectedCode, w.Code)
@@ -149,3 +194,100 @@ func TestHandler_sendMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_sendMessage_bodyLimit(t *testing.T) {
+	mockUsername, mockPassword := "shivansh", "password123"
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(mockPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	// The binary data is far larger than the base body limit, but within the configured limit.
+	binary := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 64*1024))
+
+	var testCases = []struct {
+		name         string
+		data         string
+		expectedCode int
+	}{
+		{name: "Binary data within the limit, 202 expected", data: binary, expectedCode: http.StatusAccepted},
+		{name: "Body above the limit, 400 expected", data: binary + binary, expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := &Handler{
+				dbase:         &fakeDatabase{getUser: database.User{Username: mockUsername, PasswordHash: string(passwordHash)}},
+				wsManager:     ws.NewManager(nil),
+				messageLimits: messageLimits{binary: 64 * 1024},
+			}
+			handler.addRoutes(nil)
+			handler.addMiddleware(config.Config{})
+
+			body := `{"binary":{"content_type":"image/png","data":"` + tc.data + `"},"receivers":["alice"]}`
+			r := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(body))
+			r.SetBasicAuth(mockUsername, mockPassword)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestHandler_sendMessage_idempotency(t *testing.T) {
+	mockUsername, mockPassword := "shivansh", "password123"
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(mockPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	handler := &Handler{
+		dbase:       &fakeDatabase{getUser: database.User{Username: mockUsername, PasswordHash: string(passwordHash)}},
+		wsManager:   ws.NewManager(nil),
+		idempotency: idempotency.NewStore(time.Minute, 10),
+	}
+
+	// send makes a request with the given idempotency key and body.
+	send := func(key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(body))
+		r.SetBasicAuth(mockUsername, mockPassword)
+		r.Header.Set(headerIdempotencyKey, key)
+		handler.sendMessage(w, r)
+		return w
+	}
+
+	body := `{"message":"hello","receivers":["alice"]}`
+
+	// Original request.
+	w := send("key-1", body)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Equal(t, `{"receivers":[{"username":"alice","outcome":"accepted"}]}`, w.Body.String())
+	require.Empty(t, w.Header().Get(headerIdempotentReplay))
+
+	// Retry gets the original response.
+	w = send("key-1", body)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Equal(t, `{"receivers":[{"username":"alice","outcome":"accepted"}]}`, w.Body.String())
+	require.Equal(t, "true", w.Header().Get(headerIdempotentReplay))
+
+	// Retry that only differs in formatting and key order, including within the payload.
+	w = send("key-2", `{"message":"hi","payload":{"a":1,"b":[true]},"receivers":["alice"]}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	w = send("key-2", `{ "receivers": ["alice"], "payload": {"b": [ true ], "a": 1}, "message": "hi" }`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Equal(t, "true", w.Header().Get(headerIdempotentReplay))
+
+	// Reused key with a different payload.
+	w = send("key-2", `{"message":"hi","payload":{"a":2,"b":[true]},"receivers":["alice"]}`)
+	require.Equal(t, http.StatusConflict, w.Code)
+
+	// Reused key with a different body.
+	w = send("key-1", `{"message":"bye","receivers":["alice"]}`)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Equal(t, `{"status":"Conflict","reason":"`+idempotency.ErrFingerprintMismatch.Error()+`"}`, w.Body.String())
+
+	// Invalid key.
+	w = send(strings.Repeat("k", idempotencyKeyMaxLength+1), body)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, `{"status":"Bad Request","reason":"`+errIdempotencyKeyLength.Error()+`"}`, w.Body.String())
+}
@@ -0,0 +1,136 @@
+package rest
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/shivanshkc/rosenbridge/internal/metrics"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
+)
+
+// metricsPath is the route that serves metrics in the Prometheus text format.
+const metricsPath = "/metrics"
+
+var (
+	httpRequests = metrics.NewCounterVec("rosenbridge_http_requests_total",
+		"Number of HTTP requests served, by method, route and status code.", "method", "route", "status")
+	httpRequestDuration = metrics.NewHistogramVec("rosenbridge_http_request_duration_seconds",
+		"Duration of HTTP requests, by method, route and status code.", metrics.DefaultBuckets,
+		"method", "route", "status")
+)
+
+// requestRoute returns the route of a served request, which is the pattern that it matched without the method, like
+// "/api/topic/{name}/publish". This way, paths with IDs or names in them do not make a new series each. Requests that
+// did not match any route share the "unmatched" route.
+func requestRoute(r *http.Request) string {
+	route := r.Pattern
+	if _, path, ok := strings.Cut(route, " "); ok {
+		// The method is a label of its own.
+		route = path
+	}
+	if route == "" {
+		route = "unmatched"
+	}
+	return route
+}
+
+// responseStatus returns the status code of a response, as recorded by httputils.ResponseWriterWithCode.
+func responseStatus(status int) int {
+	if status == 0 {
+		// Nothing was written, which makes the server respond with 200.
+		return http.StatusOK
+	}
+	return status
+}
+
+// observeRequest records the metrics of a served request.
+func observeRequest(r *http.Request, status int, seconds float64) {
+	route := requestRoute(r)
+	statusLabel := strconv.Itoa(responseStatus(status))
+	httpRequests.With(r.Method, route, statusLabel).Inc()
+	httpRequestDuration.With(r.Method, route, statusLabel).Observe(seconds)
+}
+
+// metricsAccess restricts who can scrape metrics.
+type metricsAccess struct {
+	// bearerToken must be sent by scrapers, if set.
+	bearerToken string
+	// networks are the networks that scrapers must be in, if not nil.
+	networks []netip.Prefix
+}
+
+// newMetricsAccess returns the access rules as per the config. Invalid networks are logged and skipped, so that
+// a typo restricts access instead of opening it.
+func newMetricsAccess(ctx context.Context, bearerToken string, allowedNetworks []string) *metricsAccess {
+	access := &metricsAccess{bearerToken: bearerToken}
+	if len(allowedNetworks) == 0 {
+		return access
+	}
+
+	access.networks = []netip.Prefix{}
+	for _, network := range allowedNetworks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			// Single addresses are allowed too.
+			addr, addrErr := netip.ParseAddr(network)
+			if addrErr != nil {
+				slog.ErrorContext(ctx, "invalid metrics network, skipping", "network", network, "error", err)
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		access.networks = append(access.networks, prefix.Masked())
+	}
+
+	return access
+}
+
+// getMetrics is the API handler for the GET /metrics route.
+func (h *Handler) getMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if h.metricsAccess.networks != nil && !h.metricsAccess.allowsAddr(r.RemoteAddr) {
+		slog.ErrorContext(ctx, "metrics scraper is not in an allowed network", "remoteAddr", r.RemoteAddr)
+		httputils.WriteError(w, httputils.Forbidden())
+		return
+	}
+
+	if h.metricsAccess.bearerToken != "" {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.metricsAccess.bearerToken)) != 1 {
+			slog.ErrorContext(ctx, "invalid metrics bearer token")
+			httputils.WriteError(w, httputils.Unauthorized())
+			return
+		}
+	}
+
+	metrics.Default.Handler().ServeHTTP(w, r)
+}
+
+// allowsAddr reports whether the address, in the host:port form of http.Request.RemoteAddr, is in an allowed network.
+func (m metricsAccess) allowsAddr(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	// IPv4 clients of dual-stack listeners have IPv4-mapped IPv6 addresses.
+	addr = addr.Unmap()
+
+	for _, network := range m.networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
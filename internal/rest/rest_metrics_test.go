@@ -0,0 +1,127 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shivanshkc/rosenbridge/internal/metrics"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_getMetrics(t *testing.T) {
+	var testCases = []struct {
+		name            string
+		bearerToken     string
+		allowedNetworks []string
+		remoteAddr      string
+		authorization   string
+		expectedCode    int
+	}{
+		{
+			name:         "No restrictions, 200 expected",
+			remoteAddr:   "203.0.113.5:1234",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:            "Client in an allowed network, 200 expected",
+			allowedNetworks: []string{"10.0.0.0/8", "192.168.1.10"},
+			remoteAddr:      "10.1.2.3:1234",
+			expectedCode:    http.StatusOK,
+		},
+		{
+			name:            "Client with an allowed address, 200 expected",
+			allowedNetworks: []string{"10.0.0.0/8", "192.168.1.10"},
+			remoteAddr:      "192.168.1.10:1234",
+			expectedCode:    http.StatusOK,
+		},
+		{
+			name:            "IPv4-mapped client address, 200 expected",
+			allowedNetworks: []string{"10.0.0.0/8"},
+			remoteAddr:      "[::ffff:10.1.2.3]:1234",
+			expectedCode:    http.StatusOK,
+		},
+		{
+			name:            "Client outside the allowed networks, 403 expected",
+			allowedNetworks: []string{"10.0.0.0/8", "192.168.1.10"},
+			remoteAddr:      "192.168.1.11:1234",
+			expectedCode:    http.StatusForbidden,
+		},
+		{
+			name:            "Only invalid networks, 403 expected",
+			allowedNetworks: []string{"10.0.0.0/33"},
+			remoteAddr:      "10.1.2.3:1234",
+			expectedCode:    http.StatusForbidden,
+		},
+		{
+			name:          "Correct bearer token, 200 expected",
+			bearerToken:   "secret",
+			remoteAddr:    "203.0.113.5:1234",
+			authorization: "Bearer secret",
+			expectedCode:  http.StatusOK,
+		},
+		{
+			name:          "Wrong bearer token, 401 expected",
+			bearerToken:   "secret",
+			remoteAddr:    "203.0.113.5:1234",
+			authorization: "Bearer wrong",
+			expectedCode:  http.StatusUnauthorized,
+		},
+		{
+			name:         "No bearer token, 401 expected",
+			bearerToken:  "secret",
+			remoteAddr:   "203.0.113.5:1234",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := &Handler{
+				metricsAccess: newMetricsAccess(context.Background(), tc.bearerToken, tc.allowedNetworks),
+			}
+
+			r := httptest.NewRequest(http.MethodGet, metricsPath, nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+
+			w := httptest.NewRecorder()
+			handler.getMetrics(w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusOK {
+				require.Equal(t, metrics.ContentType, w.Header().Get("Content-Type"))
+				require.Contains(t, w.Body.String(), "# TYPE rosenbridge_http_requests_total counter")
+			}
+		})
+	}
+}
+
+func TestAccessLoggerMiddleware_Metrics(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/topic/{name}/publish", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	handler := accessLoggerMiddleware(mux)
+
+	for _, path := range []string{"/api/topic/news/publish", "/api/topic/sports/publish", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+
+	var builder strings.Builder
+	require.NoError(t, metrics.Default.Write(&builder))
+	exposition := builder.String()
+
+	// Paths with names in them share the series of their route.
+	require.Contains(t, exposition,
+		`rosenbridge_http_requests_total{method="POST",route="/api/topic/{name}/publish",status="202"} 2`)
+	require.Contains(t, exposition,
+		`rosenbridge_http_request_duration_seconds_count{method="POST",route="/api/topic/{name}/publish",status="202"} 2`)
+	require.Contains(t, exposition,
+		`rosenbridge_http_requests_total{method="POST",route="unmatched",status="404"} 1`)
+}
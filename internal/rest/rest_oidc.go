@@ -0,0 +1,298 @@
+package rest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/oidc"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
+)
+
+const (
+	// oidcLoginPath is the route that starts an OpenID Connect login.
+	oidcLoginPath = "/api/auth/oidc/login"
+	// oidcCallbackPath is the route that the provider redirects to after the login.
+	oidcCallbackPath = "/api/auth/oidc/callback"
+
+	// oidcCookieName is the cookie that keeps the state, the nonce and the PKCE verifier of a login until its callback.
+	oidcCookieName = "rosenbridge_oidc"
+	// oidcLoginTTL is how long a user has to complete the login at the provider.
+	oidcLoginTTL = 10 * time.Minute
+)
+
+var (
+	errOIDCLoginState  = errors.New("login expired or was started in another browser, please try again")
+	errOIDCNotLinked   = errors.New("no user is linked to this account")
+	errOIDCUserTaken   = errors.New("no username is available for this account")
+	errOIDCProviderErr = errors.New("login was not completed at the provider")
+
+	// usernameInvalidChars are replaced when usernames are derived from claims.
+	usernameInvalidChars = regexp.MustCompile("[^a-zA-Z0-9_-]")
+)
+
+// oidcLogin is the config of OpenID Connect logins.
+type oidcLogin struct {
+	// autoProvision creates a user for every new identity.
+	autoProvision bool
+	// linkExistingUsers links a new identity to the existing user with the same username.
+	linkExistingUsers bool
+	// postLoginRedirectURL is where the browser is sent after login. If empty, the callback responds with JSON.
+	postLoginRedirectURL string
+	// secureCookie restricts the login cookie to HTTPS.
+	secureCookie bool
+}
+
+// sessionResponse is the response of a successful login.
+type sessionResponse struct {
+	Username  string    `json:"username"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// oidcLoginStart is the API handler for the GET /api/auth/oidc/login route.
+//
+// It redirects the browser to the login page of the provider. The state, the nonce and the PKCE verifier of the login
+// are kept in a signed cookie, so that the callback can be handled by any instance of Rosenbridge.
+func (h *Handler) oidcLoginStart(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	state, nonce, verifier := oidc.RandomString(), oidc.RandomString(), oidc.RandomString()
+
+	authURL, err := h.oidc.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to build oidc login url", "error", err)
+		httputils.WriteError(w, httputils.ServiceUnavailable().WithReasonStr("login provider is unavailable"))
+		return
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(oidcLoginTTL).Unix(), 10)
+	value := strings.Join([]string{state, nonce, verifier, expires}, ".")
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    value + "." + h.oidcCookieSignature(value),
+		Path:     oidcCallbackPath,
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.oidcLogin.secureCookie,
+		// Lax, because the callback is a top-level navigation from the provider.
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcLoginCallback is the API handler for the GET /api/auth/oidc/callback route.
+//
+// It redeems the code from the provider, finds or provisions the user of the identity, and issues a session token.
+func (h *Handler) oidcLoginCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	// The cookie is only good for one attempt.
+	http.SetCookie(w, &http.Cookie{Name: oidcCookieName, Path: oidcCallbackPath, MaxAge: -1})
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		slog.ErrorContext(ctx, "oidc provider returned an error", "error", providerErr,
+			"description", query.Get("error_description"))
+		httputils.WriteError(w, httputils.Unauthorized().WithReasonErr(errOIDCProviderErr))
+		return
+	}
+
+	nonce, verifier, err := h.readOIDCCookie(r, query.Get("state"))
+	if err != nil {
+		slog.ErrorContext(ctx, "invalid oidc login state", "error", err)
+		httputils.WriteError(w, httputils.BadRequest().WithReasonErr(errOIDCLoginState))
+		return
+	}
+
+	claims, err := h.oidc.Exchange(ctx, query.Get("code"), verifier, nonce)
+	if err != nil {
+		if errors.Is(err, oidc.ErrInvalidToken) {
+			slog.ErrorContext(ctx, "invalid id token", "error", err)
+			httputils.WriteError(w, httputils.Unauthorized())
+			return
+		}
+		slog.ErrorContext(ctx, "failed to redeem oidc code", "error", err)
+		httputils.WriteError(w, httputils.ServiceUnavailable().WithReasonStr("login provider is unavailable"))
+		return
+	}
+
+	user, err := h.oidcUser(ctx, claims)
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	token, expiresAt := h.issueSessionToken(user.Username)
+	slog.InfoContext(ctx, "oidc login successful", "username", user.Username)
+
+	if h.oidcLogin.postLoginRedirectURL == "" {
+		httputils.WriteJson(w, http.StatusOK, nil,
+			sessionResponse{Username: user.Username, Token: token, ExpiresAt: expiresAt})
+		return
+	}
+
+	// The token is put in the fragment, so that it is not sent to any server, or logged.
+	fragment := url.Values{}
+	fragment.Set("username", user.Username)
+	fragment.Set("token", token)
+	fragment.Set("expiresAt", expiresAt.Format(time.RFC3339))
+	http.Redirect(w, r, h.oidcLogin.postLoginRedirectURL+"#"+fragment.Encode(), http.StatusSeeOther)
+}
+
+// readOIDCCookie verifies the login cookie of the request against the state from the provider, and returns the nonce
+// and the PKCE verifier of the login.
+func (h *Handler) readOIDCCookie(r *http.Request, state string) (nonce, verifier string, err error) {
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		return "", "", errors.New("no login cookie")
+	}
+
+	index := strings.LastIndex(cookie.Value, ".")
+	if index < 0 {
+		return "", "", errors.New("malformed login cookie")
+	}
+	value, signature := cookie.Value[:index], cookie.Value[index+1:]
+
+	if !hmac.Equal([]byte(signature), []byte(h.oidcCookieSignature(value))) {
+		return "", "", errors.New("login cookie signature does not match")
+	}
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 4 {
+		return "", "", errors.New("malformed login cookie")
+	}
+
+	unix, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || time.Now().After(time.Unix(unix, 0)) {
+		return "", "", errors.New("login cookie is expired")
+	}
+
+	// The state ties the callback to the browser that started the login, which prevents login CSRF.
+	if subtle.ConstantTimeCompare([]byte(parts[0]), []byte(state)) != 1 {
+		return "", "", errors.New("state does not match")
+	}
+
+	return parts[1], parts[2], nil
+}
+
+// oidcCookieSignature returns the hex encoded HMAC-SHA256 of the login cookie value, keyed with the session key.
+func (h *Handler) oidcCookieSignature(value string) string {
+	mac := hmac.New(sha256.New, h.sessionKey)
+	mac.Write([]byte("oidc\n" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// oidcUser returns the user linked to the identity of the claims. If there is none, it links the identity to an
+// existing user or provisions a new one, as per the config.
+//
+// The caller does not need to log the returned error. Also, the returned error is safe to send in the response.
+func (h *Handler) oidcUser(ctx context.Context, claims oidc.Claims) (database.User, error) {
+	identity := database.Identity{Issuer: claims.Issuer, Subject: claims.Subject}
+
+	user, err := h.dbase.GetUserByIdentity(ctx, identity)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, database.ErrUserNotFound) {
+		slog.ErrorContext(ctx, "unexpected error while getting user by identity", "error", err)
+		return database.User{}, httputils.InternalServerError()
+	}
+
+	username := oidcUsername(claims)
+
+	if h.oidcLogin.linkExistingUsers {
+		user, err := h.linkIdentity(ctx, username, identity)
+		if err == nil {
+			slog.InfoContext(ctx, "linked identity to existing user", "username", username)
+			return user, nil
+		}
+		if !errors.Is(err, database.ErrUserNotFound) && !errors.Is(err, errOIDCUserTaken) {
+			slog.ErrorContext(ctx, "unexpected error while linking identity", "error", err)
+			return database.User{}, httputils.InternalServerError()
+		}
+	}
+
+	if !h.oidcLogin.autoProvision {
+		slog.ErrorContext(ctx, "identity is not linked to any user", "issuer", identity.Issuer)
+		return database.User{}, httputils.Forbidden().WithReasonErr(errOIDCNotLinked)
+	}
+
+	// If the username is taken, a suffix derived from the identity makes it unique, and stable across attempts.
+	suffix := "-" + identityHash(identity)[:8]
+	candidates := []string{username, username[:min(len(username), usernameMaxLength-len(suffix))] + suffix}
+
+	for _, candidate := range candidates {
+		user := database.User{Username: candidate, Identities: []database.Identity{identity}}
+		err := h.dbase.InsertUser(ctx, user)
+		if err == nil {
+			slog.InfoContext(ctx, "provisioned user for identity", "username", candidate)
+			return user, nil
+		}
+		if !errors.Is(err, database.ErrUserAlreadyExists) {
+			slog.ErrorContext(ctx, "unexpected error while provisioning user", "error", err)
+			return database.User{}, httputils.InternalServerError()
+		}
+	}
+
+	slog.ErrorContext(ctx, "no username is available for identity", "username", username)
+	return database.User{}, httputils.Conflict().WithReasonErr(errOIDCUserTaken)
+}
+
+// linkIdentity adds the identity to the existing user. It fails with errOIDCUserTaken if the user already has another
+// identity at the same provider.
+func (h *Handler) linkIdentity(ctx context.Context, username string, identity database.Identity,
+) (database.User, error) {
+	var linked database.User
+	err := h.dbase.UpdateUser(ctx, username, func(user *database.User) error {
+		if slices.ContainsFunc(user.Identities, func(i database.Identity) bool { return i.Issuer == identity.Issuer }) {
+			return errOIDCUserTaken
+		}
+
+		user.Identities = append(user.Identities, identity)
+		linked = *user
+		return nil
+	})
+
+	return linked, err
+}
+
+// oidcUsername derives a valid username from the claims: the preferred username, or else the local part of the
+// verified email address, or else a name derived from the identity itself.
+func oidcUsername(claims oidc.Claims) string {
+	candidates := []string{claims.PreferredUsername}
+	if claims.EmailVerified {
+		local, _, _ := strings.Cut(claims.Email, "@")
+		candidates = append(candidates, local)
+	}
+
+	for _, candidate := range candidates {
+		candidate = usernameInvalidChars.ReplaceAllString(candidate, "_")
+		candidate = candidate[:min(len(candidate), usernameMaxLength)]
+		if validateUsername(candidate) == nil {
+			return candidate
+		}
+	}
+
+	return "user-" + identityHash(database.Identity{Issuer: claims.Issuer, Subject: claims.Subject})[:12]
+}
+
+// identityHash returns the hex encoded SHA-256 hash of the identity.
+func identityHash(identity database.Identity) string {
+	hash := sha256.Sum256([]byte(identity.Issuer + "\n" + identity.Subject))
+	return hex.EncodeToString(hash[:])
+}
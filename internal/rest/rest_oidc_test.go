@@ -0,0 +1,268 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/oidc"
+	"github.com/shivanshkc/rosenbridge/internal/oidc/oidctest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newOIDCTestHandler returns a handler that logs users in with the issuer, backed by a new file database.
+func newOIDCTestHandler(t *testing.T, issuer *oidctest.Issuer, login oidcLogin) (*Handler, *database.FileDatabase) {
+	dbase, err := database.NewFileDatabase(filepath.Join(t.TempDir(), "users.json"))
+	require.NoError(t, err)
+
+	handler := &Handler{
+		dbase: dbase,
+		oidc: oidc.NewProvider(oidc.Options{
+			Issuer:      issuer.URL(),
+			ClientID:    oidctest.ClientID,
+			RedirectURL: "http://localhost:8080" + oidcCallbackPath,
+		}),
+		oidcLogin:  login,
+		sessionKey: []byte("session-key"),
+		sessionTTL: time.Hour,
+	}
+	return handler, dbase
+}
+
+// loginWithOIDC goes through the whole login, with the given claims in the ID token. The tamper function can change
+// the callback request before it is sent.
+func loginWithOIDC(t *testing.T, handler *Handler, issuer *oidctest.Issuer, claims map[string]any,
+	tamper func(r *http.Request),
+) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.oidcLoginStart(w, httptest.NewRequest(http.MethodGet, oidcLoginPath, nil))
+	require.Equal(t, http.StatusFound, w.Code)
+
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	require.True(t, cookies[0].HttpOnly)
+
+	code, state := issuer.Authorize(w.Header().Get("Location"), claims)
+
+	query := url.Values{"code": {code}, "state": {state}}
+	r := httptest.NewRequest(http.MethodGet, oidcCallbackPath+"?"+query.Encode(), nil)
+	r.AddCookie(cookies[0])
+	if tamper != nil {
+		tamper(r)
+	}
+
+	w = httptest.NewRecorder()
+	handler.oidcLoginCallback(w, r)
+	return w
+}
+
+func TestHandler_oidcLogin(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	handler, dbase := newOIDCTestHandler(t, issuer, oidcLogin{autoProvision: true})
+
+	w := loginWithOIDC(t, handler, issuer, map[string]any{"preferred_username": "alice"}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var session sessionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &session))
+	require.Equal(t, "alice", session.Username)
+	require.True(t, strings.HasPrefix(session.Token, sessionTokenPrefix))
+
+	// The user is provisioned with the identity, and without a password.
+	user, err := dbase.GetUser(context.Background(), "alice")
+	require.NoError(t, err)
+	require.Empty(t, user.PasswordHash)
+	require.Equal(t, []database.Identity{{Issuer: issuer.URL(), Subject: "subject-1"}}, user.Identities)
+
+	// The session token authenticates the user, including for account management.
+	r := httptest.NewRequest(http.MethodGet, privacyPath, nil)
+	r.Header.Set("Authorization", "Bearer "+session.Token)
+	w = httptest.NewRecorder()
+	handler.getPrivacy(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Another login with the same identity gets the same user, even if the username at the provider changed.
+	w = loginWithOIDC(t, handler, issuer, map[string]any{"preferred_username": "alice2"}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &session))
+	require.Equal(t, "alice", session.Username)
+
+	// Another identity with a taken username gets a suffix.
+	w = loginWithOIDC(t, handler, issuer, map[string]any{"sub": "subject-2", "preferred_username": "alice"}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &session))
+	require.Regexp(t, "^alice-[0-9a-f]{8}$", session.Username)
+}
+
+func TestHandler_oidcLogin_PostLoginRedirect(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	handler, _ := newOIDCTestHandler(t, issuer,
+		oidcLogin{autoProvision: true, postLoginRedirectURL: "https://app.example.com/login"})
+
+	w := loginWithOIDC(t, handler, issuer, map[string]any{"email": "bob@example.com", "email_verified": true}, nil)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, "https://app.example.com/login", location.Scheme+"://"+location.Host+location.Path)
+
+	// The session is in the fragment, so that it never reaches a server.
+	fragment, err := url.ParseQuery(location.Fragment)
+	require.NoError(t, err)
+	require.Equal(t, "bob", fragment.Get("username"))
+	require.True(t, strings.HasPrefix(fragment.Get("token"), sessionTokenPrefix))
+}
+
+func TestHandler_oidcLogin_Errors(t *testing.T) {
+	var testCases = []struct {
+		name         string
+		login        oidcLogin
+		existingUser *database.User
+		claims       map[string]any
+		tamper       func(r *http.Request)
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "State does not match, 400 expected",
+			login:        oidcLogin{autoProvision: true},
+			tamper:       func(r *http.Request) { r.URL.RawQuery += "x" },
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"` + errOIDCLoginState.Error() + `"}`,
+		},
+		{
+			name:         "No login cookie, 400 expected",
+			login:        oidcLogin{autoProvision: true},
+			tamper:       func(r *http.Request) { r.Header.Del("Cookie") },
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"` + errOIDCLoginState.Error() + `"}`,
+		},
+		{
+			name:         "Error from the provider, 401 expected",
+			login:        oidcLogin{autoProvision: true},
+			tamper:       func(r *http.Request) { r.URL.RawQuery = "error=access_denied" },
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"status":"Unauthorized","reason":"` + errOIDCProviderErr.Error() + `"}`,
+		},
+		{
+			name:         "Token for another client, 401 expected",
+			login:        oidcLogin{autoProvision: true},
+			claims:       map[string]any{"aud": "other"},
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"status":"Unauthorized","reason":""}`,
+		},
+		{
+			name:         "New identity without provisioning, 403 expected",
+			login:        oidcLogin{},
+			claims:       map[string]any{"preferred_username": "carol"},
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"` + errOIDCNotLinked.Error() + `"}`,
+		},
+		{
+			name:         "Existing user is not linked without the config, 403 expected",
+			login:        oidcLogin{},
+			existingUser: &database.User{Username: "carol"},
+			claims:       map[string]any{"preferred_username": "carol"},
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"` + errOIDCNotLinked.Error() + `"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			issuer := oidctest.NewIssuer(t)
+			handler, dbase := newOIDCTestHandler(t, issuer, tc.login)
+			if tc.existingUser != nil {
+				require.NoError(t, dbase.InsertUser(context.Background(), *tc.existingUser))
+			}
+
+			w := loginWithOIDC(t, handler, issuer, tc.claims, tc.tamper)
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.Equal(t, tc.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestHandler_oidcLogin_LinkExistingUsers(t *testing.T) {
+	issuer := oidctest.NewIssuer(t)
+	handler, dbase := newOIDCTestHandler(t, issuer, oidcLogin{linkExistingUsers: true})
+
+	existing := database.User{Username: "carol", PasswordHash: "hash"}
+	require.NoError(t, dbase.InsertUser(context.Background(), existing))
+
+	w := loginWithOIDC(t, handler, issuer, map[string]any{"preferred_username": "carol"}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	user, err := dbase.GetUser(context.Background(), "carol")
+	require.NoError(t, err)
+	require.Equal(t, "hash", user.PasswordHash)
+	require.Equal(t, []database.Identity{{Issuer: issuer.URL(), Subject: "subject-1"}}, user.Identities)
+
+	// Another identity at the same provider cannot take over the user.
+	w = loginWithOIDC(t, handler, issuer, map[string]any{"sub": "subject-2", "preferred_username": "carol"}, nil)
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestHandler_authenticateSessionToken(t *testing.T) {
+	user := database.User{Username: "shivansh"}
+	handler := &Handler{dbase: &fakeDatabase{getUser: user}, sessionKey: []byte("key"), sessionTTL: time.Hour}
+
+	token, _ := handler.issueSessionToken(user.Username)
+
+	expiredHandler := &Handler{dbase: handler.dbase, sessionKey: handler.sessionKey, sessionTTL: -time.Hour}
+	expiredToken, _ := expiredHandler.issueSessionToken(user.Username)
+
+	var testCases = []struct {
+		name         string
+		token        string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Valid token, 200 expected",
+			token:        token,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Malformed token, 401 expected",
+			token:        "not-a-token",
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"status":"Unauthorized","reason":""}`,
+		},
+		{
+			name:         "Token of another user, 401 expected",
+			token:        strings.Replace(token, "shivansh", "admin", 1),
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"status":"Unauthorized","reason":""}`,
+		},
+		{
+			name:         "Expired token, 401 expected",
+			token:        expiredToken,
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"status":"Unauthorized","reason":"session expired"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, privacyPath, nil)
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+
+			w := httptest.NewRecorder()
+			handler.getPrivacy(w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedBody != "" {
+				require.Equal(t, tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
+)
+
+const (
+	// privacyPath is the route of the privacy settings API.
+	privacyPath = "/api/user/privacy"
+	// blockedPath is the route of the block list APIs.
+	blockedPath = privacyPath + "/blocked"
+	// contactsPath is the route of the contact list APIs.
+	contactsPath = privacyPath + "/contacts"
+
+	// refusedMessagesReject is the value of the privacy.refusedMessages config that reports refused receivers as
+	// rejected. Any other value drops their messages silently.
+	refusedMessagesReject = "reject"
+
+	// privacyListMaxLength is the max number of users in a block list or a contact list.
+	privacyListMaxLength = 1000
+)
+
+// Outcomes of a receiver of a message, as reported to the sender.
+const (
+	outcomeAccepted = "accepted"
+	outcomeRejected = "rejected"
+)
+
+var (
+	errPrivacyListSelf = errors.New("cannot add yourself to your own list")
+	errPrivacyListFull = fmt.Errorf("a list can have at most %d users", privacyListMaxLength)
+)
+
+// privacyResponse is the representation of the privacy settings of a user in responses.
+type privacyResponse struct {
+	ContactsOnly bool     `json:"contactsOnly"`
+	Contacts     []string `json:"contacts"`
+	Blocked      []string `json:"blocked"`
+}
+
+// newPrivacyResponse converts the privacy settings of the user for responses. Empty lists are never null.
+func newPrivacyResponse(user database.User) privacyResponse {
+	response := privacyResponse{ContactsOnly: user.ContactsOnly, Contacts: user.Contacts, Blocked: user.Blocked}
+	if response.Contacts == nil {
+		response.Contacts = []string{}
+	}
+	if response.Blocked == nil {
+		response.Blocked = []string{}
+	}
+	return response
+}
+
+// receiverOutcome is the outcome of one receiver of a message, as reported to the sender.
+type receiverOutcome struct {
+	Username string `json:"username"`
+	Outcome  string `json:"outcome"`
+}
+
+// getPrivacy is the API handler for the GET /api/user/privacy route.
+func (h *Handler) getPrivacy(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Make sure credentials are correct, and that they allow receiving messages, which the settings are about.
+	caller, err := h.authorize(r, scope.Connect)
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	if err := caller.allowAccountManagement(); err != nil {
+		slog.ErrorContext(ctx, "api key used for account management", "error", err)
+		httputils.WriteError(w, httputils.Forbidden().WithReasonErr(err))
+		return
+	}
+
+	user, err := h.dbase.GetUser(ctx, caller.username)
+	if err != nil {
+		slog.ErrorContext(ctx, "unexpected error while getting privacy settings", "error", err)
+		httputils.WriteError(w, httputils.InternalServerError())
+		return
+	}
+
+	httputils.WriteJson(w, http.StatusOK, nil, newPrivacyResponse(user))
+}
+
+// putPrivacy is the API handler for the PUT /api/user/privacy route.
+//
+// It turns the contacts-only mode on or off. The lists themselves are managed with their own routes.
+func (h *Handler) putPrivacy(w http.ResponseWriter, r *http.Request) {
+	// Anonymous struct variable to decode request body.
+	var body struct {
+		ContactsOnly bool `json:"contactsOnly"`
+	}
+
+	h.updatePrivacy(w, r, func(string) error {
+		return readJsonBody(r, &body)
+	}, func(user *database.User) error {
+		user.ContactsOnly = body.ContactsOnly
+		return nil
+	})
+}
+
+// blockUser is the API handler for the PUT /api/user/privacy/blocked/{username} route.
+func (h *Handler) blockUser(w http.ResponseWriter, r *http.Request) {
+	h.updatePrivacyList(w, r, func(user *database.User) *[]string { return &user.Blocked }, true)
+}
+
+// unblockUser is the API handler for the DELETE /api/user/privacy/blocked/{username} route.
+func (h *Handler) unblockUser(w http.ResponseWriter, r *http.Request) {
+	h.updatePrivacyList(w, r, func(user *database.User) *[]string { return &user.Blocked }, false)
+}
+
+// addContact is the API handler for the PUT /api/user/privacy/contacts/{username} route.
+func (h *Handler) addContact(w http.ResponseWriter, r *http.Request) {
+	h.updatePrivacyList(w, r, func(user *database.User) *[]string { return &user.Contacts }, true)
+}
+
+// removeContact is the API handler for the DELETE /api/user/privacy/contacts/{username} route.
+func (h *Handler) removeContact(w http.ResponseWriter, r *http.Request) {
+	h.updatePrivacyList(w, r, func(user *database.User) *[]string { return &user.Contacts }, false)
+}
+
+// updatePrivacyList adds the user in the path to, or removes them from, the list of the caller selected by the given
+// function. Both operations are idempotent.
+//
+// The user in the path does not have to exist, so that the response does not reveal which usernames are taken.
+func (h *Handler) updatePrivacyList(w http.ResponseWriter, r *http.Request, list func(*database.User) *[]string,
+	add bool,
+) {
+	ctx := r.Context()
+	username := r.PathValue("username")
+
+	h.updatePrivacy(w, r, func(caller string) error {
+		if err := validateUsername(username); err != nil {
+			slog.ErrorContext(ctx, "invalid username in path", "error", err)
+			return httputils.BadRequest().WithReasonErr(err)
+		}
+		if username == caller {
+			slog.ErrorContext(ctx, "user added themselves to their own list")
+			return httputils.BadRequest().WithReasonErr(errPrivacyListSelf)
+		}
+		return nil
+	}, func(user *database.User) error {
+		entries := list(user)
+		if !add {
+			*entries = slices.DeleteFunc(*entries, func(entry string) bool { return entry == username })
+			return nil
+		}
+
+		if slices.Contains(*entries, username) {
+			return nil
+		}
+		if len(*entries) >= privacyListMaxLength {
+			return errPrivacyListFull
+		}
+
+		*entries = append(*entries, username)
+		return nil
+	})
+}
+
+// updatePrivacy is the common implementation of the routes that change the privacy settings of the caller.
+//
+// The prepare function runs after authentication with the caller's username, and before the update. It must return
+// errors that are safe to send in the response. The update function runs inside the database update. The updated
+// settings are sent in the response.
+func (h *Handler) updatePrivacy(w http.ResponseWriter, r *http.Request, prepare func(caller string) error,
+	update func(*database.User) error,
+) {
+	ctx := r.Context()
+
+	// Make sure credentials are correct, and that they allow receiving messages, which the settings are about.
+	caller, err := h.authorize(r, scope.Connect)
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	if err := caller.allowAccountManagement(); err != nil {
+		slog.ErrorContext(ctx, "api key used for account management", "error", err)
+		httputils.WriteError(w, httputils.Forbidden().WithReasonErr(err))
+		return
+	}
+
+	if err := prepare(caller.username); err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	var updated database.User
+	err = h.dbase.UpdateUser(ctx, caller.username, func(user *database.User) error {
+		if err := update(user); err != nil {
+			return err
+		}
+		updated = *user
+		return nil
+	})
+
+	if err != nil {
+		switch {
+		case errors.Is(err, errPrivacyListFull):
+			slog.ErrorContext(ctx, "privacy list is full", "error", err)
+			httputils.WriteError(w, httputils.Conflict().WithReasonErr(err))
+		case errors.Is(err, database.ErrUserNotFound):
+			// The user was authenticated, so it can only be missing if it was deleted in the meantime.
+			slog.ErrorContext(ctx, "user not found while updating privacy settings", "error", err)
+			httputils.WriteError(w, httputils.Unauthorized())
+		default:
+			slog.ErrorContext(ctx, "unexpected error while updating privacy settings", "error", err)
+			httputils.WriteError(w, httputils.InternalServerError())
+		}
+		return
+	}
+
+	httputils.WriteJson(w, http.StatusOK, nil, newPrivacyResponse(updated))
+}
+
+// filterReceivers returns the receivers who accept messages from the sender, along with the outcome of every unique
+// receiver as it must be reported to the sender.
+//
+// Receivers who refuse the sender are reported the same way as receivers that do not exist, so the outcomes never
+// reveal whether the sender is blocked.
+//
+// The caller does not need to log the returned error. Also, the returned error is safe to send in the response.
+func (h *Handler) filterReceivers(ctx context.Context, sender string, receivers []string,
+) ([]string, []receiverOutcome, error) {
+	accepted := make([]string, 0, len(receivers))
+	outcomes := make([]receiverOutcome, 0, len(receivers))
+
+	for _, receiver := range receivers {
+		if slices.ContainsFunc(outcomes, func(o receiverOutcome) bool { return o.Username == receiver }) {
+			continue
+		}
+
+		ok, err := h.acceptsFrom(ctx, receiver, sender)
+		if err != nil {
+			slog.ErrorContext(ctx, "unexpected error while checking receiver privacy", "error", err)
+			return nil, nil, httputils.InternalServerError()
+		}
+
+		outcome := outcomeAccepted
+		if ok {
+			accepted = append(accepted, receiver)
+		} else if h.rejectRefused {
+			outcome = outcomeRejected
+		}
+
+		outcomes = append(outcomes, receiverOutcome{Username: receiver, Outcome: outcome})
+	}
+
+	return accepted, outcomes, nil
+}
+
+// acceptsFrom reports whether the receiver exists and accepts messages from the sender.
+func (h *Handler) acceptsFrom(ctx context.Context, receiver, sender string) (bool, error) {
+	// Users can always message themselves, which is how their other sessions are reached.
+	if receiver == sender {
+		return true, nil
+	}
+
+	user, err := h.dbase.GetUser(ctx, receiver)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error in GetUser call: %w", err)
+	}
+
+	if slices.Contains(user.Blocked, sender) {
+		return false, nil
+	}
+
+	return !user.ContactsOnly || slices.Contains(user.Contacts, sender), nil
+}
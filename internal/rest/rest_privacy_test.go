@@ -0,0 +1,200 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_updatePrivacyList(t *testing.T) {
+	mockPassword := "password123"
+	user, key := newAPIKeyTestUser(t, mockPassword, scope.Default)
+	user.Blocked = []string{"mallory"}
+
+	fullUser := user
+	fullUser.Contacts = make([]string, privacyListMaxLength)
+
+	var testCases = []struct {
+		name         string
+		user         database.User
+		useAPIKey    bool
+		serve        func(h *Handler, w http.ResponseWriter, r *http.Request)
+		username     string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "Authenticated with an api key, 403 expected",
+			user:         user,
+			useAPIKey:    true,
+			serve:        (*Handler).blockUser,
+			username:     "alice",
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"` + errAPIKeyNotAllowed.Error() + `"}`,
+		},
+		{
+			name:         "Invalid username, 400 expected",
+			user:         user,
+			serve:        (*Handler).blockUser,
+			username:     "a.b",
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"` + errUsernamePattern.Error() + `"}`,
+		},
+		{
+			name:         "Caller blocks themselves, 400 expected",
+			user:         user,
+			serve:        (*Handler).blockUser,
+			username:     user.Username,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"` + errPrivacyListSelf.Error() + `"}`,
+		},
+		{
+			name:         "Block a new user, 200 expected",
+			user:         user,
+			serve:        (*Handler).blockUser,
+			username:     "alice",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"contactsOnly":false,"contacts":[],"blocked":["mallory","alice"]}`,
+		},
+		{
+			name:         "Block an already blocked user, 200 expected",
+			user:         user,
+			serve:        (*Handler).blockUser,
+			username:     "mallory",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"contactsOnly":false,"contacts":[],"blocked":["mallory"]}`,
+		},
+		{
+			name:         "Unblock a user, 200 expected",
+			user:         user,
+			serve:        (*Handler).unblockUser,
+			username:     "mallory",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"contactsOnly":false,"contacts":[],"blocked":[]}`,
+		},
+		{
+			name:         "Add a contact, 200 expected",
+			user:         user,
+			serve:        (*Handler).addContact,
+			username:     "alice",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"contactsOnly":false,"contacts":["alice"],"blocked":["mallory"]}`,
+		},
+		{
+			name:         "Contact list is full, 409 expected",
+			user:         fullUser,
+			serve:        (*Handler).addContact,
+			username:     "alice",
+			expectedCode: http.StatusConflict,
+			expectedBody: `{"status":"Conflict","reason":"` + errPrivacyListFull.Error() + `"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, blockedPath+"/"+tc.username, nil)
+			r.SetPathValue("username", tc.username)
+			if tc.useAPIKey {
+				r.Header.Set(headerAPIKey, key)
+			} else {
+				r.SetBasicAuth(tc.user.Username, mockPassword)
+			}
+
+			// The fake database does not clone the lists before updates, unlike real ones.
+			tc.user.Blocked = slices.Clone(tc.user.Blocked)
+
+			handler := &Handler{dbase: &fakeDatabase{getUser: tc.user}}
+			tc.serve(handler, w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.Equal(t, tc.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestHandler_putPrivacy(t *testing.T) {
+	mockPassword := "password123"
+	user, _ := newAPIKeyTestUser(t, mockPassword, scope.Default)
+	dbase := &fakeDatabase{getUser: user}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, privacyPath, strings.NewReader(`{"contactsOnly":true}`))
+	r.SetBasicAuth(user.Username, mockPassword)
+
+	handler := &Handler{dbase: dbase}
+	handler.putPrivacy(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `{"contactsOnly":true,"contacts":[],"blocked":[]}`, w.Body.String())
+	require.True(t, dbase.updatedUser.ContactsOnly)
+}
+
+func TestHandler_filterReceivers(t *testing.T) {
+	dbase, err := database.NewFileDatabase(filepath.Join(t.TempDir(), "users.json"))
+	require.NoError(t, err)
+
+	for _, user := range []database.User{
+		{Username: "alice", Blocked: []string{"shivansh"}},
+		{Username: "bob", ContactsOnly: true, Contacts: []string{"alice"}},
+		{Username: "carol", ContactsOnly: true, Contacts: []string{"shivansh"}},
+		{Username: "dave", Blocked: []string{"alice"}},
+		{Username: "shivansh", ContactsOnly: true},
+	} {
+		require.NoError(t, dbase.InsertUser(context.Background(), user))
+	}
+
+	receivers := []string{"alice", "bob", "carol", "dave", "ghost", "shivansh", "carol"}
+
+	var testCases = []struct {
+		name             string
+		rejectRefused    bool
+		expectedOutcomes []receiverOutcome
+	}{
+		{
+			name:          "Refused receivers are dropped",
+			rejectRefused: false,
+			expectedOutcomes: []receiverOutcome{
+				{Username: "alice", Outcome: outcomeAccepted},
+				{Username: "bob", Outcome: outcomeAccepted},
+				{Username: "carol", Outcome: outcomeAccepted},
+				{Username: "dave", Outcome: outcomeAccepted},
+				{Username: "ghost", Outcome: outcomeAccepted},
+				{Username: "shivansh", Outcome: outcomeAccepted},
+			},
+		},
+		{
+			name:          "Refused receivers are rejected like unknown ones",
+			rejectRefused: true,
+			expectedOutcomes: []receiverOutcome{
+				{Username: "alice", Outcome: outcomeRejected},
+				{Username: "bob", Outcome: outcomeRejected},
+				{Username: "carol", Outcome: outcomeAccepted},
+				{Username: "dave", Outcome: outcomeAccepted},
+				{Username: "ghost", Outcome: outcomeRejected},
+				{Username: "shivansh", Outcome: outcomeAccepted},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := &Handler{dbase: dbase, rejectRefused: tc.rejectRefused}
+
+			accepted, outcomes, err := handler.filterReceivers(context.Background(), "shivansh", receivers)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedOutcomes, outcomes)
+			// Messages are delivered only to the receivers who accept the sender, whatever is reported.
+			require.Equal(t, []string{"carol", "dave", "shivansh"}, accepted)
+		})
+	}
+}
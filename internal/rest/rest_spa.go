@@ -1,26 +1,286 @@
 package rest
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
 	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/config"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
+)
+
+// frontendConfigPath is the route of the runtime config of the SPA.
+const frontendConfigPath = "/config.json"
+
+// spaIndex is the entry point of the SPA, which is served for all paths that are not files.
+const spaIndex = "index.html"
+
+// hashedAssetPattern matches the names of the files that may have a content hash, in the formats of esbuild, like
+// main-MKTYTAS7.js, and webpack, like main.3f2a1b9c8d7e6f50.js. Its group is the hash.
+var hashedAssetPattern = regexp.MustCompile(`[-.]([A-Z2-7]{8}|[a-f0-9]{16,})\.[a-z0-9]+$`)
+
+// precompressedEncodings are the content codings of the precompressed siblings of the SPA files, in order of
+// preference, along with the extensions of the siblings.
+var precompressedEncodings = []struct{ name, extension string }{
+	{name: "br", extension: ".br"},
+	{name: "gzip", extension: ".gz"},
+}
+
+// Login methods of the SPA, as listed in the authModes of its runtime config.
+const (
+	authModeBasic = "basic"
+	authModeOIDC  = "oidc"
 )
 
-// serveFrontend serves the SPA present in the given directory.
-func serveFrontend(frontendDir string) http.Handler {
-	// http.Dir protects against directory traversal ("../../secrets")
-	dir := http.Dir(frontendDir)
-	server := http.FileServer(dir)
+// frontendConfig is the runtime config of the SPA, which it fetches on startup.
+type frontendConfig struct {
+	// APIBaseURL is the base URL of the REST API. If empty, the SPA uses its own origin.
+	APIBaseURL string `json:"apiBaseUrl"`
+	// WebSocketURL is the URL of the websocket API. If empty, the SPA derives it from its own origin.
+	WebSocketURL string `json:"webSocketUrl"`
+	// AuthModes lists the methods that users can log in with.
+	AuthModes []string `json:"authModes"`
+	// Features maps the names of the optional features to whether they are enabled.
+	Features map[string]bool `json:"features"`
+}
+
+// newFrontendConfig returns the runtime config of the SPA as per the server config.
+//
+// The features of the server that are optional are reported as flags, along with the ones that are configured, which
+// take precedence.
+func newFrontendConfig(conf config.Config) frontendConfig {
+	frontend := frontendConfig{
+		APIBaseURL:   conf.Frontend.BackendAddr,
+		WebSocketURL: webSocketURL(conf.Frontend.BackendAddr),
+		AuthModes:    []string{authModeBasic},
+		Features: map[string]bool{
+			"attachments": conf.Attachment.Dir != "",
+			"retained":    conf.Retained.FilePath != "",
+			"webhooks":    conf.Webhook.Enabled,
+		},
+	}
+
+	if conf.OIDC.Issuer != "" {
+		frontend.AuthModes = append(frontend.AuthModes, authModeOIDC)
+	}
+	for name, enabled := range conf.Frontend.Features {
+		frontend.Features[name] = enabled
+	}
+
+	return frontend
+}
+
+// webSocketURL returns the URL of the websocket API at the given http or https base URL, or an empty string if it is
+// empty.
+func webSocketURL(backendAddr string) string {
+	if backendAddr == "" {
+		return ""
+	}
+
+	// The config is validated, so the scheme is http or https.
+	wsAddr := "ws" + strings.TrimPrefix(backendAddr, "http")
+	return strings.TrimSuffix(wsAddr, "/") + connectPath
+}
+
+// getFrontendConfig is the API handler for the GET /config.json route.
+func (h *Handler) getFrontendConfig(w http.ResponseWriter, r *http.Request) {
+	// The config changes with restarts, so it must not be served from cache without a check.
+	httputils.WriteJson(w, http.StatusOK, map[string]string{"Cache-Control": "no-cache"}, h.frontendConfig)
+}
+
+// serveFrontend serves the SPA present in the given file system.
+//
+// Content-hashed files, like main-MKTYTAS7.js, are cached by browsers for good, while others, including index.html,
+// are revalidated with their ETags on every use. If the client accepts it, a precompressed sibling of the file, like
+// main-MKTYTAS7.js.br, is served instead.
+//
+// Paths that are not files fall back to index.html, for client-side routing. Paths that look like files, because they
+// have an extension, get a 404 instead, so that a missing script is not answered with HTML.
+func serveFrontend(files fs.FS) http.Handler {
+	return &frontendServer{files: files}
+}
+
+// frontendServer is the http.Handler returned by serveFrontend.
+type frontendServer struct {
+	files fs.FS
+	// etags caches the ETags of the files by their etagKey, so that a file is hashed only once.
+	etags sync.Map
+}
+
+// etagKey identifies a version of a file. Embedded files have no modification time, but they never change.
+type etagKey struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (f *frontendServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// The path is cleaned, so that it cannot go out of the root ("../../secrets").
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = spaIndex
+	}
+
+	info, err := fs.Stat(f.files, name)
+	if err == nil && info.IsDir() {
+		err = fs.ErrNotExist
+	}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		file, err := dir.Open(r.URL.Path)
+	switch {
+	case err == nil:
+	case !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrInvalid):
+		// Real errors are not hidden behind the fallback.
+		slog.ErrorContext(ctx, "failed to stat frontend file", "name", name, "error", err)
+		httputils.WriteError(w, httputils.InternalServerError().WithReasonStr("failed to read file"))
+		return
+	case path.Ext(name) != "":
+		httputils.WriteError(w, httputils.NotFound().WithReasonStr("file not found"))
+		return
+	default:
+		name = spaIndex
+	}
+
+	if err := f.serveFile(w, r, name); err != nil {
+		slog.ErrorContext(ctx, "failed to serve frontend file", "name", name, "error", err)
+		httputils.WriteError(w, httputils.InternalServerError().WithReasonStr("failed to read file"))
+	}
+}
+
+// serveFile serves the file with the given name, or its precompressed sibling, with the caching headers. It handles
+// conditional and range requests, as per http.ServeContent.
+func (f *frontendServer) serveFile(w http.ResponseWriter, r *http.Request, name string) error {
+	served, contentEncoding, hasSibling := name, "", false
+
+	// Look for a precompressed sibling, in order of preference.
+	for _, encoding := range precompressedEncodings {
+		sibling := name + encoding.extension
+		if info, err := fs.Stat(f.files, sibling); err != nil || info.IsDir() {
+			continue
+		}
+
+		hasSibling = true
+		if acceptsEncoding(r.Header.Get("Accept-Encoding"), encoding.name) {
+			served, contentEncoding = sibling, encoding.name
+			break
+		}
+	}
+
+	file, err := f.files.Open(served)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", served, err)
+	}
+
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", served, err)
+	}
+
+	// The files of embed.FS and os.DirFS can seek, so they are served without being copied into memory.
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(file)
 		if err != nil {
-			// Serve index.html, if file not found, or any error.
-			r.URL.Path = "/index.html"
-			server.ServeHTTP(w, r)
-			return
+			return fmt.Errorf("failed to read %s: %w", served, err)
+		}
+		content = bytes.NewReader(data)
+	}
+
+	etag, err := f.etag(etagKey{name: served, size: info.Size(), modTime: info.ModTime()}, content)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", served, err)
+	}
+
+	// The response depends on the header as soon as there is a sibling, even if it is not served.
+	if hasSibling {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if contentEncoding != "" {
+		w.Header().Set("Content-Encoding", contentEncoding)
+	}
+
+	w.Header().Set("ETag", etag)
+	if isHashedAsset(name) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	// The name of the original file is passed, so that the content type is not that of the compression.
+	http.ServeContent(w, r, name, info.ModTime(), content)
+	return nil
+}
+
+// isHashedAsset reports whether the file with the given name has a content hash. The content of such files never
+// changes, so they can be cached for good.
+//
+// Words in capitals, like in LICENSE-THIRDPARTY.txt, also fit the alphabet of esbuild, so a hash must have a digit.
+// The few hashes without one are only revalidated like other files.
+func isHashedAsset(name string) bool {
+	match := hashedAssetPattern.FindStringSubmatch(name)
+	return match != nil && strings.ContainsAny(match[1], "0123456789")
+}
+
+// etag returns the strong ETag of the given version of a file, with the given content. If the content has to be
+// hashed, it is read fully and then rewound.
+func (f *frontendServer) etag(key etagKey, content io.ReadSeeker) (string, error) {
+	if cached, ok := f.etags.Load(key); ok {
+		return cached.(string), nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", fmt.Errorf("failed to read content: %w", err)
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind content: %w", err)
+	}
+
+	etag := `"` + base64.RawURLEncoding.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	f.etags.Store(key, etag)
+	return etag, nil
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header accepts the given content coding, as per RFC 9110.
+// A coding is not accepted if its quality is zero, or if it is only covered by a "*" of quality zero.
+func acceptsEncoding(header, encoding string) bool {
+	accepted, wildcard := false, false
+	for item := range strings.SplitSeq(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		coding = strings.TrimSpace(coding)
+
+		// The quality is 1 unless it is set.
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
 		}
 
-		// Serve file, if found.
-		_ = file.Close()
-		server.ServeHTTP(w, r)
-	})
+		switch {
+		case strings.EqualFold(coding, encoding):
+			// An explicit entry takes precedence over the wildcard.
+			return quality > 0
+		case coding == "*":
+			accepted, wildcard = quality > 0, true
+		}
+	}
+	return wildcard && accepted
 }
@@ -0,0 +1,348 @@
+package rest
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/shivanshkc/rosenbridge/internal/config"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeFrontend(t *testing.T) {
+	files := fstest.MapFS{
+		"index.html":             {Data: []byte("index")},
+		"main-MKTYTAS7.js":       {Data: []byte("main")},
+		"main-MKTYTAS7.js.br":    {Data: []byte("main-br")},
+		"main-MKTYTAS7.js.gz":    {Data: []byte("main-gz")},
+		"styles-Q2R4S55C.css":    {Data: []byte("styles")},
+		"styles-Q2R4S55C.css.gz": {Data: []byte("styles-gz")},
+		"favicon.svg":            {Data: []byte("svg")},
+		"assets/logo.svg":        {Data: []byte("logo")},
+	}
+
+	const immutable, noCache = "public, max-age=31536000, immutable", "no-cache"
+
+	var testCases = []struct {
+		name           string
+		path           string
+		acceptEncoding string
+
+		expectedCode         int
+		expectedBody         string
+		expectedCacheControl string
+		expectedEncoding     string
+		expectedContentType  string
+	}{
+		{
+			name:                 "Hashed file, immutable expected",
+			path:                 "/main-MKTYTAS7.js",
+			expectedCode:         http.StatusOK,
+			expectedBody:         "main",
+			expectedCacheControl: immutable,
+			expectedContentType:  "text/javascript; charset=utf-8",
+		},
+		{
+			name:                 "File without hash, no-cache expected",
+			path:                 "/favicon.svg",
+			expectedCode:         http.StatusOK,
+			expectedBody:         "svg",
+			expectedCacheControl: noCache,
+			expectedContentType:  "image/svg+xml",
+		},
+		{
+			name:                 "Nested file, served",
+			path:                 "/assets/logo.svg",
+			expectedCode:         http.StatusOK,
+			expectedBody:         "logo",
+			expectedCacheControl: noCache,
+		},
+		{
+			name:                 "Root, index expected",
+			path:                 "/",
+			expectedCode:         http.StatusOK,
+			expectedBody:         "index",
+			expectedCacheControl: noCache,
+			expectedContentType:  "text/html; charset=utf-8",
+		},
+		{
+			name:                 "Index by name, served without redirect",
+			path:                 "/index.html",
+			expectedCode:         http.StatusOK,
+			expectedBody:         "index",
+			expectedCacheControl: noCache,
+		},
+		{
+			name:                 "Client-side route, index expected",
+			path:                 "/chat/alice",
+			expectedCode:         http.StatusOK,
+			expectedBody:         "index",
+			expectedCacheControl: noCache,
+		},
+		{
+			name:                 "Directory, index expected",
+			path:                 "/assets",
+			expectedCode:         http.StatusOK,
+			expectedBody:         "index",
+			expectedCacheControl: noCache,
+		},
+		{
+			name:                 "Directory traversal, cleaned",
+			path:                 "/../../index.html",
+			expectedCode:         http.StatusOK,
+			expectedBody:         "index",
+			expectedCacheControl: noCache,
+		},
+		{
+			name:         "Missing file with an extension, 404 expected",
+			path:         "/main-OLDHASH1.js",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:                 "Brotli accepted, brotli expected",
+			path:                 "/main-MKTYTAS7.js",
+			acceptEncoding:       "gzip, deflate, br",
+			expectedCode:         http.StatusOK,
+			expectedBody:         "main-br",
+			expectedCacheControl: immutable,
+			expectedEncoding:     "br",
+			expectedContentType:  "text/javascript; charset=utf-8",
+		},
+		{
+			name:                 "Brotli refused, gzip expected",
+			path:                 "/main-MKTYTAS7.js",
+			acceptEncoding:       "br;q=0, gzip",
+			expectedCode:         http.StatusOK,
+			expectedBody:         "main-gz",
+			expectedCacheControl: immutable,
+			expectedEncoding:     "gzip",
+		},
+		{
+			name:                 "Only gzip sibling, gzip expected",
+			path:                 "/styles-Q2R4S55C.css",
+			acceptEncoding:       "br, gzip",
+			expectedCode:         http.StatusOK,
+			expectedBody:         "styles-gz",
+			expectedCacheControl: immutable,
+			expectedEncoding:     "gzip",
+			expectedContentType:  "text/css; charset=utf-8",
+		},
+		{
+			name:                 "Nothing accepted, original expected",
+			path:                 "/main-MKTYTAS7.js",
+			acceptEncoding:       "identity",
+			expectedCode:         http.StatusOK,
+			expectedBody:         "main",
+			expectedCacheControl: immutable,
+		},
+	}
+
+	handler := serveFrontend(files)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			r.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			require.Equal(t, tc.expectedBody, w.Body.String())
+			require.Equal(t, tc.expectedCacheControl, w.Header().Get("Cache-Control"))
+			require.Equal(t, tc.expectedEncoding, w.Header().Get("Content-Encoding"))
+			require.NotEmpty(t, w.Header().Get("ETag"))
+			if tc.expectedContentType != "" {
+				require.Equal(t, tc.expectedContentType, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestServeFrontend_ETag(t *testing.T) {
+	files := fstest.MapFS{
+		"main-MKTYTAS7.js":    {Data: []byte("main")},
+		"main-MKTYTAS7.js.br": {Data: []byte("main-br")},
+	}
+	handler := serveFrontend(files)
+
+	get := func(acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/main-MKTYTAS7.js", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		r.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	plain, compressed := get("", ""), get("br", "")
+	require.Equal(t, "Accept-Encoding", plain.Header().Get("Vary"))
+	// Every representation has its own ETag.
+	require.NotEqual(t, plain.Header().Get("ETag"), compressed.Header().Get("ETag"))
+
+	// A cached representation is not sent again.
+	w := get("br", compressed.Header().Get("ETag"))
+	require.Equal(t, http.StatusNotModified, w.Code)
+	require.Empty(t, w.Body.String())
+
+	// The ETag of another representation does not match.
+	require.Equal(t, http.StatusOK, get("br", plain.Header().Get("ETag")).Code)
+}
+
+// failingFS is an fs.FS whose files cannot be accessed.
+type failingFS struct{}
+
+func (failingFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+}
+
+func TestServeFrontend_Error(t *testing.T) {
+	w := httptest.NewRecorder()
+	serveFrontend(failingFS{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/chat/alice", nil))
+
+	// Real errors are not masked by the index.html fallback.
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	var testCases = []struct {
+		header   string
+		encoding string
+		expected bool
+	}{
+		{header: "", encoding: "br", expected: false},
+		{header: "gzip, deflate, br", encoding: "br", expected: true},
+		{header: "gzip, deflate", encoding: "br", expected: false},
+		{header: "BR", encoding: "br", expected: true},
+		{header: "br;q=0.5", encoding: "br", expected: true},
+		{header: "br;q=0", encoding: "br", expected: false},
+		{header: "*", encoding: "br", expected: true},
+		{header: "*;q=0", encoding: "gzip", expected: false},
+		{header: "*;q=0, gzip", encoding: "gzip", expected: true},
+		{header: "gzip;q=0, *", encoding: "gzip", expected: false},
+		{header: "br;q=high", encoding: "br", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.header+"/"+tc.encoding, func(t *testing.T) {
+			require.Equal(t, tc.expected, acceptsEncoding(tc.header, tc.encoding))
+		})
+	}
+}
+
+func TestIsHashedAsset(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		expected bool
+	}{
+		{name: "main-MKTYTAS7.js", expected: true},
+		{name: "main.3f2a1b9c8d7e6f50.js", expected: true},
+		{name: "LICENSE-THIRDPARTY.txt", expected: false},
+		{name: "README-ABCDEFGH.md", expected: false},
+		{name: "styles-q2r4s55c.css", expected: false},
+		{name: "index.html", expected: false},
+		{name: "main.abcdefabcdefabcd.js", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, isHashedAsset(tc.name))
+		})
+	}
+}
+
+func TestFrontendFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("custom"), 0600))
+
+	// The embedded build is served by default.
+	var conf config.Config
+	_, err := fs.Stat(frontendFiles(conf), "index.html")
+	require.NoError(t, err)
+
+	// The configured directory overrides it.
+	conf.Frontend.Path = dir
+	content, err := fs.ReadFile(frontendFiles(conf), "index.html")
+	require.NoError(t, err)
+	require.Equal(t, "custom", string(content))
+}
+
+func TestNewFrontendConfig(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		modify   func(conf *config.Config)
+		expected frontendConfig
+	}{
+		{
+			name:   "No backend address, the origin of the SPA is used",
+			modify: func(conf *config.Config) {},
+			expected: frontendConfig{
+				AuthModes: []string{authModeBasic},
+				Features:  map[string]bool{"attachments": false, "retained": false, "webhooks": false},
+			},
+		},
+		{
+			name: "Secure backend address, wss expected",
+			modify: func(conf *config.Config) {
+				conf.Frontend.BackendAddr = "https://rosenbridge.shivansh.io/"
+				conf.Attachment.Dir = "attachments"
+				conf.OIDC.Issuer = "https://accounts.example.com"
+			},
+			expected: frontendConfig{
+				APIBaseURL:   "https://rosenbridge.shivansh.io/",
+				WebSocketURL: "wss://rosenbridge.shivansh.io/api/connect",
+				AuthModes:    []string{authModeBasic, authModeOIDC},
+				Features:     map[string]bool{"attachments": true, "retained": false, "webhooks": false},
+			},
+		},
+		{
+			name: "Configured features, they take precedence",
+			modify: func(conf *config.Config) {
+				conf.Frontend.BackendAddr = "http://localhost:8080"
+				conf.Webhook.Enabled = true
+				conf.Frontend.Features = map[string]bool{"webhooks": false, "darkMode": true}
+			},
+			expected: frontendConfig{
+				APIBaseURL:   "http://localhost:8080",
+				WebSocketURL: "ws://localhost:8080/api/connect",
+				AuthModes:    []string{authModeBasic},
+				Features:     map[string]bool{"attachments": false, "retained": false, "webhooks": false, "darkMode": true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var conf config.Config
+			tc.modify(&conf)
+			require.Equal(t, tc.expected, newFrontendConfig(conf))
+		})
+	}
+}
+
+func TestHandler_getFrontendConfig(t *testing.T) {
+	// Quotes must be escaped, which a hand-built JSON would not do.
+	var conf config.Config
+	conf.Frontend.BackendAddr = `http://localhost:8080/"quoted"`
+
+	handler := &Handler{frontendConfig: newFrontendConfig(conf)}
+	handler.addRoutes(fstest.MapFS{"index.html": {Data: []byte("index")}})
+
+	w := httptest.NewRecorder()
+	handler.underlying.ServeHTTP(w, httptest.NewRequest(http.MethodGet, frontendConfigPath, nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+
+	var body frontendConfig
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	require.Equal(t, handler.frontendConfig, body)
+}
@@ -0,0 +1,31 @@
+package rest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/client"
+	"github.com/shivanshkc/rosenbridge/internal/blob"
+	"github.com/shivanshkc/rosenbridge/internal/oidc"
+	"github.com/shivanshkc/rosenbridge/internal/webhook"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_addRoutes(t *testing.T) {
+	blobs, err := blob.NewFileStore(t.TempDir(), 10, 100, time.Hour, time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = blobs.Close() })
+
+	// Every optional feature is enabled, so that all routes are registered.
+	handler := &Handler{
+		blobs:         blobs,
+		oidc:          oidc.NewProvider(oidc.Options{Issuer: "https://accounts.example.com"}),
+		metricsAccess: newMetricsAccess(context.Background(), "token", nil),
+		webhooks:      &webhook.Dispatcher{},
+	}
+
+	// The mux panics if any two routes conflict.
+	require.NotPanics(t, func() { handler.addRoutes(client.Web()) })
+}
@@ -0,0 +1,156 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/broker"
+	"github.com/shivanshkc/rosenbridge/internal/idempotency"
+	"github.com/shivanshkc/rosenbridge/internal/retained"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
+)
+
+// publishToTopic is the API handler for the POST /api/topic/{name}/publish route.
+//
+// The message is delivered to all subscribers of the topic, over any protocol, whose users are allowed to subscribe to
+// it.
+// If it is marked as retained, it is also stored as the retained message of the topic, and delivered to every new
+// subscription of the topic.
+func (h *Handler) publishToTopic(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	sender, topicName, err := h.authorizePublish(r)
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	// Anonymous struct variable to decode request body.
+	var body struct {
+		messageBody
+		Retain bool `json:"retain"`
+	}
+
+	// Read request body.
+	if err := readJsonBody(r, &body); err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	// Validate message.
+	if err := validateMessage(body.Message, body.Payload, body.Binary, body.Attachments,
+		h.messageLimits.orDefaults()); err != nil {
+		slog.ErrorContext(ctx, "invalid message", "error", err)
+		httputils.WriteError(w, httputils.BadRequest().WithReasonErr(err))
+		return
+	}
+
+	if body.Retain {
+		if err := validateRetain(h.broker.RetainEnabled(), body.Attachments); err != nil {
+			slog.ErrorContext(ctx, "invalid retained message", "error", err)
+			httputils.WriteError(w, httputils.BadRequest().WithReasonErr(err))
+			return
+		}
+	}
+
+	// Event to be sent over connections.
+	message, err := h.newMessage(r, sender, body.messageBody, attachmentAudience{topic: topicName})
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+	message.Topic = topicName
+
+	// Retries carrying the same Idempotency-Key get the original response, and are not published again.
+	idempotencyKey := r.Header.Get(headerIdempotencyKey)
+	if proceed := h.beginIdempotentRequest(w, r, sender, idempotencyKey, body); !proceed {
+		return
+	}
+
+	if body.Retain {
+		if err := h.broker.Retain(ctx, message); err != nil {
+			h.abortIdempotentRequest(sender, idempotencyKey)
+			if errors.Is(err, retained.ErrLimitReached) {
+				slog.ErrorContext(ctx, "retained message limit reached", "error", err)
+				httputils.WriteError(w, httputils.Forbidden().WithReasonErr(err))
+				return
+			}
+			slog.ErrorContext(ctx, "unexpected error while retaining message", "error", err)
+			httputils.WriteError(w, httputils.InternalServerError())
+			return
+		}
+	}
+
+	response := idempotency.Response{StatusCode: http.StatusAccepted, Body: map[string]string{}}
+	h.completeIdempotentRequest(sender, idempotencyKey, response)
+	httputils.WriteJson(w, response.StatusCode, nil, response.Body)
+
+	// Context for the write operations of all subscribers.
+	// It outlives the request, but keeps its values, like the trace.
+	sendCtx, cancelFunc := context.WithTimeout(context.WithoutCancel(ctx), time.Second*5)
+	defer cancelFunc()
+
+	if err := h.broker.Publish(sendCtx, message); err != nil {
+		slog.ErrorContext(ctx, "failed to publish message", "error", err)
+	}
+}
+
+// deleteRetainedMessage is the API handler for the DELETE /api/topic/{name}/retained route.
+//
+// Only the users allowed to publish to the topic can delete its retained message.
+func (h *Handler) deleteRetainedMessage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	_, topicName, err := h.authorizePublish(r)
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	if err := h.broker.DeleteRetained(ctx, topicName); err != nil {
+		if errors.Is(err, broker.ErrRetainDisabled) {
+			slog.ErrorContext(ctx, "retained messages are disabled")
+			httputils.WriteError(w, httputils.BadRequest().WithReasonErr(err))
+			return
+		}
+		slog.ErrorContext(ctx, "unexpected error while deleting retained message", "error", err)
+		httputils.WriteError(w, httputils.InternalServerError())
+		return
+	}
+
+	httputils.WriteJson(w, http.StatusOK, nil, map[string]string{})
+}
+
+// authorizePublish authenticates the user, and makes sure that the topic in the path is valid and that the user is
+// allowed to publish to it. It returns the username and the topic name.
+//
+// The caller does not need to log the returned error. Also, the returned error is safe to send in the response.
+func (h *Handler) authorizePublish(r *http.Request) (string, string, error) {
+	ctx := r.Context()
+
+	// Make sure credentials are correct.
+	caller, err := h.authorize(r, scope.Send)
+	if err != nil {
+		return "", "", err
+	}
+	username := caller.username
+
+	// Validate topic.
+	topicName := r.PathValue("name")
+	if err := topic.ValidateName(topicName); err != nil {
+		slog.ErrorContext(ctx, "invalid topic name", "error", err)
+		return "", "", httputils.BadRequest().WithReasonErr(err)
+	}
+
+	if !h.broker.CanPublish(username, caller.scopes, topicName) {
+		slog.ErrorContext(ctx, "publish not allowed", "topic", topicName)
+		return "", "", httputils.Forbidden().WithReasonStr("not allowed to publish to this topic")
+	}
+
+	return username, topicName, nil
+}
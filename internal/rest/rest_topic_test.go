@@ -0,0 +1,240 @@
+package rest
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shivanshkc/rosenbridge/internal/broker"
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/retained"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/internal/ws"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+
+	"github.com/coder/websocket"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTopicTestACL returns an ACL that allows everyone to publish and subscribe to "public/#", and nothing else.
+func newTopicTestACL(t *testing.T) *topic.ACL {
+	topics, err := topic.NewACL([]topic.Rule{{
+		Topic:       "public/#",
+		Publishers:  []string{topic.AnyUser},
+		Subscribers: []string{topic.AnyUser},
+	}})
+	require.NoError(t, err)
+	return topics
+}
+
+func TestHandler_publishToTopic(t *testing.T) {
+	mockUsername, mockPassword := "shivansh", "password123"
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(mockPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	validUser := database.User{Username: mockUsername, PasswordHash: string(passwordHash)}
+
+	var testCases = []struct {
+		name         string
+		setBasicAuth bool
+		topicName    string
+		requestBody  string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "No basic auth, 401 expected",
+			setBasicAuth: false,
+			topicName:    "public/news",
+			requestBody:  `{"message":"hello"}`,
+			expectedCode: http.StatusUnauthorized,
+			expectedBody: `{"status":"Unauthorized","reason":"basic auth credentials absent"}`,
+		},
+		{
+			name:         "Topic with wildcard, 400 expected",
+			setBasicAuth: true,
+			topicName:    "public/*",
+			requestBody:  `{"message":"hello"}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"topic must not contain wildcards"}`,
+		},
+		{
+			name:         "Topic not allowed, 403 expected",
+			setBasicAuth: true,
+			topicName:    "private/news",
+			requestBody:  `{"message":"hello"}`,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"not allowed to publish to this topic"}`,
+		},
+		{
+			name:         "Invalid JSON body, 400 expected",
+			setBasicAuth: true,
+			topicName:    "public/news",
+			requestBody:  `{{{`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"failed to read request body"}`,
+		},
+		{
+			name:         "Empty message, 400 expected",
+			setBasicAuth: true,
+			topicName:    "public/news",
+			requestBody:  `{}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"` + errMessageEmpty.Error() + `"}`,
+		},
+		{
+			name:         "Retained message when disabled, 400 expected",
+			setBasicAuth: true,
+			topicName:    "public/news",
+			requestBody:  `{"message":"hello","retain":true}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"` + broker.ErrRetainDisabled.Error() + `"}`,
+		},
+		{
+			name:         "Valid request, 202 expected",
+			setBasicAuth: true,
+			topicName:    "public/news",
+			requestBody:  `{"message":"hello"}`,
+			expectedCode: http.StatusAccepted,
+			expectedBody: `{}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/api/topic/x/publish", strings.NewReader(tc.requestBody))
+			r.SetPathValue("name", tc.topicName)
+			if tc.setBasicAuth {
+				r.SetBasicAuth(mockUsername, mockPassword)
+			}
+
+			handler := &Handler{
+				dbase:     &fakeDatabase{getUser: validUser},
+				wsManager: ws.NewManager(nil),
+				broker:    broker.New(newTopicTestACL(t), nil),
+			}
+			handler.publishToTopic(w, r)
+
+			require.Equal(t, tc.expectedCode, w.Code)
+			require.Equal(t, tc.expectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestHandler_publishToTopic_Delivery(t *testing.T) {
+	mockUsername, mockPassword := "shivansh", "password123"
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(mockPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	bus := broker.New(newTopicTestACL(t), nil)
+	handler := &Handler{
+		dbase:     &fakeDatabase{getUser: database.User{Username: mockUsername, PasswordHash: string(passwordHash)}},
+		wsManager: ws.NewManager(socketHandler{broker: bus}),
+		broker:    bus,
+	}
+	bus.AddDeliverer(wsDeliverer{manager: handler.wsManager})
+
+	server := httptest.NewServer(http.HandlerFunc(handler.getConnection))
+	defer server.Close()
+
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(mockUsername+":"+mockPassword)))
+
+	ctx := context.Background()
+	conn, _, err := websocket.Dial(ctx, "ws"+server.URL[4:], &websocket.DialOptions{HTTPHeader: header})
+	require.NoError(t, err)
+	defer func() { _ = conn.Close(websocket.StatusNormalClosure, "") }()
+
+	// Skip the Hello event.
+	_, _, err = conn.Read(ctx)
+	require.NoError(t, err)
+
+	subscribe := `{"event_type":"Subscribe","event_body":{"topic":"public/*"}}`
+	require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(subscribe)))
+	_, data, err := conn.Read(ctx)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"version":1,"event_type":"Subscribed","event_body":{"topic":"public/*"}}`, string(data))
+
+	publish := func(topicName string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/topic/x/publish", strings.NewReader(`{"message":"hi"}`))
+		r.SetPathValue("name", topicName)
+		r.SetBasicAuth(mockUsername, mockPassword)
+		handler.publishToTopic(w, r)
+		require.Equal(t, http.StatusAccepted, w.Code)
+	}
+
+	// The first one does not match the subscription.
+	publish("public/news/today")
+	publish("public/news")
+
+	_, data, err = conn.Read(ctx)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"version":1,"event_type":"MessageReceived","event_body":`+
+		`{"message":"hi","sender":"shivansh","topic":"public/news"}}`, string(data))
+}
+
+func TestHandler_publishToTopic_Retained(t *testing.T) {
+	mockUsername, mockPassword := "shivansh", "password123"
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(mockPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	store, err := retained.NewFileStore(filepath.Join(t.TempDir(), "retained.json"), 1)
+	require.NoError(t, err)
+
+	handler := &Handler{
+		dbase:     &fakeDatabase{getUser: database.User{Username: mockUsername, PasswordHash: string(passwordHash)}},
+		wsManager: ws.NewManager(nil),
+		broker:    broker.New(newTopicTestACL(t), store),
+	}
+
+	publish := func(topicName, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/topic/x/publish", strings.NewReader(body))
+		r.SetPathValue("name", topicName)
+		r.SetBasicAuth(mockUsername, mockPassword)
+		handler.publishToTopic(w, r)
+		return w
+	}
+
+	// Not retained.
+	require.Equal(t, http.StatusAccepted, publish("public/a", `{"message":"1"}`).Code)
+	// Retained.
+	require.Equal(t, http.StatusAccepted, publish("public/a", `{"message":"2","retain":true}`).Code)
+
+	w := publish("public/a", `{"attachments":["`+strings.Repeat("0", 32)+`"],"retain":true}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, `{"status":"Bad Request","reason":"`+errRetainAttachments.Error()+`"}`, w.Body.String())
+
+	// The store allows only 1 topic.
+	w = publish("public/b", `{"message":"3","retain":true}`)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Equal(t, `{"status":"Forbidden","reason":"`+retained.ErrLimitReached.Error()+`"}`, w.Body.String())
+
+	messages, err := store.Match(context.Background(), "#")
+	require.NoError(t, err)
+	require.Equal(t, []protocol.MessageReceived{{Message: "2", Sender: mockUsername, Topic: "public/a"}}, messages)
+
+	// Delete the retained message.
+	for _, topicName := range []string{"public/a", "public/nonexistent"} {
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodDelete, "/api/topic/x/retained", nil)
+		r.SetPathValue("name", topicName)
+		r.SetBasicAuth(mockUsername, mockPassword)
+		handler.deleteRetainedMessage(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	messages, err = store.Match(context.Background(), "#")
+	require.NoError(t, err)
+	require.Empty(t, messages)
+}
@@ -7,9 +7,8 @@ import (
 	"net/http"
 
 	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
 	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 // createUser is the API handler for the POST /api/user route.
@@ -18,8 +17,9 @@ func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
 
 	// Anonymous struct variable to decode request body.
 	var body struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Username string   `json:"username"`
+		Password string   `json:"password"`
+		Scopes   []string `json:"scopes"`
 	}
 
 	// Read request body.
@@ -35,14 +35,30 @@ func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := validatePassword(body.Password); err != nil {
+	if err := h.passwords.Validate(body.Password); err != nil {
 		slog.ErrorContext(ctx, "invalid password", "error", err)
 		httputils.WriteError(w, httputils.BadRequest().WithReasonErr(err))
 		return
 	}
 
+	// Anyone can create a user, so only the default scopes can be asked for. They can be narrowed down though, like
+	// for receive-only or send-only accounts.
+	if body.Scopes != nil {
+		if err := validateScopes(body.Scopes); err != nil {
+			slog.ErrorContext(ctx, "invalid scopes", "error", err)
+			httputils.WriteError(w, httputils.BadRequest().WithReasonErr(err))
+			return
+		}
+
+		if missing := scope.Default.Missing(body.Scopes); missing != "" {
+			slog.ErrorContext(ctx, "scope not allowed for new users", "scope", missing)
+			httputils.WriteError(w, httputils.Forbidden().WithReasonErr(errScopeNotAllowed(missing)))
+			return
+		}
+	}
+
 	// Hash password.
-	passwordHashBytes, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	passwordHash, err := h.passwords.Hash(body.Password)
 	if err != nil {
 		slog.ErrorContext(ctx, "failed to hash password", "error", err)
 		httputils.WriteError(w, httputils.InternalServerError())
@@ -50,7 +66,7 @@ func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Insert in database.
-	user := database.User{Username: body.Username, PasswordHash: string(passwordHashBytes)}
+	user := database.User{Username: body.Username, PasswordHash: passwordHash, Scopes: body.Scopes}
 	if err := h.dbase.InsertUser(ctx, user); err != nil {
 		if errors.Is(err, database.ErrUserAlreadyExists) {
 			slog.ErrorContext(ctx, "user already exists", "error", err)
@@ -64,3 +80,50 @@ func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
 
 	httputils.WriteJson(w, http.StatusCreated, nil, map[string]string{"username": user.Username})
 }
+
+// setUserScopes is the API handler for the PUT /api/user/{username}/scopes route. It replaces the scopes of any user,
+// so it requires the admin scope.
+func (h *Handler) setUserScopes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Make sure credentials are correct, and that they allow administration.
+	if _, err := h.authorize(r, scope.Admin); err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	// Anonymous struct variable to decode request body.
+	var body struct {
+		Scopes []string `json:"scopes"`
+	}
+
+	if err := readJsonBody(r, &body); err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	if err := validateScopes(body.Scopes); err != nil {
+		slog.ErrorContext(ctx, "invalid scopes", "error", err)
+		httputils.WriteError(w, httputils.BadRequest().WithReasonErr(err))
+		return
+	}
+
+	username := r.PathValue("username")
+	err := h.dbase.UpdateUser(ctx, username, func(user *database.User) error {
+		user.Scopes = body.Scopes
+		return nil
+	})
+
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			slog.ErrorContext(ctx, "user not found while setting scopes", "username", username)
+			httputils.WriteError(w, httputils.NotFound().WithReasonStr("user not found"))
+			return
+		}
+		slog.ErrorContext(ctx, "unexpected error while setting scopes", "error", err)
+		httputils.WriteError(w, httputils.InternalServerError())
+		return
+	}
+
+	httputils.WriteJson(w, http.StatusOK, nil, map[string]any{"username": username, "scopes": body.Scopes})
+}
@@ -5,12 +5,15 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/password"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestHandler_createUser_validations(t *testing.T) {
@@ -40,15 +43,15 @@ func TestHandler_createUser_validations(t *testing.T) {
 		},
 		{
 			name:         "Password too short, error expected",
-			requestBody:  `{"username":"shivansh","password":"` + strings.Repeat("s", passwordMinLength-1) + `"}`,
+			requestBody:  `{"username":"shivansh","password":"` + strings.Repeat("s", password.DefaultMinLength-1) + `"}`,
 			expectedCode: http.StatusBadRequest,
-			expectedBody: `{"status":"Bad Request","reason":"` + errPasswordLength.Error() + `"}`,
+			expectedBody: `{"status":"Bad Request","reason":"password must be at least 8 characters"}`,
 		},
 		{
-			name:         "Password too long, error expected",
-			requestBody:  `{"username":"shivansh","password":"` + strings.Repeat("s", passwordMaxLength+1) + `"}`,
+			name:         "Password too long for bcrypt, error expected",
+			requestBody:  `{"username":"shivansh","password":"` + strings.Repeat("s", 73) + `"}`,
 			expectedCode: http.StatusBadRequest,
-			expectedBody: `{"status":"Bad Request","reason":"` + errPasswordLength.Error() + `"}`,
+			expectedBody: `{"status":"Bad Request","reason":"password must not be longer than 72 bytes"}`,
 		},
 		{
 			name:         "Username pattern mismatch, error expected",
@@ -56,6 +59,18 @@ func TestHandler_createUser_validations(t *testing.T) {
 			expectedCode: http.StatusBadRequest,
 			expectedBody: `{"status":"Bad Request","reason":"` + errUsernamePattern.Error() + `"}`,
 		},
+		{
+			name:         "Unknown scope, error expected",
+			requestBody:  `{"username":"shivansh","password":"password123","scopes":["everything"]}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"Bad Request","reason":"unknown scope: \"everything\""}`,
+		},
+		{
+			name:         "Admin scope, error expected",
+			requestBody:  `{"username":"shivansh","password":"password123","scopes":["connect","admin"]}`,
+			expectedCode: http.StatusForbidden,
+			expectedBody: `{"status":"Forbidden","reason":"` + errScopeNotAllowed("admin").Error() + `"}`,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -119,11 +134,55 @@ func TestHandler_createUser(t *testing.T) {
 	}
 }
 
+func TestHandler_Authenticate_Rehash(t *testing.T) {
+	dbase, err := database.NewFileDatabase(filepath.Join(t.TempDir(), "users.json"))
+	require.NoError(t, err)
+
+	// The user's hash is made with bcrypt, which is no longer the configured algorithm.
+	oldHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, dbase.InsertUser(context.Background(),
+		database.User{Username: "shivansh", PasswordHash: string(oldHash)}))
+
+	passwords, err := password.New(password.Options{
+		Algorithm: password.Argon2id,
+		Argon2:    password.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1},
+	})
+	require.NoError(t, err)
+	handler := &Handler{dbase: dbase, passwords: passwords}
+
+	// A failed login does not rehash.
+	err = handler.Authenticate(context.Background(), "shivansh", "wrong-password")
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	user, err := dbase.GetUser(context.Background(), "shivansh")
+	require.NoError(t, err)
+	require.Equal(t, string(oldHash), user.PasswordHash)
+
+	// A successful one does.
+	require.NoError(t, handler.Authenticate(context.Background(), "shivansh", "password123"))
+
+	user, err = dbase.GetUser(context.Background(), "shivansh")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(user.PasswordHash, "$argon2id$"), user.PasswordHash)
+
+	// The new hash works, and is not replaced again.
+	newHash := user.PasswordHash
+	require.NoError(t, handler.Authenticate(context.Background(), "shivansh", "password123"))
+
+	user, err = dbase.GetUser(context.Background(), "shivansh")
+	require.NoError(t, err)
+	require.Equal(t, newHash, user.PasswordHash)
+}
+
 // fakeDatabase is a mock implementation of database.Database.
 type fakeDatabase struct {
 	errInsertUser error
 	getUser       database.User
 	errGetUser    error
+	updatedUser   database.User
+	errUpdateUser error
+	errPing       error
 }
 
 func (f *fakeDatabase) InsertUser(context.Context, database.User) error {
@@ -133,3 +192,25 @@ func (f *fakeDatabase) InsertUser(context.Context, database.User) error {
 func (f *fakeDatabase) GetUser(_ context.Context, _ string) (database.User, error) {
 	return f.getUser, f.errGetUser
 }
+
+func (f *fakeDatabase) GetUserByIdentity(_ context.Context, _ database.Identity) (database.User, error) {
+	return f.getUser, f.errGetUser
+}
+
+func (f *fakeDatabase) UpdateUser(_ context.Context, _ string, update func(user *database.User) error) error {
+	if f.errUpdateUser != nil {
+		return f.errUpdateUser
+	}
+
+	user := f.getUser
+	if err := update(&user); err != nil {
+		return err
+	}
+
+	f.updatedUser = user
+	return nil
+}
+
+func (f *fakeDatabase) Ping(context.Context) error {
+	return f.errPing
+}
@@ -0,0 +1,170 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/tracing"
+	"github.com/shivanshkc/rosenbridge/internal/webhook"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
+
+	"github.com/google/uuid"
+)
+
+// webhookPath is the route of the webhook APIs.
+const webhookPath = "/api/user/webhook"
+
+// putWebhook is the API handler for the PUT /api/user/webhook route.
+//
+// It registers the webhook of the user, replacing the existing one if any. A new signing secret is generated every
+// time, and it is only revealed in this response.
+func (h *Handler) putWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Make sure credentials are correct.
+	caller, err := h.authorize(r, scope.Connect)
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	if err := caller.allowAccountManagement(); err != nil {
+		slog.ErrorContext(ctx, "api key used for account management", "error", err)
+		httputils.WriteError(w, httputils.Forbidden().WithReasonErr(err))
+		return
+	}
+
+	// Anonymous struct variable to decode request body.
+	var body struct {
+		URL         string `json:"url"`
+		AllMessages bool   `json:"allMessages"`
+	}
+
+	if err := readJsonBody(r, &body); err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	if err := validateWebhookURL(body.URL); err != nil {
+		slog.ErrorContext(ctx, "invalid webhook url", "error", err)
+		httputils.WriteError(w, httputils.BadRequest().WithReasonErr(err))
+		return
+	}
+
+	hook := &database.Webhook{URL: body.URL, Secret: randomHex(32), AllMessages: body.AllMessages}
+	if err := h.setWebhook(ctx, caller.username, hook); err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	httputils.WriteJson(w, http.StatusOK, nil, hook)
+}
+
+// deleteWebhook is the API handler for the DELETE /api/user/webhook route.
+func (h *Handler) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Make sure credentials are correct.
+	caller, err := h.authorize(r, scope.Connect)
+	if err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	if err := caller.allowAccountManagement(); err != nil {
+		slog.ErrorContext(ctx, "api key used for account management", "error", err)
+		httputils.WriteError(w, httputils.Forbidden().WithReasonErr(err))
+		return
+	}
+
+	if err := h.setWebhook(ctx, caller.username, nil); err != nil {
+		httputils.WriteError(w, err)
+		return
+	}
+
+	httputils.WriteJson(w, http.StatusOK, nil, map[string]string{})
+}
+
+// setWebhook stores the webhook of the user. A nil webhook removes it.
+//
+// The caller does not need to log the returned error. Also, the returned error is safe to send in the response.
+func (h *Handler) setWebhook(ctx context.Context, username string, hook *database.Webhook) error {
+	err := h.dbase.UpdateUser(ctx, username, func(user *database.User) error {
+		user.Webhook = hook
+		return nil
+	})
+
+	if err != nil {
+		// The user was authenticated, so it can only be missing if it was deleted in the meantime.
+		if errors.Is(err, database.ErrUserNotFound) {
+			slog.ErrorContext(ctx, "user not found while setting webhook", "error", err)
+			return httputils.Unauthorized()
+		}
+		slog.ErrorContext(ctx, "unexpected error while setting webhook", "error", err)
+		return httputils.InternalServerError()
+	}
+
+	return nil
+}
+
+// enqueueWebhooks schedules the webhook deliveries of the message for the receivers that have a webhook. Unless their
+// webhook asks for all messages, only the receivers without a connection get a delivery.
+//
+// Webhooks are best-effort, so errors are only logged.
+func (h *Handler) enqueueWebhooks(ctx context.Context, message protocol.MessageReceived, receivers []string) {
+	// Duplicate receivers get a single delivery.
+	seen := make(map[string]struct{}, len(receivers))
+
+	for _, receiver := range receivers {
+		if _, exists := seen[receiver]; exists {
+			continue
+		}
+		seen[receiver] = struct{}{}
+
+		user, err := h.dbase.GetUser(ctx, receiver)
+		if err != nil {
+			if !errors.Is(err, database.ErrUserNotFound) {
+				slog.ErrorContext(ctx, "failed to get receiver for webhook", "receiver", receiver, "error", err)
+			}
+			continue
+		}
+
+		if user.Webhook == nil {
+			continue
+		}
+
+		offline := !h.isConnected(receiver)
+		if !offline && !user.Webhook.AllMessages {
+			continue
+		}
+
+		delivery := webhook.Delivery{
+			ID:      uuid.NewString(),
+			URL:     user.Webhook.URL,
+			Secret:  user.Webhook.Secret,
+			Payload: webhook.Payload{Receiver: receiver, Offline: offline, Message: message},
+			Trace:   tracing.SpanContextFrom(ctx),
+		}
+
+		if err := h.webhooks.Enqueue(delivery); err != nil {
+			slog.ErrorContext(ctx, "failed to enqueue webhook delivery", "receiver", receiver, "error", err)
+		}
+	}
+}
+
+// isConnected reports whether the user has a connection that receives direct messages, over any protocol.
+func (h *Handler) isConnected(username string) bool {
+	if h.wsManager.IsConnected(username) {
+		return true
+	}
+	if h.stomp != nil && h.stomp.IsConnected(username) {
+		return true
+	}
+	mqttServer := h.mqtt.Load()
+	return mqttServer != nil && mqttServer.IsConnected(username)
+}
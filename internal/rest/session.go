@@ -0,0 +1,79 @@
+package rest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
+)
+
+const (
+	// sessionTokenPrefix makes session tokens easy to tell apart from API keys.
+	sessionTokenPrefix = "rbs_"
+
+	// defaultSessionTTL is the validity duration of session tokens if none is configured.
+	defaultSessionTTL = 12 * time.Hour
+)
+
+// issueSessionToken returns a session token of the user, and the time when it expires.
+//
+// Session tokens are issued by logins that do not involve a password, like OpenID Connect logins. They are stateless:
+// they carry the username and the expiry time, signed with the session key, so they cannot be revoked before they
+// expire, except by changing the key.
+func (h *Handler) issueSessionToken(username string) (string, time.Time) {
+	expiresAt := time.Now().Add(h.sessionTTL).Truncate(time.Second).UTC()
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	return sessionTokenPrefix + username + "." + expires + "." + h.sessionSignature(username, expires), expiresAt
+}
+
+// authenticateSessionToken verifies the session token and returns its caller.
+//
+// The caller does not need to log the returned error. Also, the returned error is safe to send in the response.
+func (h *Handler) authenticateSessionToken(ctx context.Context, token string) (caller, error) {
+	token, ok := strings.CutPrefix(token, sessionTokenPrefix)
+	parts := strings.Split(token, ".")
+	if !ok || len(parts) != 3 {
+		slog.ErrorContext(ctx, "malformed session token")
+		return caller{}, httputils.Unauthorized()
+	}
+
+	username, expires, signature := parts[0], parts[1], parts[2]
+	if !hmac.Equal([]byte(signature), []byte(h.sessionSignature(username, expires))) {
+		slog.ErrorContext(ctx, "session token signature does not match", "username", username)
+		return caller{}, httputils.Unauthorized()
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().After(time.Unix(unix, 0)) {
+		slog.ErrorContext(ctx, "session token is expired", "username", username)
+		return caller{}, httputils.Unauthorized().WithReasonStr("session expired")
+	}
+
+	// The user may have been deleted, or their scopes changed, since the token was issued.
+	user, err := h.dbase.GetUser(ctx, username)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			slog.ErrorContext(ctx, "session token of a user that does not exist", "username", username)
+			return caller{}, httputils.Unauthorized()
+		}
+		slog.ErrorContext(ctx, "unexpected error while authenticating session token", "error", err)
+		return caller{}, httputils.InternalServerError()
+	}
+
+	return caller{username: username, scopes: userScopes(user)}, nil
+}
+
+// sessionSignature returns the hex encoded HMAC-SHA256 of the username and the expiry time, keyed with the session key.
+func (h *Handler) sessionSignature(username, expires string) string {
+	mac := hmac.New(sha256.New, h.sessionKey)
+	mac.Write([]byte("session\n" + username + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,124 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/broker"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/internal/version"
+	"github.com/shivanshkc/rosenbridge/internal/ws"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+)
+
+// socketHandler implements ws.Handler using the event protocol defined by the protocol package.
+type socketHandler struct {
+	// broker decides who can subscribe to which topics, and provides the retained messages.
+	broker *broker.Broker
+	// reconnectDelayMax is the upper bound of the reconnect delay suggested to clients at shutdown.
+	reconnectDelayMax time.Duration
+}
+
+// OnConnect greets the client with the Hello event.
+func (socketHandler) OnConnect(_ context.Context, client *ws.Client) any {
+	return protocol.NewEvent(protocol.EventTypeHello, protocol.Hello{
+		ServerVersion:   version.Version,
+		ProtocolVersion: protocol.Version,
+		Capabilities:    []string{protocol.CapabilityPing, protocol.CapabilityMessagePack, protocol.CapabilityTopics},
+		Username:        client.Username,
+	})
+}
+
+// OnMessage processes the events sent by the client.
+func (s socketHandler) OnMessage(ctx context.Context, client *ws.Client, message []byte) []any {
+	event, err := protocol.DecodeClientEvent(message, client.Codec.Unmarshal)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to parse client event", "username", client.Username, "error", err)
+		return []any{errorEvent(protocol.ErrorCode(err), err.Error())}
+	}
+
+	switch body := event.EventBody.(type) {
+	case *protocol.Ping:
+		return []any{protocol.NewEvent(protocol.EventTypePong, protocol.Pong{ID: body.ID})}
+	case *protocol.Subscribe:
+		return s.subscribe(ctx, client, body.Topic)
+	case *protocol.Unsubscribe:
+		// Unsubscribing from a filter that was never subscribed to is not an error.
+		client.Unsubscribe(body.Topic)
+		return []any{protocol.NewEvent(protocol.EventTypeUnsubscribed, protocol.Unsubscribed{Topic: body.Topic})}
+	default:
+		// DecodeClientEvent only returns known events, so this is a programming error.
+		slog.ErrorContext(ctx, "unhandled client event", "username", client.Username, "eventType", event.EventType)
+		return []any{errorEvent(protocol.ErrorCodeInternal, "unhandled event type")}
+	}
+}
+
+// OnShutdown tells the client that the server is shutting down, with a random reconnect delay, so that the clients of
+// a restarting server do not all reconnect at the same moment.
+func (s socketHandler) OnShutdown(_ context.Context, _ *ws.Client) any {
+	var delay time.Duration
+	if s.reconnectDelayMax > 0 {
+		delay = rand.N(s.reconnectDelayMax)
+	}
+
+	return protocol.NewEvent(protocol.EventTypeServerShuttingDown, protocol.ServerShuttingDown{
+		ReconnectAfterMs: delay.Milliseconds(),
+	})
+}
+
+// subscribe subscribes the client to the given topic filter, if allowed, and returns the reply events.
+// The Subscribed event is followed by the retained messages of all matching topics.
+func (s socketHandler) subscribe(ctx context.Context, client *ws.Client, filter string) []any {
+	if err := topic.ValidateFilter(filter); err != nil {
+		slog.ErrorContext(ctx, "invalid topic filter", "username", client.Username, "error", err)
+		return []any{errorEvent(protocol.ErrorCodeInvalidTopic, err.Error())}
+	}
+
+	if !s.broker.CanSubscribe(client.Username, client.Scopes, filter) {
+		slog.ErrorContext(ctx, "subscription not allowed", "username", client.Username, "topic", filter)
+		return []any{errorEvent(protocol.ErrorCodeForbidden, "not allowed to subscribe to this topic")}
+	}
+
+	if err := client.Subscribe(filter); err != nil {
+		slog.ErrorContext(ctx, "failed to subscribe", "username", client.Username, "error", err)
+		if errors.Is(err, ws.ErrTooManySubscriptions) {
+			return []any{errorEvent(protocol.ErrorCodeLimitExceeded, err.Error())}
+		}
+		return []any{errorEvent(protocol.ErrorCodeInternal, "failed to subscribe")}
+	}
+
+	replies := []any{protocol.NewEvent(protocol.EventTypeSubscribed, protocol.Subscribed{Topic: filter})}
+
+	// The subscription remains active even if retained messages cannot be delivered.
+	messages, err := s.broker.Retained(ctx, client.Username, client.Scopes, filter)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get retained messages", "username", client.Username, "error", err)
+		return replies
+	}
+
+	for _, message := range messages {
+		replies = append(replies, protocol.NewEvent(protocol.EventTypeMessageReceived, message))
+	}
+
+	return replies
+}
+
+// errorEvent returns an Error event with the given code and reason.
+func errorEvent(code, reason string) protocol.Event {
+	return protocol.NewEvent(protocol.EventTypeError, protocol.Error{Code: code, Reason: reason})
+}
+
+// wsDeliverer implements broker.Deliverer for websocket connections.
+type wsDeliverer struct {
+	manager *ws.Manager
+}
+
+func (w wsDeliverer) Deliver(ctx context.Context, message protocol.MessageReceived, allow broker.Allow) error {
+	event := protocol.NewEvent(protocol.EventTypeMessageReceived, message)
+	return w.manager.Publish(ctx, event, message.Topic, func(client *ws.Client) bool {
+		return allow(client.Username, client.Scopes)
+	})
+}
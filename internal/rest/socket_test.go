@@ -0,0 +1,181 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/broker"
+	"github.com/shivanshkc/rosenbridge/internal/retained"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/internal/version"
+	"github.com/shivanshkc/rosenbridge/internal/ws"
+	"github.com/shivanshkc/rosenbridge/pkg/codec"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSocketHandler_OnConnect(t *testing.T) {
+	greeting := socketHandler{}.OnConnect(context.Background(), &ws.Client{Username: "alice", Codec: codec.JSON})
+
+	greetingBytes, err := json.Marshal(greeting)
+	require.NoError(t, err)
+
+	expected := `{"version":1,"event_type":"Hello","event_body":{"server_version":"` + version.Version +
+		`","protocol_version":1,"capabilities":["ping","msgpack","topics"],"username":"alice"}}`
+	require.JSONEq(t, expected, string(greetingBytes))
+}
+
+func TestSocketHandler_OnShutdown(t *testing.T) {
+	handler := socketHandler{reconnectDelayMax: 5 * time.Second}
+	client := &ws.Client{Username: "alice", Codec: codec.JSON}
+
+	// The delays are random, but never beyond the max.
+	delays := map[int64]struct{}{}
+	for range 20 {
+		event, ok := handler.OnShutdown(context.Background(), client).(protocol.Event)
+		require.True(t, ok)
+		require.Equal(t, protocol.EventTypeServerShuttingDown, event.EventType)
+
+		body, ok := event.EventBody.(protocol.ServerShuttingDown)
+		require.True(t, ok)
+		require.GreaterOrEqual(t, body.ReconnectAfterMs, int64(0))
+		require.Less(t, body.ReconnectAfterMs, int64(5000))
+		delays[body.ReconnectAfterMs] = struct{}{}
+	}
+	require.Greater(t, len(delays), 1)
+
+	// Without a max, clients can reconnect right away.
+	event := socketHandler{}.OnShutdown(context.Background(), client)
+	eventBytes, err := json.Marshal(event)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"version":1,"event_type":"ServerShuttingDown","event_body":{"reconnect_after_ms":0}}`,
+		string(eventBytes))
+}
+
+func TestSocketHandler_OnMessage(t *testing.T) {
+	topics, err := topic.NewACL([]topic.Rule{{Topic: "public/#", Subscribers: []string{topic.AnyUser}}})
+	require.NoError(t, err)
+
+	handler := socketHandler{broker: broker.New(topics, nil)}
+
+	var testCases = []struct {
+		name          string
+		input         string
+		expectedReply string
+	}{
+		{
+			name:          "Ping, pong expected",
+			input:         `{"event_type":"Ping","event_body":{"id":"1"}}`,
+			expectedReply: `{"version":1,"event_type":"Pong","event_body":{"id":"1"}}`,
+		},
+		{
+			name:  "Unknown event type, error expected",
+			input: `{"event_type":"Dance"}`,
+			expectedReply: `{"version":1,"event_type":"Error","event_body":{"code":"` +
+				protocol.ErrorCodeUnknownEventType + `","reason":"unknown event type: \"Dance\""}}`,
+		},
+		{
+			name:          "Subscribe, subscribed expected",
+			input:         `{"event_type":"Subscribe","event_body":{"topic":"public/*"}}`,
+			expectedReply: `{"version":1,"event_type":"Subscribed","event_body":{"topic":"public/*"}}`,
+		},
+		{
+			name:  "Subscribe to invalid filter, error expected",
+			input: `{"event_type":"Subscribe","event_body":{"topic":"public/#/x"}}`,
+			expectedReply: `{"version":1,"event_type":"Error","event_body":{"code":"` +
+				protocol.ErrorCodeInvalidTopic + `","reason":"the # wildcard must be the last topic level"}}`,
+		},
+		{
+			name:  "Subscribe to forbidden filter, error expected",
+			input: `{"event_type":"Subscribe","event_body":{"topic":"#"}}`,
+			expectedReply: `{"version":1,"event_type":"Error","event_body":{"code":"` +
+				protocol.ErrorCodeForbidden + `","reason":"not allowed to subscribe to this topic"}}`,
+		},
+		{
+			name:          "Unsubscribe, unsubscribed expected",
+			input:         `{"event_type":"Unsubscribe","event_body":{"topic":"public/*"}}`,
+			expectedReply: `{"version":1,"event_type":"Unsubscribed","event_body":{"topic":"public/*"}}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		for _, c := range codec.Supported {
+			t.Run(tc.name+" - "+c.Subprotocol(), func(t *testing.T) {
+				// Convert the JSON input to the codec under test.
+				var generic any
+				require.NoError(t, json.Unmarshal([]byte(tc.input), &generic))
+				input, err := c.Marshal(generic)
+				require.NoError(t, err)
+
+				client := &ws.Client{Username: "alice", Codec: c}
+				replies := handler.OnMessage(context.Background(), client, input)
+				require.Len(t, replies, 1)
+
+				replyBytes, err := json.Marshal(replies[0])
+				require.NoError(t, err)
+				require.JSONEq(t, tc.expectedReply, string(replyBytes))
+			})
+		}
+	}
+}
+
+func TestSocketHandler_OnMessage_Subscriptions(t *testing.T) {
+	topics, err := topic.NewACL([]topic.Rule{{Topic: "#", Subscribers: []string{topic.AnyUser}}})
+	require.NoError(t, err)
+
+	handler := socketHandler{broker: broker.New(topics, nil)}
+	client := &ws.Client{Username: "alice", Codec: codec.JSON}
+
+	handler.OnMessage(context.Background(), client, []byte(`{"event_type":"Subscribe","event_body":{"topic":"a/#"}}`))
+	require.True(t, client.IsSubscribed("a/b"))
+
+	handler.OnMessage(context.Background(), client, []byte(`{"event_type":"Unsubscribe","event_body":{"topic":"a/#"}}`))
+	require.False(t, client.IsSubscribed("a/b"))
+
+	// The scopes that the client connected with are checked, not only those of the user.
+	handler.broker.SetAuthorizer(func(_ string, scopes scope.Set, required string) bool { return scopes.Has(required) })
+	client = &ws.Client{Username: "alice", Scopes: scope.Set{scope.Send}, Codec: codec.JSON}
+
+	handler.OnMessage(context.Background(), client, []byte(`{"event_type":"Subscribe","event_body":{"topic":"a/#"}}`))
+	require.False(t, client.IsSubscribed("a/b"))
+}
+
+func TestSocketHandler_OnMessage_RetainedMessages(t *testing.T) {
+	ctx := context.Background()
+
+	topics, err := topic.NewACL([]topic.Rule{
+		{Topic: "status/secret", Subscribers: []string{"bob"}},
+		{Topic: "status/#", Subscribers: []string{topic.AnyUser}},
+	})
+	require.NoError(t, err)
+
+	store, err := retained.NewFileStore(filepath.Join(t.TempDir(), "retained.json"), 0)
+	require.NoError(t, err)
+
+	for _, name := range []string{"status/a", "status/b", "status/secret", "other"} {
+		require.NoError(t, store.Set(ctx, protocol.MessageReceived{Message: "up", Sender: "bob", Topic: name}))
+	}
+
+	handler := socketHandler{broker: broker.New(topics, store)}
+	client := &ws.Client{Username: "alice", Codec: codec.JSON}
+
+	replies := handler.OnMessage(ctx, client, []byte(`{"event_type":"Subscribe","event_body":{"topic":"status/#"}}`))
+
+	repliesBytes, err := json.Marshal(replies)
+	require.NoError(t, err)
+
+	// The secret status is not delivered to Alice, as a more specific rule denies it.
+	expected := `[` +
+		`{"version":1,"event_type":"Subscribed","event_body":{"topic":"status/#"}},` +
+		`{"version":1,"event_type":"MessageReceived","event_body":` +
+		`{"message":"up","sender":"bob","topic":"status/a","retained":true}},` +
+		`{"version":1,"event_type":"MessageReceived","event_body":` +
+		`{"message":"up","sender":"bob","topic":"status/b","retained":true}}` +
+		`]`
+	require.JSONEq(t, expected, string(repliesBytes))
+}
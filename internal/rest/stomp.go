@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+)
+
+// stompHandler implements stomp.Handler using the same authentication and validations as the REST API.
+type stompHandler struct {
+	handler *Handler
+}
+
+// Authenticate verifies the credentials, and returns the scopes of the caller. STOMP connections are made to the
+// connect endpoint, so like the websocket connections, they require the connect scope.
+func (s stompHandler) Authenticate(ctx context.Context, username, password string) (scope.Set, bool, error) {
+	caller, err := s.handler.authenticatePassword(ctx, username, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := caller.require(scope.Connect); err != nil {
+		slog.ErrorContext(ctx, "stomp user lacks the connect scope", "username", username, "error", err)
+		return nil, false, nil
+	}
+
+	return caller.scopes, true, nil
+}
+
+// SendMessage validates the message like the POST /api/message route, and sends it to the receivers.
+//
+// The caller does not need to log the returned error. Also, the returned error is safe to send to the client.
+func (s stompHandler) SendMessage(ctx context.Context, message protocol.MessageReceived, scopes scope.Set,
+	receivers []string,
+) error {
+	return s.handler.relayMessage(ctx, message, scopes, receivers)
+}
+
+// Publish validates the message like the POST /api/topic/{name}/publish route, and publishes it to its topic.
+//
+// The caller does not need to log the returned error. Also, the returned error is safe to send to the client.
+func (s stompHandler) Publish(ctx context.Context, message protocol.MessageReceived, scopes scope.Set) error {
+	if err := topic.ValidateName(message.Topic); err != nil {
+		slog.ErrorContext(ctx, "invalid topic name", "error", err)
+		return err
+	}
+
+	if !s.handler.broker.CanPublish(message.Sender, scopes, message.Topic) {
+		slog.ErrorContext(ctx, "publish not allowed", "topic", message.Topic)
+		return errors.New("not allowed to publish to this topic")
+	}
+
+	if err := validateMessage(message.Message, message.Payload, message.Binary, nil,
+		s.handler.messageLimits.orDefaults()); err != nil {
+		slog.ErrorContext(ctx, "invalid message", "error", err)
+		return err
+	}
+
+	// Delivery failures are not the publisher's fault, so they are only logged.
+	if err := s.handler.broker.Publish(ctx, message); err != nil {
+		slog.ErrorContext(ctx, "failed to publish message", "error", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/shivanshkc/rosenbridge/internal/broker"
+	"github.com/shivanshkc/rosenbridge/internal/database"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/ws"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestStompHandler_Authenticate(t *testing.T) {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	validUser := database.User{Username: "shivansh", PasswordHash: string(passwordHash)}
+
+	handler := stompHandler{handler: &Handler{dbase: &fakeDatabase{getUser: validUser}}}
+	scopes, ok, err := handler.Authenticate(context.Background(), "shivansh", "password123")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, scope.Default, scopes)
+
+	_, ok, err = handler.Authenticate(context.Background(), "shivansh", "wrong")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// STOMP connections receive messages, so they require the connect scope.
+	sendOnlyUser := database.User{Username: "shivansh", PasswordHash: string(passwordHash), Scopes: []string{scope.Send}}
+	handler = stompHandler{handler: &Handler{dbase: &fakeDatabase{getUser: sendOnlyUser}}}
+	_, ok, err = handler.Authenticate(context.Background(), "shivansh", "password123")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	handler = stompHandler{handler: &Handler{dbase: &fakeDatabase{errGetUser: errors.New("db connection failed")}}}
+	_, ok, err = handler.Authenticate(context.Background(), "shivansh", "password123")
+	require.Error(t, err)
+	require.False(t, ok)
+}
+
+func TestStompHandler_SendMessage(t *testing.T) {
+	dbase := &fakeDatabase{getUser: database.User{Username: "alice"}}
+	handler := stompHandler{handler: &Handler{dbase: dbase, wsManager: ws.NewManager(nil)}}
+
+	var testCases = []struct {
+		name          string
+		message       protocol.MessageReceived
+		receivers     []string
+		expectedError error
+	}{
+		{
+			name:      "Valid message",
+			message:   protocol.MessageReceived{Sender: "alice", Message: "hello"},
+			receivers: []string{"bob"},
+		},
+		{
+			name:          "Empty message, error expected",
+			message:       protocol.MessageReceived{Sender: "alice"},
+			receivers:     []string{"bob"},
+			expectedError: errMessageEmpty,
+		},
+		{
+			name:          "Payload not an object, error expected",
+			message:       protocol.MessageReceived{Sender: "alice", Payload: json.RawMessage(`[1]`)},
+			receivers:     []string{"bob"},
+			expectedError: errPayloadNotObject,
+		},
+		{
+			name:          "No receivers, error expected",
+			message:       protocol.MessageReceived{Sender: "alice", Message: "hello"},
+			expectedError: errReceiversEmpty,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := handler.SendMessage(context.Background(), tc.message, scope.Default, tc.receivers)
+			require.ErrorIs(t, err, tc.expectedError)
+		})
+	}
+
+	// The scopes of the sender apply as well.
+	dbase.getUser.Scopes = []string{scope.SendTo("bob")}
+	message := protocol.MessageReceived{Sender: "alice", Message: "hello"}
+	require.NoError(t, handler.SendMessage(context.Background(), message, scope.Default, []string{"bob"}))
+	require.EqualError(t, handler.SendMessage(context.Background(), message, scope.Default, []string{"bob", "carol"}),
+		"missing scope: send:carol")
+
+	// And so do the scopes that the sender authenticated with.
+	dbase.getUser.Scopes = nil
+	restricted := scope.Set{scope.SendTo("bob")}
+	require.NoError(t, handler.SendMessage(context.Background(), message, restricted, []string{"bob"}))
+	require.EqualError(t, handler.SendMessage(context.Background(), message, restricted, []string{"bob", "carol"}),
+		"missing scope: send:carol")
+}
+
+func TestStompHandler_Publish(t *testing.T) {
+	dbase := &fakeDatabase{getUser: database.User{Username: "alice"}}
+	handler := stompHandler{handler: &Handler{dbase: dbase, broker: broker.New(newTopicTestACL(t), nil)}}
+	handler.handler.broker.SetAuthorizer(handler.handler.hasScope)
+
+	var testCases = []struct {
+		name          string
+		message       protocol.MessageReceived
+		scopes        scope.Set
+		expectedError string
+	}{
+		{
+			name:    "Valid message",
+			message: protocol.MessageReceived{Sender: "alice", Topic: "public/news", Message: "hello"},
+			scopes:  scope.Default,
+		},
+		{
+			name:          "Invalid topic, error expected",
+			message:       protocol.MessageReceived{Sender: "alice", Topic: "public/*", Message: "hello"},
+			scopes:        scope.Default,
+			expectedError: "topic must not contain wildcards",
+		},
+		{
+			name:          "Forbidden topic, error expected",
+			message:       protocol.MessageReceived{Sender: "alice", Topic: "private", Message: "hello"},
+			scopes:        scope.Default,
+			expectedError: "not allowed to publish to this topic",
+		},
+		{
+			name:          "Send scope missing, error expected",
+			message:       protocol.MessageReceived{Sender: "alice", Topic: "public/news", Message: "hello"},
+			scopes:        scope.Set{scope.Connect},
+			expectedError: "not allowed to publish to this topic",
+		},
+		{
+			name:          "Invalid message, error expected",
+			message:       protocol.MessageReceived{Sender: "alice", Topic: "public/news"},
+			scopes:        scope.Default,
+			expectedError: errMessageEmpty.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := handler.Publish(context.Background(), tc.message, tc.scopes)
+			if tc.expectedError == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
@@ -1,42 +1,95 @@
 package rest
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
+	"net/url"
 	"regexp"
 	"unicode/utf8"
+
+	"github.com/shivanshkc/rosenbridge/internal/blob"
+	"github.com/shivanshkc/rosenbridge/internal/broker"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
 )
 
 const (
 	usernameMinLength = 3
 	usernameMaxLength = 100
 
-	passwordMinLength = 3
-	passwordMaxLength = 100
-
 	receiversMaxCount = 100
 
-	messageMaxLength = 4096
+	attachmentsMaxCount = 10
+
+	idempotencyKeyMaxLength = 255
+
+	webhookURLMaxLength = 2048
+
+	apiKeyNameMaxLength = 100
+
+	scopesMaxCount = 100
 )
 
 var (
 	usernamePattern = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+	// Only visible ASCII characters are allowed in idempotency keys.
+	idempotencyKeyPattern = regexp.MustCompile(`^[\x21-\x7E]+$`)
 
 	errUsernameLength  = fmt.Errorf("username must be between %d and %d characters", usernameMinLength, usernameMaxLength)
 	errUsernamePattern = errors.New("username must only contain lowercase and uppercase letters, numbers, hyphens, and underscores")
 
-	errPasswordLength = fmt.Errorf("password must be between %d and %d characters", passwordMinLength, passwordMaxLength)
-
 	errReceiversEmpty   = fmt.Errorf("must provide at least 1 receiver")
 	errReceiversTooMany = fmt.Errorf("must provide at most %d receivers", receiversMaxCount)
 
 	errReceiverLength  = fmt.Errorf("each receiver must be between %d and %d characters", usernameMinLength, usernameMaxLength)
 	errReceiverPattern = errors.New("each receiver must only contain lowercase and uppercase letters, numbers, hyphens, and underscores")
 
-	errMessageEmpty   = fmt.Errorf("message must not be empty")
-	errMessageTooLong = fmt.Errorf("message must not be longer than %d characters", messageMaxLength)
+	errMessageEmpty       = errors.New("message must have text, a payload, binary data, or attachments")
+	errMessageInvalidUTF8 = errors.New("message must be valid UTF-8")
+	errPayloadNotObject   = errors.New("payload must be a JSON object")
+	errBinaryContentType  = errors.New("binary data must have a valid content type")
+	errBinaryDataEmpty    = errors.New("binary data must not be empty")
+
+	errAttachmentsTooMany  = fmt.Errorf("must provide at most %d attachments", attachmentsMaxCount)
+	errAttachmentID        = errors.New("each attachment must be a valid attachment ID")
+	errAttachmentNotFound  = errors.New("attachment not found")
+	errAttachmentsDisabled = errors.New("attachments are disabled")
+
+	errRetainAttachments = errors.New("retained messages cannot have attachments")
+
+	errWebhookURLLength = fmt.Errorf("webhook url must not be longer than %d characters", webhookURLMaxLength)
+	errWebhookURL       = errors.New("webhook url must be an absolute http or https url without credentials")
+
+	errScopesTooMany = fmt.Errorf("must provide at most %d scopes", scopesMaxCount)
+
+	errAPIKeyNameLength = fmt.Errorf("api key name must be between 1 and %d characters", apiKeyNameMaxLength)
+
+	errIdempotencyKeyLength  = fmt.Errorf("idempotency key must not be longer than %d characters", idempotencyKeyMaxLength)
+	errIdempotencyKeyPattern = errors.New("idempotency key must only contain visible ASCII characters")
 )
 
+func errScopeNotAllowed(scope string) error {
+	return fmt.Errorf("new users cannot have the %s scope", scope)
+}
+
+// The following errors depend on the configured message limits.
+
+func errMessageTooLong(limit int) error {
+	return fmt.Errorf("message must not be longer than %d bytes", limit)
+}
+
+func errPayloadTooLong(limit int) error {
+	return fmt.Errorf("payload must not be longer than %d bytes", limit)
+}
+
+func errBinaryDataTooLong(limit int) error {
+	return fmt.Errorf("binary data must not be longer than %d bytes", limit)
+}
+
 func validateUsername(username string) error {
 	if len(username) < usernameMinLength || len(username) > usernameMaxLength {
 		return errUsernameLength
@@ -48,14 +101,6 @@ func validateUsername(username string) error {
 	return nil
 }
 
-func validatePassword(password string) error {
-	if len(password) < passwordMinLength || len(password) > passwordMaxLength {
-		return errPasswordLength
-	}
-
-	return nil
-}
-
 func validateReceiverList(receivers []string) error {
 	if len(receivers) == 0 {
 		return errReceiversEmpty
@@ -80,14 +125,146 @@ func validateReceiverList(receivers []string) error {
 	return nil
 }
 
-func validateMessage(message string) error {
-	if message == "" {
+// messageLimits are the max sizes of the different parts of a message, in bytes.
+type messageLimits struct {
+	text    int
+	payload int
+	binary  int
+}
+
+// defaultMessageLimits are used for the limits that are not configured.
+var defaultMessageLimits = messageLimits{text: 4096, payload: 8192, binary: 8192}
+
+// maxBodyBytes returns the max size of a request body, or of a websocket message, that carries a message within the
+// limits. On top of maxBodyReadBytes for the rest of the body, it has room for the text even if all of it is escaped,
+// for the payload, and for the base64 encoded binary data.
+func (l messageLimits) maxBodyBytes() int64 {
+	// The longest JSON escape of a byte is \u00XX.
+	const maxEscapedLen = 6
+	return maxBodyReadBytes + int64(maxEscapedLen*l.text+l.payload+base64.StdEncoding.EncodedLen(l.binary))
+}
+
+// orDefaults returns a copy of the limits with unset (non-positive) values replaced by the defaults.
+func (l messageLimits) orDefaults() messageLimits {
+	if l.text <= 0 {
+		l.text = defaultMessageLimits.text
+	}
+	if l.payload <= 0 {
+		l.payload = defaultMessageLimits.payload
+	}
+	if l.binary <= 0 {
+		l.binary = defaultMessageLimits.binary
+	}
+	return l
+}
+
+// validateMessage validates the parts of a message. At least one of them must be present.
+// The payload is considered absent if it is empty or JSON null.
+//
+// Only the format of the attachment IDs is validated here. Their existence is verified later.
+func validateMessage(text string, payload json.RawMessage, binary *protocol.Binary, attachments []string,
+	limits messageLimits) error {
+	hasPayload := len(payload) > 0 && string(payload) != "null"
+	if text == "" && !hasPayload && binary == nil && len(attachments) == 0 {
 		return errMessageEmpty
 	}
 
-	if utf8.RuneCountInString(message) > messageMaxLength {
-		return errMessageTooLong
+	if len(text) > limits.text {
+		return errMessageTooLong(limits.text)
+	}
+
+	if !utf8.ValidString(text) {
+		return errMessageInvalidUTF8
+	}
+
+	if hasPayload {
+		if len(payload) > limits.payload {
+			return errPayloadTooLong(limits.payload)
+		}
+		// The payload is already known to be valid JSON, as it was decoded as a part of the request body.
+		if trimmed := bytes.TrimSpace(payload); len(trimmed) == 0 || trimmed[0] != '{' {
+			return errPayloadNotObject
+		}
+	}
+
+	if binary != nil {
+		if _, _, err := mime.ParseMediaType(binary.ContentType); err != nil {
+			return errBinaryContentType
+		}
+		if len(binary.Data) == 0 {
+			return errBinaryDataEmpty
+		}
+		if len(binary.Data) > limits.binary {
+			return errBinaryDataTooLong(limits.binary)
+		}
+	}
+
+	if len(attachments) > attachmentsMaxCount {
+		return errAttachmentsTooMany
+	}
+
+	for _, id := range attachments {
+		if !blob.ValidID(id) {
+			return errAttachmentID
+		}
+	}
+
+	return nil
+}
+
+// validateRetain validates a message that is to be retained. Attachments are not allowed because their download links
+// expire long before the retained message is delivered.
+func validateRetain(enabled bool, attachments []string) error {
+	if !enabled {
+		return broker.ErrRetainDisabled
+	}
+
+	if len(attachments) > 0 {
+		return errRetainAttachments
 	}
 
 	return nil
 }
+
+func validateIdempotencyKey(key string) error {
+	if len(key) > idempotencyKeyMaxLength {
+		return errIdempotencyKeyLength
+	}
+
+	if !idempotencyKeyPattern.MatchString(key) {
+		return errIdempotencyKeyPattern
+	}
+
+	return nil
+}
+
+func validateWebhookURL(rawURL string) error {
+	if len(rawURL) > webhookURLMaxLength {
+		return errWebhookURLLength
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+		parsed.User != nil {
+		return errWebhookURL
+	}
+
+	return nil
+}
+
+func validateAPIKeyName(name string) error {
+	if !utf8.ValidString(name) {
+		return errAPIKeyNameLength
+	}
+	if length := utf8.RuneCountInString(name); length < 1 || length > apiKeyNameMaxLength {
+		return errAPIKeyNameLength
+	}
+	return nil
+}
+
+func validateScopes(scopes []string) error {
+	if len(scopes) > scopesMaxCount {
+		return errScopesTooMany
+	}
+	return scope.ValidateAll(scopes)
+}
@@ -0,0 +1,123 @@
+package retained
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+)
+
+// FileStore implements Store using a single JSON file, which maps topics to their retained messages.
+//
+// All messages are also kept in memory. The file is rewritten on every change, so it is only suitable for a moderate
+// number of topics.
+type FileStore struct {
+	messages map[string]protocol.MessageReceived
+	mutex    sync.RWMutex
+
+	filePath string
+	// maxTopics is the max number of topics with a retained message. Zero means no limit.
+	maxTopics int
+}
+
+// NewFileStore returns a new FileStore instance that persists messages in the given file.
+// It loads the existing messages, if any, or creates the file if it does not exist.
+func NewFileStore(filePath string, maxTopics int) (*FileStore, error) {
+	if filePath == "" {
+		return nil, errors.New("file path is empty")
+	}
+
+	// Without the parent directory, the os.OpenFile call below will fail, even with the os.O_CREATE flag.
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create parent directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open retained messages file: %w", err)
+	}
+
+	// Like FileDatabase, the file is reopened for each write.
+	defer func() { _ = file.Close() }()
+
+	messages := map[string]protocol.MessageReceived{}
+	if err := json.NewDecoder(file).Decode(&messages); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read retained messages file: %w", err)
+	}
+
+	return &FileStore{messages: messages, filePath: filePath, maxTopics: maxTopics}, nil
+}
+
+func (f *FileStore) Set(ctx context.Context, message protocol.MessageReceived) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	_, exists := f.messages[message.Topic]
+	if !exists && f.maxTopics > 0 && len(f.messages) >= f.maxTopics {
+		return ErrLimitReached
+	}
+
+	// The actual map will be modified only if the file write is successful.
+	clone := maps.Clone(f.messages)
+	clone[message.Topic] = message
+
+	return f.write(clone)
+}
+
+func (f *FileStore) Delete(ctx context.Context, topic string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, exists := f.messages[topic]; !exists {
+		return nil
+	}
+
+	clone := maps.Clone(f.messages)
+	delete(clone, topic)
+
+	return f.write(clone)
+}
+
+func (f *FileStore) Match(ctx context.Context, filter string) ([]protocol.MessageReceived, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	var matches []protocol.MessageReceived
+	for name, message := range f.messages {
+		if topic.Match(filter, name) {
+			matches = append(matches, message)
+		}
+	}
+
+	slices.SortFunc(matches, func(a, b protocol.MessageReceived) int {
+		return strings.Compare(a.Topic, b.Topic)
+	})
+
+	return matches, nil
+}
+
+// write persists the given messages and, if successful, replaces the in-memory messages with them.
+// The caller must hold the write lock.
+func (f *FileStore) write(messages map[string]protocol.MessageReceived) error {
+	marshalled, err := json.MarshalIndent(messages, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal retained messages: %w", err)
+	}
+
+	if err := os.WriteFile(f.filePath, marshalled, 0600); err != nil {
+		return fmt.Errorf("failed to write retained messages file: %w", err)
+	}
+
+	f.messages = messages
+	return nil
+}
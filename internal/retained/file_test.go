@@ -0,0 +1,79 @@
+package retained
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFileStore(t *testing.T) {
+	_, err := NewFileStore("", 0)
+	require.ErrorContains(t, err, "file path is empty")
+
+	invalidPath := filepath.Join(t.TempDir(), "retained.json")
+	require.NoError(t, os.WriteFile(invalidPath, []byte(`{{{`), 0600))
+	_, err = NewFileStore(invalidPath, 0)
+	require.ErrorContains(t, err, "invalid character")
+
+	// The parent directory is created if required.
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "nested", "retained.json"), 0)
+	require.NoError(t, err)
+	require.Empty(t, store.messages)
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "retained.json")
+
+	store, err := NewFileStore(filePath, 0)
+	require.NoError(t, err)
+
+	kitchen := protocol.MessageReceived{Message: "21", Sender: "alice", Topic: "sensors/kitchen"}
+	garage := protocol.MessageReceived{Message: "15", Sender: "alice", Topic: "sensors/garage"}
+	news := protocol.MessageReceived{Message: "hi", Sender: "bob", Topic: "news"}
+
+	for _, message := range []protocol.MessageReceived{kitchen, garage, news} {
+		require.NoError(t, store.Set(ctx, message))
+	}
+
+	// Setting again replaces the message.
+	kitchen.Message = "22"
+	require.NoError(t, store.Set(ctx, kitchen))
+
+	matches, err := store.Match(ctx, "sensors/*")
+	require.NoError(t, err)
+	require.Equal(t, []protocol.MessageReceived{garage, kitchen}, matches)
+
+	matches, err = store.Match(ctx, "sensors")
+	require.NoError(t, err)
+	require.Empty(t, matches)
+
+	require.NoError(t, store.Delete(ctx, "sensors/garage"))
+	require.NoError(t, store.Delete(ctx, "nonexistent"))
+
+	// Messages persist across restarts.
+	reopened, err := NewFileStore(filePath, 0)
+	require.NoError(t, err)
+
+	matches, err = reopened.Match(ctx, "#")
+	require.NoError(t, err)
+	require.Equal(t, []protocol.MessageReceived{news, kitchen}, matches)
+}
+
+func TestFileStore_Limit(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "retained.json"), 1)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Set(ctx, protocol.MessageReceived{Message: "1", Topic: "a"}))
+	require.ErrorIs(t, store.Set(ctx, protocol.MessageReceived{Message: "1", Topic: "b"}), ErrLimitReached)
+
+	// Topics that already have a retained message can still be updated.
+	require.NoError(t, store.Set(ctx, protocol.MessageReceived{Message: "2", Topic: "a"}))
+}
@@ -0,0 +1,24 @@
+// Package retained stores the last retained message of each topic, so it can be delivered to new subscribers.
+package retained
+
+import (
+	"context"
+	"errors"
+
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+)
+
+var ErrLimitReached = errors.New("retained message limit reached")
+
+// Store encapsulates all retained message operations required by Rosenbridge.
+type Store interface {
+	// Set makes the given message the retained message of its topic, replacing the previous one, if any.
+	// If the topic has no retained message yet and the store is full, it returns ErrLimitReached.
+	Set(ctx context.Context, message protocol.MessageReceived) error
+
+	// Delete deletes the retained message of the given topic. It is a no-op if the topic has none.
+	Delete(ctx context.Context, topic string) error
+
+	// Match returns the retained messages of all topics that match the given filter, sorted by topic.
+	Match(ctx context.Context, filter string) ([]protocol.MessageReceived, error)
+}
@@ -0,0 +1,107 @@
+// Package scope defines the permissions that can be granted to users and to their API keys.
+package scope
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The known scopes.
+const (
+	// Connect allows connecting to receive messages, and subscribing to topics.
+	Connect = "connect"
+	// Send allows sending messages to any user, publishing to topics, and uploading attachments.
+	Send = "send"
+	// Admin grants every scope, and allows managing the scopes of other users.
+	Admin = "admin"
+	// SendAny is required by the routes that send to receivers that are only known from the request, and by the
+	// management of API keys, which are meant for sending. It is granted by Send and by every scope returned by SendTo,
+	// and it cannot be given to users.
+	SendAny = sendToPrefix + "*"
+
+	// sendToPrefix is the prefix of the scopes that allow sending to a single user, like "send:alice".
+	sendToPrefix = "send:"
+)
+
+// Default are the scopes of users that were not given any. They are also the most that a new user can ask for.
+var Default = Set{Connect, Send}
+
+// usernamePattern must match the username rules of the REST API.
+var usernamePattern = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+// SendTo returns the scope that allows sending messages to the given user.
+func SendTo(username string) string {
+	return sendToPrefix + username
+}
+
+// Validate returns an error if the scope is not known.
+func Validate(scope string) error {
+	switch scope {
+	case Connect, Send, Admin:
+		return nil
+	}
+
+	if username, ok := strings.CutPrefix(scope, sendToPrefix); ok && usernamePattern.MatchString(username) {
+		return nil
+	}
+
+	return fmt.Errorf("unknown scope: %q", scope)
+}
+
+// ValidateAll returns an error if the list is empty or has an unknown scope.
+func ValidateAll(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("must provide at least 1 scope")
+	}
+
+	for _, scope := range scopes {
+		if err := Validate(scope); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Set is a list of granted scopes.
+type Set []string
+
+// Has reports whether the set grants the required scope. Admin grants every scope, Send grants every scope returned
+// by SendTo, and both grant SendAny.
+func (s Set) Has(required string) bool {
+	if slices.Contains(s, Admin) || slices.Contains(s, required) {
+		return true
+	}
+
+	if required == SendAny {
+		return slices.ContainsFunc(s, func(granted string) bool { return strings.HasPrefix(granted, sendToPrefix) }) ||
+			slices.Contains(s, Send)
+	}
+
+	return strings.HasPrefix(required, sendToPrefix) && slices.Contains(s, Send)
+}
+
+// Missing returns the first of the required scopes that the set does not grant, or an empty string if it grants all.
+func (s Set) Missing(required []string) string {
+	for _, scope := range required {
+		if !s.Has(scope) {
+			return scope
+		}
+	}
+	return ""
+}
+
+// Restrict returns the scopes of the set that are also granted by the limit. It is used so that an API key never has
+// more access than its user, even if the user loses some scopes after the key is created.
+func (s Set) Restrict(limit Set) Set {
+	restricted := make(Set, 0, len(s))
+	for _, scope := range s {
+		if limit.Has(scope) {
+			restricted = append(restricted, scope)
+		}
+	}
+	return restricted
+}
@@ -0,0 +1,77 @@
+package scope
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	var testCases = []struct {
+		name      string
+		scope     string
+		expectErr bool
+	}{
+		{name: "Connect", scope: Connect},
+		{name: "Send", scope: Send},
+		{name: "Admin", scope: Admin},
+		{name: "Send to a user", scope: SendTo("alice")},
+		{name: "Send to nobody, error expected", scope: "send:", expectErr: true},
+		{name: "Send to an invalid username, error expected", scope: "send:alice$", expectErr: true},
+		{name: "Unknown scope, error expected", scope: "delete", expectErr: true},
+		{name: "Removed presence scope, error expected", scope: "presence:read", expectErr: true},
+		{name: "Send to anyone, error expected", scope: SendAny, expectErr: true},
+		{name: "Empty scope, error expected", scope: "", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(tc.scope)
+			if tc.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestSet_Has(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		set      Set
+		required string
+		expected bool
+	}{
+		{name: "Exact scope", set: Set{Connect}, required: Connect, expected: true},
+		{name: "Missing scope", set: Set{Send}, required: Connect, expected: false},
+		{name: "Admin grants everything", set: Set{Admin}, required: SendTo("alice"), expected: true},
+		{name: "Send grants sending to a user", set: Set{Send}, required: SendTo("alice"), expected: true},
+		{name: "Sending to a user does not grant send", set: Set{SendTo("alice")}, required: Send, expected: false},
+		{name: "Sending to a user does not grant another", set: Set{SendTo("alice")}, required: SendTo("bob")},
+		{name: "Send grants sending to anyone", set: Set{Send}, required: SendAny, expected: true},
+		{name: "Sending to a user grants sending to anyone", set: Set{SendTo("alice")}, required: SendAny, expected: true},
+		{name: "Connect does not grant sending to anyone", set: Set{Connect}, required: SendAny, expected: false},
+		{name: "Empty set", set: nil, required: Connect, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.set.Has(tc.required))
+		})
+	}
+}
+
+func TestSet_Missing(t *testing.T) {
+	set := Set{Connect, SendTo("alice")}
+	require.Empty(t, set.Missing([]string{Connect, SendTo("alice")}))
+	require.Equal(t, SendTo("bob"), set.Missing([]string{SendTo("alice"), SendTo("bob")}))
+}
+
+func TestSet_Restrict(t *testing.T) {
+	key := Set{Connect, Send, SendTo("alice"), Admin}
+	user := Set{Send}
+
+	require.Equal(t, Set{Send, SendTo("alice")}, key.Restrict(user))
+	require.Equal(t, key, key.Restrict(Set{Admin}))
+}
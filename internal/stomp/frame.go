@@ -0,0 +1,250 @@
+package stomp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Client commands.
+const (
+	commandConnect     = "CONNECT"
+	commandStomp       = "STOMP"
+	commandSend        = "SEND"
+	commandSubscribe   = "SUBSCRIBE"
+	commandUnsubscribe = "UNSUBSCRIBE"
+	commandAck         = "ACK"
+	commandNack        = "NACK"
+	commandBegin       = "BEGIN"
+	commandCommit      = "COMMIT"
+	commandAbort       = "ABORT"
+	commandDisconnect  = "DISCONNECT"
+)
+
+// Server commands.
+const (
+	commandConnected = "CONNECTED"
+	commandMessage   = "MESSAGE"
+	commandReceipt   = "RECEIPT"
+	commandError     = "ERROR"
+)
+
+// Headers used by Rosenbridge.
+const (
+	headerAcceptVersion = "accept-version"
+	headerAck           = "ack"
+	headerContentLength = "content-length"
+	headerContentType   = "content-type"
+	headerDestination   = "destination"
+	headerHeartBeat     = "heart-beat"
+	headerID            = "id"
+	headerLogin         = "login"
+	headerMessage       = "message"
+	headerMessageID     = "message-id"
+	headerPasscode      = "passcode"
+	headerReceipt       = "receipt"
+	headerReceiptID     = "receipt-id"
+	headerServer        = "server"
+	headerSubscription  = "subscription"
+	headerUserName      = "user-name"
+	headerVersion       = "version"
+
+	// headerReceivers lists the receivers of a direct message, separated by commas.
+	headerReceivers = "receivers"
+	// headerSender is the username of the sender of a message.
+	headerSender = "sender"
+	// headerRetained is set to true on retained messages.
+	headerRetained = "retained"
+)
+
+var errMalformedFrame = errors.New("malformed frame")
+
+// frame is a STOMP frame.
+type frame struct {
+	command string
+	// headers holds the first value of every header, as repeated headers are ignored by the spec.
+	headers map[string]string
+	body    []byte
+}
+
+// newFrame returns a frame with the given command and headers, given as key-value pairs.
+func newFrame(command string, keyValues ...string) frame {
+	f := frame{command: command, headers: map[string]string{}}
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		f.headers[keyValues[i]] = keyValues[i+1]
+	}
+	return f
+}
+
+// escapesHeaders reports whether the headers of the frame are escaped. As per the spec, the headers of the CONNECT and
+// CONNECTED frames are not, for backward compatibility.
+func escapesHeaders(command string) bool {
+	return command != commandConnect && command != commandConnected
+}
+
+// parseFrames parses all frames in the data. EOLs between frames are heart-beats and are skipped.
+//
+// Every websocket message must contain whole frames. Frames split across messages are not supported.
+func parseFrames(data []byte) ([]frame, error) {
+	var frames []frame
+
+	for {
+		// Skip heart-beats.
+		data = bytes.TrimLeft(data, "\r\n")
+		if len(data) == 0 {
+			return frames, nil
+		}
+
+		f, rest, err := parseFrame(data)
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, f)
+		data = rest
+	}
+}
+
+// parseFrame parses the frame at the start of the data, and returns the unparsed data.
+func parseFrame(data []byte) (frame, []byte, error) {
+	command, data, ok := cutLine(data)
+	if !ok || command == "" {
+		return frame{}, nil, fmt.Errorf("%w: missing command", errMalformedFrame)
+	}
+
+	f := frame{command: command, headers: map[string]string{}}
+
+	for {
+		var line string
+		if line, data, ok = cutLine(data); !ok {
+			return frame{}, nil, fmt.Errorf("%w: unterminated headers", errMalformedFrame)
+		}
+
+		// An empty line ends the headers.
+		if line == "" {
+			break
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return frame{}, nil, fmt.Errorf("%w: header without a colon", errMalformedFrame)
+		}
+
+		if escapesHeaders(command) {
+			var err error
+			if key, err = unescape(key); err != nil {
+				return frame{}, nil, err
+			}
+			if value, err = unescape(value); err != nil {
+				return frame{}, nil, err
+			}
+		}
+
+		if _, exists := f.headers[key]; !exists {
+			f.headers[key] = value
+		}
+	}
+
+	// The body ends at the NUL octet, which can only be inside the body if the content length is given.
+	end := bytes.IndexByte(data, 0)
+	if lengthStr, exists := f.headers[headerContentLength]; exists {
+		length, err := strconv.Atoi(lengthStr)
+		if err != nil || length < 0 {
+			return frame{}, nil, fmt.Errorf("%w: invalid content length", errMalformedFrame)
+		}
+		if len(data) <= length || data[length] != 0 {
+			return frame{}, nil, fmt.Errorf("%w: body does not match the content length", errMalformedFrame)
+		}
+		end = length
+	}
+
+	if end < 0 {
+		return frame{}, nil, fmt.Errorf("%w: unterminated body", errMalformedFrame)
+	}
+
+	f.body = bytes.Clone(data[:end])
+	return f, data[end+1:], nil
+}
+
+// cutLine returns the first line of the data, without its EOL, and the data after it.
+// It returns false if the data has no EOL.
+func cutLine(data []byte) (string, []byte, bool) {
+	line, rest, found := bytes.Cut(data, []byte("\n"))
+	if !found {
+		return "", nil, false
+	}
+	return string(bytes.TrimSuffix(line, []byte("\r"))), rest, true
+}
+
+// encode returns the wire format of the frame. Headers are sorted so that the output is deterministic.
+func (f frame) encode() []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString(f.command)
+	buffer.WriteByte('\n')
+
+	keys := make([]string, 0, len(f.headers))
+	for key := range f.headers {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		value := f.headers[key]
+		if escapesHeaders(f.command) {
+			key, value = escape(key), escape(value)
+		}
+		buffer.WriteString(key)
+		buffer.WriteByte(':')
+		buffer.WriteString(value)
+		buffer.WriteByte('\n')
+	}
+
+	buffer.WriteByte('\n')
+	buffer.Write(f.body)
+	buffer.WriteByte(0)
+	return buffer.Bytes()
+}
+
+var headerEscaper = strings.NewReplacer("\\", `\\`, "\r", `\r`, "\n", `\n`, ":", `\c`)
+
+// escape escapes a header key or value as per the spec.
+func escape(value string) string {
+	return headerEscaper.Replace(value)
+}
+
+// unescape reverses escape. Undefined escape sequences are a fatal error as per the spec.
+func unescape(value string) (string, error) {
+	if !strings.Contains(value, `\`) {
+		return value, nil
+	}
+
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			builder.WriteByte(value[i])
+			continue
+		}
+
+		if i++; i == len(value) {
+			return "", fmt.Errorf("%w: incomplete escape sequence", errMalformedFrame)
+		}
+
+		switch value[i] {
+		case '\\':
+			builder.WriteByte('\\')
+		case 'r':
+			builder.WriteByte('\r')
+		case 'n':
+			builder.WriteByte('\n')
+		case 'c':
+			builder.WriteByte(':')
+		default:
+			return "", fmt.Errorf("%w: undefined escape sequence", errMalformedFrame)
+		}
+	}
+
+	return builder.String(), nil
+}
@@ -0,0 +1,113 @@
+package stomp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFrames(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		input         string
+		expected      []frame
+		expectedError bool
+	}{
+		{
+			name:     "Heart-beats only",
+			input:    "\n\r\n",
+			expected: nil,
+		},
+		{
+			name:  "Frame without body",
+			input: "SUBSCRIBE\nid:0\ndestination:/topic/a\n\n\x00",
+			expected: []frame{
+				{command: "SUBSCRIBE", headers: map[string]string{"id": "0", "destination": "/topic/a"}, body: []byte{}},
+			},
+		},
+		{
+			name:  "CRLF line endings, repeated header and trailing heart-beats",
+			input: "SEND\r\ndestination:/topic/a\r\ndestination:/topic/b\r\n\r\nhello\x00\n\n",
+			expected: []frame{
+				{command: "SEND", headers: map[string]string{"destination": "/topic/a"}, body: []byte("hello")},
+			},
+		},
+		{
+			name:  "Content length allows NUL in the body",
+			input: "SEND\ncontent-length:3\n\na\x00b\x00",
+			expected: []frame{
+				{command: "SEND", headers: map[string]string{"content-length": "3"}, body: []byte("a\x00b")},
+			},
+		},
+		{
+			name:  "Escaped headers",
+			input: "SEND\nkey\\c1:a\\nb\\\\c\n\n\x00",
+			expected: []frame{
+				{command: "SEND", headers: map[string]string{"key:1": "a\nb\\c"}, body: []byte{}},
+			},
+		},
+		{
+			name:  "CONNECT headers are not unescaped",
+			input: "CONNECT\npasscode:a\\b\n\n\x00",
+			expected: []frame{
+				{command: "CONNECT", headers: map[string]string{"passcode": `a\b`}, body: []byte{}},
+			},
+		},
+		{
+			name:  "Multiple frames",
+			input: "SEND\n\na\x00\nSEND\n\nb\x00",
+			expected: []frame{
+				{command: "SEND", headers: map[string]string{}, body: []byte("a")},
+				{command: "SEND", headers: map[string]string{}, body: []byte("b")},
+			},
+		},
+		{
+			name:          "Undefined escape sequence, error expected",
+			input:         "SEND\nkey:a\\t\n\n\x00",
+			expectedError: true,
+		},
+		{
+			name:          "Missing NUL, error expected",
+			input:         "SEND\n\nhello",
+			expectedError: true,
+		},
+		{
+			name:          "Wrong content length, error expected",
+			input:         "SEND\ncontent-length:2\n\nhello\x00",
+			expectedError: true,
+		},
+		{
+			name:          "Header without colon, error expected",
+			input:         "SEND\nkey\n\n\x00",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			frames, err := parseFrames([]byte(tc.input))
+			if tc.expectedError {
+				require.ErrorIs(t, err, errMalformedFrame)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, frames)
+		})
+	}
+}
+
+func TestFrame_Encode(t *testing.T) {
+	f := newFrame(commandMessage, "subscription", "0", "key:1", "a\nb")
+	f.body = []byte("hello")
+	require.Equal(t, "MESSAGE\nkey\\c1:a\\nb\nsubscription:0\n\nhello\x00", string(f.encode()))
+
+	// The encoded frame parses back to the same frame.
+	frames, err := parseFrames(f.encode())
+	require.NoError(t, err)
+	require.Equal(t, []frame{f}, frames)
+
+	// CONNECTED headers are not escaped.
+	f = newFrame(commandConnected, "server", "a:b")
+	require.Equal(t, "CONNECTED\nserver:a:b\n\n\x00", string(f.encode()))
+}
@@ -0,0 +1,69 @@
+package stomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+)
+
+// toMessage converts the body of a SEND frame into a message, as per its content type:
+//   - No content type, or text/plain, makes the message text.
+//   - application/json makes the JSON payload.
+//   - Any other content type makes binary data.
+func toMessage(sender string, f frame) protocol.MessageReceived {
+	message := protocol.MessageReceived{Sender: sender}
+
+	contentType := f.headers[headerContentType]
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case contentType == "" || mediaType == "text/plain":
+		message.Message = string(f.body)
+	case mediaType == contentTypeJSON:
+		message.Payload = f.body
+	default:
+		message.Binary = &protocol.Binary{ContentType: contentType, Data: f.body}
+	}
+
+	return message
+}
+
+// toFrame converts a message into a MESSAGE frame, without the subscription headers. A message that only has one of
+// text, JSON payload or binary data is sent as is, with the matching content type. Any other message is sent as the
+// JSON encoding of the MessageReceived event body.
+func toFrame(message protocol.MessageReceived) (frame, error) {
+	hasText := message.Message != ""
+	hasPayload := len(message.Payload) > 0 && string(message.Payload) != "null"
+	hasBinary := message.Binary != nil
+	hasAttachments := len(message.Attachments) > 0
+
+	f := newFrame(commandMessage, headerSender, message.Sender)
+	if message.Retained {
+		f.headers[headerRetained] = "true"
+	}
+
+	switch {
+	case !hasPayload && !hasBinary && !hasAttachments:
+		f.headers[headerContentType] = contentTypeText
+		f.body = []byte(message.Message)
+	case !hasText && !hasBinary && !hasAttachments:
+		f.headers[headerContentType] = contentTypeJSON
+		f.body = message.Payload
+	case !hasText && !hasPayload && !hasAttachments:
+		f.headers[headerContentType] = message.Binary.ContentType
+		f.body = message.Binary.Data
+	default:
+		body, err := json.Marshal(message)
+		if err != nil {
+			return frame{}, fmt.Errorf("failed to marshal message: %w", err)
+		}
+		f.headers[headerContentType] = contentTypeMessage
+		f.body = body
+	}
+
+	// The body can contain NUL octets, so the content length is always set.
+	f.headers[headerContentLength] = fmt.Sprint(len(f.body))
+	return f, nil
+}
@@ -0,0 +1,65 @@
+package stomp
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestToMessage(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		contentType string
+		expected    protocol.MessageReceived
+	}{
+		{
+			name:     "No content type",
+			expected: protocol.MessageReceived{Sender: "alice", Message: "body"},
+		},
+		{
+			name:        "Text",
+			contentType: "text/plain;charset=utf-8",
+			expected:    protocol.MessageReceived{Sender: "alice", Message: "body"},
+		},
+		{
+			name:        "JSON",
+			contentType: "application/json",
+			expected:    protocol.MessageReceived{Sender: "alice", Payload: json.RawMessage("body")},
+		},
+		{
+			name:        "Binary",
+			contentType: "image/png",
+			expected: protocol.MessageReceived{Sender: "alice",
+				Binary: &protocol.Binary{ContentType: "image/png", Data: []byte("body")}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFrame(commandSend)
+			if tc.contentType != "" {
+				f.headers[headerContentType] = tc.contentType
+			}
+			f.body = []byte("body")
+
+			require.Equal(t, tc.expected, toMessage("alice", f))
+		})
+	}
+}
+
+func TestToFrame(t *testing.T) {
+	message := protocol.MessageReceived{Sender: "alice", Message: "hi", Payload: json.RawMessage(`{"a":1}`)}
+
+	// Mixed messages are sent as the JSON of the event body.
+	f, err := toFrame(message)
+	require.NoError(t, err)
+	require.Equal(t, commandMessage, f.command)
+	require.Equal(t, contentTypeMessage, f.headers[headerContentType])
+	require.Equal(t, "alice", f.headers[headerSender])
+	require.JSONEq(t, `{"message":"hi","payload":{"a":1},"sender":"alice"}`, string(f.body))
+	require.Equal(t, strconv.Itoa(len(f.body)), f.headers[headerContentLength])
+}
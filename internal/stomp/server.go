@@ -0,0 +1,267 @@
+// Package stomp implements STOMP 1.2 over websocket, so that off-the-shelf STOMP clients can exchange messages with
+// Rosenbridge.
+//
+// Clients subscribe to /user/queue/messages to receive the messages sent to their user, and to /topic/<filter> to
+// receive the messages published to topics. SEND frames are passed to a Handler, which validates and delivers them.
+package stomp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/shivanshkc/rosenbridge/internal/broker"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
+
+	"github.com/coder/websocket"
+)
+
+// ErrClosed is returned by Accept after the Server is closed.
+var ErrClosed = errors.New("stomp server is closed")
+
+// closeReasonShutdown is the reason of the close frame of the connections closed by Server.Close.
+const closeReasonShutdown = "server is shutting down"
+
+// Subprotocol is the websocket subprotocol of STOMP 1.2.
+const Subprotocol = "v12.stomp"
+
+// Handler authenticates STOMP clients and processes the messages they send.
+//
+// The errors returned by SendMessage and Publish are sent to the client in ERROR frames, so they must be safe to
+// send. The Handler is responsible for logging them.
+type Handler interface {
+	// Authenticate verifies the credentials of a user, and returns the scopes that they grant. It returns false, with
+	// a nil error, if they are wrong. Errors are reserved for unexpected failures.
+	Authenticate(ctx context.Context, username, password string) (scope.Set, bool, error)
+
+	// SendMessage validates the message and sends it to the given receivers. The scopes are those returned by
+	// Authenticate for the sender.
+	SendMessage(ctx context.Context, message protocol.MessageReceived, scopes scope.Set, receivers []string) error
+
+	// Publish validates the message and publishes it to its topic. The scopes are those returned by Authenticate for
+	// the sender.
+	Publish(ctx context.Context, message protocol.MessageReceived, scopes scope.Set) error
+}
+
+// Server manages STOMP connections. It implements broker.Deliverer, so that its clients receive the messages
+// published through any protocol.
+type Server struct {
+	broker  *broker.Broker
+	handler Handler
+
+	mutex    sync.RWMutex
+	sessions map[*session]struct{}
+	// closed is set by Close, after which new connections are refused.
+	closed bool
+
+	// readLimit is the max size of a frame from a client. If it is not positive, the websocket library's default of
+	// 32 KiB applies.
+	readLimit atomic.Int64
+}
+
+// NewServer returns a new Server instance.
+func NewServer(bus *broker.Broker, handler Handler) *Server {
+	return &Server{broker: bus, handler: handler, sessions: map[*session]struct{}{}}
+}
+
+// SetReadLimit sets the max size of a frame from a client, in bytes, for the sessions that are accepted after it.
+// Clients that send larger frames are disconnected.
+func (s *Server) SetReadLimit(limit int64) {
+	s.readLimit.Store(limit)
+}
+
+// Requested reports whether the websocket upgrade request asks for the STOMP subprotocol.
+func Requested(r *http.Request) bool {
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for subprotocol := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(subprotocol), Subprotocol) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Accept upgrades the given HTTP request into a STOMP connection. If the upgrade fails, the response is written by
+// this method itself. The caller should not write the response at their end.
+//
+// Clients authenticate with the login and passcode headers of the CONNECT frame. If they are absent, the basic auth
+// credentials of the upgrade request are used instead.
+//
+// After Close, it responds with 503 and returns ErrClosed.
+func (s *Server) Accept(w http.ResponseWriter, r *http.Request) error {
+	s.mutex.RLock()
+	closed := s.closed
+	s.mutex.RUnlock()
+
+	// Fail fast, before the upgrade. Connections that race with Close are closed below.
+	if closed {
+		httputils.WriteError(w, httputils.ServiceUnavailable().WithReasonStr(closeReasonShutdown))
+		return ErrClosed
+	}
+
+	acceptOptions := &websocket.AcceptOptions{InsecureSkipVerify: true, Subprotocols: []string{Subprotocol}}
+	conn, err := websocket.Accept(w, r, acceptOptions)
+	if err != nil {
+		return fmt.Errorf("failed to upgrade to websocket connection: %w", err)
+	}
+
+	if conn.Subprotocol() != Subprotocol {
+		_ = conn.Close(websocket.StatusPolicyViolation, "the "+Subprotocol+" subprotocol is required")
+		return errors.New("client did not negotiate the stomp subprotocol")
+	}
+	if limit := s.readLimit.Load(); limit > 0 {
+		conn.SetReadLimit(limit)
+	}
+
+	// Canceling the context of a read closes the connection, which is the only way to abandon a close handshake.
+	connCtx, drop := context.WithCancel(context.Background())
+	sess := newSession(s, conn, drop)
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		drop()
+		_ = conn.Close(websocket.StatusGoingAway, closeReasonShutdown)
+		return ErrClosed
+	}
+	s.sessions[sess] = struct{}{}
+	s.mutex.Unlock()
+
+	// The request context gets canceled once the handler returns, so only its values are retained.
+	ctx := context.WithoutCancel(r.Context())
+
+	go func() {
+		// Blocking call. This releases only when the connection is no longer valid.
+		sess.serve(ctx, connCtx, r)
+		drop()
+
+		s.mutex.Lock()
+		delete(s.sessions, sess)
+		s.mutex.Unlock()
+	}()
+
+	return nil
+}
+
+// SendToUsers sends a direct message to all connections of the receivers that are subscribed to
+// /user/queue/messages.
+func (s *Server) SendToUsers(ctx context.Context, message protocol.MessageReceived, receivers []string) error {
+	return s.deliver(ctx, message, func(sess *session) []string {
+		if !slices.Contains(receivers, sess.username) {
+			return nil
+		}
+		return sess.userQueueSubscriptions()
+	})
+}
+
+// IsConnected reports whether the user has at least one connection subscribed to /user/queue/messages, which means
+// that SendToUsers would deliver to them.
+func (s *Server) IsConnected(username string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for sess := range s.sessions {
+		if sess.username == username && len(sess.userQueueSubscriptions()) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ConnectionCount returns the number of STOMP connections.
+func (s *Server) ConnectionCount() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.sessions)
+}
+
+// Deliver sends the message to all connections subscribed to its topic that pass the allow function.
+func (s *Server) Deliver(ctx context.Context, message protocol.MessageReceived, allow broker.Allow) error {
+	return s.deliver(ctx, message, func(sess *session) []string {
+		if !allow(sess.username, sess.scopes) {
+			return nil
+		}
+		return sess.topicSubscriptions(message.Topic)
+	})
+}
+
+// Close closes all connections with StatusGoingAway, and refuses new ones from then on. The close handshakes are
+// bounded by the context; connections that do not finish theirs in time are closed right away.
+func (s *Server) Close(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closed = true
+	snapshot := s.sessions
+	s.sessions = map[*session]struct{}{}
+	s.mutex.Unlock()
+
+	// This will collect all errors.
+	var errs []error
+	var errsMutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for sess := range snapshot {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := closeSession(ctx, sess); err != nil {
+				errsMutex.Lock()
+				errs = append(errs, fmt.Errorf("failed to close stomp connection for %s: %w", sess.username, err))
+				errsMutex.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// closeSession closes the connection of the session with the close handshake. If the context expires first, the
+// connection is closed right away.
+func closeSession(ctx context.Context, sess *session) error {
+	closed := make(chan error, 1)
+	go func() { closed <- sess.conn.Close(websocket.StatusGoingAway, closeReasonShutdown) }()
+
+	select {
+	case err := <-closed:
+		return err
+	case <-ctx.Done():
+		sess.drop()
+		return fmt.Errorf("close handshake did not finish: %w", ctx.Err())
+	}
+}
+
+// deliver sends the message to every subscription returned by the subscriptions function, which is called for every
+// connected session.
+func (s *Server) deliver(ctx context.Context, message protocol.MessageReceived,
+	subscriptions func(sess *session) []string,
+) error {
+	// The websocket Write calls are kept outside the mutex lock.
+	s.mutex.RLock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mutex.RUnlock()
+
+	// This will collect all errors.
+	var errs []error
+	for _, sess := range sessions {
+		// Sessions that have not sent CONNECT yet have no subscriptions.
+		for _, id := range subscriptions(sess) {
+			if err := sess.sendMessage(ctx, id, message); err != nil {
+				errs = append(errs, fmt.Errorf("failed to deliver to %s: %w", sess.username, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
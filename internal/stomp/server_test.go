@@ -0,0 +1,415 @@
+package stomp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/broker"
+	"github.com/shivanshkc/rosenbridge/internal/retained"
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+
+	"github.com/coder/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHandler delivers all valid messages right away. Messages with the text "invalid" fail validation.
+type fakeHandler struct {
+	server *Server
+	broker *broker.Broker
+}
+
+// fakeScopes are the scopes of the users of fakeHandler. Other users have scope.Default.
+var fakeScopes = map[string]scope.Set{"kiosk": {scope.Connect}, "notifier": {scope.Send}}
+
+func (f *fakeHandler) Authenticate(_ context.Context, username, password string) (scope.Set, bool, error) {
+	if username == "broken" {
+		return nil, false, errors.New("database unavailable")
+	}
+	if password != username+"-password" {
+		return nil, false, nil
+	}
+	if scopes, exists := fakeScopes[username]; exists {
+		return scopes, true, nil
+	}
+	return scope.Default, true, nil
+}
+
+func (f *fakeHandler) SendMessage(ctx context.Context, message protocol.MessageReceived, scopes scope.Set,
+	receivers []string,
+) error {
+	if message.Message == "invalid" {
+		return errors.New("message is invalid")
+	}
+	if !scopes.Has(scope.Send) {
+		return errors.New("missing scope: send")
+	}
+	return f.server.SendToUsers(ctx, message, receivers)
+}
+
+func (f *fakeHandler) Publish(ctx context.Context, message protocol.MessageReceived, scopes scope.Set) error {
+	if message.Message == "invalid" {
+		return errors.New("message is invalid")
+	}
+	if !f.broker.CanPublish(message.Sender, scopes, message.Topic) {
+		return errors.New("not allowed to publish to this topic")
+	}
+	return f.broker.Publish(ctx, message)
+}
+
+// startServer starts a STOMP server behind an httptest server and returns its websocket URL.
+func startServer(t *testing.T) (string, *broker.Broker, *Server) {
+	topics, err := topic.NewACL([]topic.Rule{
+		{Topic: "private/#", Publishers: []string{"bob"}, Subscribers: []string{"bob"}},
+		{Topic: "#", Publishers: []string{topic.AnyUser}, Subscribers: []string{topic.AnyUser}},
+	})
+	require.NoError(t, err)
+
+	store, err := retained.NewFileStore(filepath.Join(t.TempDir(), "retained.json"), 0)
+	require.NoError(t, err)
+
+	bus := broker.New(topics, store)
+	bus.SetAuthorizer(func(_ string, scopes scope.Set, required string) bool { return scopes.Has(required) })
+	handler := &fakeHandler{broker: bus}
+	server := NewServer(bus, handler)
+	handler.server = server
+	bus.AddDeliverer(server)
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = server.Accept(w, r)
+	}))
+	t.Cleanup(httpServer.Close)
+	t.Cleanup(func() { _ = server.Close(context.Background()) })
+
+	return "ws" + httpServer.URL[4:], bus, server
+}
+
+// testClient exchanges raw frames with the server.
+type testClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dial(t *testing.T, url string) *testClient {
+	dialOptions := &websocket.DialOptions{Subprotocols: []string{"v10.stomp", Subprotocol}}
+	conn, _, err := websocket.Dial(context.Background(), url, dialOptions)
+	require.NoError(t, err)
+	require.Equal(t, Subprotocol, conn.Subprotocol())
+	t.Cleanup(func() { _ = conn.CloseNow() })
+
+	return &testClient{t: t, conn: conn}
+}
+
+// connect dials the server and sends a CONNECT frame with the given credentials, expecting CONNECTED.
+func connect(t *testing.T, url, username string) *testClient {
+	client := dial(t, url)
+	client.send(newFrame(commandConnect, headerAcceptVersion, "1.1,1.2", headerLogin, username,
+		headerPasscode, username+"-password"))
+
+	connected := client.receive()
+	require.Equal(t, commandConnected, connected.command)
+	require.Equal(t, "1.2", connected.headers[headerVersion])
+	require.Equal(t, username, connected.headers[headerUserName])
+
+	return client
+}
+
+func (c *testClient) send(f frame) {
+	require.NoError(c.t, c.conn.Write(context.Background(), websocket.MessageText, f.encode()))
+}
+
+func (c *testClient) receive() frame {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, data, err := c.conn.Read(ctx)
+	require.NoError(c.t, err)
+
+	frames, err := parseFrames(data)
+	require.NoError(c.t, err)
+	require.Len(c.t, frames, 1)
+	return frames[0]
+}
+
+// subscribe subscribes with a receipt, and waits for it.
+func (c *testClient) subscribe(id, destination string) {
+	c.send(newFrame(commandSubscribe, headerID, id, headerDestination, destination, headerReceipt, "sub-"+id))
+
+	receipt := c.receive()
+	require.Equal(c.t, commandReceipt, receipt.command)
+	require.Equal(c.t, "sub-"+id, receipt.headers[headerReceiptID])
+}
+
+func TestServer_Connect(t *testing.T) {
+	url, _, _ := startServer(t)
+
+	var testCases = []struct {
+		name            string
+		connect         frame
+		expectedMessage string
+	}{
+		{
+			name:            "Wrong password, error expected",
+			connect:         newFrame(commandConnect, headerAcceptVersion, "1.2", headerLogin, "alice", headerPasscode, "x"),
+			expectedMessage: "invalid credentials",
+		},
+		{
+			name:            "Authentication failure, error expected",
+			connect:         newFrame(commandConnect, headerAcceptVersion, "1.2", headerLogin, "broken", headerPasscode, "x"),
+			expectedMessage: "internal server error",
+		},
+		{
+			name:            "Unsupported version, error expected",
+			connect:         newFrame(commandConnect, headerAcceptVersion, "1.0,1.1", headerLogin, "alice"),
+			expectedMessage: "only STOMP 1.2 is supported",
+		},
+		{
+			name:            "Not a CONNECT frame, error expected",
+			connect:         newFrame(commandSend, headerDestination, "/topic/a"),
+			expectedMessage: "the first frame must be the only frame of its message, and must be CONNECT",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := dial(t, url)
+			client.send(tc.connect)
+
+			f := client.receive()
+			require.Equal(t, commandError, f.command)
+			require.Equal(t, tc.expectedMessage, f.headers[headerMessage])
+		})
+	}
+}
+
+func TestServer_HeartBeats(t *testing.T) {
+	url, _, _ := startServer(t)
+
+	client := dial(t, url)
+	client.send(newFrame(commandStomp, headerAcceptVersion, "1.2", headerLogin, "alice",
+		headerPasscode, "alice-password", headerHeartBeat, "0,1"))
+
+	// The server offers 10 seconds in both directions, which is slower than what the client asked for.
+	connected := client.receive()
+	require.Equal(t, commandConnected, connected.command)
+	require.Equal(t, "10000,10000", connected.headers[headerHeartBeat])
+
+	read, write, err := negotiateHeartBeats("0,1")
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), read)
+	require.Equal(t, heartBeatInterval, write)
+
+	read, write, err = negotiateHeartBeats("20000,0")
+	require.NoError(t, err)
+	require.Equal(t, 20*time.Second, read)
+	require.Equal(t, time.Duration(0), write)
+
+	_, _, err = negotiateHeartBeats("1")
+	require.Error(t, err)
+}
+
+func TestServer_DirectMessages(t *testing.T) {
+	url, _, server := startServer(t)
+
+	alice := connect(t, url, "alice")
+	bob := connect(t, url, "bob")
+	require.False(t, server.IsConnected("bob"))
+	bob.subscribe("inbox", userQueueDestination)
+	require.True(t, server.IsConnected("bob"))
+
+	send := newFrame(commandSend, headerDestination, userQueueDestination, headerReceivers, "bob",
+		headerContentType, "application/json", headerReceipt, "1")
+	send.body = []byte(`{"a":1}`)
+	alice.send(send)
+
+	message := bob.receive()
+	require.Equal(t, commandMessage, message.command)
+	require.Equal(t, "inbox", message.headers[headerSubscription])
+	require.Equal(t, userQueueDestination, message.headers[headerDestination])
+	require.Equal(t, "alice", message.headers[headerSender])
+	require.NotEmpty(t, message.headers[headerMessageID])
+	require.Equal(t, contentTypeJSON, message.headers[headerContentType])
+	require.Equal(t, `{"a":1}`, string(message.body))
+
+	receipt := alice.receive()
+	require.Equal(t, commandReceipt, receipt.command)
+	require.Equal(t, "1", receipt.headers[headerReceiptID])
+
+	// Invalid messages are reported with an ERROR frame carrying the receipt ID.
+	send = newFrame(commandSend, headerDestination, userQueueDestination, headerReceivers, "bob", headerReceipt, "2")
+	send.body = []byte("invalid")
+	alice.send(send)
+	failure := alice.receive()
+	require.Equal(t, commandError, failure.command)
+	require.Equal(t, "2", failure.headers[headerReceiptID])
+	require.Equal(t, "message is invalid", failure.headers[headerMessage])
+}
+
+func TestServer_Topics(t *testing.T) {
+	url, bus, _ := startServer(t)
+
+	require.NoError(t, bus.Retain(context.Background(),
+		protocol.MessageReceived{Sender: "bob", Topic: "status/bob", Message: "online"}))
+
+	alice := connect(t, url, "alice")
+	alice.subscribe("status", "/topic/status/*")
+
+	// The retained message follows the receipt.
+	retainedMessage := alice.receive()
+	require.Equal(t, commandMessage, retainedMessage.command)
+	require.Equal(t, "/topic/status/bob", retainedMessage.headers[headerDestination])
+	require.Equal(t, "true", retainedMessage.headers[headerRetained])
+	require.Equal(t, "online", string(retainedMessage.body))
+
+	bob := connect(t, url, "bob")
+	bob.send(newFrame(commandSend, headerDestination, "/topic/status/bob", headerContentType, "image/png"))
+
+	message := alice.receive()
+	require.Equal(t, commandMessage, message.command)
+	require.Equal(t, "status", message.headers[headerSubscription])
+	require.Equal(t, "image/png", message.headers[headerContentType])
+	require.Equal(t, "0", message.headers[headerContentLength])
+	require.Empty(t, message.headers[headerRetained])
+
+	// Alice is not allowed to subscribe to the private topics.
+	alice.send(newFrame(commandSubscribe, headerID, "private", headerDestination, "/topic/private/#"))
+	failure := alice.receive()
+	require.Equal(t, commandError, failure.command)
+	require.Equal(t, "not allowed to subscribe to this topic", failure.headers[headerMessage])
+}
+
+func TestServer_Scopes(t *testing.T) {
+	url, _, _ := startServer(t)
+
+	// The kiosk can subscribe, but not send. The notifier can publish, but not subscribe.
+	kiosk := connect(t, url, "kiosk")
+	kiosk.subscribe("status", "/topic/status/*")
+
+	notifier := connect(t, url, "notifier")
+	notifier.send(newFrame(commandSend, headerDestination, "/topic/status/notifier", headerReceipt, "1"))
+	require.Equal(t, commandReceipt, notifier.receive().command)
+
+	message := kiosk.receive()
+	require.Equal(t, commandMessage, message.command)
+	require.Equal(t, "/topic/status/notifier", message.headers[headerDestination])
+
+	// Errors close the connections, so they come last.
+	kiosk.send(newFrame(commandSend, headerDestination, userQueueDestination, headerReceivers, "bob"))
+	failure := kiosk.receive()
+	require.Equal(t, commandError, failure.command)
+	require.Equal(t, "missing scope: send", failure.headers[headerMessage])
+
+	notifier.send(newFrame(commandSubscribe, headerID, "status", headerDestination, "/topic/status/*"))
+	failure = notifier.receive()
+	require.Equal(t, commandError, failure.command)
+	require.Equal(t, "not allowed to subscribe to this topic", failure.headers[headerMessage])
+}
+
+func TestServer_Unsupported(t *testing.T) {
+	url, _, _ := startServer(t)
+
+	var testCases = []struct {
+		name            string
+		frame           frame
+		expectedMessage string
+	}{
+		{
+			name:            "Transaction",
+			frame:           newFrame(commandBegin, "transaction", "tx1"),
+			expectedMessage: "BEGIN is not supported",
+		},
+		{
+			name:            "Client ack mode",
+			frame:           newFrame(commandSubscribe, headerID, "0", headerDestination, "/topic/a", headerAck, "client"),
+			expectedMessage: "only the auto ack mode is supported",
+		},
+		{
+			name:            "Unknown destination",
+			frame:           newFrame(commandSubscribe, headerID, "0", headerDestination, "/queue/a"),
+			expectedMessage: "unknown destination",
+		},
+		{
+			name:            "Invalid topic filter",
+			frame:           newFrame(commandSubscribe, headerID, "0", headerDestination, "/topic/a/#/b"),
+			expectedMessage: "the # wildcard must be the last topic level",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := connect(t, url, "alice")
+			client.send(tc.frame)
+
+			failure := client.receive()
+			require.Equal(t, commandError, failure.command)
+			require.Equal(t, tc.expectedMessage, failure.headers[headerMessage])
+		})
+	}
+}
+
+func TestServer_Disconnect(t *testing.T) {
+	url, _, _ := startServer(t)
+
+	client := connect(t, url, "alice")
+	client.send(newFrame(commandDisconnect, headerReceipt, "bye"))
+
+	receipt := client.receive()
+	require.Equal(t, commandReceipt, receipt.command)
+	require.Equal(t, "bye", receipt.headers[headerReceiptID])
+
+	// The server closes the connection after the receipt.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, _, err := client.conn.Read(ctx)
+	require.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(err))
+}
+
+func TestServer_Close(t *testing.T) {
+	url, _, server := startServer(t)
+	client := connect(t, url, "alice")
+	require.Equal(t, 1, server.ConnectionCount())
+
+	// The client must keep reading for the close handshake to finish.
+	readErr := make(chan error, 1)
+	go func() {
+		_, _, err := client.conn.Read(context.Background())
+		readErr <- err
+	}()
+
+	require.NoError(t, server.Close(context.Background()))
+	require.Equal(t, websocket.StatusGoingAway, websocket.CloseStatus(<-readErr))
+	require.Zero(t, server.ConnectionCount())
+
+	// New connections are refused.
+	_, resp, err := websocket.Dial(context.Background(), url, &websocket.DialOptions{Subprotocols: []string{Subprotocol}})
+	require.Error(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestServer_Close_Deadline(t *testing.T) {
+	url, _, server := startServer(t)
+
+	// This client never reads, so it never completes the close handshake.
+	connect(t, url, "alice")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	require.ErrorIs(t, server.Close(ctx), context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestRequested(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/connect", nil)
+	require.False(t, Requested(r))
+
+	r.Header.Set("Sec-WebSocket-Protocol", "v10.stomp, V12.STOMP")
+	require.True(t, Requested(r))
+}
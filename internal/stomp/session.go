@@ -0,0 +1,500 @@
+package stomp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/internal/version"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+
+	"github.com/coder/websocket"
+)
+
+const (
+	// connectTimeout is the max time allowed for a client to send CONNECT after the upgrade.
+	connectTimeout = 10 * time.Second
+	// writeTimeout is the max time allowed for writing a frame.
+	writeTimeout = 5 * time.Second
+	// sendTimeout is the max time allowed for delivering a message sent by the client.
+	sendTimeout = 5 * time.Second
+
+	// heartBeatInterval is the heart-beat interval that the server offers in both directions.
+	heartBeatInterval = 10 * time.Second
+	// heartBeatTolerance is how late the heart-beats of a client can be before the connection is closed.
+	heartBeatTolerance = 2
+
+	// maxSubscriptions is the max number of subscriptions that a client can have at once.
+	maxSubscriptions = 100
+)
+
+// Destinations.
+const (
+	// userQueueDestination is where the messages sent to the user are delivered, and where direct messages are sent.
+	userQueueDestination = "/user/queue/messages"
+	// topicDestinationPrefix is prefixed to topic names and filters to make destinations.
+	topicDestinationPrefix = "/topic/"
+)
+
+// Content types used when converting messages into frames.
+const (
+	contentTypeText = "text/plain;charset=utf-8"
+	contentTypeJSON = "application/json"
+	// contentTypeMessage is used for messages that combine text, payload, binary data or attachments. The body is
+	// the JSON encoding of the MessageReceived event body.
+	contentTypeMessage = "application/vnd.rosenbridge.message+json"
+)
+
+// errClientError wraps the errors that are reported to the client with an ERROR frame.
+var errClientError = errors.New("client error")
+
+// clientError returns an error that is reported to the client with the given message.
+func clientError(message string) error {
+	return fmt.Errorf("%w: %s", errClientError, message)
+}
+
+// session is the state of one STOMP connection.
+type session struct {
+	server *Server
+	conn   *websocket.Conn
+	// drop closes the connection without the close handshake, by canceling the context of the reads.
+	drop context.CancelFunc
+
+	// username and scopes are set after CONNECT.
+	username string
+	scopes   scope.Set
+
+	// mutex guards subscriptions.
+	mutex sync.RWMutex
+	// subscriptions maps the subscription IDs to their destinations.
+	subscriptions map[string]string
+
+	// messageCounter is used to generate message IDs.
+	messageCounter atomic.Uint64
+}
+
+func newSession(server *Server, conn *websocket.Conn, drop context.CancelFunc) *session {
+	return &session{server: server, conn: conn, drop: drop, subscriptions: map[string]string{}}
+}
+
+// serve handles the connection until it is closed. It is a blocking call.
+//
+// The upgrade request is used for the fallback credentials. The reads use the connCtx, whose cancellation closes the
+// connection.
+func (s *session) serve(ctx, connCtx context.Context, r *http.Request) {
+	// When this function returns, the connection is most likely already closed.
+	// This is just for additional safety.
+	defer func() { _ = s.conn.Close(websocket.StatusNormalClosure, "") }()
+
+	connectCtx, cancelConnect := context.WithTimeout(connCtx, connectTimeout)
+	_, data, err := s.conn.Read(connectCtx)
+	cancelConnect()
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to read stomp connect frame", "error", err)
+		return
+	}
+
+	readInterval, writeInterval, err := s.connect(ctx, r, data)
+	if err != nil {
+		s.fail(ctx, err, "")
+		return
+	}
+
+	slog.InfoContext(ctx, "stomp client connected", "username", s.username)
+
+	// The heart-beats stop with the connection.
+	heartBeatCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if writeInterval > 0 {
+		go s.sendHeartBeats(heartBeatCtx, writeInterval)
+	}
+
+	for {
+		readCtx, cancelRead := connCtx, context.CancelFunc(func() {})
+		if readInterval > 0 {
+			// The connection is closed by the websocket library if the read times out.
+			readCtx, cancelRead = context.WithTimeout(readCtx, readInterval*heartBeatTolerance)
+		}
+
+		_, data, err := s.conn.Read(readCtx)
+		cancelRead()
+		if err != nil {
+			// Browsers close with StatusGoingAway when the page is left, and so does Server.Close.
+			if status := websocket.CloseStatus(err); status == websocket.StatusNormalClosure ||
+				status == websocket.StatusGoingAway {
+				slog.InfoContext(ctx, "stomp connection closed normally", "username", s.username)
+			} else {
+				slog.ErrorContext(ctx, "stomp connection read error", "username", s.username, "error", err)
+			}
+			return
+		}
+
+		frames, err := parseFrames(data)
+		if err != nil {
+			s.fail(ctx, clientError(err.Error()), "")
+			return
+		}
+
+		for _, f := range frames {
+			if err := s.handle(ctx, f); err != nil {
+				if errors.Is(err, errDisconnect) {
+					slog.InfoContext(ctx, "stomp client disconnected", "username", s.username)
+				} else {
+					s.fail(ctx, err, f.headers[headerReceipt])
+				}
+				return
+			}
+		}
+	}
+}
+
+// connect processes the first message of the connection, which must be the CONNECT frame. It returns the negotiated
+// heart-beat intervals for reading and writing. Zero means no heart-beats.
+func (s *session) connect(ctx context.Context, r *http.Request, data []byte) (time.Duration, time.Duration, error) {
+	frames, err := parseFrames(data)
+	if err != nil {
+		return 0, 0, clientError(err.Error())
+	}
+
+	if len(frames) != 1 || (frames[0].command != commandConnect && frames[0].command != commandStomp) {
+		return 0, 0, clientError("the first frame must be the only frame of its message, and must be CONNECT")
+	}
+
+	connect := frames[0]
+
+	versions := strings.Split(connect.headers[headerAcceptVersion], ",")
+	if !slices.Contains(versions, "1.2") {
+		return 0, 0, clientError("only STOMP 1.2 is supported")
+	}
+
+	username, password := connect.headers[headerLogin], connect.headers[headerPasscode]
+	if _, exists := connect.headers[headerLogin]; !exists {
+		username, password, _ = r.BasicAuth()
+	}
+
+	scopes, ok, err := s.server.handler.Authenticate(ctx, username, password)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to authenticate: %w", err)
+	}
+	if !ok {
+		slog.ErrorContext(ctx, "stomp authentication failed", "username", username)
+		return 0, 0, clientError("invalid credentials")
+	}
+
+	s.username, s.scopes = username, scopes
+
+	readInterval, writeInterval, err := negotiateHeartBeats(connect.headers[headerHeartBeat])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	intervalMillis := strconv.FormatInt(heartBeatInterval.Milliseconds(), 10)
+	connected := newFrame(commandConnected,
+		headerVersion, "1.2",
+		headerHeartBeat, intervalMillis+","+intervalMillis,
+		headerServer, "rosenbridge/"+version.Version,
+		headerUserName, s.username,
+	)
+
+	if err := s.write(ctx, connected); err != nil {
+		return 0, 0, err
+	}
+
+	return readInterval, writeInterval, nil
+}
+
+// negotiateHeartBeats returns the read and write heart-beat intervals as per the heart-beat header of the client.
+func negotiateHeartBeats(header string) (time.Duration, time.Duration, error) {
+	if header == "" {
+		return 0, 0, nil
+	}
+
+	clientWrite, clientRead, found := strings.Cut(header, ",")
+	clientWriteMillis, err1 := strconv.ParseUint(clientWrite, 10, 32)
+	clientReadMillis, err2 := strconv.ParseUint(clientRead, 10, 32)
+	if !found || err1 != nil || err2 != nil {
+		return 0, 0, clientError("invalid heart-beat header")
+	}
+
+	// As per the spec, heart-beats are only used in a direction if both sides want them, and at the slower rate.
+	negotiate := func(millis uint64) time.Duration {
+		if millis == 0 {
+			return 0
+		}
+		return max(time.Duration(millis)*time.Millisecond, heartBeatInterval)
+	}
+
+	return negotiate(clientWriteMillis), negotiate(clientReadMillis), nil
+}
+
+// sendHeartBeats sends an EOL at the given interval until the context is canceled.
+func (s *session) sendHeartBeats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			writeCtx, cancel := context.WithTimeout(ctx, writeTimeout)
+			err := s.conn.Write(writeCtx, websocket.MessageText, []byte("\n"))
+			cancel()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// errDisconnect is returned by handle when the client sends DISCONNECT.
+var errDisconnect = errors.New("disconnect")
+
+// handle processes one frame sent by the client. If it returns an error, the connection must be closed.
+func (s *session) handle(ctx context.Context, f frame) error {
+	var err error
+
+	switch f.command {
+	case commandSend:
+		err = s.handleSend(ctx, f)
+	case commandSubscribe:
+		err = s.handleSubscribe(ctx, f)
+	case commandUnsubscribe:
+		err = s.handleUnsubscribe(f)
+	case commandDisconnect:
+		err = errDisconnect
+	case commandConnect, commandStomp:
+		err = clientError("already connected")
+	case commandAck, commandNack, commandBegin, commandCommit, commandAbort:
+		err = clientError(f.command + " is not supported")
+	default:
+		err = clientError("unknown command " + f.command)
+	}
+
+	if err != nil && !errors.Is(err, errDisconnect) {
+		return err
+	}
+
+	if receipt, exists := f.headers[headerReceipt]; exists {
+		if err := s.write(ctx, newFrame(commandReceipt, headerReceiptID, receipt)); err != nil {
+			return err
+		}
+	}
+
+	// Retained messages follow the receipt of the subscription.
+	if f.command == commandSubscribe {
+		s.sendRetained(ctx, f.headers[headerID])
+	}
+
+	return err
+}
+
+// handleSend processes a SEND frame.
+func (s *session) handleSend(ctx context.Context, f frame) error {
+	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	message := toMessage(s.username, f)
+	destination := f.headers[headerDestination]
+
+	var err error
+	switch {
+	case destination == userQueueDestination:
+		var receivers []string
+		if header := f.headers[headerReceivers]; header != "" {
+			receivers = strings.Split(header, ",")
+		}
+		err = s.server.handler.SendMessage(sendCtx, message, s.scopes, receivers)
+	case strings.HasPrefix(destination, topicDestinationPrefix):
+		message.Topic = strings.TrimPrefix(destination, topicDestinationPrefix)
+		err = s.server.handler.Publish(sendCtx, message, s.scopes)
+	default:
+		return clientError("unknown destination")
+	}
+
+	if err != nil {
+		return clientError(err.Error())
+	}
+
+	return nil
+}
+
+// handleSubscribe processes a SUBSCRIBE frame. The retained messages are sent separately, by sendRetained.
+func (s *session) handleSubscribe(_ context.Context, f frame) error {
+	id, destination := f.headers[headerID], f.headers[headerDestination]
+	if id == "" {
+		return clientError("missing id header")
+	}
+
+	if ack := f.headers[headerAck]; ack != "" && ack != "auto" {
+		return clientError("only the auto ack mode is supported")
+	}
+
+	if destination != userQueueDestination {
+		filter, found := strings.CutPrefix(destination, topicDestinationPrefix)
+		if !found {
+			return clientError("unknown destination")
+		}
+		if err := topic.ValidateFilter(filter); err != nil {
+			return clientError(err.Error())
+		}
+		if !s.server.broker.CanSubscribe(s.username, s.scopes, filter) {
+			return clientError("not allowed to subscribe to this topic")
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.subscriptions[id]; exists {
+		return clientError("subscription id already in use")
+	}
+
+	if len(s.subscriptions) >= maxSubscriptions {
+		return clientError(fmt.Sprintf("cannot subscribe more than %d times", maxSubscriptions))
+	}
+
+	s.subscriptions[id] = destination
+	return nil
+}
+
+// handleUnsubscribe processes an UNSUBSCRIBE frame.
+func (s *session) handleUnsubscribe(f frame) error {
+	id := f.headers[headerID]
+	if id == "" {
+		return clientError("missing id header")
+	}
+
+	s.mutex.Lock()
+	delete(s.subscriptions, id)
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// sendRetained sends the retained messages of the given topic subscription.
+// The subscription remains active even if retained messages cannot be delivered.
+func (s *session) sendRetained(ctx context.Context, id string) {
+	s.mutex.RLock()
+	destination := s.subscriptions[id]
+	s.mutex.RUnlock()
+
+	filter, found := strings.CutPrefix(destination, topicDestinationPrefix)
+	if !found {
+		return
+	}
+
+	messages, err := s.server.broker.Retained(ctx, s.username, s.scopes, filter)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get retained messages", "username", s.username, "error", err)
+		return
+	}
+
+	for _, message := range messages {
+		if err := s.sendMessage(ctx, id, message); err != nil {
+			slog.ErrorContext(ctx, "failed to send retained message", "username", s.username, "error", err)
+			return
+		}
+	}
+}
+
+// userQueueSubscriptions returns the IDs of the subscriptions to the user queue.
+func (s *session) userQueueSubscriptions() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var ids []string
+	for id, destination := range s.subscriptions {
+		if destination == userQueueDestination {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// topicSubscriptions returns the IDs of the subscriptions whose topic filter matches the given topic name.
+func (s *session) topicSubscriptions(name string) []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var ids []string
+	for id, destination := range s.subscriptions {
+		filter, found := strings.CutPrefix(destination, topicDestinationPrefix)
+		if found && topic.Match(filter, name) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// sendMessage sends a MESSAGE frame for the given subscription.
+func (s *session) sendMessage(ctx context.Context, subscriptionID string, message protocol.MessageReceived) error {
+	f, err := toFrame(message)
+	if err != nil {
+		return err
+	}
+
+	destination := userQueueDestination
+	if message.Topic != "" {
+		destination = topicDestinationPrefix + message.Topic
+	}
+
+	f.headers[headerSubscription] = subscriptionID
+	f.headers[headerDestination] = destination
+	f.headers[headerMessageID] = strconv.FormatUint(s.messageCounter.Add(1), 10)
+
+	return s.write(ctx, f)
+}
+
+// fail reports the error to the client with an ERROR frame, and closes the connection, as required by the spec.
+// Only client errors are reported in detail.
+func (s *session) fail(ctx context.Context, err error, receipt string) {
+	message := "internal server error"
+	if errors.Is(err, errClientError) {
+		message = strings.TrimPrefix(err.Error(), errClientError.Error()+": ")
+	}
+
+	slog.ErrorContext(ctx, "closing stomp connection", "username", s.username, "error", err)
+
+	f := newFrame(commandError, headerMessage, message, headerContentType, contentTypeText)
+	if receipt != "" {
+		f.headers[headerReceiptID] = receipt
+	}
+	f.body = []byte(message)
+
+	if err := s.write(ctx, f); err != nil {
+		slog.ErrorContext(ctx, "failed to send stomp error frame", "username", s.username, "error", err)
+	}
+
+	_ = s.conn.Close(websocket.StatusNormalClosure, "")
+}
+
+// write writes a frame to the connection. Frames with a body that is not valid UTF-8 are sent as binary messages.
+func (s *session) write(ctx context.Context, f frame) error {
+	writeCtx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	messageType := websocket.MessageText
+	if !utf8.Valid(f.body) {
+		messageType = websocket.MessageBinary
+	}
+
+	if err := s.conn.Write(writeCtx, messageType, f.encode()); err != nil {
+		return fmt.Errorf("failed to write frame: %w", err)
+	}
+
+	return nil
+}
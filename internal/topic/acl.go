@@ -0,0 +1,75 @@
+package topic
+
+import (
+	"fmt"
+	"slices"
+)
+
+// AnyUser can be used in the user lists of a Rule to allow all users.
+const AnyUser = "*"
+
+// Rule grants access to the topics matched by its filter.
+type Rule struct {
+	// Topic is the filter that selects the topics this rule applies to.
+	Topic string `json:"topic"`
+	// Publishers are the users allowed to publish to the topics. AnyUser allows all users.
+	Publishers []string `json:"publishers"`
+	// Subscribers are the users allowed to subscribe to the topics. AnyUser allows all users.
+	Subscribers []string `json:"subscribers"`
+}
+
+// ACL decides who can publish and subscribe to which topics.
+//
+// Rules are checked in order, and only the first rule that applies to a topic is used. If no rule applies, access is
+// denied. So, more specific rules must come before more general ones.
+//
+// A nil ACL denies everything.
+type ACL struct {
+	rules []Rule
+}
+
+// NewACL returns a new ACL with the given rules. It returns an error if any rule has an invalid topic filter.
+func NewACL(rules []Rule) (*ACL, error) {
+	for i, rule := range rules {
+		if err := ValidateFilter(rule.Topic); err != nil {
+			return nil, fmt.Errorf("invalid topic in rule %d: %w", i+1, err)
+		}
+	}
+
+	return &ACL{rules: slices.Clone(rules)}, nil
+}
+
+// CanPublish reports whether the user can publish to the given topic name.
+func (a *ACL) CanPublish(username, name string) bool {
+	rule, exists := a.ruleFor(name)
+	return exists && allows(rule.Publishers, username)
+}
+
+// CanSubscribe reports whether the user can subscribe to the given filter. The filter can also be a plain topic name.
+//
+// A filter is allowed if the first rule that covers all of its topics allows it. Even then, a more specific rule may
+// deny some of those topics, so CanSubscribe must also be checked for the topic name of every published message.
+func (a *ACL) CanSubscribe(username, filter string) bool {
+	rule, exists := a.ruleFor(filter)
+	return exists && allows(rule.Subscribers, username)
+}
+
+// ruleFor returns the first rule that covers the given filter.
+func (a *ACL) ruleFor(filter string) (Rule, bool) {
+	if a == nil {
+		return Rule{}, false
+	}
+
+	for _, rule := range a.rules {
+		if Covers(rule.Topic, filter) {
+			return rule, true
+		}
+	}
+
+	return Rule{}, false
+}
+
+// allows reports whether the given user list contains the user or AnyUser.
+func allows(users []string, username string) bool {
+	return slices.Contains(users, AnyUser) || slices.Contains(users, username)
+}
@@ -0,0 +1,59 @@
+package topic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewACL(t *testing.T) {
+	_, err := NewACL([]Rule{{Topic: "a/#"}, {Topic: "a/#/b"}})
+	require.ErrorIs(t, err, errMultiLevelEnd)
+	require.ErrorContains(t, err, "rule 2")
+
+	_, err = NewACL(nil)
+	require.NoError(t, err)
+}
+
+func TestACL(t *testing.T) {
+	acl, err := NewACL([]Rule{
+		{Topic: "alerts/#", Publishers: []string{"admin"}, Subscribers: []string{AnyUser}},
+		{Topic: "chat/private", Publishers: []string{"alice"}, Subscribers: []string{"bob"}},
+		{Topic: "chat/*", Publishers: []string{AnyUser}, Subscribers: []string{AnyUser}},
+	})
+	require.NoError(t, err)
+
+	var testCases = []struct {
+		name              string
+		username          string
+		topic             string
+		isFilter          bool
+		expectedPublish   bool
+		expectedSubscribe bool
+	}{
+		{name: "Allowed subscriber only", username: "bob", topic: "alerts/fire", expectedSubscribe: true},
+		{name: "Allowed publisher", username: "admin", topic: "alerts/fire", expectedPublish: true, expectedSubscribe: true},
+		{name: "Specific rule first", username: "carol", topic: "chat/private"},
+		{name: "Specific rule allows", username: "bob", topic: "chat/private", expectedSubscribe: true},
+		{name: "General rule", username: "carol", topic: "chat/general", expectedPublish: true, expectedSubscribe: true},
+		{name: "Filter covered by general rule", username: "carol", topic: "chat/*", isFilter: true, expectedSubscribe: true},
+		{name: "Filter not covered by any rule", username: "carol", topic: "chat/#", isFilter: true},
+		{name: "No rule", username: "admin", topic: "news"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Filters cannot be published to.
+			if !tc.isFilter {
+				require.Equal(t, tc.expectedPublish, acl.CanPublish(tc.username, tc.topic))
+			}
+			require.Equal(t, tc.expectedSubscribe, acl.CanSubscribe(tc.username, tc.topic))
+		})
+	}
+}
+
+func TestACL_Nil(t *testing.T) {
+	var acl *ACL
+	require.False(t, acl.CanPublish("alice", "a"))
+	require.False(t, acl.CanSubscribe("alice", "#"))
+}
@@ -0,0 +1,114 @@
+// Package topic implements hierarchical topic names, wildcard filters and the access rules of topics.
+//
+// A topic name is a list of levels separated by "/", like "sensors/kitchen/temperature". A filter is a topic name in
+// which a level can also be a wildcard:
+//   - "*" matches exactly one level, for example, "sensors/*/temperature".
+//   - "#" matches any number of levels, including zero. It is only allowed as the last level, for example, "sensors/#".
+package topic
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Separator separates the levels of a topic name.
+	Separator = "/"
+	// SingleLevelWildcard matches exactly one level.
+	SingleLevelWildcard = "*"
+	// MultiLevelWildcard matches any number of trailing levels.
+	MultiLevelWildcard = "#"
+
+	nameMaxLength  = 255
+	levelsMaxCount = 16
+)
+
+// levelPattern matches all valid non-wildcard levels.
+var levelPattern = regexp.MustCompile("^[a-zA-Z0-9_.-]+$")
+
+var (
+	errNameEmpty     = errors.New("topic must not be empty")
+	errNameLength    = fmt.Errorf("topic must not be longer than %d characters", nameMaxLength)
+	errLevelsTooMany = fmt.Errorf("topic must have at most %d levels", levelsMaxCount)
+	errLevelPattern  = errors.New("each topic level must only contain letters, numbers, dots, hyphens, and underscores")
+	errNameWildcard  = errors.New("topic must not contain wildcards")
+	errMultiLevelEnd = errors.New("the # wildcard must be the last topic level")
+)
+
+// ValidateName validates a topic name. Names cannot contain wildcards.
+func ValidateName(name string) error {
+	return validate(name, false)
+}
+
+// ValidateFilter validates a topic filter. Filters can contain wildcards.
+func ValidateFilter(filter string) error {
+	return validate(filter, true)
+}
+
+// Match reports whether the given topic name matches the given filter. Both are expected to be valid.
+func Match(filter, name string) bool {
+	return Covers(filter, name)
+}
+
+// Covers reports whether every topic matched by the inner filter is also matched by the outer filter.
+// Both are expected to be valid. If the inner filter is a plain topic name, this is the same as Match.
+func Covers(outer, inner string) bool {
+	outerLevels := strings.Split(outer, Separator)
+	innerLevels := strings.Split(inner, Separator)
+
+	for i, outerLevel := range outerLevels {
+		if outerLevel == MultiLevelWildcard {
+			return true
+		}
+		if i >= len(innerLevels) {
+			return false
+		}
+
+		innerLevel := innerLevels[i]
+		// Only # can cover #, and only wildcards can cover *.
+		if innerLevel == MultiLevelWildcard {
+			return false
+		}
+		if outerLevel != SingleLevelWildcard && outerLevel != innerLevel {
+			return false
+		}
+	}
+
+	return len(outerLevels) == len(innerLevels)
+}
+
+// validate validates a topic name or filter.
+func validate(topic string, allowWildcards bool) error {
+	if topic == "" {
+		return errNameEmpty
+	}
+
+	if len(topic) > nameMaxLength {
+		return errNameLength
+	}
+
+	levels := strings.Split(topic, Separator)
+	if len(levels) > levelsMaxCount {
+		return errLevelsTooMany
+	}
+
+	for i, level := range levels {
+		if level == SingleLevelWildcard || level == MultiLevelWildcard {
+			if !allowWildcards {
+				return errNameWildcard
+			}
+			if level == MultiLevelWildcard && i != len(levels)-1 {
+				return errMultiLevelEnd
+			}
+			continue
+		}
+
+		if !levelPattern.MatchString(level) {
+			return errLevelPattern
+		}
+	}
+
+	return nil
+}
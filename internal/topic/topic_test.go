@@ -0,0 +1,104 @@
+package topic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	var testCases = []struct {
+		name              string
+		topic             string
+		expectedNameErr   error
+		expectedFilterErr error
+	}{
+		{name: "Single level", topic: "news"},
+		{name: "Multiple levels", topic: "sensors/kitchen/temp-1.5_c"},
+		{name: "Empty", topic: "", expectedNameErr: errNameEmpty, expectedFilterErr: errNameEmpty},
+		{
+			name:              "Too long",
+			topic:             strings.Repeat("x", nameMaxLength+1),
+			expectedNameErr:   errNameLength,
+			expectedFilterErr: errNameLength,
+		},
+		{
+			name:              "Too many levels",
+			topic:             strings.Repeat("x/", levelsMaxCount) + "x",
+			expectedNameErr:   errLevelsTooMany,
+			expectedFilterErr: errLevelsTooMany,
+		},
+		{name: "Empty level", topic: "a//b", expectedNameErr: errLevelPattern, expectedFilterErr: errLevelPattern},
+		{name: "Leading separator", topic: "/a", expectedNameErr: errLevelPattern, expectedFilterErr: errLevelPattern},
+		{name: "Invalid character", topic: "a/b c", expectedNameErr: errLevelPattern, expectedFilterErr: errLevelPattern},
+		{name: "Partial wildcard", topic: "a/b*", expectedNameErr: errLevelPattern, expectedFilterErr: errLevelPattern},
+		{name: "Single level wildcard", topic: "a/*/c", expectedNameErr: errNameWildcard},
+		{name: "Multi level wildcard", topic: "a/#", expectedNameErr: errNameWildcard},
+		{name: "Only multi level wildcard", topic: "#", expectedNameErr: errNameWildcard},
+		{
+			name:              "Multi level wildcard not at the end",
+			topic:             "a/#/c",
+			expectedNameErr:   errNameWildcard,
+			expectedFilterErr: errMultiLevelEnd,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.ErrorIs(t, ValidateName(tc.topic), tc.expectedNameErr)
+			require.ErrorIs(t, ValidateFilter(tc.topic), tc.expectedFilterErr)
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	var testCases = []struct {
+		filter   string
+		name     string
+		expected bool
+	}{
+		{filter: "a/b", name: "a/b", expected: true},
+		{filter: "a/b", name: "a/c", expected: false},
+		{filter: "a/b", name: "a/b/c", expected: false},
+		{filter: "a/b/c", name: "a/b", expected: false},
+		{filter: "a/*", name: "a/b", expected: true},
+		{filter: "a/*", name: "a", expected: false},
+		{filter: "a/*", name: "a/b/c", expected: false},
+		{filter: "*/b/*", name: "a/b/c", expected: true},
+		{filter: "a/#", name: "a", expected: true},
+		{filter: "a/#", name: "a/b", expected: true},
+		{filter: "a/#", name: "a/b/c", expected: true},
+		{filter: "a/#", name: "b/a", expected: false},
+		{filter: "#", name: "a/b/c", expected: true},
+		{filter: "*/#", name: "a", expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.filter+" - "+tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, Match(tc.filter, tc.name))
+		})
+	}
+}
+
+func TestCovers(t *testing.T) {
+	var testCases = []struct {
+		outer    string
+		inner    string
+		expected bool
+	}{
+		{outer: "#", inner: "a/#", expected: true},
+		{outer: "a/#", inner: "a/*/c", expected: true},
+		{outer: "a/#", inner: "#", expected: false},
+		{outer: "a/*", inner: "a/*", expected: true},
+		{outer: "a/*", inner: "a/#", expected: false},
+		{outer: "a/b", inner: "a/*", expected: false},
+		{outer: "*/*", inner: "a/b", expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.outer+" - "+tc.inner, func(t *testing.T) {
+			require.Equal(t, tc.expected, Covers(tc.outer, tc.inner))
+		})
+	}
+}
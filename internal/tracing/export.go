@@ -0,0 +1,334 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/version"
+)
+
+// tracesPath is the path of the OTLP/HTTP traces endpoint, relative to the collector URL.
+const tracesPath = "/v1/traces"
+
+// exporter is the running Exporter, if any.
+var exporter atomic.Pointer[Exporter]
+
+// current returns the running Exporter, or nil.
+func current() *Exporter {
+	return exporter.Load()
+}
+
+// ExporterOptions configure an Exporter. Zero values mean the defaults.
+type ExporterOptions struct {
+	// Endpoint is the base URL of the OTLP/HTTP collector, like "http://localhost:4318". Spans are POSTed to its
+	// /v1/traces path.
+	Endpoint string
+	// ServiceName is the service.name resource attribute of the spans. Defaults to "rosenbridge".
+	ServiceName string
+	// BatchSize is the max number of spans per export. Defaults to 512.
+	BatchSize int
+	// QueueSize is the max number of ended spans waiting for export. Spans are dropped if it is full. Defaults to 4096.
+	QueueSize int
+	// Interval is the max time that an ended span waits for its export. Defaults to 5 seconds.
+	Interval time.Duration
+	// Timeout is the max duration of an export. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// withDefaults returns the options with the defaults applied.
+func (o ExporterOptions) withDefaults() ExporterOptions {
+	if o.ServiceName == "" {
+		o.ServiceName = "rosenbridge"
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 512
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 4096
+	}
+	if o.Interval <= 0 {
+		o.Interval = 5 * time.Second
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	return o
+}
+
+// Exporter sends ended spans to a collector in batches.
+type Exporter struct {
+	options ExporterOptions
+	client  *http.Client
+
+	queue chan otlpSpan
+	// flush asks the export loop to export the queued spans right away. The loop closes the channel it receives once
+	// the export is done.
+	flush chan chan struct{}
+	done  chan struct{}
+
+	closeOnce sync.Once
+	stopped   chan struct{}
+
+	// dropped counts the spans that did not fit in the queue since the last warning.
+	dropped atomic.Int64
+}
+
+// StartExporter starts an Exporter, and makes it the one that spans are recorded with. Call Close to stop it.
+func StartExporter(options ExporterOptions) *Exporter {
+	options = options.withDefaults()
+
+	e := &Exporter{
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		queue:   make(chan otlpSpan, options.QueueSize),
+		flush:   make(chan chan struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go e.loop()
+	exporter.Store(e)
+	return e
+}
+
+// Flush exports the ended spans right away, and waits for the export to finish. It is meant for tests.
+func (e *Exporter) Flush() {
+	flushed := make(chan struct{})
+	select {
+	case e.flush <- flushed:
+		<-flushed
+	case <-e.stopped:
+	}
+}
+
+// Close exports the ended spans, and stops the Exporter. Spans that end later are not recorded.
+func (e *Exporter) Close() error {
+	e.closeOnce.Do(func() {
+		exporter.CompareAndSwap(e, nil)
+		close(e.done)
+		<-e.stopped
+	})
+	return nil
+}
+
+// enqueue adds the span to the queue without blocking. The span is dropped if the queue is full, as tracing must not
+// slow down the work it records.
+func (e *Exporter) enqueue(span otlpSpan) {
+	select {
+	case e.queue <- span:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// loop exports the queued spans in batches, when a batch is full or when the interval elapses, until Close is called.
+func (e *Exporter) loop() {
+	defer close(e.stopped)
+
+	ticker := time.NewTicker(e.options.Interval)
+	defer ticker.Stop()
+
+	batch := make([]otlpSpan, 0, e.options.BatchSize)
+	export := func() {
+		if dropped := e.dropped.Swap(0); dropped > 0 {
+			slog.Warn("trace spans dropped because the queue is full", "count", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			slog.Error("failed to export trace spans", "count", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+
+	// drain moves the queued spans into batches, exporting the full ones.
+	drain := func() {
+		for {
+			select {
+			case span := <-e.queue:
+				batch = append(batch, span)
+				if len(batch) >= e.options.BatchSize {
+					export()
+				}
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= e.options.BatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-e.flush:
+			drain()
+			export()
+			close(flushed)
+		case <-e.done:
+			drain()
+			export()
+			return
+		}
+	}
+}
+
+// export POSTs the spans to the collector.
+func (e *Exporter) export(spans []otlpSpan) error {
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: attributeValue(e.options.ServiceName)},
+			{Key: "service.version", Value: attributeValue(version.Version)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/shivanshkc/rosenbridge", Version: version.Version},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return fmt.Errorf("failed to marshal spans: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.options.Timeout)
+	defer cancel()
+
+	url := strings.TrimSuffix(e.options.Endpoint, "/") + tracesPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// The following types are the JSON encoding of an OTLP ExportTraceServiceRequest. IDs are hex, and 64-bit integers
+// are strings, as per the OTLP/JSON spec.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	TraceState        string          `json:"traceState,omitempty"`
+	Name              string          `json:"name"`
+	Kind              Kind            `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+// otlpStatus codes.
+const (
+	statusUnset = 0
+	statusError = 2
+)
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// export returns the OTLP encoding of the span.
+func (s *Span) export(end time.Time) otlpSpan {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	span := otlpSpan{
+		TraceID:           s.context.TraceID.String(),
+		SpanID:            s.context.SpanID.String(),
+		TraceState:        s.context.TraceState,
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Status:            otlpStatus{Code: statusUnset},
+	}
+
+	if s.parent.IsValid() {
+		span.ParentSpanID = s.parent.String()
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(s.attributes)) {
+		span.Attributes = append(span.Attributes, otlpAttribute{Key: key, Value: attributeValue(s.attributes[key])})
+	}
+
+	if s.failed {
+		span.Status = otlpStatus{Code: statusError, Message: s.errMessage}
+	}
+
+	return span
+}
+
+// attributeValue returns the OTLP AnyValue of the value. Unknown types are formatted as strings.
+func attributeValue(value any) map[string]any {
+	switch value := value.(type) {
+	case string:
+		return map[string]any{"stringValue": value}
+	case bool:
+		return map[string]any{"boolValue": value}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(value)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(value, 10)}
+	case float64:
+		return map[string]any{"doubleValue": value}
+	default:
+		return map[string]any{"stringValue": fmt.Sprint(value)}
+	}
+}
@@ -0,0 +1,149 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// The headers of W3C Trace Context.
+const (
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+)
+
+// flagSampled is the trace flag that tells that the caller may record the trace.
+const flagSampled = 0x01
+
+var errInvalidTraceParent = errors.New("invalid traceparent")
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// String returns the ID in lowercase hex, as used in headers and exports.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid reports whether the ID is not all zeros.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// String returns the ID in lowercase hex, as used in headers and exports.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid reports whether the ID is not all zeros.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext is the part of a span that is propagated across processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled reports whether the span is recorded.
+	Sampled bool
+	// TraceState is the vendor specific state from the tracestate header, passed along as is.
+	TraceState string
+}
+
+// IsValid reports whether both IDs are valid.
+func (s SpanContext) IsValid() bool {
+	return s.TraceID.IsValid() && s.SpanID.IsValid()
+}
+
+// TraceParent returns the value of the traceparent header for the span context.
+func (s SpanContext) TraceParent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID.String() + "-" + s.SpanID.String() + "-" + flags
+}
+
+// ParseTraceParent parses the value of a traceparent header, as per the W3C Trace Context spec. Headers of future
+// versions are parsed as far as version 00 goes.
+func ParseTraceParent(value string) (SpanContext, error) {
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	const length = 2 + 1 + 32 + 1 + 16 + 1 + 2
+
+	if len(value) < length || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return SpanContext{}, errInvalidTraceParent
+	}
+
+	version, ok := decodeHex(value[:2], 1)
+	if !ok || version[0] == 0xff {
+		return SpanContext{}, errInvalidTraceParent
+	}
+	// Only future versions may be longer, and what follows must be separated.
+	if (version[0] == 0 && len(value) != length) || (len(value) > length && value[length] != '-') {
+		return SpanContext{}, errInvalidTraceParent
+	}
+
+	traceID, ok := decodeHex(value[3:35], 16)
+	if !ok {
+		return SpanContext{}, errInvalidTraceParent
+	}
+	spanID, ok := decodeHex(value[36:52], 8)
+	if !ok {
+		return SpanContext{}, errInvalidTraceParent
+	}
+	flags, ok := decodeHex(value[53:55], 1)
+	if !ok {
+		return SpanContext{}, errInvalidTraceParent
+	}
+
+	spanContext := SpanContext{
+		TraceID: TraceID(traceID),
+		SpanID:  SpanID(spanID),
+		Sampled: flags[0]&flagSampled != 0,
+	}
+	if !spanContext.IsValid() {
+		return SpanContext{}, errInvalidTraceParent
+	}
+
+	return spanContext, nil
+}
+
+// decodeHex decodes lowercase hex of the given byte length. Uppercase hex is not allowed by the spec.
+func decodeHex(value string, size int) ([]byte, bool) {
+	if len(value) != 2*size || strings.ToLower(value) != value {
+		return nil, false
+	}
+
+	decoded, err := hex.DecodeString(value)
+	return decoded, err == nil
+}
+
+// Extract returns a context with the span context of the traceparent and tracestate headers as the remote parent of
+// the spans started with it. Invalid headers are ignored, in which case a new trace is started, as the spec requires.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	spanContext, err := ParseTraceParent(header.Get(HeaderTraceParent))
+	if err != nil {
+		return ctx
+	}
+
+	spanContext.TraceState = header.Get(HeaderTraceState)
+	return ContextWithRemote(ctx, spanContext)
+}
+
+// Inject sets the traceparent and tracestate headers to the span context of the context, if any.
+func Inject(ctx context.Context, header http.Header) {
+	spanContext := SpanContextFrom(ctx)
+	if !spanContext.IsValid() {
+		return
+	}
+
+	header.Set(HeaderTraceParent, spanContext.TraceParent())
+	if spanContext.TraceState != "" {
+		header.Set(HeaderTraceState, spanContext.TraceState)
+	}
+}
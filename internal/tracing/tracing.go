@@ -0,0 +1,170 @@
+// Package tracing records spans, propagates them with the W3C Trace Context headers, and exports them to an
+// OpenTelemetry collector over OTLP/HTTP with JSON encoding.
+//
+// It only implements what Rosenbridge needs, so that no OpenTelemetry SDK is required. Spans are recorded only if an
+// Exporter is running, and their trace is sampled. Otherwise, spans still carry IDs, so that traces stay connected
+// across services, and so that logs can name their trace.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// Kind tells the role of a span in a trace. The values are the ones of OTLP.
+type Kind int
+
+// The kinds of spans.
+const (
+	// KindInternal is for operations within Rosenbridge.
+	KindInternal Kind = 1
+	// KindServer is for handling a request from a client.
+	KindServer Kind = 2
+	// KindClient is for a request to another service.
+	KindClient Kind = 3
+)
+
+type contextKey int
+
+const (
+	// spanKey holds the current span in a context.
+	spanKey contextKey = iota
+	// remoteKey holds the span context of a parent from another process.
+	remoteKey
+)
+
+// Span is an operation within a trace. It must be ended by calling End. It is safe for concurrent use.
+type Span struct {
+	context SpanContext
+	parent  SpanID
+	kind    Kind
+	start   time.Time
+	// exporter is the exporter that the span is sent to when it ends. It is nil if the span is not recorded.
+	exporter *Exporter
+
+	mutex      sync.Mutex
+	name       string
+	attributes map[string]any
+	errMessage string
+	failed     bool
+	ended      bool
+}
+
+// Start starts a span. Its parent is the span of the context, or else the remote parent of the context, if any.
+// Otherwise, the span starts a new trace.
+//
+// The returned context holds the new span, so that it is the parent of the spans started with it.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := SpanContextFrom(ctx)
+
+	span := &Span{kind: kind, start: time.Now(), name: name}
+	span.context.SpanID = newSpanID()
+
+	exporter := current()
+	if parent.IsValid() {
+		span.context.TraceID = parent.TraceID
+		span.context.TraceState = parent.TraceState
+		span.context.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		span.context.TraceID = newTraceID()
+		// New traces are sampled if they can be recorded.
+		span.context.Sampled = exporter != nil
+	}
+
+	if span.context.Sampled {
+		span.exporter = exporter
+	}
+
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// ContextWithRemote returns a context with the span context as the parent of the spans started with it. It is meant
+// for span contexts from other processes, or from requests that ended before the work they caused.
+func ContextWithRemote(ctx context.Context, spanContext SpanContext) context.Context {
+	// The span of the context, if any, is no longer the parent.
+	ctx = context.WithValue(ctx, spanKey, (*Span)(nil))
+	return context.WithValue(ctx, remoteKey, spanContext)
+}
+
+// SpanContextFrom returns the span context of the span of the context, or else of the remote parent of the context.
+// It is invalid if there is neither.
+func SpanContextFrom(ctx context.Context) SpanContext {
+	if span, _ := ctx.Value(spanKey).(*Span); span != nil {
+		return span.context
+	}
+
+	spanContext, _ := ctx.Value(remoteKey).(SpanContext)
+	return spanContext
+}
+
+// Context returns the span context of the span.
+func (s *Span) Context() SpanContext {
+	return s.context
+}
+
+// SetName replaces the name of the span, for when a better one is known after the span started.
+func (s *Span) SetName(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.name = name
+}
+
+// SetAttribute sets an attribute of the span. The value should be a string, a bool, an integer or a float.
+func (s *Span) SetAttribute(key string, value any) {
+	if s.exporter == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.attributes == nil {
+		s.attributes = map[string]any{}
+	}
+	s.attributes[key] = value
+}
+
+// SetError marks the span as failed with the error. It does nothing if the error is nil.
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.failed = true
+	s.errMessage = err.Error()
+}
+
+// End ends the span, and sends it to the exporter if it is recorded. Calls after the first one do nothing.
+func (s *Span) End() {
+	end := time.Now()
+
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.mutex.Unlock()
+
+	if s.exporter != nil {
+		s.exporter.enqueue(s.export(end))
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
@@ -0,0 +1,165 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/shivanshkc/rosenbridge/internal/tracing/tracingtest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	var testCases = []struct {
+		name          string
+		value         string
+		expectedValid bool
+		expectSampled bool
+	}{
+		{
+			name:          "Valid sampled header, no error expected",
+			value:         "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			expectedValid: true,
+			expectSampled: true,
+		},
+		{
+			name:          "Valid header that is not sampled, no error expected",
+			value:         "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			expectedValid: true,
+		},
+		{
+			name:          "Future version with more fields, no error expected",
+			value:         "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			expectedValid: true,
+			expectSampled: true,
+		},
+		{
+			name:  "Version 00 with more fields, error expected",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		},
+		{
+			name:  "Invalid version, error expected",
+			value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:  "Zero trace ID, error expected",
+			value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		},
+		{
+			name:  "Zero span ID, error expected",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		},
+		{
+			name:  "Uppercase hex, error expected",
+			value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:  "Wrong separator, error expected",
+			value: "00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:  "Too short, error expected",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		},
+		{
+			name:  "Empty, error expected",
+			value: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			spanContext, err := ParseTraceParent(tc.value)
+			if !tc.expectedValid {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spanContext.TraceID.String())
+			require.Equal(t, "00f067aa0ba902b7", spanContext.SpanID.String())
+			require.Equal(t, tc.expectSampled, spanContext.Sampled)
+		})
+	}
+}
+
+func TestExtractAndInject(t *testing.T) {
+	incoming := http.Header{}
+	incoming.Set(HeaderTraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	incoming.Set(HeaderTraceState, "vendor=value")
+
+	ctx, span := Start(Extract(context.Background(), incoming), "server", KindServer)
+	defer span.End()
+
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+
+	// The trace continues, with the new span as the parent of the next hop.
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.Context().SpanID.String()+"-01",
+		outgoing.Get(HeaderTraceParent))
+	require.Equal(t, "vendor=value", outgoing.Get(HeaderTraceState))
+
+	// Invalid headers start a new trace.
+	incoming.Set(HeaderTraceParent, "invalid")
+	_, span = Start(Extract(context.Background(), incoming), "server", KindServer)
+	require.True(t, span.Context().IsValid())
+	require.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.Context().TraceID.String())
+}
+
+func TestExporter(t *testing.T) {
+	collector := tracingtest.NewCollector(t)
+	exporter := StartExporter(ExporterOptions{Endpoint: collector.URL(), ServiceName: "test"})
+	t.Cleanup(func() { _ = exporter.Close() })
+
+	ctx, root := Start(context.Background(), "root", KindServer)
+	root.SetAttribute("http.response.status_code", 200)
+
+	_, child := Start(ctx, "child", KindInternal)
+	child.SetAttribute("rosenbridge.username", "alice")
+	child.SetError(errors.New("mock error"))
+	child.End()
+	root.End()
+
+	// Spans of a trace that the caller did not sample are not recorded.
+	remote := SpanContext{TraceID: TraceID{1}, SpanID: SpanID{1}}
+	_, unsampled := Start(ContextWithRemote(context.Background(), remote), "unsampled", KindServer)
+	unsampled.End()
+
+	exporter.Flush()
+
+	spans := collector.Spans()
+	require.Len(t, spans, 2)
+
+	require.Equal(t, "child", spans[0].Name)
+	require.Equal(t, int(KindInternal), spans[0].Kind)
+	require.Equal(t, root.Context().TraceID.String(), spans[0].TraceID)
+	require.Equal(t, root.Context().SpanID.String(), spans[0].ParentSpanID)
+	require.Equal(t, map[string]any{"rosenbridge.username": "alice"}, spans[0].Attributes)
+	require.Equal(t, statusError, spans[0].StatusCode)
+	require.Equal(t, "mock error", spans[0].StatusMessage)
+	require.Equal(t, "test", spans[0].ServiceName)
+
+	require.Equal(t, "root", spans[1].Name)
+	require.Empty(t, spans[1].ParentSpanID)
+	// 64-bit integers are strings in OTLP/JSON.
+	require.Equal(t, map[string]any{"http.response.status_code": "200"}, spans[1].Attributes)
+
+	// Spans that end after Close are not recorded.
+	require.NoError(t, exporter.Close())
+	_, late := Start(context.Background(), "late", KindInternal)
+	late.End()
+	require.Len(t, collector.Spans(), 2)
+}
+
+func TestStart_WithoutExporter(t *testing.T) {
+	ctx, span := Start(context.Background(), "root", KindServer)
+	span.SetAttribute("key", "value")
+	span.End()
+
+	// Spans still carry IDs, but they are not sampled.
+	require.True(t, span.Context().IsValid())
+	require.False(t, span.Context().Sampled)
+	require.Equal(t, span.Context(), SpanContextFrom(ctx))
+}
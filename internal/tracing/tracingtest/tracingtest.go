@@ -0,0 +1,139 @@
+// Package tracingtest provides a stub OTLP/HTTP collector for tests.
+package tracingtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Span is a span as received by the Collector. Attributes are flattened to their values.
+type Span struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Kind         int
+	Attributes   map[string]any
+	// StatusCode is 2 for failed spans.
+	StatusCode    int
+	StatusMessage string
+	// ServiceName is the service.name attribute of the resource of the span.
+	ServiceName string
+}
+
+// Collector is a stub collector that records the spans exported to it.
+type Collector struct {
+	server *httptest.Server
+
+	mutex sync.Mutex
+	spans []Span
+}
+
+// NewCollector starts a Collector, which is stopped when the test ends.
+func NewCollector(t *testing.T) *Collector {
+	collector := &Collector{}
+	collector.server = httptest.NewServer(http.HandlerFunc(collector.handle))
+	t.Cleanup(collector.server.Close)
+	return collector
+}
+
+// URL is the endpoint to export to.
+func (c *Collector) URL() string {
+	return c.server.URL
+}
+
+// Spans returns the spans received so far, in order.
+func (c *Collector) Spans() []Span {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]Span(nil), c.spans...)
+}
+
+// SpanNamed returns the first span with the given name, and whether there is one.
+func (c *Collector) SpanNamed(name string) (Span, bool) {
+	for _, span := range c.Spans() {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return Span{}, false
+}
+
+// handle is the handler of the /v1/traces endpoint.
+func (c *Collector) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" ||
+		r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	type attribute struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+
+	var request struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []attribute `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string      `json:"traceId"`
+					SpanID       string      `json:"spanId"`
+					ParentSpanID string      `json:"parentSpanId"`
+					Name         string      `json:"name"`
+					Kind         int         `json:"kind"`
+					Attributes   []attribute `json:"attributes"`
+					Status       struct {
+						Code    int    `json:"code"`
+						Message string `json:"message"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// flatten returns the attributes as a map of their values, whatever their type.
+	flatten := func(attributes []attribute) map[string]any {
+		flat := map[string]any{}
+		for _, attr := range attributes {
+			for _, value := range attr.Value {
+				flat[attr.Key] = value
+			}
+		}
+		return flat
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, resourceSpans := range request.ResourceSpans {
+		serviceName, _ := flatten(resourceSpans.Resource.Attributes)["service.name"].(string)
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, span := range scopeSpans.Spans {
+				c.spans = append(c.spans, Span{
+					TraceID:       span.TraceID,
+					SpanID:        span.SpanID,
+					ParentSpanID:  span.ParentSpanID,
+					Name:          span.Name,
+					Kind:          span.Kind,
+					Attributes:    flatten(span.Attributes),
+					StatusCode:    span.Status.Code,
+					StatusMessage: span.Status.Message,
+					ServiceName:   serviceName,
+				})
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("{}"))
+}
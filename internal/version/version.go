@@ -0,0 +1,6 @@
+package version
+
+// Version of the running Rosenbridge build.
+//
+// It is set at build time using: -ldflags "-X github.com/shivanshkc/rosenbridge/internal/version.Version=<version>"
+var Version = "dev"
@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+)
+
+// DeadLetter is the record of a delivery that failed permanently.
+type DeadLetter struct {
+	Time       time.Time                `json:"time"`
+	DeliveryID string                   `json:"deliveryId"`
+	URL        string                   `json:"url"`
+	Receiver   string                   `json:"receiver"`
+	Attempts   int                      `json:"attempts"`
+	Error      string                   `json:"error"`
+	Message    protocol.MessageReceived `json:"message"`
+}
+
+// DeadLetterLog appends dead letters to a file, one JSON object per line.
+type DeadLetterLog struct {
+	mutex    sync.Mutex
+	filePath string
+}
+
+// NewDeadLetterLog returns a new DeadLetterLog instance that appends to the given file, creating it if required.
+func NewDeadLetterLog(filePath string) (*DeadLetterLog, error) {
+	if filePath == "" {
+		return nil, errors.New("dead letter file path is empty")
+	}
+
+	// Create the parent directory if it does not exist.
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create parent directory: %w", err)
+	}
+
+	// Make sure that the file is writable right away, instead of at the first failure.
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close dead letter file: %w", err)
+	}
+
+	return &DeadLetterLog{filePath: filePath}, nil
+}
+
+// Record appends the dead letter to the file.
+func (l *DeadLetterLog) Record(deadLetter DeadLetter) error {
+	line, err := json.Marshal(deadLetter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// The file is not kept open, like the users file of the database.
+	file, err := os.OpenFile(l.filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open dead letter file: %w", err)
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+
+	return file.Close()
+}
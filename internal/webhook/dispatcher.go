@@ -0,0 +1,375 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/tracing"
+	"github.com/shivanshkc/rosenbridge/internal/version"
+)
+
+var (
+	// ErrQueueFull is returned by Enqueue when the queue has no room for the delivery.
+	ErrQueueFull = errors.New("webhook queue is full")
+	// ErrClosed is returned by Enqueue after Close is called.
+	ErrClosed = errors.New("webhook dispatcher is closed")
+
+	// errBlockedAddress is returned when a webhook resolves to a non-public address that is not allowed.
+	errBlockedAddress = errors.New("webhook address is not public")
+)
+
+// maxResponseBytes is the max size of a response body that is read, so that the connection can be reused.
+const maxResponseBytes = 64 * 1024
+
+// Options configure a Dispatcher. Zero values mean the defaults.
+type Options struct {
+	// Workers is the number of deliveries that can be in flight at once. Defaults to 4.
+	Workers int
+	// QueueSize is the max number of deliveries waiting for a worker, including retries. Defaults to 1000.
+	QueueSize int
+	// MaxAttempts is the max number of attempts of a delivery before it is dead-lettered. Defaults to 5.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. It doubles with every retry. Defaults to 1 second.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries. Defaults to 5 minutes.
+	MaxBackoff time.Duration
+	// Timeout is the max duration of an attempt. Defaults to 10 seconds.
+	Timeout time.Duration
+	// AllowPrivateNetworks allows webhooks on loopback, private and link-local addresses. It should only be set if
+	// all users are trusted, as it lets them reach internal services.
+	AllowPrivateNetworks bool
+}
+
+// withDefaults returns the options with the defaults applied.
+func (o Options) withDefaults() Options {
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = 1000
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 5
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 5 * time.Minute
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	return o
+}
+
+// job is a delivery along with its attempt count.
+type job struct {
+	delivery Delivery
+	attempts int
+}
+
+// Dispatcher POSTs deliveries to webhooks using a fixed number of workers. Failed attempts are retried with
+// exponential backoff, without holding a worker while waiting. Deliveries that fail permanently are recorded in the
+// dead letter log.
+type Dispatcher struct {
+	options     Options
+	client      *http.Client
+	deadLetters *DeadLetterLog
+
+	queue  chan job
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mutex guards closed and retries.
+	mutex  sync.Mutex
+	closed bool
+	// retries holds the jobs waiting for their backoff to elapse.
+	retries map[*time.Timer]job
+
+	workers sync.WaitGroup
+}
+
+// NewDispatcher returns a new Dispatcher and starts its workers. The dead letter log can be nil, in which case dead
+// letters are only logged. Call Close to stop the workers.
+func NewDispatcher(options Options, deadLetters *DeadLetterLog) *Dispatcher {
+	options = options.withDefaults()
+
+	dialer := &net.Dialer{Timeout: options.Timeout}
+	if !options.AllowPrivateNetworks {
+		// The address is checked after DNS resolution, so that DNS cannot be used to bypass the check.
+		dialer.Control = blockNonPublic
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d := &Dispatcher{
+		options: options,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   options.Timeout,
+			// Redirects are not followed, as they could lead anywhere.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		deadLetters: deadLetters,
+		queue:       make(chan job, options.QueueSize),
+		ctx:         ctx,
+		cancel:      cancel,
+		retries:     map[*time.Timer]job{},
+	}
+
+	for range options.Workers {
+		d.workers.Add(1)
+		go func() {
+			defer d.workers.Done()
+			d.work()
+		}()
+	}
+
+	return d
+}
+
+// Enqueue schedules the delivery. It does not block. It returns ErrQueueFull if the queue has no room.
+func (d *Dispatcher) Enqueue(delivery Delivery) error {
+	return d.enqueue(job{delivery: delivery})
+}
+
+// Close stops the workers. The deliveries that are queued or waiting to be retried are dead-lettered.
+func (d *Dispatcher) Close() error {
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		return nil
+	}
+	d.closed = true
+	retries := d.retries
+	d.retries = nil
+	d.mutex.Unlock()
+
+	// In-flight attempts are aborted.
+	d.cancel()
+	d.workers.Wait()
+
+	for timer, j := range retries {
+		// If the timer already fired, its job is being handled by enqueue, which dead-letters it.
+		if timer.Stop() {
+			d.deadLetter(j, ErrClosed)
+		}
+	}
+
+	for {
+		select {
+		case j := <-d.queue:
+			d.deadLetter(j, ErrClosed)
+		default:
+			return nil
+		}
+	}
+}
+
+// enqueue adds the job to the queue without blocking.
+func (d *Dispatcher) enqueue(j job) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+
+	select {
+	case d.queue <- j:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// work processes jobs until the dispatcher is closed.
+func (d *Dispatcher) work() {
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case j := <-d.queue:
+			d.process(j)
+		}
+	}
+}
+
+// process makes an attempt, and schedules a retry or records a dead letter if it fails.
+func (d *Dispatcher) process(j job) {
+	j.attempts++
+
+	retryable, err := d.attempt(j.delivery)
+	if err == nil {
+		slog.Debug("webhook delivered", "deliveryId", j.delivery.ID, "attempts", j.attempts)
+		return
+	}
+
+	slog.Warn("webhook attempt failed", "deliveryId", j.delivery.ID, "attempts", j.attempts, "error", err)
+
+	if d.ctx.Err() != nil {
+		d.deadLetter(j, ErrClosed)
+		return
+	}
+
+	if !retryable || j.attempts >= d.options.MaxAttempts {
+		d.deadLetter(j, err)
+		return
+	}
+
+	d.retryLater(j, err)
+}
+
+// retryLater puts the job back in the queue after its backoff elapses.
+func (d *Dispatcher) retryLater(j job, lastErr error) {
+	// The backoff doubles with every attempt.
+	backoff := d.options.InitialBackoff << (j.attempts - 1)
+	if backoff <= 0 || backoff > d.options.MaxBackoff {
+		backoff = d.options.MaxBackoff
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.closed {
+		d.deadLetter(j, ErrClosed)
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(backoff, func() {
+		d.mutex.Lock()
+		_, pending := d.retries[timer]
+		delete(d.retries, timer)
+		d.mutex.Unlock()
+
+		// Close has taken over the job.
+		if !pending {
+			return
+		}
+
+		if err := d.enqueue(j); err != nil {
+			d.deadLetter(j, fmt.Errorf("%w, after: %w", err, lastErr))
+		}
+	})
+
+	d.retries[timer] = j
+}
+
+// attempt POSTs the delivery once, in a span that continues the trace of the delivery. It returns whether a failure is
+// worth retrying.
+func (d *Dispatcher) attempt(delivery Delivery) (bool, error) {
+	ctx, span := tracing.Start(tracing.ContextWithRemote(d.ctx, delivery.Trace), "webhook delivery", tracing.KindClient)
+	defer span.End()
+	span.SetAttribute("rosenbridge.delivery_id", delivery.ID)
+	span.SetAttribute("rosenbridge.receiver", delivery.Payload.Receiver)
+
+	retryable, err := d.post(ctx, delivery)
+	span.SetError(err)
+	return retryable, err
+}
+
+// post POSTs the delivery to its webhook. It returns whether a failure is worth retrying.
+func (d *Dispatcher) post(ctx context.Context, delivery Delivery) (bool, error) {
+	body, err := json.Marshal(delivery.Payload)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.options.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "rosenbridge/"+version.Version)
+	req.Header.Set(HeaderDeliveryID, delivery.ID)
+	req.Header.Set(HeaderTimestamp, fmt.Sprint(now.Unix()))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, now, body))
+	// The receiver can continue the trace too.
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return !errors.Is(err, errBlockedAddress), fmt.Errorf("failed to send request: %w", err)
+	}
+
+	// The body is drained so that the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+}
+
+// deadLetter records the permanently failed job.
+func (d *Dispatcher) deadLetter(j job, err error) {
+	slog.Error("webhook delivery failed permanently", "deliveryId", j.delivery.ID,
+		"receiver", j.delivery.Payload.Receiver, "attempts", j.attempts, "error", err)
+
+	if d.deadLetters == nil {
+		return
+	}
+
+	deadLetter := DeadLetter{
+		Time:       time.Now(),
+		DeliveryID: j.delivery.ID,
+		URL:        j.delivery.URL,
+		Receiver:   j.delivery.Payload.Receiver,
+		Attempts:   j.attempts,
+		Error:      err.Error(),
+		Message:    j.delivery.Payload.Message,
+	}
+
+	if err := d.deadLetters.Record(deadLetter); err != nil {
+		slog.Error("failed to record dead letter", "deliveryId", j.delivery.ID, "error", err)
+	}
+}
+
+// blockNonPublic is a net.Dialer Control function that rejects loopback, private, link-local and other non-public
+// addresses.
+func blockNonPublic(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return fmt.Errorf("%w: %s", errBlockedAddress, ip)
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+package webhook
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testOptions returns options with short timings, that allow deliveries to the httptest servers.
+func testOptions() Options {
+	return Options{
+		Workers:              2,
+		MaxAttempts:          3,
+		InitialBackoff:       time.Millisecond,
+		Timeout:              time.Second,
+		AllowPrivateNetworks: true,
+	}
+}
+
+func testDelivery(url string) Delivery {
+	return Delivery{
+		ID:     "delivery-1",
+		URL:    url,
+		Secret: "secret",
+		Payload: Payload{
+			Receiver: "bob",
+			Offline:  true,
+			Message:  protocol.MessageReceived{Message: "hello", Sender: "alice"},
+		},
+	}
+}
+
+// readDeadLetters returns all the dead letters in the file.
+func readDeadLetters(t *testing.T, filePath string) []DeadLetter {
+	file, err := os.Open(filePath)
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+
+	var deadLetters []DeadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var deadLetter DeadLetter
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &deadLetter))
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	require.NoError(t, scanner.Err())
+	return deadLetters
+}
+
+func TestDispatcher_RetriesUntilSuccess(t *testing.T) {
+	var attempts atomic.Int32
+	received := make(chan []byte, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first two attempts fail with a retryable status.
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, "delivery-1", r.Header.Get(HeaderDeliveryID))
+		require.True(t, Verify("secret", r.Header.Get(HeaderSignature), r.Header.Get(HeaderTimestamp), body))
+
+		received <- body
+	}))
+	defer server.Close()
+
+	dispatcher := NewDispatcher(testOptions(), nil)
+	defer func() { _ = dispatcher.Close() }()
+
+	require.NoError(t, dispatcher.Enqueue(testDelivery(server.URL)))
+
+	select {
+	case body := <-received:
+		require.JSONEq(t, `{"receiver":"bob","offline":true,"message":{"message":"hello","sender":"alice"}}`,
+			string(body))
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery not received")
+	}
+
+	require.Equal(t, int32(3), attempts.Load())
+}
+
+func TestDispatcher_DeadLetters(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var testCases = []struct {
+		name             string
+		url              string
+		options          func(Options) Options
+		expectedAttempts int
+	}{
+		{
+			name:             "Permanent failure, no retries expected",
+			url:              server.URL + "/gone",
+			expectedAttempts: 1,
+		},
+		{
+			name:             "Retryable failure, max attempts expected",
+			url:              server.URL + "/error",
+			expectedAttempts: 3,
+		},
+		{
+			name: "Private address, no retries expected",
+			url:  server.URL + "/error",
+			options: func(o Options) Options {
+				o.AllowPrivateNetworks = false
+				return o
+			},
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+			deadLetters, err := NewDeadLetterLog(filePath)
+			require.NoError(t, err)
+
+			options := testOptions()
+			if tc.options != nil {
+				options = tc.options(options)
+			}
+
+			dispatcher := NewDispatcher(options, deadLetters)
+			defer func() { _ = dispatcher.Close() }()
+
+			require.NoError(t, dispatcher.Enqueue(testDelivery(tc.url)))
+
+			require.Eventually(t, func() bool {
+				info, err := os.Stat(filePath)
+				return err == nil && info.Size() > 0
+			}, 5*time.Second, 10*time.Millisecond)
+
+			records := readDeadLetters(t, filePath)
+			require.Len(t, records, 1)
+			require.Equal(t, "delivery-1", records[0].DeliveryID)
+			require.Equal(t, "bob", records[0].Receiver)
+			require.Equal(t, tc.url, records[0].URL)
+			require.Equal(t, tc.expectedAttempts, records[0].Attempts)
+			require.Equal(t, "hello", records[0].Message.Message)
+		})
+	}
+}
+
+func TestDispatcher_Close(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	filePath := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	deadLetters, err := NewDeadLetterLog(filePath)
+	require.NoError(t, err)
+
+	// The backoff is long enough for the retry to be pending when the dispatcher is closed.
+	options := testOptions()
+	options.InitialBackoff = time.Hour
+	dispatcher := NewDispatcher(options, deadLetters)
+
+	require.NoError(t, dispatcher.Enqueue(testDelivery(server.URL)))
+	require.Eventually(t, func() bool {
+		dispatcher.mutex.Lock()
+		defer dispatcher.mutex.Unlock()
+		return len(dispatcher.retries) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, dispatcher.Close())
+	require.ErrorIs(t, dispatcher.Enqueue(testDelivery(server.URL)), ErrClosed)
+
+	records := readDeadLetters(t, filePath)
+	require.Len(t, records, 1)
+	require.Equal(t, ErrClosed.Error(), records[0].Error)
+}
+
+func TestDispatcher_QueueFull(t *testing.T) {
+	// No workers are started, so that the queue never drains.
+	dispatcher := &Dispatcher{queue: make(chan job, 1)}
+
+	require.NoError(t, dispatcher.Enqueue(testDelivery("https://example.com")))
+	require.ErrorIs(t, dispatcher.Enqueue(testDelivery("https://example.com")), ErrQueueFull)
+}
+
+func TestSign(t *testing.T) {
+	timestamp := time.Unix(1700000000, 0)
+	signature := Sign("secret", timestamp, []byte(`{}`))
+
+	require.True(t, Verify("secret", signature, "1700000000", []byte(`{}`)))
+	require.False(t, Verify("other", signature, "1700000000", []byte(`{}`)))
+	require.False(t, Verify("secret", signature, "1700000001", []byte(`{}`)))
+	require.False(t, Verify("secret", signature, "1700000000", []byte(`{"a":1}`)))
+}
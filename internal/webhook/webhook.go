@@ -0,0 +1,64 @@
+// Package webhook delivers messages to the HTTP endpoints registered by users, so that they can receive messages
+// without holding a connection open.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/tracing"
+	"github.com/shivanshkc/rosenbridge/pkg/protocol"
+)
+
+// Headers of every delivery.
+const (
+	// HeaderSignature is the hex encoded HMAC-SHA256 of the timestamp, a dot, and the body, prefixed with "sha256=".
+	HeaderSignature = "X-Rosenbridge-Signature"
+	// HeaderTimestamp is the Unix time of the delivery attempt, in seconds. Receivers can reject old deliveries to
+	// prevent replays.
+	HeaderTimestamp = "X-Rosenbridge-Timestamp"
+	// HeaderDeliveryID is the same for all attempts of a delivery, so that receivers can deduplicate them.
+	HeaderDeliveryID = "X-Rosenbridge-Delivery"
+)
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	// Receiver is the user that the webhook belongs to.
+	Receiver string `json:"receiver"`
+	// Offline is true if the receiver had no connection when the message was sent.
+	Offline bool                     `json:"offline"`
+	Message protocol.MessageReceived `json:"message"`
+}
+
+// Delivery is a payload to be POSTed to a webhook.
+type Delivery struct {
+	ID      string
+	URL     string
+	Secret  string
+	Payload Payload
+	// Trace is the span context of the request that caused the delivery. The delivery continues its trace, so that
+	// both show up as one.
+	Trace tracing.SpanContext
+}
+
+// Sign returns the value of the signature header for the given timestamp and body.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether the signature matches the timestamp and body. It is meant for receivers written in Go, and
+// for tests.
+func Verify(secret, signature, timestamp string, body []byte) bool {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, time.Unix(unix, 0), body)), []byte(signature))
+}
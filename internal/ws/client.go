@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/internal/topic"
+	"github.com/shivanshkc/rosenbridge/pkg/codec"
+
+	"github.com/coder/websocket"
+)
+
+// Client is a websocket connection managed by a Manager.
+type Client struct {
+	// Username is the user that the connection belongs to.
+	Username string
+	// Scopes are those that the user connected with, which are fewer than the user's own if an API key was used.
+	Scopes scope.Set
+	// Codec is the encoding negotiated with the client. It is used for all messages in both directions.
+	Codec codec.Codec
+
+	conn *websocket.Conn
+	// drop closes the connection without the close handshake, by canceling the context of the read loop.
+	drop context.CancelFunc
+
+	// subscriptions is the set of topic filters that the client is subscribed to.
+	subscriptions     map[string]struct{}
+	subscriptionMutex sync.RWMutex
+}
+
+// maxSubscriptions is the max number of topic filters that a client can be subscribed to at once.
+const maxSubscriptions = 100
+
+// ErrTooManySubscriptions is returned by Subscribe if the client already has maxSubscriptions.
+var ErrTooManySubscriptions = fmt.Errorf("cannot subscribe to more than %d topics", maxSubscriptions)
+
+// Send encodes the given event using the client's codec and writes it to the connection.
+func (c *Client) Send(ctx context.Context, event any) error {
+	message, err := c.Codec.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	return c.write(ctx, message)
+}
+
+// Subscribe subscribes the client to the given topic filter, which is expected to be valid.
+// Subscribing to the same filter again has no effect.
+func (c *Client) Subscribe(filter string) error {
+	c.subscriptionMutex.Lock()
+	defer c.subscriptionMutex.Unlock()
+
+	if _, exists := c.subscriptions[filter]; exists {
+		return nil
+	}
+
+	if len(c.subscriptions) >= maxSubscriptions {
+		return ErrTooManySubscriptions
+	}
+
+	if c.subscriptions == nil {
+		c.subscriptions = map[string]struct{}{}
+	}
+
+	c.subscriptions[filter] = struct{}{}
+	return nil
+}
+
+// Unsubscribe unsubscribes the client from the given topic filter. It must be the same filter that was subscribed to.
+// It returns false if the client was not subscribed to the filter.
+func (c *Client) Unsubscribe(filter string) bool {
+	c.subscriptionMutex.Lock()
+	defer c.subscriptionMutex.Unlock()
+
+	_, exists := c.subscriptions[filter]
+	delete(c.subscriptions, filter)
+	return exists
+}
+
+// IsSubscribed reports whether any of the client's topic filters matches the given topic name.
+func (c *Client) IsSubscribed(name string) bool {
+	c.subscriptionMutex.RLock()
+	defer c.subscriptionMutex.RUnlock()
+
+	for filter := range c.subscriptions {
+		if topic.Match(filter, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// write writes an already encoded message to the connection, with the frame type required by the client's codec.
+func (c *Client) write(ctx context.Context, message []byte) error {
+	messageType := websocket.MessageText
+	if c.Codec.Binary() {
+		messageType = websocket.MessageBinary
+	}
+
+	start := time.Now()
+	err := c.conn.Write(ctx, messageType, message)
+	writeDuration.Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		writeFailures.Inc()
+	}
+	return err
+}
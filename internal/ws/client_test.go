@@ -0,0 +1,36 @@
+package ws
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Subscriptions(t *testing.T) {
+	client := &Client{Username: "alice"}
+	require.False(t, client.IsSubscribed("a/b"))
+
+	require.NoError(t, client.Subscribe("a/*"))
+	require.NoError(t, client.Subscribe("a/*"))
+	require.True(t, client.IsSubscribed("a/b"))
+	require.False(t, client.IsSubscribed("a/b/c"))
+
+	// Unsubscribing requires the same filter.
+	require.False(t, client.Unsubscribe("a/b"))
+	require.True(t, client.IsSubscribed("a/b"))
+	require.True(t, client.Unsubscribe("a/*"))
+	require.False(t, client.IsSubscribed("a/b"))
+	require.False(t, client.Unsubscribe("a/*"))
+}
+
+func TestClient_Subscribe_Limit(t *testing.T) {
+	client := &Client{Username: "alice"}
+	for i := range maxSubscriptions {
+		require.NoError(t, client.Subscribe("topic/"+strconv.Itoa(i)))
+	}
+
+	require.ErrorIs(t, client.Subscribe("one-more"), ErrTooManySubscriptions)
+	// Existing subscriptions are not affected by the limit.
+	require.NoError(t, client.Subscribe("topic/0"))
+}
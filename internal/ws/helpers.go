@@ -2,27 +2,49 @@ package ws
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"slices"
+	"time"
+
+	"github.com/shivanshkc/rosenbridge/internal/tracing"
 
 	"github.com/coder/websocket"
 )
 
+// writeTimeout is the max time allowed for writing replies to clients.
+const writeTimeout = 5 * time.Second
+
+// closeReasonShutdown is the reason of the close frame of the connections closed by Manager.Close.
+const closeReasonShutdown = "server is shutting down"
+
 // websocketReadLoop starts an infinite loop to read from the connection continuously.
 // It is a blocking call that returns when the Read call fails (meaning the connection is no longer good).
-func websocketReadLoop(username string, conn *websocket.Conn) {
+//
+// Every message read is passed to the Manager's handler, if any, and the handler's replies are written back. The
+// reads use the readCtx, whose cancellation closes the connection.
+func (m *Manager) websocketReadLoop(ctx, readCtx context.Context, client *Client) {
+	username := client.Username
 	// When this function returns, the connection is most likely already closed.
 	// This is just for additional safety.
-	defer func() { _ = conn.Close(websocket.StatusNormalClosure, "") }()
+	defer func() { _ = client.conn.Close(websocket.StatusNormalClosure, "") }()
 
 	for {
-		_, _, err := conn.Read(context.Background())
+		_, message, err := client.conn.Read(readCtx)
 		if err == nil {
+			if m.handler == nil {
+				continue
+			}
+			for _, reply := range m.handler.OnMessage(ctx, client, message) {
+				sendWithTimeout(ctx, client, reply)
+			}
 			continue
 		}
 
-		// Error handling.
-		if websocket.CloseStatus(err) == websocket.StatusNormalClosure {
+		// Error handling. Browsers close with StatusGoingAway when the page is left, and so does Close.
+		if status := websocket.CloseStatus(err); status == websocket.StatusNormalClosure ||
+			status == websocket.StatusGoingAway {
 			slog.Info("connection closed normally", "username", username)
 		} else {
 			slog.Error("connection read error", "username", username, "error", err)
@@ -32,28 +54,120 @@ func websocketReadLoop(username string, conn *websocket.Conn) {
 	}
 }
 
-// addConnection adds the given connection in the internal state.
-// It returns the total number of connections, and number of connections held by the given user.
-func (m *Manager) addConnection(username string, conn *websocket.Conn) (int, int) {
+// sendWithTimeout sends the given event to the client, and logs the error if any.
+func sendWithTimeout(ctx context.Context, client *Client, event any) {
+	writeCtx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	if err := client.Send(writeCtx, event); err != nil {
+		slog.ErrorContext(ctx, "failed to send event", "username", client.Username, "error", err)
+	}
+}
+
+// send sends the event to the given clients. The event is encoded once per codec in use by the clients. If a codec
+// fails to encode it, the clients of the other codecs still get it.
+func (m *Manager) send(ctx context.Context, event any, clients []*Client) error {
+	// This will collect all errors.
+	var errs []error
+	// Encoded event per codec subprotocol.
+	encoded := map[string][]byte{}
+	// Codec subprotocols that failed to encode the event. Their clients are skipped, but not the others.
+	failed := map[string]struct{}{}
+
+	for _, client := range clients {
+		subprotocol := client.Codec.Subprotocol()
+		if _, isFailed := failed[subprotocol]; isFailed {
+			continue
+		}
+
+		message, exists := encoded[subprotocol]
+		if !exists {
+			var err error
+			if message, err = client.Codec.Marshal(event); err != nil {
+				errs = append(errs, fmt.Errorf("failed to encode event for %s: %w", subprotocol, err))
+				failed[subprotocol] = struct{}{}
+				continue
+			}
+			encoded[subprotocol] = message
+		}
+
+		// Every write has a span, so that a slow receiver can be told apart in the trace.
+		writeCtx, span := tracing.Start(ctx, "websocket write", tracing.KindInternal)
+		span.SetAttribute("rosenbridge.receiver", client.Username)
+		span.SetAttribute("rosenbridge.subprotocol", subprotocol)
+		if err := client.write(writeCtx, message); err != nil {
+			err = fmt.Errorf("failed to send message to %s: %w", client.Username, err)
+			span.SetError(err)
+			errs = append(errs, err)
+		}
+		span.End()
+	}
+
+	return errors.Join(errs...)
+}
+
+// addConnection adds the given client in the internal state.
+// It returns the total number of connections, and number of connections held by the client's user.
+// It returns ErrClosed, without adding the client, if the Manager is closed.
+func (m *Manager) addConnection(client *Client) (int, int, error) {
 	m.connectionMutex.Lock()
 	defer m.connectionMutex.Unlock()
 
-	m.connections[username] = append(m.connections[username], conn)
+	if m.closed {
+		return 0, 0, ErrClosed
+	}
+
+	username := client.Username
+	m.connections[username] = append(m.connections[username], client)
 	m.connectionCount++
+	connectionsOpen.Add(1)
+	connectionsTotal.Inc()
 
-	return m.connectionCount, len(m.connections[username])
+	return m.connectionCount, len(m.connections[username]), nil
+}
+
+// isClosed reports whether Close was called.
+func (m *Manager) isClosed() bool {
+	m.connectionMutex.RLock()
+	defer m.connectionMutex.RUnlock()
+
+	return m.closed
+}
+
+// closeClient sends the shutdown event of the handler to the client, if any, and closes the connection with the close
+// handshake. If the context expires first, the connection is closed right away.
+func (m *Manager) closeClient(ctx context.Context, client *Client) error {
+	if m.handler != nil {
+		if event := m.handler.OnShutdown(ctx, client); event != nil {
+			sendWithTimeout(ctx, client, event)
+		}
+	}
+
+	// The close handshake waits for the client to respond, so it is bounded by the context.
+	closed := make(chan error, 1)
+	go func() { closed <- client.conn.Close(websocket.StatusGoingAway, closeReasonShutdown) }()
+
+	select {
+	case err := <-closed:
+		return err
+	case <-ctx.Done():
+		client.drop()
+		return fmt.Errorf("close handshake did not finish: %w", ctx.Err())
+	}
 }
 
-// removeConnection removes the given connection from the internal state.
-// It returns the total number of connections, and number of connections held by the given user.
-func (m *Manager) removeConnection(username string, conn *websocket.Conn) (int, int) {
+// removeConnection removes the given client from the internal state.
+// It returns the total number of connections, and number of connections held by the client's user.
+func (m *Manager) removeConnection(client *Client) (int, int) {
 	m.connectionMutex.Lock()
 	defer m.connectionMutex.Unlock()
 
+	username := client.Username
 	for i, stored := range m.connections[username] {
-		if conn == stored {
+		if client == stored {
 			m.connections[username] = slices.Delete(m.connections[username], i, i+1)
 			m.connectionCount--
+			connectionsOpen.Add(-1)
 			if len(m.connections[username]) == 0 {
 				delete(m.connections, username)
 			}
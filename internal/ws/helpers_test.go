@@ -4,17 +4,16 @@ import (
 	"sync"
 	"testing"
 
-	"github.com/coder/websocket"
 	"github.com/stretchr/testify/require"
 )
 
 func TestManager_addRemoveConnection_ThreadSafety(t *testing.T) {
-	m := NewManager()
+	m := NewManager(nil)
 
 	goroutineCount := 100
-	conns := make([]*websocket.Conn, goroutineCount)
-	for i := range conns {
-		conns[i] = &websocket.Conn{}
+	clients := make([]*Client, goroutineCount)
+	for i := range clients {
+		clients[i] = &Client{Username: "user"}
 	}
 
 	var wg sync.WaitGroup
@@ -22,7 +21,7 @@ func TestManager_addRemoveConnection_ThreadSafety(t *testing.T) {
 	for i := 0; i < goroutineCount; i++ {
 		go func(idx int) {
 			defer wg.Done()
-			m.addConnection("user", conns[idx])
+			m.addConnection(clients[idx])
 		}(i)
 	}
 	wg.Wait()
@@ -34,7 +33,7 @@ func TestManager_addRemoveConnection_ThreadSafety(t *testing.T) {
 	for i := 0; i < goroutineCount; i++ {
 		go func(idx int) {
 			defer wg.Done()
-			m.removeConnection("user", conns[idx])
+			m.removeConnection(clients[idx])
 		}(i)
 	}
 	wg.Wait()
@@ -45,18 +44,18 @@ func TestManager_addRemoveConnection_ThreadSafety(t *testing.T) {
 }
 
 func TestManager_removeConnection_NotFound(t *testing.T) {
-	m := NewManager()
-	m.addConnection("alice", &websocket.Conn{})
+	m := NewManager(nil)
+	m.addConnection(&Client{Username: "alice"})
 
-	totalCount, userCount := m.removeConnection("alice", &websocket.Conn{})
+	totalCount, userCount := m.removeConnection(&Client{Username: "alice"})
 	require.Equal(t, 1, totalCount)
 	require.Equal(t, 1, userCount)
 }
 
 func TestManager_removeConnection_UnknownUser(t *testing.T) {
-	m := NewManager()
+	m := NewManager(nil)
 
-	totalCount, userCount := m.removeConnection("nonexistent", &websocket.Conn{})
+	totalCount, userCount := m.removeConnection(&Client{Username: "nonexistent"})
 	require.Equal(t, 0, totalCount)
 	require.Equal(t, 0, userCount)
 }
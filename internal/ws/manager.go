@@ -6,54 +6,135 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
-	"slices"
 	"sync"
+	"sync/atomic"
+
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/pkg/codec"
+	"github.com/shivanshkc/rosenbridge/pkg/utils/httputils"
 
 	"github.com/coder/websocket"
 )
 
+// Handler processes the lifecycle events of the connections managed by a Manager.
+type Handler interface {
+	// OnConnect is called right after a connection is added.
+	// The returned event, if non-nil, is sent to the client.
+	OnConnect(ctx context.Context, client *Client) any
+
+	// OnShutdown is called for every connection when the Manager closes.
+	// The returned event, if non-nil, is sent to the client before the connection is closed.
+	OnShutdown(ctx context.Context, client *Client) any
+
+	// OnMessage is called for every message sent by the client. The message is encoded with the client's codec.
+	// The returned events, if any, are sent back to the client in order.
+	OnMessage(ctx context.Context, client *Client, message []byte) []any
+}
+
+// ErrClosed is returned by UpgradeAndAddConnection after the Manager is closed.
+var ErrClosed = errors.New("websocket manager is closed")
+
 // Manager makes it convenient to manage many websocket connections.
 // It also allows different connections to be mapped to different usernames.
 type Manager struct {
 	connectionMutex sync.RWMutex
-	connections     map[string][]*websocket.Conn
+	connections     map[string][]*Client
 	connectionCount int
+	// closed is set by Close, after which new connections are refused.
+	closed bool
+
+	// handler processes connection events. It can be nil, in which case client messages are ignored.
+	handler Handler
+
+	// readLimit is the max size of a message from a client. If it is not positive, the websocket library's default of
+	// 32 KiB applies.
+	readLimit atomic.Int64
 }
 
-// NewManager returns a new Manager instance.
-func NewManager() *Manager {
-	return &Manager{connections: map[string][]*websocket.Conn{}}
+// NewManager returns a new Manager instance. The handler can be nil.
+func NewManager(handler Handler) *Manager {
+	return &Manager{connections: map[string][]*Client{}, handler: handler}
+}
+
+// SetReadLimit sets the max size of a message from a client, in bytes, for the connections that are added after it.
+// Clients that send larger messages are disconnected.
+func (m *Manager) SetReadLimit(limit int64) {
+	m.readLimit.Store(limit)
 }
 
 // UpgradeAndAddConnection upgrades the given HTTP request into a websocket connection. If the upgrade fails, the
 // response is written by this method itself. The caller should not write the response at their end.
 //
-// After the upgrade, the connection is stored in the internal state of the Manager with the given username.
+// The codec of the connection is negotiated using the websocket subprotocol. If the client does not ask for any of
+// the codec.Subprotocols, the JSON codec is used.
+//
+// After the upgrade, the connection is stored in the internal state of the Manager with the given username and the
+// scopes that the user authenticated with.
 // The Broadcast method can be used to send messages to this connection.
-func (m *Manager) UpgradeAndAddConnection(w http.ResponseWriter, r *http.Request, username string) error {
+//
+// After Close, it responds with 503 and returns ErrClosed.
+func (m *Manager) UpgradeAndAddConnection(w http.ResponseWriter, r *http.Request, username string, scopes scope.Set,
+) error {
 	ctx := r.Context()
 
+	// Fail fast, before the upgrade. Connections that race with Close are closed by addConnection.
+	if m.isClosed() {
+		httputils.WriteError(w, httputils.ServiceUnavailable().WithReasonStr(closeReasonShutdown))
+		return ErrClosed
+	}
+
 	// Upgrade to websocket.
-	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	acceptOptions := &websocket.AcceptOptions{InsecureSkipVerify: true, Subprotocols: codec.Subprotocols()}
+	conn, err := websocket.Accept(w, r, acceptOptions)
 	if err != nil {
 		return fmt.Errorf("failed to upgrade to websocket connection: %w", err)
 	}
+	if limit := m.readLimit.Load(); limit > 0 {
+		conn.SetReadLimit(limit)
+	}
+
+	// Canceling the context of a read closes the connection, which is the only way to abandon a close handshake.
+	readCtx, drop := context.WithCancel(context.Background())
+	client := &Client{
+		Username: username,
+		Scopes:   scopes,
+		Codec:    codec.BySubprotocol(conn.Subprotocol()),
+		conn:     conn,
+		drop:     drop,
+	}
 
-	slog.InfoContext(ctx, "successfully upgraded to websocket connection", "username", username)
+	slog.InfoContext(ctx, "successfully upgraded to websocket connection", "username", username,
+		"subprotocol", client.Codec.Subprotocol())
 
 	// Add connection to internal state.
-	totalConnCount, userConnCount := m.addConnection(username, conn)
+	totalConnCount, userConnCount, err := m.addConnection(client)
+	if err != nil {
+		drop()
+		_ = conn.Close(websocket.StatusGoingAway, closeReasonShutdown)
+		return err
+	}
 
 	slog.InfoContext(ctx, "added new connection", "username", username,
 		"totalConnectionCount", totalConnCount, "userConnectionCount", userConnCount)
 
+	// The request context gets canceled once the handler returns, so only its values are retained.
+	ctx = context.WithoutCancel(ctx)
+
+	// Greet the client, if required.
+	if m.handler != nil {
+		if greeting := m.handler.OnConnect(ctx, client); greeting != nil {
+			sendWithTimeout(ctx, client, greeting)
+		}
+	}
+
 	// The read loop starts in a separate goroutine, so the caller isn't blocked.
 	go func() {
 		// Blocking call. This releases only when the connection is no longer valid.
-		websocketReadLoop(username, conn)
+		m.websocketReadLoop(ctx, readCtx, client)
+		drop()
 
 		// Remove connection from internal state.
-		tcc, ucc := m.removeConnection(username, conn)
+		tcc, ucc := m.removeConnection(client)
 
 		slog.InfoContext(ctx, "removed connection", "username", username,
 			"totalConnectionCount", tcc, "userConnectionCount", ucc)
@@ -62,60 +143,103 @@ func (m *Manager) UpgradeAndAddConnection(w http.ResponseWriter, r *http.Request
 	return nil
 }
 
-// Broadcast a message to a list of receivers.
-func (m *Manager) Broadcast(ctx context.Context, message []byte, receivers []string) error {
-	subConnections := map[string][]*websocket.Conn{}
+// Broadcast an event to a list of receivers.
+//
+// The event is encoded once per codec in use by the receivers' connections, so a single broadcast can serve clients
+// that negotiated different encodings.
+func (m *Manager) Broadcast(ctx context.Context, event any, receivers []string) error {
+	var clients []*Client
 
-	// Extract all required connections into a sub-map so it can be used outside the mutex.
+	// Extract all required connections so they can be used outside the mutex.
 	// The main purpose is to keep the websocket Write calls outside the mutex lock.
 	m.connectionMutex.RLock()
 	for _, receiver := range receivers {
-		subConnections[receiver] = slices.Clone(m.connections[receiver])
+		clients = append(clients, m.connections[receiver]...)
 	}
 	m.connectionMutex.RUnlock()
 
-	// This will collect all errors.
-	var errs []error
+	fanoutSize.With("direct").Observe(float64(len(clients)))
+	return m.send(ctx, event, clients)
+}
+
+// ConnectionCount returns the number of connections of all users.
+func (m *Manager) ConnectionCount() int {
+	m.connectionMutex.RLock()
+	defer m.connectionMutex.RUnlock()
+
+	return m.connectionCount
+}
 
-	for receiver, connList := range subConnections {
-		for _, conn := range connList {
-			if err := conn.Write(ctx, websocket.MessageText, message); err != nil {
-				err = fmt.Errorf("failed to send message to %s: %w", receiver, err)
-				errs = append(errs, err)
+// IsConnected reports whether the user has at least one connection.
+func (m *Manager) IsConnected(username string) bool {
+	m.connectionMutex.RLock()
+	defer m.connectionMutex.RUnlock()
+
+	return len(m.connections[username]) > 0
+}
+
+// Publish an event to all connections that are subscribed to the given topic name.
+//
+// The allow function, if non-nil, is called for every subscribed connection, and the event is only sent if it returns
+// true. It can be used to apply access rules at delivery time.
+func (m *Manager) Publish(ctx context.Context, event any, topicName string, allow func(client *Client) bool) error {
+	var clients []*Client
+
+	// Like Broadcast, the websocket Write calls are kept outside the mutex lock.
+	m.connectionMutex.RLock()
+	for _, clientList := range m.connections {
+		for _, client := range clientList {
+			if client.IsSubscribed(topicName) && (allow == nil || allow(client)) {
+				clients = append(clients, client)
 			}
 		}
 	}
+	m.connectionMutex.RUnlock()
 
-	return errors.Join(errs...)
+	fanoutSize.With("topic").Observe(float64(len(clients)))
+	return m.send(ctx, event, clients)
 }
 
-// Close the Manager. This closes all connections being managed. The Manager can still be used after this call.
+// Close the Manager gracefully. New connections are refused from now on.
 //
-// TODO: Allow callers to pass a context to control timeout.
-func (m *Manager) Close() error {
+// Every connection is sent the event returned by Handler.OnShutdown, if any, and is then closed with
+// StatusGoingAway. Connections are closed concurrently. Those that are not closed by the time the context expires are
+// closed right away, without the close handshake. Calls after the first one only return nil.
+func (m *Manager) Close(ctx context.Context) error {
 	m.connectionMutex.Lock()
+	m.closed = true
 	snapshot := m.connections
 	// Swap the live connections map with an empty one while holding the lock.
 	// After the swap, the old map (snapshot) is exclusively owned by this function.
 	// Which means that no other goroutine can reach it through m.connections.
 	// So, it's safe to iterate and close connections outside the lock.
-	m.connections = map[string][]*websocket.Conn{}
+	m.connections = map[string][]*Client{}
+	connectionsOpen.Add(-float64(m.connectionCount))
 	m.connectionCount = 0
 	m.connectionMutex.Unlock()
 
 	// This will collect all errors.
 	var errs []error
+	var errsMutex sync.Mutex
+	var wg sync.WaitGroup
 
 	// Close all connections.
-	for username, connList := range snapshot {
-		for i, conn := range connList {
-			slog.Info("closing connection", "username", username, "number", i+1, "total", len(connList))
-			if err := conn.CloseNow(); err != nil {
-				err = fmt.Errorf("failed to close connection for %s: %w", username, err)
-				errs = append(errs, err)
-			}
+	for username, clientList := range snapshot {
+		for i, client := range clientList {
+			slog.Info("closing connection", "username", username, "number", i+1, "total", len(clientList))
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := m.closeClient(ctx, client); err != nil {
+					errsMutex.Lock()
+					errs = append(errs, fmt.Errorf("failed to close connection for %s: %w", username, err))
+					errsMutex.Unlock()
+				}
+			}()
 		}
 	}
 
+	wg.Wait()
 	return errors.Join(errs...)
 }
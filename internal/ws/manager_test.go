@@ -2,11 +2,16 @@ package ws
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/shivanshkc/rosenbridge/internal/scope"
+	"github.com/shivanshkc/rosenbridge/pkg/codec"
+
 	"github.com/coder/websocket"
 	"github.com/stretchr/testify/require"
 )
@@ -14,7 +19,7 @@ import (
 func startServer(t *testing.T, m *Manager) *httptest.Server {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		username := r.URL.Query().Get("username")
-		_ = m.UpgradeAndAddConnection(w, r, username)
+		_ = m.UpgradeAndAddConnection(w, r, username, scope.Default)
 	}))
 
 	t.Cleanup(server.Close)
@@ -31,14 +36,14 @@ func waitForConnectionCount(t *testing.T, m *Manager, expected int) {
 }
 
 func TestNewManager(t *testing.T) {
-	m := NewManager()
+	m := NewManager(nil)
 	require.NotNil(t, m)
 	require.Empty(t, m.connections)
 	require.Equal(t, 0, m.connectionCount)
 }
 
 func TestManager_UpgradeAndAddConnection(t *testing.T) {
-	m := NewManager()
+	m := NewManager(nil)
 	server := startServer(t, m)
 
 	ctx := context.Background()
@@ -51,22 +56,25 @@ func TestManager_UpgradeAndAddConnection(t *testing.T) {
 	m.connectionMutex.RLock()
 	require.Len(t, m.connections["alice"], 1)
 	m.connectionMutex.RUnlock()
+
+	require.True(t, m.IsConnected("alice"))
+	require.False(t, m.IsConnected("bob"))
 }
 
 func TestManager_UpgradeAndAddConnection_InvalidRequest(t *testing.T) {
-	m := NewManager()
+	m := NewManager(nil)
 
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
 
-	err := m.UpgradeAndAddConnection(w, r, "alice")
+	err := m.UpgradeAndAddConnection(w, r, "alice", scope.Default)
 	require.Error(t, err)
 	require.ErrorContains(t, err, "failed to upgrade to websocket connection")
 	require.Equal(t, 0, m.connectionCount)
 }
 
 func TestManager_Broadcast(t *testing.T) {
-	m := NewManager()
+	m := NewManager(nil)
 	server := startServer(t, m)
 	ctx := context.Background()
 
@@ -80,41 +88,39 @@ func TestManager_Broadcast(t *testing.T) {
 
 	waitForConnectionCount(t, m, 2)
 
-	msg := []byte("hello alice")
-	err = m.Broadcast(ctx, msg, []string{"alice"})
+	err = m.Broadcast(ctx, "hello alice", []string{"alice"})
 	require.NoError(t, err)
 
 	_, data, err := aliceConn.Read(ctx)
 	require.NoError(t, err)
-	require.Equal(t, msg, data)
+	require.Equal(t, []byte(`"hello alice"`), data)
 
-	msg2 := []byte("hello everyone")
-	err = m.Broadcast(ctx, msg2, []string{"alice", "bob"})
+	err = m.Broadcast(ctx, "hello everyone", []string{"alice", "bob"})
 	require.NoError(t, err)
 
 	_, data, err = aliceConn.Read(ctx)
 	require.NoError(t, err)
-	require.Equal(t, msg2, data)
+	require.Equal(t, []byte(`"hello everyone"`), data)
 
 	_, data, err = bobConn.Read(ctx)
 	require.NoError(t, err)
-	require.Equal(t, msg2, data)
+	require.Equal(t, []byte(`"hello everyone"`), data)
 }
 
 func TestManager_Broadcast_NonexistentReceiver(t *testing.T) {
-	m := NewManager()
-	err := m.Broadcast(context.Background(), []byte("hello"), []string{"nonexistent"})
+	m := NewManager(nil)
+	err := m.Broadcast(context.Background(), "hello", []string{"nonexistent"})
 	require.NoError(t, err)
 }
 
 func TestManager_Broadcast_EmptyReceivers(t *testing.T) {
-	m := NewManager()
-	err := m.Broadcast(context.Background(), []byte("hello"), nil)
+	m := NewManager(nil)
+	err := m.Broadcast(context.Background(), "hello", nil)
 	require.NoError(t, err)
 }
 
 func TestManager_Close(t *testing.T) {
-	m := NewManager()
+	m := NewManager(echoHandler{})
 	server := startServer(t, m)
 	ctx := context.Background()
 
@@ -122,32 +128,80 @@ func TestManager_Close(t *testing.T) {
 	require.NoError(t, err)
 	defer func() { _ = clientConn.Close(websocket.StatusNormalClosure, "") }()
 
-	waitForConnectionCount(t, m, 1)
+	// Skip the greeting.
+	_, _, err = clientConn.Read(ctx)
+	require.NoError(t, err)
 
-	err = m.Close()
+	// The client must keep reading for the close handshake to finish.
+	received := make(chan []byte, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		_, data, err := clientConn.Read(ctx)
+		if err != nil {
+			readErr <- err
+			return
+		}
+		received <- data
+		_, _, err = clientConn.Read(ctx)
+		readErr <- err
+	}()
+
+	err = m.Close(ctx)
 	require.NoError(t, err)
 	require.Empty(t, m.connections)
 	require.Equal(t, 0, m.connectionCount)
 
-	_, _, err = clientConn.Read(ctx)
+	// The shutdown event comes before the close frame.
+	require.Equal(t, []byte(`"bye"`), <-received)
+	require.Equal(t, websocket.StatusGoingAway, websocket.CloseStatus(<-readErr))
+}
+
+func TestManager_Close_Deadline(t *testing.T) {
+	m := NewManager(nil)
+	server := startServer(t, m)
+
+	// This client never reads, so it never completes the close handshake.
+	clientConn, _, err := websocket.Dial(context.Background(), "ws"+server.URL[4:]+"?username=alice", nil)
+	require.NoError(t, err)
+	defer func() { _ = clientConn.CloseNow() }()
+
+	waitForConnectionCount(t, m, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = m.Close(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestManager_Close_RefusesNewConnections(t *testing.T) {
+	m := NewManager(nil)
+	server := startServer(t, m)
+	require.NoError(t, m.Close(context.Background()))
+
+	_, resp, err := websocket.Dial(context.Background(), "ws"+server.URL[4:]+"?username=alice", nil)
 	require.Error(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, 0, m.ConnectionCount())
 }
 
 func TestManager_Close_Empty(t *testing.T) {
-	m := NewManager()
-	require.NoError(t, m.Close())
+	m := NewManager(nil)
+	require.NoError(t, m.Close(context.Background()))
 	require.Empty(t, m.connections)
 	require.Equal(t, 0, m.connectionCount)
 }
 
 func TestManager_Close_Idempotent(t *testing.T) {
-	m := NewManager()
-	require.NoError(t, m.Close())
-	require.NoError(t, m.Close())
+	m := NewManager(nil)
+	require.NoError(t, m.Close(context.Background()))
+	require.NoError(t, m.Close(context.Background()))
 }
 
 func TestManager_ConnectionRemovedOnClientClose(t *testing.T) {
-	m := NewManager()
+	m := NewManager(nil)
 	server := startServer(t, m)
 	ctx := context.Background()
 
@@ -164,3 +218,182 @@ func TestManager_ConnectionRemovedOnClientClose(t *testing.T) {
 	require.Empty(t, m.connections)
 	m.connectionMutex.RUnlock()
 }
+
+// echoHandler is a mock Handler that greets and says goodbye with fixed events, and echoes every message back.
+type echoHandler struct{}
+
+func (echoHandler) OnConnect(context.Context, *Client) any { return "hello" }
+
+func (echoHandler) OnShutdown(context.Context, *Client) any { return "bye" }
+
+func (echoHandler) OnMessage(_ context.Context, client *Client, message []byte) []any {
+	var decoded any
+	if err := client.Codec.Unmarshal(message, &decoded); err != nil {
+		return []any{err.Error()}
+	}
+	return []any{decoded}
+}
+
+func TestManager_Handler(t *testing.T) {
+	m := NewManager(echoHandler{})
+	server := startServer(t, m)
+	ctx := context.Background()
+
+	clientConn, _, err := websocket.Dial(ctx, "ws"+server.URL[4:]+"?username=alice", nil)
+	require.NoError(t, err)
+	defer func() { _ = clientConn.Close(websocket.StatusNormalClosure, "") }()
+
+	// Greeting is sent right after connection.
+	_, data, err := clientConn.Read(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte(`"hello"`), data)
+
+	// Replies are sent back.
+	require.NoError(t, clientConn.Write(ctx, websocket.MessageText, []byte(`"ping"`)))
+	_, data, err = clientConn.Read(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte(`"ping"`), data)
+}
+
+func TestManager_Broadcast_MixedCodecs(t *testing.T) {
+	m := NewManager(nil)
+	server := startServer(t, m)
+	ctx := context.Background()
+
+	jsonConn, resp, err := websocket.Dial(ctx, "ws"+server.URL[4:]+"?username=alice", nil)
+	require.NoError(t, err)
+	require.Empty(t, resp.Header.Get("Sec-WebSocket-Protocol"))
+	defer func() { _ = jsonConn.Close(websocket.StatusNormalClosure, "") }()
+
+	dialOptions := &websocket.DialOptions{Subprotocols: []string{codec.MessagePack.Subprotocol()}}
+	msgpackConn, resp, err := websocket.Dial(ctx, "ws"+server.URL[4:]+"?username=alice", dialOptions)
+	require.NoError(t, err)
+	require.Equal(t, codec.MessagePack.Subprotocol(), resp.Header.Get("Sec-WebSocket-Protocol"))
+	defer func() { _ = msgpackConn.Close(websocket.StatusNormalClosure, "") }()
+
+	waitForConnectionCount(t, m, 2)
+
+	event := map[string]string{"greeting": "hi"}
+	require.NoError(t, m.Broadcast(ctx, event, []string{"alice"}))
+
+	messageType, data, err := jsonConn.Read(ctx)
+	require.NoError(t, err)
+	require.Equal(t, websocket.MessageText, messageType)
+	require.Equal(t, []byte(`{"greeting":"hi"}`), data)
+
+	messageType, data, err = msgpackConn.Read(ctx)
+	require.NoError(t, err)
+	require.Equal(t, websocket.MessageBinary, messageType)
+	expected, err := codec.MessagePack.Marshal(event)
+	require.NoError(t, err)
+	require.Equal(t, expected, data)
+}
+
+// failingCodec is a codec that cannot encode anything.
+type failingCodec struct {
+	codec.Codec
+}
+
+func (failingCodec) Subprotocol() string { return "failing" }
+
+func (failingCodec) Marshal(any) ([]byte, error) { return nil, errors.New("cannot encode") }
+
+func TestManager_Broadcast_EncodeError(t *testing.T) {
+	m := NewManager(nil)
+	server := startServer(t, m)
+	ctx := context.Background()
+
+	aliceConn, _, err := websocket.Dial(ctx, "ws"+server.URL[4:]+"?username=alice", nil)
+	require.NoError(t, err)
+	defer func() { _ = aliceConn.Close(websocket.StatusNormalClosure, "") }()
+
+	bobConn, _, err := websocket.Dial(ctx, "ws"+server.URL[4:]+"?username=bob", nil)
+	require.NoError(t, err)
+	defer func() { _ = bobConn.Close(websocket.StatusNormalClosure, "") }()
+
+	waitForConnectionCount(t, m, 2)
+
+	// Alice cannot be sent to, but Bob must still get the event.
+	m.connectionMutex.Lock()
+	m.connections["alice"][0].Codec = failingCodec{Codec: codec.JSON}
+	m.connectionMutex.Unlock()
+
+	err = m.Broadcast(ctx, map[string]string{"greeting": "hi"}, []string{"alice", "bob"})
+	require.ErrorContains(t, err, "failed to encode event for failing")
+
+	readCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_, data, err := bobConn.Read(readCtx)
+	require.NoError(t, err)
+	require.Equal(t, []byte(`{"greeting":"hi"}`), data)
+}
+
+func TestManager_Publish(t *testing.T) {
+	m := NewManager(nil)
+	server := startServer(t, m)
+	ctx := context.Background()
+
+	var conns []*websocket.Conn
+	for _, username := range []string{"alice", "bob", "carol"} {
+		conn, _, err := websocket.Dial(ctx, "ws"+server.URL[4:]+"?username="+username, nil)
+		require.NoError(t, err)
+		defer func() { _ = conn.Close(websocket.StatusNormalClosure, "") }()
+		conns = append(conns, conn)
+	}
+
+	waitForConnectionCount(t, m, 3)
+
+	m.connectionMutex.RLock()
+	require.NoError(t, m.connections["alice"][0].Subscribe("sensors/#"))
+	require.NoError(t, m.connections["bob"][0].Subscribe("sensors/*/temp"))
+	require.NoError(t, m.connections["carol"][0].Subscribe("news"))
+	m.connectionMutex.RUnlock()
+
+	// Bob is subscribed, but not allowed.
+	allow := func(client *Client) bool { return client.Username != "bob" }
+	require.NoError(t, m.Publish(ctx, "reading", "sensors/kitchen/temp", allow))
+	require.NoError(t, m.Publish(ctx, "headline", "news", nil))
+
+	_, data, err := conns[0].Read(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte(`"reading"`), data)
+
+	// Bob and Carol only receive what they are subscribed to and allowed.
+	_, data, err = conns[2].Read(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []byte(`"headline"`), data)
+
+	readCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, _, err = conns[1].Read(readCtx)
+	require.Error(t, err)
+}
+
+func TestManager_SetReadLimit(t *testing.T) {
+	m := NewManager(echoHandler{})
+	m.SetReadLimit(64 * 1024)
+	server := startServer(t, m)
+	ctx := context.Background()
+
+	clientConn, _, err := websocket.Dial(ctx, "ws"+server.URL[4:]+"?username=alice", nil)
+	require.NoError(t, err)
+	defer func() { _ = clientConn.CloseNow() }()
+	clientConn.SetReadLimit(128 * 1024)
+
+	// The greeting.
+	_, _, err = clientConn.Read(ctx)
+	require.NoError(t, err)
+
+	// Above the default limit of 32 KiB, but within the configured one.
+	message := `"` + strings.Repeat("a", 48*1024) + `"`
+	require.NoError(t, clientConn.Write(ctx, websocket.MessageText, []byte(message)))
+	_, echoed, err := clientConn.Read(ctx)
+	require.NoError(t, err)
+	require.Equal(t, message, string(echoed))
+
+	// Above the configured limit, the connection is closed.
+	message = `"` + strings.Repeat("a", 80*1024) + `"`
+	require.NoError(t, clientConn.Write(ctx, websocket.MessageText, []byte(message)))
+	_, _, err = clientConn.Read(ctx)
+	require.Equal(t, websocket.StatusMessageTooBig, websocket.CloseStatus(err))
+}
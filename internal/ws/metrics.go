@@ -0,0 +1,22 @@
+package ws
+
+import "github.com/shivanshkc/rosenbridge/internal/metrics"
+
+// fanoutBuckets are the histogram buckets of the number of connections that an event is sent to.
+var fanoutBuckets = []float64{0, 1, 2, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+var (
+	connectionsOpen = metrics.NewGauge("rosenbridge_websocket_connections",
+		"Number of open websocket connections.")
+	connectionsTotal = metrics.NewCounter("rosenbridge_websocket_connections_total",
+		"Number of websocket connections opened since the start.")
+
+	fanoutSize = metrics.NewHistogramVec("rosenbridge_websocket_fanout_size",
+		"Number of connections that an event is sent to, by kind: direct messages or topic publications.",
+		fanoutBuckets, "kind")
+
+	writeDuration = metrics.NewHistogram("rosenbridge_websocket_write_duration_seconds",
+		"Duration of websocket writes.", metrics.DefaultBuckets)
+	writeFailures = metrics.NewCounter("rosenbridge_websocket_write_failures_total",
+		"Number of websocket writes that failed.")
+)
@@ -0,0 +1,52 @@
+// Package codec provides the encodings that Rosenbridge can use for the events sent over stateful connections.
+package codec
+
+import (
+	"strings"
+)
+
+// Codec encodes and decodes events for the wire.
+type Codec interface {
+	// Subprotocol is the websocket subprotocol that selects this codec.
+	Subprotocol() string
+
+	// Binary reports whether the encoded data is binary. If false, the encoded data is valid UTF-8 text.
+	Binary() bool
+
+	// Marshal encodes the given value. Struct fields are named as per their "json" tags.
+	Marshal(v any) ([]byte, error)
+
+	// Unmarshal decodes the given data into v, which must be a non-nil pointer.
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSON is the default codec, used when the client does not negotiate a subprotocol.
+	JSON Codec = jsonCodec{}
+
+	// MessagePack is the codec for https://msgpack.org.
+	MessagePack Codec = msgpackCodec{}
+)
+
+// Supported lists all codecs in the order of server preference.
+var Supported = []Codec{JSON, MessagePack}
+
+// Subprotocols returns the subprotocols of all Supported codecs, in the same order.
+func Subprotocols() []string {
+	subprotocols := make([]string, 0, len(Supported))
+	for _, c := range Supported {
+		subprotocols = append(subprotocols, c.Subprotocol())
+	}
+	return subprotocols
+}
+
+// BySubprotocol returns the codec for the given subprotocol, matched case-insensitively.
+// If the subprotocol is empty or unknown, it returns the JSON codec.
+func BySubprotocol(subprotocol string) Codec {
+	for _, c := range Supported {
+		if strings.EqualFold(c.Subprotocol(), subprotocol) {
+			return c
+		}
+	}
+	return JSON
+}
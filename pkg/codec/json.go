@@ -0,0 +1,16 @@
+package codec
+
+import (
+	"encoding/json"
+)
+
+// jsonCodec implements Codec using the encoding/json package.
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string { return "rosenbridge.v1.json" }
+
+func (jsonCodec) Binary() bool { return false }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
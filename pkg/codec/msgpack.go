@@ -0,0 +1,657 @@
+package codec
+
+import (
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// msgpackMaxDepth is the max nesting of arrays and maps allowed while decoding.
+const msgpackMaxDepth = 64
+
+var (
+	errMsgpackTruncated = errors.New("msgpack: unexpected end of data")
+	errMsgpackTooDeep   = errors.New("msgpack: max nesting depth exceeded")
+
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+	jsonNumberType    = reflect.TypeFor[json.Number]()
+)
+
+// msgpackCodec implements Codec for MessagePack, as per https://github.com/msgpack/msgpack/blob/master/spec.md.
+//
+// Encoding is reflection based and follows the rules of encoding/json for struct fields, so the same structs can be
+// used with both codecs. Byte slices are encoded as the binary type instead of base64 strings.
+//
+// Decoding first produces generic values (maps, slices, strings, numbers), and then lets encoding/json populate the
+// target from them. This keeps the decoder small at the cost of speed, which is acceptable because clients send far
+// fewer events than they receive.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string { return "rosenbridge.v1.msgpack" }
+
+func (msgpackCodec) Binary() bool { return true }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	encoder := &msgpackEncoder{}
+	if err := encoder.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return encoder.buf, nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	decoder := &msgpackDecoder{data: data}
+
+	generic, err := decoder.decode(0)
+	if err != nil {
+		return err
+	}
+
+	if decoder.pos != len(decoder.data) {
+		return errors.New("msgpack: unexpected data after the top-level value")
+	}
+
+	// encoding/json takes care of mapping the generic value to the target type.
+	jsonBytes, err := json.Marshal(generic)
+	if err != nil {
+		return fmt.Errorf("msgpack: failed to convert to json: %w", err)
+	}
+
+	return json.Unmarshal(jsonBytes, v)
+}
+
+// msgpackEncoder encodes values into its buffer.
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf = append(e.buf, 0xc0)
+		return nil
+	}
+
+	// Nil pointers and interfaces are encoded as nil before any marshaler is consulted, like encoding/json does.
+	if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+		e.buf = append(e.buf, 0xc0)
+		return nil
+	}
+
+	switch {
+	case v.Type() == jsonNumberType:
+		return e.encodeJSONNumber(json.Number(v.String()))
+	case v.Type().Implements(jsonMarshalerType):
+		// Types with custom JSON encodings, like json.RawMessage and time.Time, are encoded as per their JSON form.
+		return e.encodeJSONMarshaler(v.Interface().(json.Marshaler))
+	case v.Type().Implements(textMarshalerType):
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return fmt.Errorf("msgpack: failed to marshal text: %w", err)
+		}
+		e.encodeString(string(text))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 0xc3)
+		} else {
+			e.buf = append(e.buf, 0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.buf = append(e.buf, 0xca)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.encodeFloat64(v.Float())
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBinary(v.Bytes())
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	case reflect.Pointer, reflect.Interface:
+		return e.encode(v.Elem())
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+func (e *msgpackEncoder) encodeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.encodeUint(uint64(n))
+	case n >= -32:
+		e.buf = append(e.buf, byte(n))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xd2), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xd3), uint64(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.buf = append(e.buf, byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xce), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcf), n)
+	}
+}
+
+func (e *msgpackEncoder) encodeFloat64(f float64) {
+	e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcb), math.Float64bits(f))
+}
+
+func (e *msgpackEncoder) encodeString(s string) {
+	switch n := len(s); {
+	case n < 32:
+		e.buf = append(e.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xda), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdb), uint32(n))
+	}
+	e.buf = append(e.buf, s...)
+}
+
+func (e *msgpackEncoder) encodeBinary(b []byte) {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xc5), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xc6), uint32(n))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+func (e *msgpackEncoder) encodeArrayHeader(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xdc), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdd), uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeMapHeader(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xde), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdf), uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) encodeArray(v reflect.Value) error {
+	e.encodeArrayHeader(v.Len())
+	for i := range v.Len() {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeMap(v reflect.Value) error {
+	// Keys are stringified like encoding/json does, and sorted for a deterministic output.
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+
+	entries := make([]entry, 0, v.Len())
+	for iter := v.MapRange(); iter.Next(); {
+		key, err := mapKeyString(iter.Key())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{key: key, value: iter.Value()})
+	}
+
+	slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.key, b.key) })
+
+	e.encodeMapHeader(len(entries))
+	for _, en := range entries {
+		e.encodeString(en.key)
+		if err := e.encode(en.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeStruct(v reflect.Value) error {
+	type entry struct {
+		name  string
+		value reflect.Value
+	}
+
+	var entries []entry
+	for _, field := range structFields(v.Type()) {
+		fieldValue, ok := fieldByIndex(v, field.index)
+		if !ok || (field.omitEmpty && isEmptyValue(fieldValue)) {
+			continue
+		}
+		entries = append(entries, entry{name: field.name, value: fieldValue})
+	}
+
+	e.encodeMapHeader(len(entries))
+	for _, en := range entries {
+		e.encodeString(en.name)
+		if err := e.encode(en.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeJSONMarshaler(marshaler json.Marshaler) error {
+	jsonBytes, err := marshaler.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("msgpack: failed to marshal json: %w", err)
+	}
+
+	// UseNumber preserves integers, which would otherwise become floats.
+	decoder := json.NewDecoder(strings.NewReader(string(jsonBytes)))
+	decoder.UseNumber()
+
+	var generic any
+	if err := decoder.Decode(&generic); err != nil {
+		return fmt.Errorf("msgpack: failed to decode json: %w", err)
+	}
+
+	return e.encode(reflect.ValueOf(generic))
+}
+
+func (e *msgpackEncoder) encodeJSONNumber(number json.Number) error {
+	if n, err := number.Int64(); err == nil {
+		e.encodeInt(n)
+		return nil
+	}
+
+	if n, err := strconv.ParseUint(number.String(), 10, 64); err == nil {
+		e.encodeUint(n)
+		return nil
+	}
+
+	f, err := number.Float64()
+	if err != nil {
+		return fmt.Errorf("msgpack: invalid number %q: %w", number, err)
+	}
+
+	e.encodeFloat64(f)
+	return nil
+}
+
+// mapKeyString converts a map key to a string, following the rules of encoding/json.
+func mapKeyString(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+
+	if key.Type().Implements(textMarshalerType) {
+		text, err := key.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return "", fmt.Errorf("msgpack: failed to marshal map key: %w", err)
+		}
+		return string(text), nil
+	}
+
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	default:
+		return "", fmt.Errorf("msgpack: unsupported map key type %s", key.Type())
+	}
+}
+
+// structField describes how a struct field gets encoded.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// structFields lists the encodable fields of the given struct type, following the "json" tag rules.
+// Fields of embedded structs are promoted, unless shadowed by a shallower field with the same name.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	seen := map[string]struct{}{}
+
+	// Breadth-first traversal, so that shallower fields take precedence.
+	type level struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	current := []level{{typ: t}}
+	for len(current) > 0 {
+		var next []level
+		var levelFields []structField
+
+		for _, lvl := range current {
+			for i := range lvl.typ.NumField() {
+				field := lvl.typ.Field(i)
+				index := append(slices.Clone(lvl.index), i)
+
+				tag := field.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+
+				name, options, _ := strings.Cut(tag, ",")
+
+				// Untagged embedded structs get their fields promoted.
+				if field.Anonymous && name == "" {
+					embedded := field.Type
+					if embedded.Kind() == reflect.Pointer {
+						embedded = embedded.Elem()
+					}
+					if embedded.Kind() == reflect.Struct {
+						next = append(next, level{typ: embedded, index: index})
+						continue
+					}
+				}
+
+				if !field.IsExported() {
+					continue
+				}
+
+				if name == "" {
+					name = field.Name
+				}
+
+				omitEmpty := slices.Contains(strings.Split(options, ","), "omitempty")
+				levelFields = append(levelFields, structField{name: name, index: index, omitEmpty: omitEmpty})
+			}
+		}
+
+		for _, field := range levelFields {
+			if _, exists := seen[field.name]; exists {
+				continue
+			}
+			seen[field.name] = struct{}{}
+			fields = append(fields, field)
+		}
+
+		current = next
+	}
+
+	return fields
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but it returns false instead of panicking on nil embedded pointers.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmptyValue follows the "omitempty" rules of encoding/json.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
+
+// msgpackDecoder decodes msgpack data into generic values.
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+// decode decodes the next value. Maps become map[string]any, arrays become []any, integers become int64 or
+// uint64, floats become float64, strings become string, and binary becomes []byte.
+func (d *msgpackDecoder) decode(depth int) (any, error) {
+	if depth > msgpackMaxDepth {
+		return nil, errMsgpackTooDeep
+	}
+
+	b, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	// Types with the length or value embedded in the first byte.
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return d.decodeMap(int(b&0x0f), depth)
+	case b&0xf0 == 0x90:
+		return d.decodeArray(int(b&0x0f), depth)
+	case b&0xe0 == 0xa0:
+		return d.readString(int(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readLength(b - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := d.readN(n)
+		if err != nil {
+			return nil, err
+		}
+		return slices.Clone(raw), nil
+	case 0xca:
+		raw, err := d.readN(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), nil
+	case 0xcb:
+		raw, err := d.readN(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		raw, err := d.readN(1 << (b - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return bigEndianUint(raw), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		raw, err := d.readN(size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign-extend from the encoded size.
+		shift := 64 - 8*size
+		return int64(bigEndianUint(raw)<<shift) >> shift, nil
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readLength(b - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(n)
+	case 0xdc, 0xdd:
+		n, err := d.readLength(b - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.readLength(b - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n, depth)
+	default:
+		// Extension types (0xc7-0xc9, 0xd4-0xd8) and the never-used 0xc1.
+		return nil, fmt.Errorf("msgpack: unsupported type byte 0x%02x", b)
+	}
+}
+
+func (d *msgpackDecoder) decodeArray(n, depth int) (any, error) {
+	// Every element takes at least one byte. This prevents huge allocations for malicious lengths.
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+
+	array := make([]any, 0, n)
+	for range n {
+		elem, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, elem)
+	}
+	return array, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n, depth int) (any, error) {
+	// Every entry takes at least two bytes. This prevents huge allocations for malicious lengths.
+	if 2*n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+
+	m := make(map[string]any, n)
+	for range n {
+		key, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		switch k := key.(type) {
+		case string:
+			m[k] = value
+		case []byte:
+			m[string(k)] = value
+		default:
+			m[fmt.Sprint(k)] = value
+		}
+	}
+	return m, nil
+}
+
+func (d *msgpackDecoder) readByte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, errMsgpackTruncated
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *msgpackDecoder) readN(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+	raw := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return raw, nil
+}
+
+// readLength reads a big-endian length of 1, 2 or 4 bytes, as selected by sizeClass 0, 1 or 2.
+func (d *msgpackDecoder) readLength(sizeClass byte) (int, error) {
+	raw, err := d.readN(1 << sizeClass)
+	if err != nil {
+		return 0, err
+	}
+	return int(bigEndianUint(raw)), nil
+}
+
+func (d *msgpackDecoder) readString(n int) (string, error) {
+	raw, err := d.readN(n)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// bigEndianUint decodes a big-endian unsigned integer of 1, 2, 4 or 8 bytes.
+func bigEndianUint(raw []byte) uint64 {
+	var n uint64
+	for _, b := range raw {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
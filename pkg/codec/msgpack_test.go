@@ -0,0 +1,124 @@
+package codec
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgpackCodec_Marshal(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		input    any
+		expected []byte
+	}{
+		{name: "nil", input: nil, expected: []byte{0xc0}},
+		{name: "true", input: true, expected: []byte{0xc3}},
+		{name: "positive fixint", input: 5, expected: []byte{0x05}},
+		{name: "negative fixint", input: -1, expected: []byte{0xff}},
+		{name: "uint8", input: 200, expected: []byte{0xcc, 0xc8}},
+		{name: "int8", input: -100, expected: []byte{0xd0, 0x9c}},
+		{name: "uint16", input: 1000, expected: []byte{0xcd, 0x03, 0xe8}},
+		{name: "int32", input: -100000, expected: []byte{0xd2, 0xff, 0xfe, 0x79, 0x60}},
+		{name: "float64", input: 1.5, expected: []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{name: "fixstr", input: "hi", expected: []byte{0xa2, 'h', 'i'}},
+		{name: "str8", input: strings.Repeat("a", 40), expected: append([]byte{0xd9, 40}, strings.Repeat("a", 40)...)},
+		{name: "bin8", input: []byte{1, 2}, expected: []byte{0xc4, 0x02, 0x01, 0x02}},
+		{name: "fixarray", input: []int{1, 2}, expected: []byte{0x92, 0x01, 0x02}},
+		{name: "nil slice", input: []int(nil), expected: []byte{0xc0}},
+		{name: "sorted map", input: map[string]int{"b": 2, "a": 1}, expected: []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+		{
+			name: "struct with json tags",
+			input: struct {
+				Name    string `json:"name"`
+				Skipped string `json:"-"`
+				Empty   string `json:"empty,omitempty"`
+				hidden  string
+			}{Name: "x", Skipped: "y", hidden: "z"},
+			expected: []byte{0x81, 0xa4, 'n', 'a', 'm', 'e', 0xa1, 'x'},
+		},
+		{name: "json.RawMessage", input: json.RawMessage(`{"a":1}`), expected: []byte{0x81, 0xa1, 'a', 0x01}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, err := MessagePack.Marshal(tc.input)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, encoded)
+		})
+	}
+}
+
+func TestMsgpackCodec_RoundTrip(t *testing.T) {
+	type inner struct {
+		Values []float64 `json:"values"`
+	}
+
+	type embedded struct {
+		Promoted string `json:"promoted"`
+	}
+
+	type sample struct {
+		embedded
+		Text    string            `json:"text"`
+		Number  int64             `json:"number"`
+		Big     uint64            `json:"big"`
+		Flag    bool              `json:"flag"`
+		Blob    []byte            `json:"blob"`
+		Labels  map[string]string `json:"labels"`
+		Inner   *inner            `json:"inner"`
+		Missing *inner            `json:"missing"`
+	}
+
+	input := sample{
+		embedded: embedded{Promoted: "p"},
+		Text:     strings.Repeat("long text ", 10),
+		Number:   math.MinInt64,
+		Big:      math.MaxUint64,
+		Flag:     true,
+		Blob:     []byte{0, 1, 2, 255},
+		Labels:   map[string]string{"k": "v"},
+		Inner:    &inner{Values: []float64{1.25, -3}},
+	}
+
+	encoded, err := MessagePack.Marshal(input)
+	require.NoError(t, err)
+
+	var output sample
+	require.NoError(t, MessagePack.Unmarshal(encoded, &output))
+	require.Equal(t, input, output)
+}
+
+func TestMsgpackCodec_Unmarshal_Errors(t *testing.T) {
+	var testCases = []struct {
+		name        string
+		input       []byte
+		expectedErr string
+	}{
+		{name: "empty input", input: []byte{}, expectedErr: errMsgpackTruncated.Error()},
+		{name: "truncated string", input: []byte{0xa5, 'a'}, expectedErr: errMsgpackTruncated.Error()},
+		{name: "huge array length", input: []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, expectedErr: errMsgpackTruncated.Error()},
+		{name: "extension type", input: []byte{0xd4, 0x01, 0x00}, expectedErr: "unsupported type byte 0xd4"},
+		{name: "trailing data", input: []byte{0x01, 0x02}, expectedErr: "unexpected data after the top-level value"},
+		{name: "too deep", input: []byte(strings.Repeat("\x91", msgpackMaxDepth+2)), expectedErr: errMsgpackTooDeep.Error()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var output any
+			err := MessagePack.Unmarshal(tc.input, &output)
+			require.ErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestBySubprotocol(t *testing.T) {
+	require.Equal(t, JSON, BySubprotocol(""))
+	require.Equal(t, JSON, BySubprotocol("unknown"))
+	require.Equal(t, JSON, BySubprotocol("rosenbridge.v1.json"))
+	require.Equal(t, MessagePack, BySubprotocol("Rosenbridge.V1.MsgPack"))
+	require.Equal(t, []string{"rosenbridge.v1.json", "rosenbridge.v1.msgpack"}, Subprotocols())
+}
@@ -0,0 +1,164 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Server-to-client event types.
+const (
+	// EventTypeHello is sent right after a connection is established.
+	EventTypeHello = "Hello"
+	// EventTypeError is sent when the server cannot process a client event.
+	EventTypeError = "Error"
+	// EventTypePong is sent in response to EventTypePing.
+	EventTypePong = "Pong"
+	// EventTypeMessageReceived is sent when a message is sent to the connected user, or published to a topic that the
+	// connection is subscribed to.
+	EventTypeMessageReceived = "MessageReceived"
+	// EventTypeSubscribed is sent in response to EventTypeSubscribe, once the subscription is active.
+	EventTypeSubscribed = "Subscribed"
+	// EventTypeUnsubscribed is sent in response to EventTypeUnsubscribe.
+	EventTypeUnsubscribed = "Unsubscribed"
+	// EventTypeServerShuttingDown is sent right before the server closes the connection because it is shutting down.
+	EventTypeServerShuttingDown = "ServerShuttingDown"
+)
+
+// Client-to-server event types.
+const (
+	// EventTypePing can be sent by clients to check if the connection is alive, at the application level.
+	EventTypePing = "Ping"
+	// EventTypeSubscribe subscribes the connection to a topic filter.
+	EventTypeSubscribe = "Subscribe"
+	// EventTypeUnsubscribe unsubscribes the connection from a topic filter.
+	EventTypeUnsubscribe = "Unsubscribe"
+)
+
+// Capabilities that the server can announce in the Hello event.
+const (
+	CapabilityPing = "ping"
+	// CapabilityMessagePack means that events can be MessagePack encoded, if negotiated via websocket subprotocol.
+	CapabilityMessagePack = "msgpack"
+	// CapabilityTopics means that the connection can subscribe to topics.
+	CapabilityTopics = "topics"
+)
+
+// Codes of the Error event.
+const (
+	ErrorCodeMalformedEvent     = "MALFORMED_EVENT"
+	ErrorCodeUnsupportedVersion = "UNSUPPORTED_VERSION"
+	ErrorCodeUnknownEventType   = "UNKNOWN_EVENT_TYPE"
+	ErrorCodeMalformedEventBody = "MALFORMED_EVENT_BODY"
+	ErrorCodeInvalidTopic       = "INVALID_TOPIC"
+	ErrorCodeForbidden          = "FORBIDDEN"
+	ErrorCodeLimitExceeded      = "LIMIT_EXCEEDED"
+	ErrorCodeInternal           = "INTERNAL"
+)
+
+// Hello is the body of the EventTypeHello event.
+type Hello struct {
+	// ServerVersion is the version of the Rosenbridge server.
+	ServerVersion string `json:"server_version"`
+	// ProtocolVersion is the protocol Version spoken by the server.
+	ProtocolVersion int `json:"protocol_version"`
+	// Capabilities lists the optional features supported by the server.
+	Capabilities []string `json:"capabilities"`
+	// Username is the user that the connection belongs to.
+	Username string `json:"username"`
+}
+
+// Error is the body of the EventTypeError event.
+type Error struct {
+	// Code is one of the ErrorCodeXXX constants.
+	Code string `json:"code"`
+	// Reason is a human-readable explanation of the error.
+	Reason string `json:"reason"`
+}
+
+// Ping is the body of the EventTypePing event.
+type Ping struct {
+	// ID is echoed back in the Pong event. It is optional.
+	ID string `json:"id,omitempty"`
+}
+
+// Pong is the body of the EventTypePong event.
+type Pong struct {
+	// ID is the ID of the corresponding Ping event.
+	ID string `json:"id,omitempty"`
+}
+
+// Subscribe is the body of the EventTypeSubscribe event.
+type Subscribe struct {
+	// Topic is the topic filter to subscribe to. It can contain the "*" and "#" wildcards.
+	Topic string `json:"topic"`
+}
+
+// Subscribed is the body of the EventTypeSubscribed event.
+type Subscribed struct {
+	// Topic is the topic filter of the corresponding Subscribe event.
+	Topic string `json:"topic"`
+}
+
+// Unsubscribe is the body of the EventTypeUnsubscribe event.
+type Unsubscribe struct {
+	// Topic is the topic filter to unsubscribe from. It must be the same as the one subscribed to.
+	Topic string `json:"topic"`
+}
+
+// Unsubscribed is the body of the EventTypeUnsubscribed event.
+type Unsubscribed struct {
+	// Topic is the topic filter of the corresponding Unsubscribe event.
+	Topic string `json:"topic"`
+}
+
+// ServerShuttingDown is the body of the EventTypeServerShuttingDown event.
+type ServerShuttingDown struct {
+	// ReconnectAfterMs is the suggested delay before reconnecting, in milliseconds. It is randomized per connection,
+	// so that clients do not all reconnect at once.
+	ReconnectAfterMs int64 `json:"reconnect_after_ms"`
+}
+
+// MessageReceived is the body of the EventTypeMessageReceived event.
+//
+// A message carries at least one of Message, Payload, Binary and Attachments.
+type MessageReceived struct {
+	// Message is the message text.
+	Message string `json:"message"`
+	// Payload is an arbitrary JSON object.
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Binary is an arbitrary blob of data.
+	Binary *Binary `json:"binary,omitempty"`
+	// Attachments are files uploaded by the sender, which the receivers can download.
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// Sender is the username of the sender.
+	Sender string `json:"sender"`
+	// Topic is the topic that the message was published to. It is empty for messages sent directly to the user.
+	Topic string `json:"topic,omitempty"`
+	// Retained is true if the message is the retained message of its topic, delivered upon subscription.
+	// Live deliveries always have it false, even if the message was retained by its publisher.
+	Retained bool `json:"retained,omitempty"`
+}
+
+// Binary is a blob of data along with its media type.
+//
+// With the JSON encoding, Data is base64 encoded. With binary encodings, like MessagePack, it is sent as raw bytes.
+type Binary struct {
+	// ContentType is the media type of the data, like "application/sdp".
+	ContentType string `json:"content_type"`
+	// Data is the blob itself.
+	Data []byte `json:"data"`
+}
+
+// Attachment describes an uploaded file that can be downloaded by the receivers of a message.
+type Attachment struct {
+	// ID of the attachment.
+	ID string `json:"id"`
+	// ContentType is the media type of the attachment.
+	ContentType string `json:"content_type"`
+	// Size of the attachment in bytes.
+	Size int64 `json:"size"`
+	// URL is a signed download link, relative to the server's base URL. It requires no other credentials.
+	URL string `json:"url"`
+	// URLExpiresAt is the time after which the URL stops working.
+	URLExpiresAt time.Time `json:"url_expires_at"`
+}
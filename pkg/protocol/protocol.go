@@ -0,0 +1,116 @@
+// Package protocol defines the events exchanged between Rosenbridge and its clients over stateful connections
+// (websocket, TCP). It is importable by third-party Go clients.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Version of the event protocol. It is incremented on every breaking change to the event schemas.
+const Version = 1
+
+var (
+	ErrMalformedEvent     = errors.New("malformed event")
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrUnknownEventType   = errors.New("unknown event type")
+	ErrMalformedEventBody = errors.New("malformed event body")
+)
+
+// Event is the envelope of every event exchanged over a stateful connection.
+type Event struct {
+	// Version of the protocol that the event conforms to.
+	Version int `json:"version"`
+	// EventType decides the schema of EventBody.
+	EventType string `json:"event_type"`
+	// EventBody is one of the event structs defined in this package.
+	EventBody any `json:"event_body"`
+}
+
+// NewEvent returns a new Event of the current protocol Version.
+func NewEvent(eventType string, eventBody any) Event {
+	return Event{Version: Version, EventType: eventType, EventBody: eventBody}
+}
+
+// clientEventBodies maps the types of all client-to-server events to constructors of their bodies.
+var clientEventBodies = map[string]func() any{
+	EventTypePing:        func() any { return &Ping{} },
+	EventTypeSubscribe:   func() any { return &Subscribe{} },
+	EventTypeUnsubscribe: func() any { return &Unsubscribe{} },
+}
+
+// serverEventTypes is the set of all server-to-client event types.
+var serverEventTypes = map[string]struct{}{
+	EventTypeHello:              {},
+	EventTypeError:              {},
+	EventTypePong:               {},
+	EventTypeMessageReceived:    {},
+	EventTypeSubscribed:         {},
+	EventTypeUnsubscribed:       {},
+	EventTypeServerShuttingDown: {},
+}
+
+// ParseClientEvent parses a JSON encoded event sent by a client.
+//
+// The returned Event's body is a pointer to the struct for its type, for example, *Ping for EventTypePing.
+// A missing version is treated as the current Version.
+func ParseClientEvent(data []byte) (Event, error) {
+	return DecodeClientEvent(data, json.Unmarshal)
+}
+
+// DecodeClientEvent is like ParseClientEvent, but it accepts the function to decode the event with. The function must
+// support decoding into json.RawMessage, as the event body is decoded only after its type is known.
+func DecodeClientEvent(data []byte, unmarshal func(data []byte, v any) error) (Event, error) {
+	var raw struct {
+		Version   int             `json:"version"`
+		EventType string          `json:"event_type"`
+		EventBody json.RawMessage `json:"event_body"`
+	}
+
+	if err := unmarshal(data, &raw); err != nil {
+		return Event{}, fmt.Errorf("%w: %w", ErrMalformedEvent, err)
+	}
+
+	if raw.Version == 0 {
+		raw.Version = Version
+	}
+
+	if raw.Version != Version {
+		return Event{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, raw.Version)
+	}
+
+	newBody, exists := clientEventBodies[raw.EventType]
+	if !exists {
+		if _, isServerEvent := serverEventTypes[raw.EventType]; isServerEvent {
+			return Event{}, fmt.Errorf("%w: %q is a server-to-client event", ErrUnknownEventType, raw.EventType)
+		}
+		return Event{}, fmt.Errorf("%w: %q", ErrUnknownEventType, raw.EventType)
+	}
+
+	body := newBody()
+	// An absent body is the same as an empty one.
+	if len(raw.EventBody) > 0 && string(raw.EventBody) != "null" {
+		if err := json.Unmarshal(raw.EventBody, body); err != nil {
+			return Event{}, fmt.Errorf("%w: %w", ErrMalformedEventBody, err)
+		}
+	}
+
+	return Event{Version: raw.Version, EventType: raw.EventType, EventBody: body}, nil
+}
+
+// ErrorCode returns the Error event code that corresponds to the given ParseClientEvent error.
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrMalformedEvent):
+		return ErrorCodeMalformedEvent
+	case errors.Is(err, ErrUnsupportedVersion):
+		return ErrorCodeUnsupportedVersion
+	case errors.Is(err, ErrUnknownEventType):
+		return ErrorCodeUnknownEventType
+	case errors.Is(err, ErrMalformedEventBody):
+		return ErrorCodeMalformedEventBody
+	default:
+		return ErrorCodeInternal
+	}
+}
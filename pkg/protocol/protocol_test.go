@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientEvent(t *testing.T) {
+	var testCases = []struct {
+		name              string
+		input             string
+		expectedEvent     Event
+		expectedErr       error
+		expectedErrorCode string
+	}{
+		{
+			name:          "Ping with body, no error expected",
+			input:         `{"version":1,"event_type":"Ping","event_body":{"id":"abc"}}`,
+			expectedEvent: Event{Version: 1, EventType: EventTypePing, EventBody: &Ping{ID: "abc"}},
+		},
+		{
+			name:          "Ping without version and body, no error expected",
+			input:         `{"event_type":"Ping"}`,
+			expectedEvent: Event{Version: Version, EventType: EventTypePing, EventBody: &Ping{}},
+		},
+		{
+			name:          "Subscribe, no error expected",
+			input:         `{"event_type":"Subscribe","event_body":{"topic":"sensors/#"}}`,
+			expectedEvent: Event{Version: Version, EventType: EventTypeSubscribe, EventBody: &Subscribe{Topic: "sensors/#"}},
+		},
+		{
+			name:              "Invalid JSON, error expected",
+			input:             `{{{`,
+			expectedErr:       ErrMalformedEvent,
+			expectedErrorCode: ErrorCodeMalformedEvent,
+		},
+		{
+			name:              "Unsupported version, error expected",
+			input:             `{"version":99,"event_type":"Ping"}`,
+			expectedErr:       ErrUnsupportedVersion,
+			expectedErrorCode: ErrorCodeUnsupportedVersion,
+		},
+		{
+			name:              "Unknown event type, error expected",
+			input:             `{"event_type":"Dance"}`,
+			expectedErr:       ErrUnknownEventType,
+			expectedErrorCode: ErrorCodeUnknownEventType,
+		},
+		{
+			name:              "Server event type, error expected",
+			input:             `{"event_type":"MessageReceived"}`,
+			expectedErr:       ErrUnknownEventType,
+			expectedErrorCode: ErrorCodeUnknownEventType,
+		},
+		{
+			name:              "Malformed body, error expected",
+			input:             `{"event_type":"Ping","event_body":{"id":123}}`,
+			expectedErr:       ErrMalformedEventBody,
+			expectedErrorCode: ErrorCodeMalformedEventBody,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			event, err := ParseClientEvent([]byte(tc.input))
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				require.Equal(t, tc.expectedErrorCode, ErrorCode(err))
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedEvent, event)
+		})
+	}
+}
+
+func TestNewEvent_Marshal(t *testing.T) {
+	event := NewEvent(EventTypeMessageReceived, MessageReceived{Message: "hey", Sender: "bob"})
+
+	eventBytes, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	expected := `{"version":1,"event_type":"MessageReceived","event_body":{"message":"hey","sender":"bob"}}`
+	require.JSONEq(t, expected, string(eventBytes))
+}
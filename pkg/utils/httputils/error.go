@@ -64,13 +64,14 @@ func NewError(code int) *Error {
 	return &Error{StatusCode: code, Status: statusText}
 }
 
-func BadRequest() *Error          { return NewError(http.StatusBadRequest) }
-func Unauthorized() *Error        { return NewError(http.StatusUnauthorized) }
-func PaymentRequired() *Error     { return NewError(http.StatusPaymentRequired) }
-func Forbidden() *Error           { return NewError(http.StatusForbidden) }
-func NotFound() *Error            { return NewError(http.StatusNotFound) }
-func RequestTimeout() *Error      { return NewError(http.StatusRequestTimeout) }
-func Conflict() *Error            { return NewError(http.StatusConflict) }
-func PreconditionFailed() *Error  { return NewError(http.StatusPreconditionFailed) }
-func InternalServerError() *Error { return NewError(http.StatusInternalServerError) }
-func ServiceUnavailable() *Error  { return NewError(http.StatusServiceUnavailable) }
+func BadRequest() *Error            { return NewError(http.StatusBadRequest) }
+func Unauthorized() *Error          { return NewError(http.StatusUnauthorized) }
+func PaymentRequired() *Error       { return NewError(http.StatusPaymentRequired) }
+func Forbidden() *Error             { return NewError(http.StatusForbidden) }
+func NotFound() *Error              { return NewError(http.StatusNotFound) }
+func RequestTimeout() *Error        { return NewError(http.StatusRequestTimeout) }
+func Conflict() *Error              { return NewError(http.StatusConflict) }
+func PreconditionFailed() *Error    { return NewError(http.StatusPreconditionFailed) }
+func RequestEntityTooLarge() *Error { return NewError(http.StatusRequestEntityTooLarge) }
+func InternalServerError() *Error   { return NewError(http.StatusInternalServerError) }
+func ServiceUnavailable() *Error    { return NewError(http.StatusServiceUnavailable) }